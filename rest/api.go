@@ -17,12 +17,22 @@ limitations under the License.
 package rest
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"reflect"
+	"regexp"
+	"runtime/debug"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -32,16 +42,36 @@ type HandleMethod string
 const (
 	defaultLogPrefix     = "rest "
 	defaultDocsDirectory = "_docs/"
+	defaultLimit         = 100
+	defaultNestingDepth  = 32
 
 	// Handler names
-	HandleCreate     HandleMethod = "create"
-	HandleRead                    = "read"
-	HandleUpdate                  = "update"
-	HandleDelete                  = "delete"
-	HandleReadList                = "readList"
-	HandleUpdateList              = "updateList"
+	HandleCreate        HandleMethod = "create"
+	HandleRead                       = "read"
+	HandleUpdate                     = "update"
+	HandlePatch                      = "patch"
+	HandleDelete                     = "delete"
+	HandleReadList                   = "readList"
+	HandleUpdateList                 = "updateList"
+	HandleReadResources              = "readResources"
+	HandleEvents                     = "events"
 )
 
+// RouteInfo describes a route registered with an API.
+type RouteInfo struct {
+	// Name of the route, e.g. "widgets:read" for the read route of the "widgets"
+	// resource. Empty if the route is unnamed.
+	Name string
+
+	// Methods is the list of HTTP methods matched by the route. Empty if the route
+	// matches any method.
+	Methods []string
+
+	// PathTemplate is the path template of the route, e.g.
+	// "/api/v{version:[^/]+}/widgets/{resource_id}".
+	PathTemplate string
+}
+
 // Address is the address and port to bind to (e.g. ":8080").
 type Address string
 
@@ -69,6 +99,242 @@ type Configuration struct {
 	Logger        StdLogger
 	GenerateDocs  bool
 	DocsDirectory string
+
+	// IndexTemplate and HandlerTemplate are mustache templates which replace the
+	// built-in templates for the generated index and resource documentation pages,
+	// e.g. to apply custom branding. They're rendered with the same context as the
+	// built-in templates. The built-in templates are used if they aren't set.
+	IndexTemplate   string
+	HandlerTemplate string
+
+	// GenerateOpenAPI causes an OpenAPI spec to be generated for each API version
+	// as openapi_v<version>.json in DocsDirectory, alongside or instead of the HTML
+	// documentation.
+	GenerateOpenAPI bool
+
+	// MaxQueryParams is the maximum number of query string values accepted on
+	// requests to resource endpoints. Requests exceeding it are rejected with a 400.
+	// Zero means unlimited.
+	MaxQueryParams int
+
+	// StrictVersioning causes requests to a resource whose Rules have no Rules
+	// applicable to the requested version to be rejected with a 404 instead of
+	// serving an empty resource. Resources without any Rules are unaffected.
+	StrictVersioning bool
+
+	// CreateReturnsLocationOnly causes successful creates to respond with a 201, a
+	// Location header, and no body when the handler provides an id for the created
+	// resource (see ResourceHandler.ResourceID).
+	CreateReturnsLocationOnly bool
+
+	// NilResultIsNotFound causes reads for which the ResourceHandler returns a nil
+	// resource without an error to respond with a 404 instead of a 200 with a null
+	// result.
+	NilResultIsNotFound bool
+
+	// CursorCodec, if set, allows list reads to pass the cursor in the X-Cursor
+	// header, encoded with it, as an alternative to the next query parameter, which
+	// takes precedence. This keeps URLs short when cursors hold substantial state,
+	// such as filters. The cursor for the next page is returned in the X-Cursor
+	// response header encoded the same way. See NewGzipCursorCodec.
+	CursorCodec CursorCodec
+
+	// DefaultLimit is the number of results fetched by list reads which don't
+	// specify a limit, unless the ResourceHandler provides its own. Defaults to 100.
+	DefaultLimit int
+
+	// RequestIDHeader is the header carrying the id correlating a request with its
+	// log lines. The id is read from the request, or generated if it's missing, and
+	// echoed back in the response. Defaults to X-Request-ID.
+	RequestIDHeader string
+
+	// MaxLimit caps the number of results fetched by list reads, unless the
+	// ResourceHandler provides its own cap. Larger requested limits are reduced to
+	// it. Zero means unlimited.
+	MaxLimit int
+
+	// RejectDuplicateKeys causes request payloads containing the same key more than
+	// once in an object to be rejected with a 400. By default, the last value wins.
+	RejectDuplicateKeys bool
+
+	// RejectEmptyBatch causes update list requests with an empty array payload to be
+	// rejected with a 400 instead of passing an empty slice to UpdateResourceList,
+	// which some ResourceHandlers may not treat as a no-op.
+	RejectEmptyBatch bool
+
+	// CollectAllValidationErrors causes every invalid or missing field in a request
+	// payload to be reported rather than only the first one encountered. The
+	// response is a 422 listing each failure under the "errors" key.
+	CollectAllValidationErrors bool
+
+	// ResourceNamedEnvelopes causes response envelopes to key results by resource
+	// name, e.g. "widget" for a single resource and "widgets" for a list, instead of
+	// "result" and "results". Collection names are derived using PluralName.
+	ResourceNamedEnvelopes bool
+
+	// Pluralize derives the collection name for a resource name. Defaults to
+	// appending "s".
+	Pluralize func(string) string
+
+	// IrregularPlurals maps resource names to collection names which Pluralize
+	// would not produce, e.g. "person" to "people".
+	IrregularPlurals map[string]string
+
+	// SlowRequestThreshold causes a warning to be logged for requests to resource
+	// endpoints which take longer than it to handle. Zero disables the warning.
+	SlowRequestThreshold time.Duration
+
+	// RequestBodyTransformer is applied to the raw body of create and update
+	// requests before it's decoded, e.g. to unwrap an envelope some clients send.
+	// If it returns an error, the request is rejected with a 400.
+	RequestBodyTransformer func([]byte) ([]byte, error)
+
+	// CORSPreflight causes an OPTIONS handler to be registered for each resource URI
+	// which answers CORS preflight requests with the methods registered at that URI,
+	// e.g. "GET, PUT, DELETE, OPTIONS" for the default read URI. Use it with a CORS
+	// Middleware which leaves preflight requests to the API, such as
+	// middleware.NewCORSPreflightMiddleware.
+	CORSPreflight bool
+
+	// BaseContext returns a context.Context whose values are reachable from the
+	// RequestContext of every request to a resource endpoint using Value, e.g. to
+	// share a database connection with ResourceHandlers. Values set on the request
+	// take precedence.
+	BaseContext func() context.Context
+
+	// EnableMethodOverride controls whether POST requests with an
+	// X-HTTP-Method-Override header are routed to the read, update, and delete
+	// handlers. Disable it where method tunneling is a concern. Defaults to true
+	// when nil.
+	EnableMethodOverride *bool
+
+	// AllowedContentTypes lists the media types accepted for requests with a body,
+	// e.g. "application/json". Requests with a body of any other type, or without a
+	// Content-Type, are rejected with a 415. Media type parameters such as charset
+	// are ignored. An empty list allows all content types.
+	AllowedContentTypes []string
+
+	// IncludeTiming causes responses from resource endpoints to report how long the
+	// server spent handling the request, measured up to serialization, in an
+	// X-Response-Time header (e.g. "12.345ms") and under "meta.duration_ms" in the
+	// response envelope.
+	IncludeTiming bool
+
+	// IncludeVersions causes responses from resource endpoints to list the versions
+	// the resource supports, its ValidVersions or else the versions named by its
+	// Rules, in an X-API-Versions header (e.g. "1, 2") and under "meta.versions" in
+	// the response envelope. Nothing is listed for resources which support any
+	// version.
+	IncludeVersions bool
+
+	// MaxNestingDepth is the maximum number of levels of nested Rules applied to
+	// resources in responses. Fields nested deeper are omitted, which guards against
+	// unbounded recursion through self-referential Rules on cyclic data. Defaults to
+	// 32. Negative values mean unlimited.
+	MaxNestingDepth int
+
+	// MediaTypeVersioning causes the version of requests whose path doesn't specify
+	// one to be read from the vendor media type in the Content-Type or Accept
+	// header, e.g. "2" for "application/vnd.myapi.v2+json". The path version always
+	// takes precedence. Responses list both headers in Vary.
+	MediaTypeVersioning bool
+
+	// FlagResolver resolves the feature flags read by ResourceHandlers using
+	// RequestContext.Flag. Each flag is resolved at most once per request.
+	FlagResolver FlagResolver
+
+	// MessageTranslator, if set, localizes response messages and status reasons,
+	// including validation messages, into the locale of the request given by
+	// RequestContext.Locale. Validation messages are identified by their FieldError
+	// Code, with the field and values they refer to as args, so they can be
+	// translated without parsing the English. Messages are sent in English by default.
+	MessageTranslator MessageTranslator
+
+	// AuditWrite, if set, is invoked after a successful create or update with the
+	// inbound payload, after Rules are applied, for building an audit trail. The
+	// values of fields whose Rules are Sensitive are masked. For update list
+	// requests, it's invoked once for each payload.
+	AuditWrite func(ctx RequestContext, resource string, method HandleMethod, changed Payload)
+
+	// MetricsObserver, if set, is invoked once the response to each request handled
+	// by a ResourceHandler has been sent, with metrics such as its status, latency,
+	// and request and response body sizes.
+	MetricsObserver MetricsObserver
+
+	// UnknownFormatBehavior determines how requests for a response format which
+	// isn't registered, using the format query parameter or the Accept header, are
+	// handled. Defaults to UnknownFormatError.
+	UnknownFormatBehavior UnknownFormatBehavior
+
+	// XMLResponses registers the ResponseSerializer returned by NewXMLSerializer
+	// under the "xml" format, so clients can request XML responses using
+	// ?format=xml.
+	XMLResponses bool
+
+	// CanceledStatus is the status of responses to requests for which the
+	// ResourceHandler returns an error wrapping context.Canceled, typically because
+	// the client disconnected. No body is written if the client is gone. Defaults to
+	// StatusClientClosedRequest (499).
+	CanceledStatus int
+
+	// DeadlineExceededStatus is the status of responses to requests for which the
+	// ResourceHandler returns an error wrapping context.DeadlineExceeded. Defaults to
+	// 503 Service Unavailable.
+	DeadlineExceededStatus int
+
+	// RequestTimeout, if positive, is the time ResourceHandlers have to handle a
+	// request. The RequestContext passed to the create, read, read list, read
+	// resources, update, update list, patch, and delete methods, and to BeforeHandle
+	// and AfterHandle, is canceled when it elapses. Event streams aren't limited.
+	// Handlers should observe the RequestContext's Done channel, e.g. by passing it to
+	// database queries. Requests which time out are answered with
+	// DeadlineExceededStatus as soon as it elapses, even if the ResourceHandler
+	// hasn't returned, and anything it writes afterward is discarded. Responses are
+	// buffered until the handler returns, so they aren't streamed.
+	RequestTimeout time.Duration
+
+	// IncludePanicDetails causes the 500 responses sent by RecoveryMiddleware to
+	// include the panic value and stack trace, e.g. during local development. It
+	// shouldn't be enabled in production since it exposes internals to clients.
+	// Otherwise a generic message is sent.
+	IncludePanicDetails bool
+}
+
+// UnknownFormatBehavior determines how requests for a response format which isn't
+// registered are handled.
+type UnknownFormatBehavior uint
+
+// UnknownFormatBehavior constants.
+const (
+	// UnknownFormatError rejects the request with a 400 Bad Request.
+	UnknownFormatError UnknownFormatBehavior = iota
+
+	// UnknownFormatFallbackToDefault serves the response as json, as if no format
+	// had been requested.
+	UnknownFormatFallbackToDefault
+)
+
+// MethodOverrideEnabled returns whether X-HTTP-Method-Override routes are registered,
+// which is the case unless EnableMethodOverride is set to false.
+func (c *Configuration) MethodOverrideEnabled() bool {
+	return c.EnableMethodOverride == nil || *c.EnableMethodOverride
+}
+
+// PluralName returns the collection name for the given resource name, using
+// IrregularPlurals if it contains the name and Pluralize otherwise.
+func (c *Configuration) PluralName(name string) string {
+	if plural, ok := c.IrregularPlurals[name]; ok {
+		return plural
+	}
+	if c.Pluralize != nil {
+		return c.Pluralize(name)
+	}
+	return defaultPluralize(name)
+}
+
+// defaultPluralize derives a collection name by appending "s" to the resource name.
+func defaultPluralize(name string) string {
+	return name + "s"
 }
 
 // Debugf prints the formatted string to the Configuration Logger if Debug is enabled.
@@ -90,10 +356,12 @@ func NewConfiguration() *Configuration {
 }
 
 // MiddlewareError is returned by Middleware to indicate that a request should
-// not be served.
+// not be served. Headers, if any, are added to the response, e.g. a Retry-After
+// header for a 429 Too Many Requests.
 type MiddlewareError struct {
 	Code     int
 	Response []byte
+	Headers  http.Header
 }
 
 // Middleware can be passed in to API#Start and API#StartTLS and will be
@@ -114,7 +382,14 @@ type middlewareProxy struct {
 func (m *middlewareProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	for _, middleware := range m.middleware {
 		if err := middleware(w, r); err != nil {
-			w.WriteHeader(err.Code)
+			for key, values := range err.Headers {
+				for _, value := range values {
+					w.Header().Add(key, value)
+				}
+			}
+			if err.Code != 0 {
+				w.WriteHeader(err.Code)
+			}
 			w.Write(err.Response)
 			return
 		}
@@ -136,9 +411,9 @@ type API interface {
 	http.Handler
 
 	// Start begins serving requests. This will block unless it fails, in which case an
-	// error will be returned. This will validate any defined Rules. If any Rules are
-	// invalid, it will panic. Any provided Middleware will be invoked for every request
-	// handled by the API.
+	// error will be returned, or the API is shut down, in which case nil is returned.
+	// This will validate any defined Rules. If any Rules are invalid, it will panic.
+	// Any provided Middleware will be invoked for every request handled by the API.
 	Start(Address, ...Middleware) error
 
 	// StartTLS begins serving requests received over HTTPS connections. This will block
@@ -147,9 +422,23 @@ type API interface {
 	// certificate is signed by a certificate authority, the certFile should be the
 	// concatenation of the server's certificate followed by the CA's certificate. This
 	// will validate any defined Rules. If any Rules are invalid, it will panic. Any
-	// provided Middleware will be invoked for every request handled by the API.
+	// provided Middleware will be invoked for every request handled by the API. Like
+	// Start, it returns nil once the API is shut down.
 	StartTLS(Address, FilePath, FilePath, ...Middleware) error
 
+	// Handler returns the http.Handler serving the API, wrapped with any provided
+	// Middleware, for mounting on a server the caller owns instead of using Start.
+	// Like Start, it validates any defined Rules, panicking if any are invalid, and
+	// generates documentation if configured to, only the first time the API is
+	// started or its Handler is requested.
+	Handler(...Middleware) http.Handler
+
+	// Shutdown gracefully shuts down the server started by Start or StartTLS, which
+	// stops accepting connections and waits for in-flight requests to complete until
+	// the context is done, in which case the context's error is returned. It returns
+	// nil if the server hasn't been started. It's safe to call concurrently.
+	Shutdown(context.Context) error
+
 	// RegisterResourceHandler binds the provided ResourceHandler to the appropriate REST
 	// endpoints and applies any specified middleware. Endpoints will have the following
 	// base URL: /api/:version/resourceName.
@@ -175,24 +464,98 @@ type API interface {
 	// format. If the format hasn't been registered, this is a no-op.
 	UnregisterResponseSerializer(string)
 
+	// RegisterFormatAliases registers aliases for the given format, such as MIME types,
+	// which resolve to the ResponseSerializer registered with the format. Formats and
+	// aliases are matched case-insensitively, ignoring parameters such as
+	// "; charset=utf-8". By default, "application/json" and "text/json" are aliases
+	// for "json".
+	RegisterFormatAliases(string, ...string)
+
 	// AvailableFormats returns a slice containing all of the available serialization
 	// formats currently available.
 	AvailableFormats() []string
 
+	// SnapshotSerializers returns a copy of the currently registered ResponseSerializers
+	// keyed by format. The returned map can later be passed to RestoreSerializers.
+	SnapshotSerializers() map[string]ResponseSerializer
+
+	// RestoreSerializers replaces the registered ResponseSerializers with the provided
+	// ones, discarding any formats registered since the snapshot was taken.
+	RestoreSerializers(map[string]ResponseSerializer)
+
+	// SnapshotFormatAliases returns a copy of the currently registered format aliases,
+	// normalized, mapped to their format. The returned map can later be passed to
+	// RestoreFormatAliases.
+	SnapshotFormatAliases() map[string]string
+
+	// RestoreFormatAliases replaces the registered format aliases with the provided
+	// ones, discarding any aliases registered since the snapshot was taken.
+	RestoreFormatAliases(map[string]string)
+
+	// RegisterTypeRules registers Rules to apply to resources of the Rules' resource
+	// type in list responses instead of the ResourceHandler's Rules. This allows lists
+	// to contain resources of different types, each serialized by their own Rules. If
+	// Rules have already been registered for the type, they will be overwritten.
+	RegisterTypeRules(Rules)
+
+	// RegisterTypeSerializer registers a TypeSerializer for the type of the provided
+	// value. When outbound Rules build a response payload, field values of that type,
+	// or pointers to it, are replaced with the TypeSerializer's output unless the Rule
+	// specifies an OutputHandler. If a TypeSerializer has already been registered for
+	// the type, it will be overwritten.
+	RegisterTypeSerializer(interface{}, TypeSerializer)
+
+	// UnregisterTypeSerializer unregisters the TypeSerializer for the type of the
+	// provided value. If one hasn't been registered, this is a no-op.
+	UnregisterTypeSerializer(interface{})
+
 	// Configuration returns the API Configuration.
 	Configuration() *Configuration
 
 	// ResourceHandlers returns a slice containing the registered ResourceHandlers.
 	ResourceHandlers() []ResourceHandler
 
+	// Routes returns a RouteInfo describing each registered route in the order they
+	// were registered.
+	Routes() []RouteInfo
+
+	// RouteHandler returns the http.Handler for the route with the given name, e.g.
+	// "widgets:read", or an error if there is no such route.
+	RouteHandler(string) (http.Handler, error)
+
 	// Validate will validate the Rules configured for this API. It returns nil
 	// if all Rules are valid, otherwise returns the first encountered
-	// validation error.
+	// validation error. With ResourceNamedEnvelopes, resource and collection
+	// names which collide with reserved envelope keys are invalid.
 	Validate() error
 
+	// ValidateDocs performs a dry run of documentation generation, parsing the
+	// templates and generating the documentation context for each ResourceHandler
+	// and version without writing any files. It returns nil if the documentation
+	// can be generated, otherwise returns the first encountered error.
+	ValidateDocs() error
+
 	// responseSerializer returns a ResponseSerializer for the given format type. If the
 	// format is not implemented, the returned serializer will be nil and the error set.
 	responseSerializer(string) (ResponseSerializer, error)
+
+	// acceptedFormat returns the registered format preferred by the given Accept
+	// header values, or an empty string if they don't express a preference. An error
+	// is returned if none of the media types they accept are registered.
+	acceptedFormat([]string) (string, error)
+
+	// errorFormat returns the registered format preferred by the given Accept header
+	// values, ignoring wildcard ranges, for sending errors about formats which aren't
+	// available. It falls back to "json" if none of the media types they accept are
+	// registered.
+	errorFormat([]string) string
+
+	// outboundRules returns the Rules to apply to a resource in a list response,
+	// which are those registered for its type, falling back to the provided Rules.
+	outboundRules(Resource, Rules) Rules
+
+	// typeSerializer returns the TypeSerializer registered for the type, if any.
+	typeSerializer(reflect.Type) (TypeSerializer, bool)
 }
 
 // RequestMiddleware is a function that returns a Handler wrapping the provided Handler.
@@ -200,9 +563,15 @@ type API interface {
 type RequestMiddleware func(http.Handler) http.Handler
 
 // newAuthMiddleware returns a RequestMiddleware used to authenticate requests.
-func newAuthMiddleware(authenticate func(*http.Request) error) RequestMiddleware {
+// Requests routed to one of the exempt HandleMethods are not authenticated.
+func newAuthMiddleware(authenticate func(*http.Request) error,
+	exempt []HandleMethod) RequestMiddleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isExemptMethod(r, exempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
 			if err := authenticate(r); err != nil {
 				w.WriteHeader(http.StatusUnauthorized)
 				w.Write([]byte(err.Error()))
@@ -213,11 +582,42 @@ func newAuthMiddleware(authenticate func(*http.Request) error) RequestMiddleware
 	}
 }
 
+// isExemptMethod returns true if the request was routed to one of the given
+// HandleMethods. X-HTTP-Method-Override routes match the method they override.
+func isExemptMethod(r *http.Request, exempt []HandleMethod) bool {
+	if len(exempt) == 0 {
+		return false
+	}
+
+	_, method := currentRoute(r)
+	for _, m := range exempt {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// routeVars returns the route variables of the request, unescaped. The router matches
+// the escaped path so escaped slashes, e.g. in ids built by BuildURL, stay within
+// their variable. Values which aren't validly escaped are returned as is.
+func routeVars(r *http.Request) map[string]string {
+	vars := mux.Vars(r)
+	unescaped := make(map[string]string, len(vars))
+	for key, value := range vars {
+		if v, err := url.PathUnescape(value); err == nil {
+			value = v
+		}
+		unescaped[key] = value
+	}
+	return unescaped
+}
+
 // newVersionMiddleware checks the request version against all valid versions.
 func newVersionMiddleware(validVersions []string) RequestMiddleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			requestVersion := mux.Vars(r)["version"]
+			requestVersion := routeVars(r)[versionKey]
 
 			for _, v := range validVersions {
 				if requestVersion == v {
@@ -232,6 +632,209 @@ func newVersionMiddleware(validVersions []string) RequestMiddleware {
 	}
 }
 
+// newStrictVersionMiddleware rejects requests for a version which none of the
+// handler's Rules apply to. Handlers without Rules are not versioned and are always
+// served.
+func newStrictVersionMiddleware(handler ResourceHandler) RequestMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestVersion := routeVars(r)[versionKey]
+			rules := handler.Rules()
+
+			if rules != nil && rules.Size() > 0 && rules.ForVersion(requestVersion).Size() == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(fmt.Sprintf("Resource %q is not available in version %q.",
+					handler.ResourceName(), requestVersion)))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// mediaTypeVersionPattern matches vendor media types carrying a version, e.g.
+// "application/vnd.myapi.v2+json", capturing the version.
+var mediaTypeVersionPattern = regexp.MustCompile(`(?i)^[a-z]+/vnd\.(?:[^+]*\.)?v(\d[^+]*)(?:\+.*)?$`)
+
+// newMediaTypeVersionMiddleware sets the version of requests whose path doesn't
+// specify one to the version of their vendor media type, if any.
+func newMediaTypeVersionMiddleware() RequestMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			vars := mux.Vars(r)
+			if vars[versionKey] == "" {
+				if version := mediaTypeVersion(r); version != "" {
+					withVersion := map[string]string{versionKey: version}
+					for key, value := range vars {
+						if key != versionKey {
+							withVersion[key] = value
+						}
+					}
+					r = mux.SetURLVars(r, withVersion)
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// mediaTypeVersion returns the version of the vendor media type in the request's
+// Content-Type header, falling back to the first one in its Accept header, or an
+// empty string if there isn't one.
+func mediaTypeVersion(r *http.Request) string {
+	mediaTypes := []string{r.Header.Get("Content-Type")}
+	for _, accept := range r.Header["Accept"] {
+		mediaTypes = append(mediaTypes, strings.Split(accept, ",")...)
+	}
+
+	for _, mediaType := range mediaTypes {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaType))
+		if err != nil {
+			continue
+		}
+		if match := mediaTypeVersionPattern.FindStringSubmatch(mediaType); match != nil {
+			return match[1]
+		}
+	}
+
+	return ""
+}
+
+// newQueryLimitMiddleware rejects requests whose query string contains more than
+// maxParams values.
+func newQueryLimitMiddleware(maxParams int) RequestMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count := 0
+			for _, values := range r.URL.Query() {
+				count += len(values)
+			}
+
+			if count > maxParams {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf(
+					"Too many query parameters: %d exceeds the maximum of %d.", count, maxParams)))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// newContentTypeMiddleware returns a RequestMiddleware which rejects requests with a
+// body whose media type isn't one of the allowed types.
+func newContentTypeMiddleware(allowed []string) RequestMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			contentType := r.Header.Get("Content-Type")
+			if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+				for _, a := range allowed {
+					if strings.EqualFold(mediaType, a) {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			w.Write([]byte(fmt.Sprintf("Unsupported content type: %s", contentType)))
+		})
+	}
+}
+
+// RecoveryMiddleware returns a RequestMiddleware which recovers from panics in the
+// handler chain it wraps, logs them to the Configuration Logger, or the standard
+// logger if there isn't one, and responds with a 500 Internal Server Error in the
+// JSON error envelope. The panic value and stack trace are included in the response
+// only if IncludePanicDetails is enabled. If the response was already started, the connection is
+// aborted instead, as it is for http.ErrAbortHandler panics, which are propagated.
+// RegisterResourceHandler applies it to every resource handler.
+func RecoveryMiddleware(config *Configuration) RequestMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writer := &recoveryWriter{ResponseWriter: w}
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+
+				trace := debug.Stack()
+				logf := log.Printf
+				if config.Logger != nil {
+					logf = config.Logger.Printf
+				}
+				logf("Recovered from panic serving %s %s: %v\n%s", r.Method, r.URL.Path, p, trace)
+				if writer.started {
+					// Another response can't be written, so abort the connection.
+					panic(http.ErrAbortHandler)
+				}
+
+				s := http.StatusInternalServerError
+				message := "An unexpected error occurred."
+				payload := Payload{status: s, reason: http.StatusText(s)}
+				if config.IncludePanicDetails {
+					message = fmt.Sprintf("Unexpected error: %v", p)
+					payload[stack] = string(trace)
+				}
+				payload[messages] = []interface{}{message}
+
+				body, _ := json.Marshal(payload)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(s)
+				w.Write(body)
+			}()
+
+			next.ServeHTTP(writer, r)
+		})
+	}
+}
+
+// recoveryWriter tracks whether the response has been started, i.e. whether its
+// status has been written, so RecoveryMiddleware doesn't write a second response.
+type recoveryWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+// WriteHeader writes the response status.
+func (w *recoveryWriter) WriteHeader(status int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the data to the response, writing a 200 status first if a status
+// hasn't been written.
+func (w *recoveryWriter) Write(data []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush sends any buffered data to the client if the wrapped ResponseWriter supports
+// it.
+func (w *recoveryWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.started = true
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped ResponseWriter for use with http.ResponseController.
+func (w *recoveryWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // muxAPI is an implementation of the API interface which relies on the gorilla/mux
 // package to handle request dispatching (see http://www.gorillatoolkit.org/pkg/mux).
 type muxAPI struct {
@@ -240,17 +843,35 @@ type muxAPI struct {
 	mu                 sync.RWMutex
 	handler            *requestHandler
 	serializerRegistry map[string]ResponseSerializer
+	formatAliases      map[string]string
+	typeRules          map[reflect.Type]Rules
+	typeSerializers    map[reflect.Type]TypeSerializer
 	resourceHandlers   []ResourceHandler
+	server             *http.Server
+	preprocessOnce     sync.Once
 }
 
 // NewAPI returns a newly allocated API instance.
 func NewAPI(config *Configuration) API {
 	r := mux.NewRouter()
+	// Match the escaped path so ids containing escaped slashes are routed.
+	r.UseEncodedPath()
 	restAPI := &muxAPI{
 		config:             config,
 		router:             r,
 		serializerRegistry: map[string]ResponseSerializer{"json": &jsonSerializer{}},
-		resourceHandlers:   make([]ResourceHandler, 0),
+		formatAliases: map[string]string{
+			"application/json": "json",
+			"text/json":        "json",
+			"application/xml":  "xml",
+			"text/xml":         "xml",
+		},
+		typeRules:        map[reflect.Type]Rules{},
+		typeSerializers:  map[reflect.Type]TypeSerializer{},
+		resourceHandlers: make([]ResourceHandler, 0),
+	}
+	if config.XMLResponses {
+		restAPI.serializerRegistry["xml"] = xmlSerializer{}
 	}
 	restAPI.handler = &requestHandler{restAPI, r}
 	return restAPI
@@ -259,8 +880,7 @@ func NewAPI(config *Configuration) API {
 // Start begins serving requests. This will block unless it fails, in which case an error will be
 // returned.
 func (r *muxAPI) Start(addr Address, middleware ...Middleware) error {
-	r.preprocess()
-	return http.ListenAndServe(string(addr), wrapMiddleware(r.router, middleware...))
+	return serverError(r.newServer(addr, r.Handler(middleware...)).ListenAndServe())
 }
 
 // StartTLS begins serving requests received over HTTPS connections. This will block unless it
@@ -269,19 +889,65 @@ func (r *muxAPI) Start(addr Address, middleware ...Middleware) error {
 // authority, the certFile should be the concatenation of the server's certificate followed by
 // the CA's certificate.
 func (r *muxAPI) StartTLS(addr Address, certFile, keyFile FilePath, middleware ...Middleware) error {
+	server := r.newServer(addr, r.Handler(middleware...))
+	return serverError(server.ListenAndServeTLS(string(certFile), string(keyFile)))
+}
+
+// Handler returns the http.Handler serving the API, wrapped with the Middleware,
+// preprocessing the API the first time it's called.
+func (r *muxAPI) Handler(middleware ...Middleware) http.Handler {
 	r.preprocess()
-	return http.ListenAndServeTLS(string(addr), string(certFile), string(keyFile), wrapMiddleware(r.router, middleware...))
+	return wrapMiddleware(r.router, middleware...)
+}
+
+// newServer returns an *http.Server serving the handler on the address and registers
+// it so it can be shut down using Shutdown.
+func (r *muxAPI) newServer(addr Address, handler http.Handler) *http.Server {
+	server := &http.Server{Addr: string(addr), Handler: handler}
+	r.mu.Lock()
+	r.server = server
+	r.mu.Unlock()
+	return server
+}
+
+// serverError returns the error returned by a server once it stops serving, or nil if
+// it was shut down.
+func serverError(err error) error {
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown gracefully shuts down the server started by Start or StartTLS, waiting for
+// in-flight requests to complete until the context is done.
+func (r *muxAPI) Shutdown(ctx context.Context) error {
+	r.mu.RLock()
+	server := r.server
+	r.mu.RUnlock()
+
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
 }
 
 // preprocess performs any necessary preprocessing before the server can be started, including
-// Rule validation.
+// Rule validation. It only does so the first time it's called.
 func (r *muxAPI) preprocess() {
-	r.validateRulesOrPanic()
-	if r.config.GenerateDocs {
-		if err := newDocGenerator().generateDocs(r); err != nil {
-			log.Printf("documentation could not be generated: %v", err)
+	r.preprocessOnce.Do(func() {
+		r.validateRulesOrPanic()
+		if r.config.GenerateDocs {
+			if err := newDocGenerator(r.Configuration().PluralName, nil).generateDocs(r); err != nil {
+				log.Printf("documentation could not be generated: %v", err)
+			}
 		}
-	}
+		if r.config.GenerateOpenAPI {
+			if err := newOpenAPIGenerator().generateSpecs(r); err != nil {
+				log.Printf("OpenAPI spec could not be generated: %v", err)
+			}
+		}
+	})
 }
 
 // Check the route for an error and log the error if it exists.
@@ -298,19 +964,111 @@ func (r *muxAPI) checkRoute(handler, method, uri string, route *mux.Route) {
 // RegisterResourceHandler binds the provided ResourceHandler to the appropriate REST endpoints and
 // applies any specified middleware. Endpoints will have the following base URL:
 // /api/:version/resourceName.
-func (r *muxAPI) RegisterResourceHandler(h ResourceHandler, middleware ...RequestMiddleware) {
-	h = resourceHandlerProxy{h}
+func (r *muxAPI) RegisterResourceHandler(handler ResourceHandler, middleware ...RequestMiddleware) {
+	stream, isStream := handler.(EventStream)
+	h := resourceHandlerProxy{handler}
 	resource := h.ResourceName()
-	middleware = append(middleware, newAuthMiddleware(h.Authenticate))
+	middleware = append(middleware, newAuthMiddleware(h.Authenticate, h.AuthExemptMethods()))
 	if validVersions := h.ValidVersions(); validVersions != nil {
 		middleware = append(middleware, newVersionMiddleware(validVersions))
 	}
+	if r.config.StrictVersioning {
+		middleware = append(middleware, newStrictVersionMiddleware(h))
+	}
+	if maxParams := r.config.MaxQueryParams; maxParams > 0 {
+		middleware = append(middleware, newQueryLimitMiddleware(maxParams))
+	}
+	if allowed := r.config.AllowedContentTypes; len(allowed) > 0 {
+		middleware = append(middleware, newContentTypeMiddleware(allowed))
+	}
+	if r.config.MediaTypeVersioning {
+		// Resolve the version before any middleware which depends on it.
+		middleware = append(middleware, newMediaTypeVersionMiddleware())
+	}
+	if r.config.RequestTimeout > 0 {
+		middleware = append(middleware, r.handler.timeout(h))
+	}
+	// BeforeHandle runs after every other middleware, just before the handler.
+	middleware = append([]RequestMiddleware{r.handler.beforeHandle(h)}, middleware...)
+	// Recovery runs before every other middleware so it covers the whole chain.
+	middleware = append(middleware, RecoveryMiddleware(r.config))
+
+	if r.config.MethodOverrideEnabled() {
+		r.registerMethodOverrideHandlers(h, middleware)
+	}
+
+	// These return a Route which has a GetError command. Probably should check
+	// that and log it if it fails :)
+	route := r.router.Handle(
+		h.CreateURI(), applyMiddleware(r.handler.handleCreate(h), middleware),
+	).Methods("POST").Name(resource + ":" + string(HandleCreate))
+	r.checkRoute("create", h.CreateURI(), "POST", route)
+
+	route = r.router.Handle(
+		h.ReadListURI(), applyMiddleware(r.handler.handleReadResources(h), middleware),
+	).Methods("GET").MatcherFunc(hasQueryParam(idsKey)).Name(resource + ":" + string(HandleReadResources))
+	r.checkRoute("read resources", h.ReadListURI(), "GET", route)
+
+	if isStream {
+		route = r.router.Handle(
+			h.ReadListURI(), applyMiddleware(r.handler.handleEvents(h, stream), middleware),
+		).Methods("GET").MatcherFunc(acceptsEventStream).Name(resource + ":" + HandleEvents)
+		r.checkRoute("events", h.ReadListURI(), "GET", route)
+	}
+
+	route = r.router.Handle(
+		h.ReadListURI(), applyMiddleware(r.handler.handleReadList(h), middleware),
+	).Methods("GET").Name(resource + ":" + string(HandleReadList))
+	r.checkRoute("read list", h.ReadListURI(), "GET", route)
+
+	route = r.router.Handle(
+		h.ReadURI(), applyMiddleware(r.handler.handleRead(h), middleware),
+	).Methods("GET").Name(resource + ":" + string(HandleRead))
+	r.checkRoute("read", h.ReadURI(), "GET", route)
+
+	route = r.router.Handle(
+		h.UpdateListURI(), applyMiddleware(r.handler.handleUpdateList(h), middleware),
+	).Methods("PUT").Name(resource + ":" + string(HandleUpdateList))
+	r.checkRoute("update list", h.UpdateListURI(), "PUT", route)
+
+	route = r.router.Handle(
+		h.UpdateURI(), applyMiddleware(r.handler.handleUpdate(h), middleware),
+	).Methods("PUT").Name(resource + ":" + string(HandleUpdate))
+	r.checkRoute("update", h.UpdateURI(), "PUT", route)
+
+	route = r.router.Handle(
+		h.PatchURI(), applyMiddleware(r.handler.handlePatch(h), middleware),
+	).Methods("PATCH").Name(resource + ":" + string(HandlePatch))
+	r.checkRoute("patch", h.PatchURI(), "PATCH", route)
+
+	route = r.router.Handle(
+		h.DeleteURI(), applyMiddleware(r.handler.handleDelete(h), middleware),
+	).Methods("DELETE").Name(resource + ":" + string(HandleDelete))
+	r.checkRoute("delete", h.DeleteURI(), "DELETE", route)
+
+	if r.config.CORSPreflight {
+		r.registerPreflightHandlers(h)
+	}
 
-	// Some browsers don't support PUT and DELETE, so allow method overriding.
-	// POST requests with X-HTTP-Method-Override=PUT/DELETE will route to the
-	// respective handlers.
+	r.resourceHandlers = append(r.resourceHandlers, h)
+}
+
+// registerMethodOverrideHandlers binds the ResourceHandler's read, update, patch, and
+// delete endpoints to POST requests with an X-HTTP-Method-Override header. Some browsers
+// don't support PUT, PATCH, and DELETE, so this allows method overriding: POST requests
+// with X-HTTP-Method-Override=PUT/PATCH/DELETE will route to the respective handlers.
+func (r *muxAPI) registerMethodOverrideHandlers(h resourceHandlerProxy, middleware []RequestMiddleware) {
+	resource := h.ResourceName()
 
+	// Reads with an ids query parameter on the read list URI route to
+	// ReadResources, so they must be registered before the read list routes.
 	route := r.router.Handle(
+		h.ReadListURI(), applyMiddleware(r.handler.handleReadResources(h), middleware),
+	).Methods("POST").Headers("X-HTTP-Method-Override", "GET").MatcherFunc(hasQueryParam(idsKey)).
+		Name(resource + ":readResourcesOverride")
+	r.checkRoute("read resources override", h.ReadListURI(), "OVERRIDE-GET", route)
+
+	route = r.router.Handle(
 		h.ReadListURI(), applyMiddleware(r.handler.handleReadList(h), middleware),
 	).Methods("POST").Headers("X-HTTP-Method-Override", "GET").Name(resource + ":readListOverride")
 	r.checkRoute("read list override", h.ReadListURI(), "OVERRIDE-GET", route)
@@ -330,44 +1088,70 @@ func (r *muxAPI) RegisterResourceHandler(h ResourceHandler, middleware ...Reques
 	).Methods("POST").Headers("X-HTTP-Method-Override", "PUT").Name(resource + ":updateOverride")
 	r.checkRoute("update override", h.UpdateURI(), "OVERRIDE-PUT", route)
 
+	route = r.router.Handle(
+		h.PatchURI(), applyMiddleware(r.handler.handlePatch(h), middleware),
+	).Methods("POST").Headers("X-HTTP-Method-Override", "PATCH").Name(resource + ":patchOverride")
+	r.checkRoute("patch override", h.PatchURI(), "OVERRIDE-PATCH", route)
+
 	route = r.router.Handle(
 		h.DeleteURI(), applyMiddleware(r.handler.handleDelete(h), middleware),
 	).Methods("POST").Headers("X-HTTP-Method-Override", "DELETE").Name(resource + ":deleteOverride")
 	r.checkRoute("delete override", h.DeleteURI(), "OVERRIDE-DELETE", route)
+}
 
-	// These return a Route which has a GetError command. Probably should check
-	// that and log it if it fails :)
-	r.router.Handle(
-		h.CreateURI(), applyMiddleware(r.handler.handleCreate(h), middleware),
-	).Methods("POST").Name(resource + ":" + string(HandleCreate))
-	r.checkRoute("create", h.CreateURI(), "POST", route)
-
-	r.router.Handle(
-		h.ReadListURI(), applyMiddleware(r.handler.handleReadList(h), middleware),
-	).Methods("GET").Name(resource + ":" + string(HandleReadList))
-	r.checkRoute("read list", h.ReadListURI(), "GET", route)
-
-	r.router.Handle(
-		h.ReadURI(), applyMiddleware(r.handler.handleRead(h), middleware),
-	).Methods("GET").Name(resource + ":" + string(HandleRead))
-	r.checkRoute("read", h.ReadURI(), "GET", route)
-
-	r.router.Handle(
-		h.UpdateListURI(), applyMiddleware(r.handler.handleUpdateList(h), middleware),
-	).Methods("PUT").Name(resource + ":" + string(HandleUpdateList))
-	r.checkRoute("update list", h.UpdateListURI(), "PUT", route)
+// registerPreflightHandlers binds an OPTIONS handler to each of the ResourceHandler's
+// URIs which answers CORS preflight requests with the methods registered at that URI.
+func (r *muxAPI) registerPreflightHandlers(h resourceHandlerProxy) {
+	uris := []string{}
+	methods := map[string][]string{}
+	// Each preflight route is named after the first HandleMethod registered at its URI
+	// so that names are unique, e.g. "widgets:readListPreflight".
+	names := map[string]string{}
+	for _, endpoint := range []struct {
+		uri    string
+		method string
+		handle HandleMethod
+	}{
+		{h.ReadListURI(), "GET", HandleReadList},
+		{h.ReadURI(), "GET", HandleRead},
+		{h.CreateURI(), "POST", HandleCreate},
+		{h.UpdateListURI(), "PUT", HandleUpdateList},
+		{h.UpdateURI(), "PUT", HandleUpdate},
+		{h.PatchURI(), "PATCH", HandlePatch},
+		{h.DeleteURI(), "DELETE", HandleDelete},
+	} {
+		if _, ok := methods[endpoint.uri]; !ok {
+			uris = append(uris, endpoint.uri)
+			names[endpoint.uri] = h.ResourceName() + ":" + string(endpoint.handle) + "Preflight"
+		}
+		methods[endpoint.uri] = append(methods[endpoint.uri], endpoint.method)
+	}
 
-	r.router.Handle(
-		h.UpdateURI(), applyMiddleware(r.handler.handleUpdate(h), middleware),
-	).Methods("PUT").Name(resource + ":" + string(HandleUpdate))
-	r.checkRoute("update", h.UpdateURI(), "PUT", route)
+	for _, uri := range uris {
+		allowed := strings.Join(append(methods[uri], "OPTIONS"), ", ")
+		route := r.router.Handle(uri, newPreflightHandler(allowed)).Methods("OPTIONS").
+			Name(names[uri])
+		r.checkRoute("preflight", uri, "OPTIONS", route)
+	}
+}
 
-	r.router.Handle(
-		h.DeleteURI(), applyMiddleware(r.handler.handleDelete(h), middleware),
-	).Methods("DELETE").Name(resource + ":" + string(HandleDelete))
-	r.checkRoute("delete", h.DeleteURI(), "DELETE", route)
+// newPreflightHandler returns an http.Handler which answers CORS preflight requests,
+// allowing the given comma-separated methods.
+func newPreflightHandler(allowed string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allowed)
+		w.Header().Set("Access-Control-Allow-Methods", allowed)
+		w.WriteHeader(http.StatusOK)
+	})
+}
 
-	r.resourceHandlers = append(r.resourceHandlers, h)
+// hasQueryParam returns a mux.MatcherFunc which matches requests that have the given
+// query string parameter.
+func hasQueryParam(key string) mux.MatcherFunc {
+	return func(r *http.Request, rm *mux.RouteMatch) bool {
+		_, ok := r.URL.Query()[key]
+		return ok
+	}
 }
 
 // RegisterHandlerFunc binds the http.HandlerFunc to the provided URI and applies any
@@ -403,6 +1187,58 @@ func (r *muxAPI) RegisterResponseSerializer(format string, serializer ResponseSe
 	r.serializerRegistry[format] = serializer
 }
 
+// RegisterTypeRules registers Rules to apply to resources of the Rules' resource type in
+// list responses instead of the ResourceHandler's Rules. If Rules have already been
+// registered for the type, they will be overwritten.
+func (r *muxAPI) RegisterTypeRules(rules Rules) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.typeRules[rules.ResourceType()] = rules
+}
+
+// outboundRules returns the Rules to apply to a resource in a list response, which are
+// those registered for its type, falling back to the provided Rules.
+func (r *muxAPI) outboundRules(resource Resource, fallback Rules) Rules {
+	if isNil(resource) {
+		return fallback
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	resourceType := reflect.Indirect(reflect.ValueOf(resource)).Type()
+	if rules, ok := r.typeRules[resourceType]; ok {
+		return rules
+	}
+	return fallback
+}
+
+// RegisterTypeSerializer registers a TypeSerializer for the type of the provided value.
+// When outbound Rules build a response payload, field values of that type, or pointers
+// to it, are replaced with the TypeSerializer's output unless the Rule specifies an
+// OutputHandler. If a TypeSerializer has already been registered for the type, it will
+// be overwritten.
+func (r *muxAPI) RegisterTypeSerializer(value interface{}, serializer TypeSerializer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.typeSerializers[reflect.TypeOf(value)] = serializer
+}
+
+// UnregisterTypeSerializer unregisters the TypeSerializer for the type of the provided
+// value. If one hasn't been registered, this is a no-op.
+func (r *muxAPI) UnregisterTypeSerializer(value interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.typeSerializers, reflect.TypeOf(value))
+}
+
+// typeSerializer returns the TypeSerializer registered for the type, if any.
+func (r *muxAPI) typeSerializer(t reflect.Type) (TypeSerializer, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	serializer, ok := r.typeSerializers[t]
+	return serializer, ok
+}
+
 // UnregisterResponseSerializer unregisters the ResponseSerializer with the provided format. If the
 // format hasn't been registered, this is a no-op.
 func (r *muxAPI) UnregisterResponseSerializer(format string) {
@@ -411,6 +1247,18 @@ func (r *muxAPI) UnregisterResponseSerializer(format string) {
 	delete(r.serializerRegistry, format)
 }
 
+// RegisterFormatAliases registers aliases for the given format, such as MIME types,
+// which resolve to the ResponseSerializer registered with the format. Formats and
+// aliases are matched case-insensitively, ignoring parameters such as
+// "; charset=utf-8".
+func (r *muxAPI) RegisterFormatAliases(format string, aliases ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, alias := range aliases {
+		r.formatAliases[normalizeFormat(alias)] = format
+	}
+}
+
 // AvailableFormats returns a slice containing all of the available serialization formats
 // currently available.
 func (r *muxAPI) AvailableFormats() []string {
@@ -424,6 +1272,55 @@ func (r *muxAPI) AvailableFormats() []string {
 	return formats
 }
 
+// SnapshotSerializers returns a copy of the currently registered ResponseSerializers
+// keyed by format. The returned map can later be passed to RestoreSerializers.
+func (r *muxAPI) SnapshotSerializers() map[string]ResponseSerializer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	snapshot := make(map[string]ResponseSerializer, len(r.serializerRegistry))
+	for format, serializer := range r.serializerRegistry {
+		snapshot[format] = serializer
+	}
+	return snapshot
+}
+
+// RestoreSerializers replaces the registered ResponseSerializers with the provided
+// ones, discarding any formats registered since the snapshot was taken.
+func (r *muxAPI) RestoreSerializers(serializers map[string]ResponseSerializer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	registry := make(map[string]ResponseSerializer, len(serializers))
+	for format, serializer := range serializers {
+		registry[format] = serializer
+	}
+	r.serializerRegistry = registry
+}
+
+// SnapshotFormatAliases returns a copy of the currently registered format aliases,
+// normalized, mapped to their format. The returned map can later be passed to
+// RestoreFormatAliases.
+func (r *muxAPI) SnapshotFormatAliases() map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	snapshot := make(map[string]string, len(r.formatAliases))
+	for alias, format := range r.formatAliases {
+		snapshot[alias] = format
+	}
+	return snapshot
+}
+
+// RestoreFormatAliases replaces the registered format aliases with the provided ones,
+// discarding any aliases registered since the snapshot was taken.
+func (r *muxAPI) RestoreFormatAliases(aliases map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	restored := make(map[string]string, len(aliases))
+	for alias, format := range aliases {
+		restored[alias] = format
+	}
+	r.formatAliases = restored
+}
+
 // ResourceHandlers returns a slice containing the registered ResourceHandlers.
 func (r *muxAPI) ResourceHandlers() []ResourceHandler {
 	return r.resourceHandlers
@@ -434,11 +1331,51 @@ func (r *muxAPI) Configuration() *Configuration {
 	return r.config
 }
 
+// Routes returns a RouteInfo describing each registered route in the order they
+// were registered.
+func (r *muxAPI) Routes() []RouteInfo {
+	routes := []RouteInfo{}
+	r.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			// Routes without a path, e.g. a catch-all, have no template.
+			path = ""
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{}
+		}
+		routes = append(routes, RouteInfo{
+			Name:         route.GetName(),
+			Methods:      methods,
+			PathTemplate: path,
+		})
+		return nil
+	})
+	return routes
+}
+
+// RouteHandler returns the http.Handler for the route with the given name, e.g.
+// "widgets:read", or an error if there is no such route.
+func (r *muxAPI) RouteHandler(name string) (http.Handler, error) {
+	route := r.router.Get(name)
+	if route == nil {
+		return nil, fmt.Errorf("No API route with name %s", name)
+	}
+
+	return route.GetHandler(), nil
+}
+
 // Validate will validate the Rules configured for this API. It returns nil if
 // all Rules are valid, otherwise returns the first encountered validation
-// error.
+// error. With ResourceNamedEnvelopes, resource and collection names which collide
+// with reserved envelope keys, such as "next" or "status", are invalid.
 func (r *muxAPI) Validate() error {
 	for _, handler := range r.resourceHandlers {
+		if err := r.validateEnvelopeKeys(handler.ResourceName()); err != nil {
+			return err
+		}
+
 		rules := handler.Rules()
 		if rules == nil || rules.Size() == 0 {
 			continue
@@ -448,9 +1385,41 @@ func (r *muxAPI) Validate() error {
 			return err
 		}
 	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rules := range r.typeRules {
+		if err := rules.Validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// validateEnvelopeKeys returns an error if ResourceNamedEnvelopes is enabled and the
+// resource name or its collection name is a reserved envelope key.
+func (r *muxAPI) validateEnvelopeKeys(name string) error {
+	if !r.config.ResourceNamedEnvelopes {
+		return nil
+	}
+	for _, key := range []string{name, r.config.PluralName(name)} {
+		for _, reserved := range reservedEnvelopeKeys {
+			if key == reserved {
+				return fmt.Errorf("Envelope key '%s' for resource '%s' is reserved", key, name)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateDocs performs a dry run of documentation generation, parsing the templates
+// and generating the documentation context for each ResourceHandler and version
+// without writing any files. It returns nil if the documentation can be generated,
+// otherwise returns the first encountered error.
+func (r *muxAPI) ValidateDocs() error {
+	return newDocGenerator(r.Configuration().PluralName, nil).validateDocs(r)
+}
+
 // validateRulesOrPanic verifies that the Rules for each ResourceHandler
 // registered with the muxAPI are valid, meaning they specify fields that exist
 // and correct types. If a Rule is invalid, this will panic.
@@ -468,9 +1437,135 @@ func (r *muxAPI) responseSerializer(format string) (ResponseSerializer, error) {
 	if serializer, ok := r.serializerRegistry[format]; ok {
 		return serializer, nil
 	}
+
+	normalized := normalizeFormat(format)
+	if alias, ok := r.formatAliases[normalized]; ok {
+		normalized = alias
+	}
+	for registered, serializer := range r.serializerRegistry {
+		if strings.EqualFold(registered, normalized) {
+			return serializer, nil
+		}
+	}
 	return nil, fmt.Errorf("Format not implemented: %s", format)
 }
 
+// acceptedFormat returns the registered format preferred by the given Accept header
+// values. Media ranges are considered in order of their q-values, and each is matched
+// against the ContentType of the registered ResponseSerializers, including by the
+// structured syntax suffix of vendor media types, e.g. "application/vnd.myapi+json"
+// matches "application/json". An empty string is returned if there is no Accept
+// header or a wildcard range such as */* is preferred over any registered media type.
+// If the media types accepted don't match any ResponseSerializer, an error is
+// returned.
+func (r *muxAPI) acceptedFormat(accept []string) (string, error) {
+	if strings.TrimSpace(strings.Join(accept, "")) == "" {
+		return "", nil
+	}
+
+	format, ok := r.matchFormat(parseAccept(accept), false)
+	if !ok {
+		return "", fmt.Errorf("Format not implemented: %s", strings.Join(accept, ", "))
+	}
+	return format, nil
+}
+
+// errorFormat returns the registered format preferred by the given Accept header
+// values like acceptedFormat, except wildcard ranges are skipped, so errors are sent
+// in a format the client explicitly accepts if possible. It falls back to "json".
+func (r *muxAPI) errorFormat(accept []string) string {
+	if format, ok := r.matchFormat(parseAccept(accept), true); ok && format != "" {
+		return format
+	}
+	return "json"
+}
+
+// matchFormat returns the registered format matching the first of the media ranges
+// which matches one and true, or false if none match. If a wildcard range is reached
+// first, an empty string and true are returned unless skipWildcards is set, in which
+// case wildcards are ignored.
+func (r *muxAPI) matchFormat(ranges []string, skipWildcards bool) (string, bool) {
+	r.mu.RLock()
+	formats := make([]string, 0, len(r.serializerRegistry))
+	for format := range r.serializerRegistry {
+		formats = append(formats, format)
+	}
+	// Sort so the same format wins whenever several share a content type.
+	sort.Sort(sort.Reverse(sort.StringSlice(formats)))
+	contentTypes := make(map[string]string, len(formats))
+	for _, format := range formats {
+		contentType := normalizeFormat(r.serializerRegistry[format].ContentType())
+		contentTypes[contentType] = format
+	}
+	r.mu.RUnlock()
+
+	for _, mediaRange := range ranges {
+		if strings.HasSuffix(mediaRange, "/*") {
+			if skipWildcards {
+				continue
+			}
+			return "", true
+		}
+		if format, ok := contentTypes[mediaRange]; ok {
+			return format, true
+		}
+		if i := strings.LastIndex(mediaRange, "+"); i >= 0 {
+			slash := strings.Index(mediaRange, "/")
+			if format, ok := contentTypes[mediaRange[:slash+1]+mediaRange[i+1:]]; ok {
+				return format, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// parseAccept returns the media ranges of the Accept header values, lowercased and
+// without parameters, ordered by descending q-value. Ranges with equal q-values keep
+// their order, and ranges with a q-value of zero, which are not acceptable, are
+// omitted.
+func parseAccept(accept []string) []string {
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+
+	ranges := []mediaRange{}
+	for _, value := range accept {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if qValue, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(qValue, 64); err != nil {
+					continue
+				}
+			}
+			if q > 0 {
+				ranges = append(ranges, mediaRange{mediaType, q})
+			}
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	mediaTypes := make([]string, len(ranges))
+	for i, mediaRange := range ranges {
+		mediaTypes[i] = mediaRange.mediaType
+	}
+	return mediaTypes
+}
+
+// normalizeFormat lowercases the format and strips any parameters, e.g. "; charset=utf-8",
+// so that formats and their aliases can be matched.
+func normalizeFormat(format string) string {
+	if i := strings.Index(format, ";"); i >= 0 {
+		format = format[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(format))
+}
+
 // applyMiddleware wraps the Handler with the provided RequestMiddleware and returns another Handler.
 func applyMiddleware(h http.Handler, middleware []RequestMiddleware) http.Handler {
 	for _, m := range middleware {
@@ -18,10 +18,20 @@ package rest
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -95,6 +105,16 @@ func (m *MockResourceHandler) UpdateResource(r RequestContext, id string, data P
 	return resource, args.Error(1)
 }
 
+func (m *MockResourceHandler) PartialUpdateResource(r RequestContext, id string,
+	data Payload, version string) (Resource, error) {
+	args := m.Mock.Called()
+	resource := args.Get(0)
+	if resource != nil {
+		resource = resource.(*TestResource)
+	}
+	return resource, args.Error(1)
+}
+
 func (m *MockResourceHandler) DeleteResource(r RequestContext, id string,
 	version string) (Resource, error) {
 	args := m.Mock.Called()
@@ -128,17 +148,6 @@ func (m *MockResourceHandler) Rules() Rules {
 	return nil
 }
 
-// getRouteHandler returns the http.Handler for the API route with the given name.
-// This is purely for testing purposes and shouldn't be used elsewhere.
-func (r *muxAPI) getRouteHandler(name string) (http.Handler, error) {
-	route := r.router.Get(name)
-	if route == nil {
-		return nil, fmt.Errorf("No API route with name %s", name)
-	}
-
-	return route.GetHandler(), nil
-}
-
 // Ensures that the create handler returns a Bad Request code if an invalid response
 // format is provided.
 func TestHandleCreateBadFormat(t *testing.T) {
@@ -153,7 +162,7 @@ func TestHandleCreateBadFormat(t *testing.T) {
 	handler.On("CreateResource").Return(&TestResource{}, nil)
 
 	api.RegisterResourceHandler(handler)
-	createHandler, _ := api.(*muxAPI).getRouteHandler("foo:create")
+	createHandler, _ := api.RouteHandler("foo:create")
 
 	payload := []byte(`{"foo": "bar"}`)
 	r := bytes.NewReader(payload)
@@ -185,7 +194,7 @@ func TestHandleCreateBadCreate(t *testing.T) {
 	handler.On("CreateResource").Return(nil, fmt.Errorf("couldn't create"))
 
 	api.RegisterResourceHandler(handler)
-	createHandler, _ := api.(*muxAPI).getRouteHandler("foo:create")
+	createHandler, _ := api.RouteHandler("foo:create")
 
 	payload := []byte(`{"foo": "bar"}`)
 	r := bytes.NewReader(payload)
@@ -203,6 +212,39 @@ func TestHandleCreateBadCreate(t *testing.T) {
 	)
 }
 
+// Ensures that the create handler returns an Unprocessable Entity code when a
+// ParseJSONString field isn't valid JSON.
+func TestHandleCreateInvalidJSONString(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(MockResourceHandler)
+	api := NewAPI(&Configuration{})
+
+	handler.On("ResourceName").Return("foo")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "meta", Type: Map, ParseJSONString: true}))
+
+	api.RegisterResourceHandler(handler)
+	createHandler, _ := api.RouteHandler("foo:create")
+
+	payload := []byte(`{"meta": "{\"a\": "}`)
+	r := bytes.NewReader(payload)
+	req, _ := http.NewRequest("POST", "http://foo.com/api/v0.1/foo", r)
+	resp := httptest.NewRecorder()
+
+	createHandler.ServeHTTP(resp, req)
+
+	handler.Mock.AssertExpectations(t)
+	assert.Equal(422, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"messages":["Field 'meta' is not valid JSON: unexpected end of JSON input"],`+
+			`"reason":"Unprocessable Entity","status":422}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+}
+
 // Ensures that the create handler returns the serialized resource and Created code when
 // createFunc succeeds.
 func TestHandleCreateHappyPath(t *testing.T) {
@@ -217,7 +259,7 @@ func TestHandleCreateHappyPath(t *testing.T) {
 	handler.On("CreateResource").Return(&TestResource{Foo: "bar"}, nil)
 
 	api.RegisterResourceHandler(handler)
-	createHandler, _ := api.(*muxAPI).getRouteHandler("foo:create")
+	createHandler, _ := api.RouteHandler("foo:create")
 
 	payload := []byte(`{"foo": "bar"}`)
 	r := bytes.NewReader(payload)
@@ -249,7 +291,7 @@ func TestHandleCreateNoContent(t *testing.T) {
 	handler.On("CreateResource").Return(nil, nil)
 
 	api.RegisterResourceHandler(handler)
-	createHandler, _ := api.(*muxAPI).getRouteHandler("foo:create")
+	createHandler, _ := api.RouteHandler("foo:create")
 
 	payload := []byte(`{"foo": "bar"}`)
 	r := bytes.NewReader(payload)
@@ -279,7 +321,7 @@ func TestHandleCreateNotAuthorized(t *testing.T) {
 	handler.On("ValidVersions").Return(nil)
 
 	api.RegisterResourceHandler(handler)
-	createHandler, _ := api.(*muxAPI).getRouteHandler("foo:create")
+	createHandler, _ := api.RouteHandler("foo:create")
 
 	payload := []byte(`{"foo": "bar"}`)
 	r := bytes.NewReader(payload)
@@ -307,7 +349,7 @@ func TestHandleReadListBadFormat(t *testing.T) {
 	handler.On("ReadResourceList").Return([]Resource{}, "", nil)
 
 	api.RegisterResourceHandler(handler)
-	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:readList")
+	readHandler, _ := api.RouteHandler("foo:readList")
 
 	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo?format=blah", nil)
 	resp := httptest.NewRecorder()
@@ -337,7 +379,7 @@ func TestHandleReadListBadRead(t *testing.T) {
 	handler.On("ReadResourceList").Return(nil, "", fmt.Errorf("no resource"))
 
 	api.RegisterResourceHandler(handler)
-	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:readList")
+	readHandler, _ := api.RouteHandler("foo:readList")
 
 	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo", nil)
 	resp := httptest.NewRecorder()
@@ -366,7 +408,7 @@ func TestHandleReadListHappyPath(t *testing.T) {
 	handler.On("ReadResourceList").Return([]Resource{&TestResource{Foo: "hello"}}, "cursor123", nil)
 
 	api.RegisterResourceHandler(handler)
-	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:readList")
+	readHandler, _ := api.RouteHandler("foo:readList")
 
 	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo", nil)
 	resp := httptest.NewRecorder()
@@ -376,7 +418,7 @@ func TestHandleReadListHappyPath(t *testing.T) {
 	handler.Mock.AssertExpectations(t)
 	assert.Equal(http.StatusOK, resp.Code, "Incorrect response code")
 	assert.Equal(
-		`{"messages":[],"next":"http://foo.com?next=cursor123","reason":"OK","results":[{"foo":"hello"}],"status":200}`,
+		`{"messages":[],"next":"http://foo.com/api/v0.1/foo?next=cursor123","reason":"OK","results":[{"foo":"hello"}],"status":200}`,
 		resp.Body.String(),
 		"Incorrect response string",
 	)
@@ -395,7 +437,7 @@ func TestHandleReadBadFormat(t *testing.T) {
 	handler.On("ReadResource").Return(&TestResource{}, nil)
 
 	api.RegisterResourceHandler(handler)
-	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:read")
+	readHandler, _ := api.RouteHandler("foo:read")
 
 	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1?format=blah", nil)
 	resp := httptest.NewRecorder()
@@ -424,7 +466,7 @@ func TestHandleReadBadRead(t *testing.T) {
 	handler.On("ReadResource").Return(nil, fmt.Errorf("no resource"))
 
 	api.RegisterResourceHandler(handler)
-	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:read")
+	readHandler, _ := api.RouteHandler("foo:read")
 
 	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1", nil)
 	resp := httptest.NewRecorder()
@@ -453,7 +495,7 @@ func TestHandleReadHappyPath(t *testing.T) {
 	handler.On("ReadResource").Return(&TestResource{Foo: "hello"}, nil)
 
 	api.RegisterResourceHandler(handler)
-	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:read")
+	readHandler, _ := api.RouteHandler("foo:read")
 
 	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1", nil)
 	resp := httptest.NewRecorder()
@@ -469,6 +511,43 @@ func TestHandleReadHappyPath(t *testing.T) {
 	)
 }
 
+// Ensures that the read handler responds with a null result when readFunc returns a
+// nil resource without an error unless NilResultIsNotFound is enabled, in which case
+// it responds with a 404.
+func TestHandleReadNilResult(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		nilResultIsNotFound bool
+		code                int
+		body                string
+	}{
+		{false, http.StatusOK, `{"messages":[],"reason":"OK","result":null,"status":200}`},
+		{true, http.StatusNotFound, `{"messages":["foo 1 not found"],"reason":"Not Found","status":404}`},
+	}
+
+	for _, test := range tests {
+		handler := new(MockResourceHandler)
+		api := NewAPI(&Configuration{NilResultIsNotFound: test.nilResultIsNotFound})
+
+		handler.On("ResourceName").Return("foo")
+		handler.On("Authenticate").Return(nil)
+		handler.On("ValidVersions").Return(nil)
+		handler.On("Rules").Return(&rules{})
+		handler.On("ReadResource").Return(nil, nil)
+
+		api.RegisterResourceHandler(handler)
+
+		req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1", nil)
+		resp := httptest.NewRecorder()
+
+		api.ServeHTTP(resp, req)
+
+		handler.Mock.AssertExpectations(t)
+		assert.Equal(test.code, resp.Code, "Incorrect response code")
+		assert.Equal(test.body, resp.Body.String(), "Incorrect response string")
+	}
+}
+
 // Ensures that the update list handler returns a Bad Request code if an invalid response format
 // is provided.
 func TestHandleUpdateListBadFormat(t *testing.T) {
@@ -483,7 +562,7 @@ func TestHandleUpdateListBadFormat(t *testing.T) {
 	handler.On("UpdateResourceList").Return([]Resource{&TestResource{}}, nil)
 
 	api.RegisterResourceHandler(handler)
-	updateHandler, _ := api.(*muxAPI).getRouteHandler("foo:updateList")
+	updateHandler, _ := api.RouteHandler("foo:updateList")
 
 	payload := []byte(`[{"foo": "bar"}]`)
 	r := bytes.NewReader(payload)
@@ -515,7 +594,7 @@ func TestHandleUpdateListBadUpdate(t *testing.T) {
 	handler.On("UpdateResourceList").Return(nil, fmt.Errorf("couldn't update"))
 
 	api.RegisterResourceHandler(handler)
-	updateHandler, _ := api.(*muxAPI).getRouteHandler("foo:updateList")
+	updateHandler, _ := api.RouteHandler("foo:updateList")
 
 	payload := []byte(`[{"foo": "bar"}]`)
 	r := bytes.NewReader(payload)
@@ -546,7 +625,7 @@ func TestHandleUpdateListPayloadNotList(t *testing.T) {
 	handler.On("UpdateResourceList").Return([]Resource{&TestResource{Foo: "bar"}}, nil)
 
 	api.RegisterResourceHandler(handler)
-	updateHandler, _ := api.(*muxAPI).getRouteHandler("foo:updateList")
+	updateHandler, _ := api.RouteHandler("foo:updateList")
 
 	payload := []byte(`{"foo": "bar"}`)
 	r := bytes.NewReader(payload)
@@ -578,7 +657,7 @@ func TestHandleUpdateListHappyPath(t *testing.T) {
 	handler.On("UpdateResourceList").Return([]Resource{&TestResource{Foo: "bar"}}, nil)
 
 	api.RegisterResourceHandler(handler)
-	updateHandler, _ := api.(*muxAPI).getRouteHandler("foo:updateList")
+	updateHandler, _ := api.RouteHandler("foo:updateList")
 
 	payload := []byte(`[{"foo": "bar"}]`)
 	r := bytes.NewReader(payload)
@@ -609,7 +688,7 @@ func TestHandleUpdateBadFormat(t *testing.T) {
 	handler.On("UpdateResource").Return(&TestResource{}, nil)
 
 	api.RegisterResourceHandler(handler)
-	updateHandler, _ := api.(*muxAPI).getRouteHandler("foo:update")
+	updateHandler, _ := api.RouteHandler("foo:update")
 
 	payload := []byte(`{"foo": "bar"}`)
 	r := bytes.NewReader(payload)
@@ -641,7 +720,7 @@ func TestHandleUpdateBadUpdate(t *testing.T) {
 	handler.On("UpdateResource").Return(nil, fmt.Errorf("couldn't update"))
 
 	api.RegisterResourceHandler(handler)
-	updateHandler, _ := api.(*muxAPI).getRouteHandler("foo:update")
+	updateHandler, _ := api.RouteHandler("foo:update")
 
 	payload := []byte(`{"foo": "bar"}`)
 	r := bytes.NewReader(payload)
@@ -672,7 +751,7 @@ func TestHandleUpdateHappyPath(t *testing.T) {
 	handler.On("UpdateResource").Return(&TestResource{Foo: "bar"}, nil)
 
 	api.RegisterResourceHandler(handler)
-	updateHandler, _ := api.(*muxAPI).getRouteHandler("foo:update")
+	updateHandler, _ := api.RouteHandler("foo:update")
 
 	payload := []byte(`{"foo": "bar"}`)
 	r := bytes.NewReader(payload)
@@ -690,6 +769,105 @@ func TestHandleUpdateHappyPath(t *testing.T) {
 	)
 }
 
+// Ensures that the patch handler returns the serialized resource and OK code when
+// PartialUpdateResource succeeds, without enforcing required fields.
+func TestHandlePatchHappyPath(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(MockResourceHandler)
+	api := NewAPI(&Configuration{})
+
+	handler.On("ResourceName").Return("foo")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", Required: true},
+		&Rule{FieldAlias: "count", Type: Int, InputOnly: true},
+	))
+	handler.On("PartialUpdateResource").Return(&TestResource{Foo: "bar"}, nil)
+
+	api.RegisterResourceHandler(handler)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("PATCH", "http://foo.com/api/v0.1/foo/1",
+			bytes.NewBufferString(`{"count": "5"}`)),
+		httptest.NewRequest("POST", "http://foo.com/api/v0.1/foo/1",
+			bytes.NewBufferString(`{"count": "5"}`)),
+	} {
+		if req.Method == "POST" {
+			req.Header.Set("X-HTTP-Method-Override", "PATCH")
+		}
+		resp := httptest.NewRecorder()
+
+		api.ServeHTTP(resp, req)
+
+		assert.Equal(http.StatusOK, resp.Code, "Incorrect response code")
+		assert.Equal(
+			`{"messages":[],"reason":"OK","result":{"foo":"bar"},"status":200}`,
+			resp.Body.String(),
+			"Incorrect response string",
+		)
+	}
+	handler.Mock.AssertExpectations(t)
+	handler.AssertNumberOfCalls(t, "PartialUpdateResource", 2)
+
+	// Updates still enforce required fields.
+	req := httptest.NewRequest("PUT", "http://foo.com/api/v0.1/foo/1",
+		bytes.NewBufferString(`{"count": "5"}`))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(422, resp.Code, "Incorrect response code")
+	handler.AssertNotCalled(t, "UpdateResource")
+}
+
+// Ensures that the patch handler still applies type coercion to the fields provided.
+func TestHandlePatchBadPayload(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(MockResourceHandler)
+	api := NewAPI(&Configuration{})
+
+	handler.On("ResourceName").Return("foo")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "count", Type: Int},
+	))
+
+	api.RegisterResourceHandler(handler)
+
+	req := httptest.NewRequest("PATCH", "http://foo.com/api/v0.1/foo/1",
+		bytes.NewBufferString(`{"count": true}`))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(422, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"messages":["Unable to coerce bool to int"],"reason":"Unprocessable Entity","status":422}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+	handler.AssertNotCalled(t, "PartialUpdateResource")
+}
+
+// Ensures that the patch handler returns a Method Not Allowed code when the
+// ResourceHandler doesn't implement PartialUpdateResource.
+func TestHandlePatchNotImplemented(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(TestResourceHandler{})
+
+	req := httptest.NewRequest("PATCH", "http://example.com/api/v1/widgets/1",
+		bytes.NewBufferString(`{}`))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"messages":["PartialUpdateResource not implemented"],"reason":"Method Not Allowed","status":405}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+}
+
 // Ensures that the delete handler returns a Bad Request code if an invalid response format is
 // provided.
 func TestHandleDeleteBadFormat(t *testing.T) {
@@ -704,7 +882,7 @@ func TestHandleDeleteBadFormat(t *testing.T) {
 	handler.On("DeleteResource").Return(&TestResource{}, nil)
 
 	api.RegisterResourceHandler(handler)
-	deleteHandler, _ := api.(*muxAPI).getRouteHandler("foo:delete")
+	deleteHandler, _ := api.RouteHandler("foo:delete")
 
 	req, _ := http.NewRequest("DELETE", "http://foo.com/api/v0.1/foo/1?format=blah", nil)
 	resp := httptest.NewRecorder()
@@ -734,7 +912,7 @@ func TestHandleDeleteBadDelete(t *testing.T) {
 	handler.On("DeleteResource").Return(nil, fmt.Errorf("no resource"))
 
 	api.RegisterResourceHandler(handler)
-	deleteHandler, _ := api.(*muxAPI).getRouteHandler("foo:delete")
+	deleteHandler, _ := api.RouteHandler("foo:delete")
 
 	req, _ := http.NewRequest("DELETE", "http://foo.com/api/v0.1/foo/1", nil)
 	resp := httptest.NewRecorder()
@@ -763,7 +941,7 @@ func TestHandleDeleteHappyPath(t *testing.T) {
 	handler.On("DeleteResource").Return(&TestResource{Foo: "hello"}, nil)
 
 	api.RegisterResourceHandler(handler)
-	deleteHandler, _ := api.(*muxAPI).getRouteHandler("foo:delete")
+	deleteHandler, _ := api.RouteHandler("foo:delete")
 
 	req, _ := http.NewRequest("DELETE", "http://foo.com/api/v0.1/foo/1", nil)
 	resp := httptest.NewRecorder()
@@ -802,7 +980,7 @@ func TestApplyMiddleware(t *testing.T) {
 
 	called := false
 	api.RegisterResourceHandler(handler, getMiddleware(&called))
-	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:read")
+	readHandler, _ := api.RouteHandler("foo:read")
 
 	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1", nil)
 	resp := httptest.NewRecorder()
@@ -836,7 +1014,7 @@ func TestOutboundRules(t *testing.T) {
 	handler.On("ReadResource").Return(&TestResource{Foo: "hello"}, nil)
 
 	api.RegisterResourceHandler(handler)
-	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:read")
+	readHandler, _ := api.RouteHandler("foo:read")
 
 	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1", nil)
 	resp := httptest.NewRecorder()
@@ -869,7 +1047,7 @@ func TestOutboundRulesDontApplyOnError(t *testing.T) {
 	handler.On("ReadResource").Return(nil, fmt.Errorf("oh snap"))
 
 	api.RegisterResourceHandler(handler)
-	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:read")
+	readHandler, _ := api.RouteHandler("foo:read")
 
 	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1", nil)
 	resp := httptest.NewRecorder()
@@ -903,7 +1081,7 @@ func TestOutboundRulesDontApplyOnNilResource(t *testing.T) {
 	handler.On("ReadResource").Return(nil, nil)
 
 	api.RegisterResourceHandler(handler)
-	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:read")
+	readHandler, _ := api.RouteHandler("foo:read")
 
 	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1", nil)
 	resp := httptest.NewRecorder()
@@ -945,6 +1123,92 @@ func TestRegisterUnregisterResponseSerializer(t *testing.T) {
 	assert.Equal([]string{"json"}, api.AvailableFormats())
 }
 
+// Ensures that responseSerializer resolves formats case-insensitively, ignoring
+// parameters, and resolves registered aliases.
+func TestResponseSerializerAliases(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{}).(*muxAPI)
+
+	for _, format := range []string{"json", "JSON", "application/json",
+		"application/json; charset=utf-8", "Application/JSON;charset=UTF-8", "text/json"} {
+		serializer, err := api.responseSerializer(format)
+		if assert.Nil(err, format) {
+			assert.IsType(&jsonSerializer{}, serializer, format)
+		}
+	}
+
+	_, err := api.responseSerializer("text/foo")
+	assert.Equal("Format not implemented: text/foo", err.Error())
+
+	foo := &TestResponseSerializer{}
+	api.RegisterResponseSerializer("foo", foo)
+	api.RegisterFormatAliases("foo", "text/foo", "application/x-foo")
+	serializer, err := api.responseSerializer("text/foo; charset=utf-8")
+	assert.Nil(err)
+	assert.Equal(foo, serializer)
+	serializer, err = api.responseSerializer("application/x-foo")
+	assert.Nil(err)
+	assert.Equal(foo, serializer)
+	assert.Equal([]string{"foo", "json"}, api.AvailableFormats())
+
+	api.UnregisterResponseSerializer("foo")
+	_, err = api.responseSerializer("text/foo")
+	assert.NotNil(err)
+}
+
+// Ensures that SnapshotSerializers and RestoreSerializers save and restore the
+// registered ResponseSerializers.
+func TestSnapshotRestoreSerializers(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+
+	snapshot := api.SnapshotSerializers()
+	assert.Equal([]string{"json"}, api.AvailableFormats())
+
+	api.RegisterResponseSerializer("foo", &TestResponseSerializer{})
+	api.RegisterResponseSerializer("bar", &TestResponseSerializer{})
+	api.UnregisterResponseSerializer("json")
+	assert.Equal([]string{"bar", "foo"}, api.AvailableFormats())
+
+	// Mutating the registry must not affect the snapshot.
+	assert.Len(snapshot, 1)
+
+	api.RestoreSerializers(snapshot)
+	assert.Equal([]string{"json"}, api.AvailableFormats())
+
+	// Mutating the snapshot after a restore must not affect the registry.
+	snapshot["baz"] = &TestResponseSerializer{}
+	assert.Equal([]string{"json"}, api.AvailableFormats())
+}
+
+// Ensures that SnapshotFormatAliases and RestoreFormatAliases save and restore the
+// registered format aliases.
+func TestSnapshotRestoreFormatAliases(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResponseSerializer("foo", &TestResponseSerializer{})
+
+	snapshot := api.SnapshotFormatAliases()
+
+	api.RegisterFormatAliases("foo", "application/foo")
+	_, err := api.responseSerializer("application/foo")
+	assert.Nil(err)
+
+	// Mutating the aliases must not affect the snapshot.
+	assert.NotContains(snapshot, "application/foo")
+
+	api.RestoreFormatAliases(snapshot)
+	_, err = api.responseSerializer("application/foo")
+	assert.NotNil(err)
+	_, err = api.responseSerializer("application/json")
+	assert.Nil(err)
+
+	// Mutating the snapshot after a restore must not affect the aliases.
+	snapshot["application/foo"] = "foo"
+	_, err = api.responseSerializer("application/foo")
+	assert.NotNil(err)
+}
+
 // Ensures that Validate returns an error when the resource doesn't have a Rule
 // field.
 func TestValidateBadField(t *testing.T) {
@@ -1019,6 +1283,15 @@ func TestValidateRulesOrPanicBadField(t *testing.T) {
 	api.(*muxAPI).validateRulesOrPanic()
 }
 
+// Ensures that validateRulesOrPanic panics when a Rule's Pattern doesn't compile.
+func TestValidateRulesOrPanicBadPattern(t *testing.T) {
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(TestResourceHandler{})
+	api.RegisterTypeRules(NewRules((*TestResource)(nil), &Rule{Field: "Foo", Pattern: "("}))
+
+	assert.Panics(t, api.(*muxAPI).validateRulesOrPanic)
+}
+
 // Ensures that validateRulesOrPanic panics when a Rule has an incorrect type.
 func TestValidateRulesOrPanicBadType(t *testing.T) {
 	assert := assert.New(t)
@@ -1150,3 +1423,3754 @@ func TestVersionMiddleware(t *testing.T) {
 	assert.Equal(w.Code, http.StatusBadRequest)
 	assert.NotContains(w.Body.String(), "foo")
 }
+
+// Ensures that requests with more query parameters than MaxQueryParams are
+// rejected with a 400 while requests within the limit are served.
+func TestQueryLimitMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	api := NewAPI(&Configuration{MaxQueryParams: 3})
+	handler := new(MockResourceHandler)
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(&rules{})
+	handler.On("ResourceName").Return("widgets")
+	handler.On("ReadResourceList").Return([]Resource{"foo"}, "", nil)
+
+	api.RegisterResourceHandler(handler)
+
+	// Within the limit
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?a=1&b=2&b=3", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "foo")
+
+	// Over the limit
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets?a=1&a=2&a=3&b=4", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Equal("Too many query parameters: 4 exceeds the maximum of 3.", w.Body.String())
+}
+
+// Ensures that strict versioning returns a 404 for versions which none of the
+// handler's Rules apply to and serves versions they do apply to.
+func TestStrictVersionMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	api := NewAPI(&Configuration{StrictVersioning: true})
+	handler := new(MockResourceHandler)
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", Versions: []string{"1"}}))
+	handler.On("ResourceName").Return("widgets")
+	handler.On("ReadResource").Return(&TestResource{Foo: "hello"}, nil)
+
+	api.RegisterResourceHandler(handler)
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"messages":[],"reason":"OK","result":{"foo":"hello"},"status":200}`,
+		w.Body.String())
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v2/widgets/1", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.Equal(`Resource "widgets" is not available in version "2".`, w.Body.String())
+}
+
+// Ensures that without strict versioning, versions which none of the handler's
+// Rules apply to are still served.
+func TestStrictVersioningDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	api := NewAPI(&Configuration{})
+	handler := new(MockResourceHandler)
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", Versions: []string{"1"}}))
+	handler.On("ResourceName").Return("widgets")
+	handler.On("ReadResource").Return(&TestResource{Foo: "hello"}, nil)
+
+	api.RegisterResourceHandler(handler)
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v2/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+}
+
+type LocationResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (l LocationResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (l LocationResourceHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	return &TestResource{Foo: "42"}, nil
+}
+
+func (l LocationResourceHandler) ResourceID(resource Resource) string {
+	return resource.(*TestResource).Foo
+}
+
+// Ensures that the create handler sends a Location header along with the created
+// resource when the handler provides a resource id.
+func TestHandleCreateLocation(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(LocationResourceHandler{})
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets", bytes.NewReader([]byte(`{}`)))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusCreated, resp.Code)
+	assert.Equal("http://example.com/api/v1/widgets/42", resp.Header().Get("Location"))
+	assert.Equal(
+		`{"messages":[],"reason":"Created","result":{"foo":"42"},"status":201}`,
+		resp.Body.String(),
+	)
+}
+
+// Ensures that the create handler responds with only a Location header and no body
+// when CreateReturnsLocationOnly is set.
+func TestHandleCreateLocationOnly(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{CreateReturnsLocationOnly: true})
+	api.RegisterResourceHandler(LocationResourceHandler{})
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets", bytes.NewReader([]byte(`{}`)))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusCreated, resp.Code)
+	assert.Equal("http://example.com/api/v1/widgets/42", resp.Header().Get("Location"))
+	assert.Equal("", resp.Body.String())
+}
+
+// Ensures that CreateReturnsLocationOnly has no effect if the handler doesn't
+// provide a resource id.
+func TestHandleCreateLocationOnlyNoID(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{CreateReturnsLocationOnly: true})
+	api.RegisterResourceHandler(TestResourceHandler{})
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets", bytes.NewReader([]byte(`{}`)))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusCreated, resp.Code)
+	assert.Equal("", resp.Header().Get("Location"))
+	assert.Equal(
+		`{"messages":[],"reason":"Created","result":{"test":"resource"},"status":201}`,
+		resp.Body.String(),
+	)
+}
+
+// Ensures that the create handler rejects payloads with duplicate keys when
+// RejectDuplicateKeys is set and accepts them otherwise.
+func TestHandleCreateDuplicateKeys(t *testing.T) {
+	assert := assert.New(t)
+	payload := `{"foo": "bar", "foo": "baz"}`
+
+	api := NewAPI(&Configuration{RejectDuplicateKeys: true})
+	api.RegisterResourceHandler(TestResourceHandler{})
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets", bytes.NewReader([]byte(payload)))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	assert.Equal(`{"messages":["Duplicate key 'foo'"],"reason":"Bad Request","status":400}`,
+		resp.Body.String())
+
+	req, _ = http.NewRequest("POST", "http://example.com/api/v1/widgets", bytes.NewReader([]byte(`{"foo": "bar"}`)))
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(http.StatusCreated, resp.Code)
+
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(TestResourceHandler{})
+	req, _ = http.NewRequest("POST", "http://example.com/api/v1/widgets", bytes.NewReader([]byte(payload)))
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(http.StatusCreated, resp.Code)
+}
+
+type ForceFormatResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (f ForceFormatResourceHandler) ResourceName() string {
+	return "images"
+}
+
+func (f ForceFormatResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return &TestResource{Foo: id}, nil
+}
+
+func (f ForceFormatResourceHandler) ForceFormat(ctx RequestContext) string {
+	if ctx.ResourceID() == "raw" {
+		return ""
+	}
+	return "json"
+}
+
+// Ensures that a format forced by the handler is used regardless of the format
+// requested by the client.
+func TestHandleReadForceFormat(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResponseSerializer("csv", &TestResponseSerializer{})
+	api.RegisterResourceHandler(ForceFormatResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/images/1?format=csv", nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("application/json", resp.Header().Get("Content-Type"))
+	assert.Equal(`{"messages":[],"reason":"OK","result":{"foo":"1"},"status":200}`,
+		resp.Body.String())
+
+	// Handler doesn't force a format for this request.
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/images/raw?format=csv", nil)
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("application/foo", resp.Header().Get("Content-Type"))
+}
+
+// Ensures that creating a resource with a malformed UUID field responds with a 422.
+func TestHandleCreateInvalidUUID(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	handler := new(MockResourceHandler)
+	handler.On("ResourceName").Return("widgets")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", Type: UUID}))
+	api.RegisterResourceHandler(handler)
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"foo": "not-a-uuid"}`))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(422, w.Code)
+	handler.AssertNotCalled(t, "CreateResource")
+}
+
+// Ensures that with CollectAllValidationErrors every invalid field is reported in a
+// 422 response.
+func TestHandleCreateCollectAllValidationErrors(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{CollectAllValidationErrors: true})
+	handler := new(MockResourceHandler)
+	handler.On("ResourceName").Return("widgets")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "count", Type: Int},
+		&Rule{FieldAlias: "id", Type: UUID},
+		&Rule{FieldAlias: "name", Required: true},
+	))
+	api.RegisterResourceHandler(handler)
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"count": "many", "id": "abc"}`))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(422, w.Code)
+	handler.AssertNotCalled(t, "CreateResource")
+	var body map[string]interface{}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal([]interface{}{
+		map[string]interface{}{
+			"field":   "count",
+			"code":    "type_mismatch",
+			"message": `strconv.ParseInt: parsing "many": invalid syntax`,
+		},
+		map[string]interface{}{
+			"field":   "id",
+			"code":    "type_mismatch",
+			"message": "Invalid UUID 'abc'",
+		},
+		map[string]interface{}{
+			"field":   "name",
+			"code":    "field_required",
+			"message": "Missing required field 'name'",
+		},
+	}, body["errors"])
+}
+
+// Ensures that with CollectAllValidationErrors the errors for each item in a list
+// update are reported with the index of the item.
+func TestHandleUpdateListCollectAllValidationErrors(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{CollectAllValidationErrors: true})
+	handler := new(MockResourceHandler)
+	handler.On("ResourceName").Return("widgets")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "count", Type: Int},
+	))
+	api.RegisterResourceHandler(handler)
+
+	req, _ := http.NewRequest("PUT", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`[{"count": true}, {"count": 1}, {"count": false}]`))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(422, w.Code)
+	var body map[string]interface{}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal([]interface{}{
+		map[string]interface{}{
+			"field":   "0.count",
+			"code":    "type_mismatch",
+			"message": "Unable to coerce bool to int",
+		},
+		map[string]interface{}{
+			"field":   "2.count",
+			"code":    "type_mismatch",
+			"message": "Unable to coerce bool to int",
+		},
+	}, body["errors"])
+}
+
+// Ensures that PluralName appends "s" by default.
+func TestPluralNameDefault(t *testing.T) {
+	assert.Equal(t, "widgets", (&Configuration{}).PluralName("widget"))
+}
+
+// Ensures that PluralName uses IrregularPlurals before Pluralize.
+func TestPluralNameIrregular(t *testing.T) {
+	assert := assert.New(t)
+	config := &Configuration{
+		Pluralize:        func(name string) string { return name + "es" },
+		IrregularPlurals: map[string]string{"person": "people"},
+	}
+
+	assert.Equal("people", config.PluralName("person"))
+	assert.Equal("boxes", config.PluralName("box"))
+}
+
+// Ensures that ResourceNamedEnvelopes keys results by resource and collection name.
+func TestResourceNamedEnvelopes(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{
+		ResourceNamedEnvelopes: true,
+		IrregularPlurals:       map[string]string{"person": "people"},
+	})
+	handler := new(MockResourceHandler)
+	handler.On("ResourceName").Return("person")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(&rules{})
+	handler.On("ReadResource").Return(&TestResource{Foo: "hello"}, nil)
+	handler.On("ReadResourceList").Return([]Resource{&TestResource{Foo: "hello"}}, "", nil)
+	api.RegisterResourceHandler(handler)
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/person/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"messages":[],"person":{"foo":"hello"},"reason":"OK","status":200}`,
+		w.Body.String())
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/person", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"messages":[],"people":[{"foo":"hello"}],"reason":"OK","status":200}`,
+		w.Body.String())
+}
+
+// Ensures that Validate rejects resource-named envelope keys which collide with
+// reserved envelope keys.
+func TestResourceNamedEnvelopesReservedKeys(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{
+		ResourceNamedEnvelopes: true,
+		IrregularPlurals:       map[string]string{"nex": "next"},
+	})
+	handler := new(MockResourceHandler)
+	handler.On("ResourceName").Return("nex")
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(&rules{})
+	api.RegisterResourceHandler(handler)
+
+	assert.EqualError(api.Validate(), "Envelope key 'next' for resource 'nex' is reserved")
+
+	api = NewAPI(&Configuration{ResourceNamedEnvelopes: true})
+	handler = new(MockResourceHandler)
+	handler.On("ResourceName").Return("status")
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(&rules{})
+	api.RegisterResourceHandler(handler)
+
+	assert.EqualError(api.Validate(), "Envelope key 'status' for resource 'status' is reserved")
+
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler)
+
+	assert.Nil(api.Validate())
+}
+
+type MultiReadResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (m MultiReadResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (m MultiReadResourceHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil), &Rule{Field: "Foo", FieldAlias: "id"})
+}
+
+func (m MultiReadResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	if id == "missing" {
+		return nil, ResourceNotFound("No widget " + id)
+	}
+	return &TestResource{Foo: id}, nil
+}
+
+type BatchReadResourceHandler struct {
+	MultiReadResourceHandler
+}
+
+func (b BatchReadResourceHandler) ReadResources(ctx RequestContext, ids []string,
+	version string) ([]Resource, error) {
+	resources := []Resource{}
+	for _, id := range ids {
+		resources = append(resources, &TestResource{Foo: "batch-" + id})
+	}
+	return resources, nil
+}
+
+// Ensures that reading with ids falls back to ReadResource for each id when
+// ReadResources isn't implemented and applies outbound rules to each resource.
+func TestHandleReadResourcesFallback(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(MultiReadResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?ids=1,2,3", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(
+		`{"messages":[],"reason":"OK","results":[{"id":"1"},{"id":"2"},{"id":"3"}],"status":200}`,
+		w.Body.String())
+}
+
+// Ensures that reading with ids returns the error if reading any resource fails.
+func TestHandleReadResourcesFallbackError(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(MultiReadResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?ids=1,missing", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+// Ensures that reading with ids invokes ReadResources when it's implemented,
+// including through method override.
+func TestHandleReadResources(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(BatchReadResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?ids=1&ids=2", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(
+		`{"messages":[],"reason":"OK","results":[{"id":"batch-1"},{"id":"batch-2"}],"status":200}`,
+		w.Body.String())
+
+	req, _ = http.NewRequest("POST", "http://example.com/api/v1/widgets?ids=3", nil)
+	req.Header.Set("X-HTTP-Method-Override", "GET")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(
+		`{"messages":[],"reason":"OK","results":[{"id":"batch-3"}],"status":200}`,
+		w.Body.String())
+}
+
+type SlowResourceHandler struct {
+	BaseResourceHandler
+	delay time.Duration
+}
+
+func (s SlowResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (s SlowResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	time.Sleep(s.delay)
+	return &TestResource{Foo: id}, nil
+}
+
+// Ensures that requests exceeding SlowRequestThreshold are logged.
+func TestSlowRequestLogged(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	api := NewAPI(&Configuration{
+		Logger:               log.New(&buf, "", 0),
+		SlowRequestThreshold: time.Millisecond,
+	})
+	api.RegisterResourceHandler(SlowResourceHandler{delay: 10 * time.Millisecond})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	api.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Contains(buf.String(), "Slow request: widgets read took ")
+}
+
+// Ensures that requests within SlowRequestThreshold are not logged.
+func TestFastRequestNotLogged(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	api := NewAPI(&Configuration{
+		Logger:               log.New(&buf, "", 0),
+		SlowRequestThreshold: time.Hour,
+	})
+	api.RegisterResourceHandler(SlowResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	api.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Empty(buf.String())
+}
+
+type PayloadRecordingResourceHandler struct {
+	BaseResourceHandler
+	payloads *[]Payload
+}
+
+func (p PayloadRecordingResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (p PayloadRecordingResourceHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	*p.payloads = append(*p.payloads, data)
+	return data, nil
+}
+
+// Ensures that the RequestBodyTransformer is applied to the body before decoding.
+func TestRequestBodyTransformer(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{
+		RequestBodyTransformer: func(body []byte) ([]byte, error) {
+			var envelope map[string]json.RawMessage
+			if err := json.Unmarshal(body, &envelope); err != nil {
+				return nil, err
+			}
+			return envelope["data"], nil
+		},
+	})
+	payloads := []Payload{}
+	api.RegisterResourceHandler(PayloadRecordingResourceHandler{payloads: &payloads})
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"data": {"foo": "bar"}}`))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusCreated, w.Code)
+	assert.Equal([]Payload{{"foo": "bar"}}, payloads)
+}
+
+// Ensures that a RequestBodyTransformer error results in a 400.
+func TestRequestBodyTransformerError(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{
+		RequestBodyTransformer: func(body []byte) ([]byte, error) {
+			return nil, fmt.Errorf("missing envelope")
+		},
+	})
+	handler := new(MockResourceHandler)
+	handler.On("ResourceName").Return("widgets")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(&rules{})
+	api.RegisterResourceHandler(handler)
+
+	for _, method := range []string{"POST", "PUT"} {
+		req, _ := http.NewRequest(method, "http://example.com/api/v1/widgets",
+			bytes.NewBufferString(`{"foo": "bar"}`))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(http.StatusBadRequest, w.Code)
+		assert.Contains(w.Body.String(), "missing envelope")
+	}
+	handler.AssertNotCalled(t, "CreateResource")
+	handler.AssertNotCalled(t, "UpdateResourceList")
+}
+
+type CacheResourceHandler struct {
+	TestResourceHandler
+}
+
+func (c CacheResourceHandler) CacheControl() string {
+	return "max-age=60, public"
+}
+
+// Ensures that read responses carry the handler's Cache-Control policy and write
+// responses are sent with no-store.
+func TestCacheControl(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(CacheResourceHandler{})
+
+	serve := func(method, url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString("{}"))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("GET", "http://example.com/api/v1/widgets/1")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("max-age=60, public", w.Header().Get("Cache-Control"))
+
+	w = serve("POST", "http://example.com/api/v1/widgets")
+	assert.Equal(http.StatusCreated, w.Code)
+	assert.Equal("no-store", w.Header().Get("Cache-Control"))
+
+	// Failed reads aren't cacheable.
+	w = serve("GET", "http://example.com/api/v1/widgets")
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+	assert.Equal("no-store", w.Header().Get("Cache-Control"))
+}
+
+// Ensures that no Cache-Control header is sent for reads by default.
+func TestCacheControlDefault(t *testing.T) {
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(TestResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Header().Get("Cache-Control"))
+}
+
+// Ensures that with CORSPreflight, OPTIONS requests to resource URIs are answered
+// with the methods registered at each URI.
+func TestCORSPreflight(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{CORSPreflight: true})
+	api.RegisterResourceHandler(TestResourceHandler{})
+
+	preflight := func(url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("OPTIONS", url, nil)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	w := preflight("http://example.com/api/v1/widgets")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("GET, POST, PUT, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal("GET, POST, PUT, OPTIONS", w.Header().Get("Allow"))
+
+	w = preflight("http://example.com/api/v1/widgets/1")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("GET, PUT, PATCH, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+
+	// Each preflight route has its own name.
+	_, err := api.RouteHandler("widgets:readListPreflight")
+	assert.Nil(err)
+	_, err = api.RouteHandler("widgets:readPreflight")
+	assert.Nil(err)
+}
+
+// Ensures that OPTIONS routes aren't registered unless CORSPreflight is enabled.
+func TestCORSPreflightDisabled(t *testing.T) {
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(TestResourceHandler{})
+
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/api/v1/widgets", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+type baseContextKey string
+
+type BaseContextResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (b BaseContextResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (b BaseContextResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	db, _ := ctx.Value(baseContextKey("db")).(string)
+	return &TestResource{Foo: db + ":" + ctx.ResourceID()}, nil
+}
+
+// Ensures that values on the BaseContext are visible from handlers' RequestContext
+// alongside the request values.
+func TestBaseContext(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{
+		BaseContext: func() context.Context {
+			return context.WithValue(context.Background(), baseContextKey("db"), "conn")
+		},
+	})
+	api.RegisterResourceHandler(BaseContextResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"foo":"conn:1"`)
+}
+
+// Ensures that values on the request take precedence over the base context.
+func TestWithBaseContext(t *testing.T) {
+	assert := assert.New(t)
+	base := context.WithValue(context.Background(), baseContextKey("a"), "base")
+	base = context.WithValue(base, baseContextKey("b"), "base")
+	parent, cancel := context.WithCancel(
+		context.WithValue(context.Background(), baseContextKey("a"), "parent"))
+
+	ctx := withBaseContext(parent, base)
+	cancel()
+
+	assert.Equal("parent", ctx.Value(baseContextKey("a")))
+	assert.Equal("base", ctx.Value(baseContextKey("b")))
+	assert.Nil(ctx.Value(baseContextKey("c")))
+	assert.Equal(context.Canceled, ctx.Err())
+}
+
+type MessageResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (m MessageResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (m MessageResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	ctx.AddMessage("Served from cache")
+	ctx.AddStructuredMessage(map[string]string{"code": "deprecated", "field": "foo"})
+	return &TestResource{Foo: id}, nil
+}
+
+// Ensures that string and structured messages are serialized together and decoded by
+// the client.
+func TestStructuredMessagesResponse(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(MessageResourceHandler{})
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	resp, err := do(http.DefaultClient, httpGet, ts.URL+"/api/v1/widgets/1", nil, nil)
+
+	assert.Nil(err)
+	assert.Equal([]string{"Served from cache"}, resp.Messages)
+	assert.Equal([]interface{}{
+		"Served from cache",
+		map[string]interface{}{"code": "deprecated", "field": "foo"},
+	}, resp.StructuredMessages)
+}
+
+type MixedResource struct {
+	Bar string
+}
+
+type MixedResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (m MixedResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (m MixedResourceHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil), &Rule{Field: "Foo", FieldAlias: "id"})
+}
+
+func (m MixedResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	return []Resource{&TestResource{Foo: "a"}, &MixedResource{Bar: "b"}, nil}, "", nil
+}
+
+// Ensures that list elements are serialized using the Rules registered for their type,
+// falling back to the handler's Rules.
+func TestTypeRulesMixedList(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(MixedResourceHandler{})
+	api.RegisterTypeRules(NewRules((*MixedResource)(nil), &Rule{Field: "Bar", FieldAlias: "name"}))
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(
+		`{"messages":[],"reason":"OK","results":[{"id":"a"},{"name":"b"},null],"status":200}`,
+		w.Body.String())
+}
+
+// Ensures that Validate checks registered type Rules.
+func TestValidateTypeRules(t *testing.T) {
+	api := NewAPI(&Configuration{})
+	api.RegisterTypeRules(NewRules((*MixedResource)(nil), &Rule{Field: "Baz"}))
+
+	assert.NotNil(t, api.Validate())
+}
+
+type NDJSONResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (n NDJSONResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (n NDJSONResourceHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil), &Rule{Field: "Foo", FieldAlias: "id"})
+}
+
+func (n NDJSONResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	return []Resource{&TestResource{Foo: "a"}, &TestResource{Foo: "b"}}, "", nil
+}
+
+func (n NDJSONResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return nil, ResourceNotFound("No widget " + id)
+}
+
+// Ensures that the NDJSON serializer writes each ruled list element on its own line,
+// followed by a line holding the rest of the envelope.
+func TestNDJSONSerializerList(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResponseSerializer("ndjson", NewNDJSONSerializer())
+	api.RegisterResourceHandler(NDJSONResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?format=ndjson", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/x-ndjson", w.Header().Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	expected := []string{"a", "b"}
+	if assert.Len(lines, len(expected)+1) {
+		for i, line := range lines[:len(expected)] {
+			var element map[string]interface{}
+			assert.Nil(json.Unmarshal([]byte(line), &element))
+			assert.Equal(map[string]interface{}{"id": expected[i]}, element)
+		}
+		assert.Equal(`{"messages":[],"reason":"OK","status":200}`, lines[len(expected)])
+	}
+}
+
+// Ensures that the NDJSON serializer picks the list deterministically and keeps the
+// rest of the envelope, such as "next", in the trailing line.
+func TestNDJSONSerializerMetadata(t *testing.T) {
+	assert := assert.New(t)
+	payload := Payload{
+		"status":  200,
+		"next":    "http://example.com/api/v1/widgets?next=abc",
+		"results": []Resource{map[string]string{"id": "a"}},
+		"widgets": []Resource{map[string]string{"id": "b"}},
+	}
+
+	for i := 0; i < 10; i++ {
+		body, err := NewNDJSONSerializer().Serialize(payload)
+		assert.Nil(err)
+		assert.Equal(`{"id":"a"}`+"\n"+
+			`{"next":"http://example.com/api/v1/widgets?next=abc","status":200,`+
+			`"widgets":[{"id":"b"}]}`+"\n", string(body))
+	}
+}
+
+// Ensures that XML responses are served for ?format=xml when XMLResponses is set,
+// including error envelopes.
+func TestXMLResponses(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{XMLResponses: true})
+	api.RegisterResourceHandler(NDJSONResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?format=xml", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/xml", w.Header().Get("Content-Type"))
+	assert.Equal(xml.Header+"<response><messages></messages><reason>OK</reason>"+
+		"<results><item><id>a</id></item><item><id>b</id></item></results>"+
+		"<status>200</status></response>", w.Body.String())
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets/c?format=xml", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.Equal(xml.Header+"<response><messages><item>No widget c</item></messages>"+
+		"<reason>Not Found</reason><status>404</status></response>", w.Body.String())
+
+	// XML isn't available unless enabled.
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(NDJSONResourceHandler{})
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets?format=xml", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
+
+// Ensures that the XML serializer writes nested maps in key order, slices as items,
+// and escapes values and invalid element names.
+func TestXMLSerializerSerialize(t *testing.T) {
+	assert := assert.New(t)
+	serializer := NewXMLSerializer()
+
+	actual, err := serializer.Serialize(Payload{
+		"status": 200,
+		"result": map[string]interface{}{
+			"name":    "<a & b>",
+			"tags":    []string{"x", "y"},
+			"nested":  map[string]interface{}{"b": true, "a": 1.5},
+			"missing": nil,
+			"1st":     "first",
+			"xmlns":   "reserved",
+		},
+	})
+
+	assert.Nil(err)
+	assert.Equal(xml.Header+"<response><result>"+
+		`<entry key="1st">first</entry><missing/><name>&lt;a &amp; b&gt;</name>`+
+		"<nested><a>1.5</a><b>true</b></nested><tags><item>x</item><item>y</item></tags>"+
+		`<entry key="xmlns">reserved</entry></result><status>200</status></response>`,
+		string(actual))
+	assert.Equal("application/xml", serializer.ContentType())
+}
+
+// Ensures that acceptedFormat picks the registered format preferred by the Accept
+// header.
+func TestAcceptedFormat(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{XMLResponses: true}).(*muxAPI)
+	tests := []struct {
+		accept   []string
+		expected string
+		err      bool
+	}{
+		{nil, "", false},
+		{[]string{"application/xml"}, "xml", false},
+		{[]string{"Application/XML; charset=utf-8"}, "xml", false},
+		{[]string{"application/json;q=0.5, application/xml"}, "xml", false},
+		{[]string{"application/xml;q=0.1", "application/json;q=0.9"}, "json", false},
+		{[]string{"text/html, application/vnd.myapi.v2+json"}, "json", false},
+		{[]string{"*/*"}, "", false},
+		{[]string{"text/html, */*;q=0.8"}, "", false},
+		{[]string{"application/xml;q=0.5, */*"}, "", false},
+		{[]string{"text/html"}, "", true},
+		{[]string{"application/xml;q=0"}, "", true},
+	}
+
+	for _, test := range tests {
+		format, err := api.acceptedFormat(test.accept)
+		assert.Equal(test.expected, format, "%v", test.accept)
+		assert.Equal(test.err, err != nil, "%v", test.accept)
+	}
+}
+
+// Ensures that the response format is negotiated using the Accept header, falling
+// back to the format query parameter and then json.
+func TestAcceptNegotiation(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{XMLResponses: true})
+	api.RegisterResourceHandler(NDJSONResourceHandler{})
+	tests := []struct {
+		query       string
+		accept      string
+		status      int
+		contentType string
+	}{
+		{"", "application/xml", http.StatusOK, "application/xml"},
+		{"?format=xml", "application/json", http.StatusOK, "application/json"},
+		{"?format=xml", "*/*", http.StatusOK, "application/xml"},
+		{"", "*/*", http.StatusOK, "application/json"},
+		{"", "", http.StatusOK, "application/json"},
+		{"?format=json", "text/html", http.StatusOK, "application/json"},
+		{"", "text/html", http.StatusBadRequest, "application/json"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets"+test.query, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(test.status, w.Code, "%s %s", test.query, test.accept)
+		assert.Equal(test.contentType, w.Header().Get("Content-Type"),
+			"%s %s", test.query, test.accept)
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(
+		`{"messages":["Format not implemented: text/html"],"reason":"Bad Request","status":400}`,
+		w.Body.String())
+}
+
+// Ensures that requests for an unknown format are rejected by default and served as
+// json with UnknownFormatFallbackToDefault.
+func TestUnknownFormatBehavior(t *testing.T) {
+	assert := assert.New(t)
+	ok := `{"messages":[],"reason":"OK","results":[{"id":"a"},{"id":"b"}],"status":200}`
+	tests := []struct {
+		behavior UnknownFormatBehavior
+		query    string
+		accept   string
+		status   int
+		body     string
+	}{
+		{UnknownFormatError, "?format=blah", "", http.StatusBadRequest,
+			`{"messages":["Format not implemented: blah"],"reason":"Bad Request","status":400}`},
+		{UnknownFormatError, "", "text/html", http.StatusBadRequest,
+			`{"messages":["Format not implemented: text/html"],"reason":"Bad Request","status":400}`},
+		{UnknownFormatFallbackToDefault, "?format=blah", "", http.StatusOK, ok},
+		{UnknownFormatFallbackToDefault, "", "text/html", http.StatusOK, ok},
+	}
+
+	for _, test := range tests {
+		api := NewAPI(&Configuration{UnknownFormatBehavior: test.behavior})
+		api.RegisterResourceHandler(NDJSONResourceHandler{})
+
+		req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets"+test.query, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(test.status, w.Code, "%s %s", test.query, test.accept)
+		assert.Equal("application/json", w.Header().Get("Content-Type"))
+		assert.Equal(test.body, w.Body.String(), "%s %s", test.query, test.accept)
+	}
+}
+
+type PanickingItem struct {
+	Foo string
+}
+
+func (p PanickingItem) MarshalJSON() ([]byte, error) {
+	if p.Foo == "panic" {
+		panic("boom")
+	}
+	return json.Marshal(map[string]string{"foo": p.Foo})
+}
+
+type StreamingResourceHandler struct {
+	BaseResourceHandler
+	items []Resource
+}
+
+func (s StreamingResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (s StreamingResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	return s.items, "", nil
+}
+
+// Ensures that a panic partway through a streamed list aborts the response after the
+// items written so far instead of completing it.
+func TestStreamedListPanicAborts(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResponseSerializer("ndjson", NewNDJSONSerializer())
+	api.RegisterResourceHandler(StreamingResourceHandler{items: []Resource{
+		PanickingItem{"a"}, PanickingItem{"b"}, PanickingItem{"panic"}, PanickingItem{"c"},
+	}})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?format=ndjson", nil)
+	w := httptest.NewRecorder()
+	assert.PanicsWithValue(http.ErrAbortHandler, func() { api.ServeHTTP(w, req) })
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("{\"foo\":\"a\"}\n{\"foo\":\"b\"}\n", w.Body.String())
+
+	// Clients see the aborted connection as an error rather than a complete body.
+	server := httptest.NewServer(api)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/api/v1/widgets?format=ndjson")
+	if assert.Nil(err) {
+		defer resp.Body.Close()
+		_, err = ioutil.ReadAll(resp.Body)
+		assert.NotNil(err)
+	}
+}
+
+// Ensures that a panic before any of a streamed list is written produces a clean
+// 500 response.
+func TestStreamedListPanicBeforeWrite(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResponseSerializer("ndjson", NewNDJSONSerializer())
+	api.RegisterResourceHandler(StreamingResourceHandler{items: []Resource{
+		PanickingItem{"panic"}, PanickingItem{"a"},
+	}})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?format=ndjson", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.Equal(`{"messages":["Unable to serialize response"],`+
+		`"reason":"Internal Server Error","status":500}`, w.Body.String())
+}
+
+type FailingStreamingSerializer struct{}
+
+func (f FailingStreamingSerializer) Serialize(p Payload) ([]byte, error) {
+	return nil, fmt.Errorf("secret detail")
+}
+
+func (f FailingStreamingSerializer) SerializeTo(w io.Writer, p Payload) error {
+	return fmt.Errorf("secret detail")
+}
+
+func (f FailingStreamingSerializer) ContentType() string {
+	return "application/x-failing"
+}
+
+// Ensures that a streamed response which fails before anything is written sends a
+// generic 500 without the error's details.
+func TestStreamedResponseErrorBeforeWrite(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResponseSerializer("failing", FailingStreamingSerializer{})
+	api.RegisterResourceHandler(NDJSONResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?format=failing", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.Equal(`{"messages":["Unable to serialize response"],`+
+		`"reason":"Internal Server Error","status":500}`, w.Body.String())
+	assert.NotContains(w.Body.String(), "secret detail")
+}
+
+// Ensures that the NDJSON serializer writes non-list responses as a single line.
+func TestNDJSONSerializerError(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResponseSerializer("ndjson", NewNDJSONSerializer())
+	api.RegisterResourceHandler(NDJSONResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1?format=ndjson", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.Equal(
+		`{"messages":["No widget 1"],"reason":"Not Found","status":404}`+"\n",
+		w.Body.String())
+}
+
+// Ensures that X-HTTP-Method-Override routes are registered by default and when
+// EnableMethodOverride is true.
+func TestMethodOverrideEnabled(t *testing.T) {
+	assert := assert.New(t)
+	enabled := true
+
+	for _, config := range []*Configuration{{}, {EnableMethodOverride: &enabled}} {
+		api := NewAPI(config)
+		api.RegisterResourceHandler(MultiReadResourceHandler{})
+
+		assert.NotNil(api.(*muxAPI).router.Get("widgets:readOverride"))
+		assert.NotNil(api.(*muxAPI).router.Get("widgets:deleteOverride"))
+
+		req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets/1", nil)
+		req.Header.Set("X-HTTP-Method-Override", "GET")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(http.StatusOK, w.Code)
+	}
+}
+
+// Ensures that X-HTTP-Method-Override routes are not registered when
+// EnableMethodOverride is false.
+func TestMethodOverrideDisabled(t *testing.T) {
+	assert := assert.New(t)
+	disabled := false
+	api := NewAPI(&Configuration{EnableMethodOverride: &disabled})
+	api.RegisterResourceHandler(MultiReadResourceHandler{})
+
+	for _, name := range []string{"readResourcesOverride", "readListOverride", "readOverride",
+		"updateListOverride", "updateOverride", "deleteOverride"} {
+		assert.Nil(api.(*muxAPI).router.Get("widgets:"+name), name)
+	}
+	assert.NotNil(api.(*muxAPI).router.Get("widgets:read"))
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets/1", nil)
+	req.Header.Set("X-HTTP-Method-Override", "GET")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+}
+
+type PublicReadResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (p PublicReadResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (p PublicReadResourceHandler) AuthExemptMethods() []HandleMethod {
+	return []HandleMethod{HandleRead, HandleReadList}
+}
+
+func (p PublicReadResourceHandler) Authenticate(r *http.Request) error {
+	if r.Header.Get("Authorization") == "" {
+		return fmt.Errorf("Not authorized")
+	}
+	return nil
+}
+
+func (p PublicReadResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return &TestResource{Foo: id}, nil
+}
+
+func (p PublicReadResourceHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	return &TestResource{Foo: "created"}, nil
+}
+
+// Ensures that requests to AuthExemptMethods skip authentication while other methods
+// still require it.
+func TestAuthExemptMethods(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(PublicReadResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("POST", "http://example.com/api/v1/widgets/1", nil)
+	req.Header.Set("X-HTTP-Method-Override", "GET")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"foo": "bar"}`))
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusUnauthorized, w.Code)
+	assert.Equal("Not authorized", w.Body.String())
+
+	req, _ = http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"foo": "bar"}`))
+	req.Header.Set("Authorization", "token")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusCreated, w.Code)
+}
+
+// Ensures that requests with a body are rejected with a 415 unless their content type
+// is in AllowedContentTypes, while requests without a body are unaffected.
+func TestAllowedContentTypes(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{
+		AllowedContentTypes: []string{"application/json", "application/vnd.api+json"},
+	})
+	handler := new(MockResourceHandler)
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(&rules{})
+	handler.On("ResourceName").Return("widgets")
+	handler.On("CreateResource").Return(&TestResource{Foo: "bar"}, nil)
+	handler.On("ReadResource").Return(&TestResource{Foo: "bar"}, nil)
+
+	api.RegisterResourceHandler(handler)
+
+	// Allowed type
+	for _, contentType := range []string{"application/json; charset=utf-8", "application/vnd.api+json"} {
+		req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+			bytes.NewBufferString(`{"foo": "bar"}`))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		assert.Equal(http.StatusCreated, w.Code, contentType)
+	}
+
+	// Disallowed type
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`foo=bar`))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusUnsupportedMediaType, w.Code)
+	assert.Equal("Unsupported content type: application/x-www-form-urlencoded", w.Body.String())
+
+	// Missing type
+	req, _ = http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"foo": "bar"}`))
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusUnsupportedMediaType, w.Code)
+
+	// No body
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+}
+
+// Ensures that the processing time is reported in a header and the envelope when
+// IncludeTiming is enabled.
+func TestIncludeTiming(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{IncludeTiming: true})
+	api.RegisterResourceHandler(MultiReadResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Regexp(`^\d+\.\d{3}ms$`, w.Header().Get("X-Response-Time"))
+
+	var payload map[string]interface{}
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &payload))
+	if assert.Contains(payload, "meta") {
+		assert.IsType(float64(0), payload["meta"].(map[string]interface{})["duration_ms"])
+	}
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets/missing", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.NotEmpty(w.Header().Get("X-Response-Time"))
+	assert.Contains(w.Body.String(), `"meta":{"duration_ms":`)
+}
+
+// Ensures that the processing time isn't reported when IncludeTiming is disabled.
+func TestIncludeTimingDisabled(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(MultiReadResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Empty(w.Header().Get("X-Response-Time"))
+	assert.NotContains(w.Body.String(), "meta")
+}
+
+type VersionedResourceHandler struct {
+	BaseResourceHandler
+	validVersions []string
+}
+
+func (v VersionedResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (v VersionedResourceHandler) ValidVersions() []string {
+	return v.validVersions
+}
+
+func (v VersionedResourceHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "id", Versions: []string{"2", "1"}},
+		&Rule{Field: "Foo", FieldAlias: "foo", Versions: []string{"3"}},
+	)
+}
+
+func (v VersionedResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return &TestResource{Foo: id}, nil
+}
+
+// Ensures that the versions supported by a resource are listed when IncludeVersions
+// is enabled, preferring its ValidVersions over the versions of its Rules.
+func TestIncludeVersions(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{IncludeVersions: true})
+	api.RegisterResourceHandler(VersionedResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("1, 2, 3", w.Header().Get("X-API-Versions"))
+	assert.Contains(w.Body.String(), `"meta":{"versions":["1","2","3"]}`)
+
+	api = NewAPI(&Configuration{IncludeVersions: true})
+	api.RegisterResourceHandler(VersionedResourceHandler{validVersions: []string{"1", "2"}})
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v3/widgets/1", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v2/widgets/1", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("1, 2", w.Header().Get("X-API-Versions"))
+	assert.Contains(w.Body.String(), `"meta":{"versions":["1","2"]}`)
+}
+
+// Ensures that no versions are listed for resources which support any version or when
+// IncludeVersions is disabled.
+func TestIncludeVersionsUnversioned(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{IncludeVersions: true})
+	api.RegisterResourceHandler(MultiReadResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Empty(w.Header().Get("X-API-Versions"))
+	assert.NotContains(w.Body.String(), "meta")
+
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(VersionedResourceHandler{})
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Empty(w.Header().Get("X-API-Versions"))
+	assert.NotContains(w.Body.String(), "meta")
+}
+
+type Money struct {
+	Cents    int64
+	Currency string
+}
+
+type PricedResource struct {
+	Name  string
+	Price Money
+	Cost  *Money
+}
+
+type PricedResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (p PricedResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (p PricedResourceHandler) Rules() Rules {
+	return NewRules((*PricedResource)(nil),
+		&Rule{Field: "Name", FieldAlias: "name"},
+		&Rule{Field: "Price", FieldAlias: "price"},
+		&Rule{Field: "Cost", FieldAlias: "cost"},
+		&Rule{Field: "Price", FieldAlias: "cents", OutputHandler: func(value interface{}) interface{} {
+			return value.(Money).Cents
+		}},
+	)
+}
+
+func (p PricedResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return &PricedResource{
+		Name:  id,
+		Price: Money{Cents: 1250, Currency: "USD"},
+		Cost:  &Money{Cents: 800, Currency: "USD"},
+	}, nil
+}
+
+// Ensures that field values are serialized using the TypeSerializer registered for
+// their type unless the Rule specifies an OutputHandler.
+func TestRegisterTypeSerializer(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterTypeSerializer(Money{}, func(value interface{}) interface{} {
+		money := value.(Money)
+		return fmt.Sprintf("%d.%02d %s", money.Cents/100, money.Cents%100, money.Currency)
+	})
+	api.RegisterResourceHandler(PricedResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/foo", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(
+		`{"messages":[],"reason":"OK","result":{"cents":1250,"cost":"8.00 USD","name":"foo","price":"12.50 USD"},"status":200}`,
+		w.Body.String())
+
+	// TypeSerializers are registered per API.
+	other := NewAPI(&Configuration{})
+	other.RegisterResourceHandler(PricedResourceHandler{})
+	w = httptest.NewRecorder()
+	other.ServeHTTP(w, req)
+
+	assert.Contains(w.Body.String(), `"price":{"Cents":1250,"Currency":"USD"}`)
+
+	api.UnregisterTypeSerializer(Money{})
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Contains(w.Body.String(), `"price":{"Cents":1250,"Currency":"USD"}`)
+}
+
+type TreeResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (t TreeResourceHandler) ResourceName() string {
+	return "nodes"
+}
+
+func (t TreeResourceHandler) Rules() Rules {
+	return selfReferentialRules()
+}
+
+func (t TreeResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	// The node is its own parent, so the data has a cycle.
+	node := &treeResource{Name: id}
+	node.Parent = node
+	return node, nil
+}
+
+// Ensures that MaxNestingDepth stops outbound Rules from recursing through cyclic data.
+func TestMaxNestingDepth(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{MaxNestingDepth: 2})
+	api.RegisterResourceHandler(TreeResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/nodes/a", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(
+		`{"messages":[],"reason":"OK","result":{"children":[],"name":"a","parent":{"children":[],"name":"a","parent":{"name":"a"}}},"status":200}`,
+		w.Body.String())
+}
+
+// Ensures that nested Rules are limited to the default depth when MaxNestingDepth
+// isn't set.
+func TestMaxNestingDepthDefault(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(TreeResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/nodes/a", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(defaultNestingDepth, strings.Count(w.Body.String(), `"parent"`))
+}
+
+type MediaTypeVersionResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (m MediaTypeVersionResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (m MediaTypeVersionResourceHandler) ReadURI() string {
+	return "/widgets/{resource_id}"
+}
+
+func (m MediaTypeVersionResourceHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "v1", Versions: []string{"1"}},
+		&Rule{Field: "Foo", FieldAlias: "v2", Versions: []string{"2"}},
+	)
+}
+
+func (m MediaTypeVersionResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return &TestResource{Foo: id}, nil
+}
+
+func (m MediaTypeVersionResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	return []Resource{&TestResource{Foo: "a"}}, "", nil
+}
+
+// Ensures that the version is read from the vendor media type when the path doesn't
+// specify one and the path version takes precedence when it does.
+func TestMediaTypeVersioning(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{MediaTypeVersioning: true})
+	api.RegisterResourceHandler(MediaTypeVersionResourceHandler{})
+
+	tests := []struct {
+		url      string
+		header   string
+		value    string
+		expected string
+	}{
+		{"/widgets/1", "Accept", "text/html, application/vnd.myapi.v2+json", `"result":{"v2":"1"}`},
+		{"/widgets/1", "Content-Type", "application/vnd.myapi.v1+json; charset=utf-8", `"result":{"v1":"1"}`},
+		{"/widgets/1", "Accept", "application/json", `"result":{"foo":"1"}`},
+		{"/api/v1/widgets", "Accept", "application/vnd.myapi.v2+json", `"results":[{"v1":"a"}]`},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com"+test.url, nil)
+		req.Header.Set(test.header, test.value)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(http.StatusOK, w.Code, test.value)
+		assert.Contains(w.Body.String(), test.expected, test.value)
+	}
+}
+
+// Ensures that the Vary header lists the request headers used to negotiate the
+// response.
+func TestVaryHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	api := NewAPI(&Configuration{MediaTypeVersioning: true})
+	api.RegisterResourceHandler(MediaTypeVersionResourceHandler{})
+	req, _ := http.NewRequest("GET", "http://example.com/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal("Accept, Content-Type", w.Header().Get("Vary"))
+
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(MediaTypeVersionResourceHandler{})
+	req, _ = http.NewRequest("GET", "http://example.com/widgets/1", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal("Accept", w.Header().Get("Vary"))
+}
+
+// Ensures that addVary merges fields into an existing Vary header without
+// duplicates.
+func TestAddVary(t *testing.T) {
+	assert := assert.New(t)
+	header := http.Header{}
+
+	addVary(header)
+	assert.Equal("", header.Get("Vary"))
+
+	header.Set("Vary", "Origin, accept")
+	addVary(header, "Accept", "Content-Type")
+	assert.Equal("Origin, accept, Content-Type", header.Get("Vary"))
+}
+
+// Ensures that mediaTypeVersion parses versions from vendor media types only.
+func TestMediaTypeVersion(t *testing.T) {
+	assert := assert.New(t)
+	tests := map[string]string{
+		"application/vnd.myapi.v2+json":     "2",
+		"application/vnd.myapi.v2.1+json":   "2.1",
+		"application/vnd.v3":                "3",
+		"application/vnd.myapi.vendor+json": "",
+		"application/json":                  "",
+		"":                                  "",
+	}
+
+	for mediaType, expected := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set("Accept", mediaType)
+		assert.Equal(expected, mediaTypeVersion(req), mediaType)
+	}
+}
+
+type AsyncResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (a AsyncResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (a AsyncResourceHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil), &Rule{Field: "Foo", FieldAlias: "foo"})
+}
+
+func (a AsyncResourceHandler) ResourceID(resource Resource) string {
+	return resource.(*TestResource).Foo
+}
+
+func (a AsyncResourceHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	return Accepted("/api/v1/jobs/1"), nil
+}
+
+func (a AsyncResourceHandler) UpdateResource(ctx RequestContext, id string,
+	data Payload, version string) (Resource, error) {
+	return Accepted("/api/v1/jobs/2"), nil
+}
+
+func (a AsyncResourceHandler) DeleteResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return Accepted("/api/v1/jobs/3"), nil
+}
+
+// Ensures that handlers returning an AcceptedResource respond with a 202 pointing at
+// the status polling URL.
+func TestAcceptedResource(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(AsyncResourceHandler{})
+
+	tests := []struct {
+		method    string
+		url       string
+		statusURL string
+	}{
+		{"POST", "http://example.com/api/v1/widgets", "/api/v1/jobs/1"},
+		{"PUT", "http://example.com/api/v1/widgets/1", "/api/v1/jobs/2"},
+		{"DELETE", "http://example.com/api/v1/widgets/1", "/api/v1/jobs/3"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, test.url, bytes.NewBufferString(`{"foo": "bar"}`))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(http.StatusAccepted, w.Code, test.method)
+		assert.Equal(test.statusURL, w.Header().Get("Location"), test.method)
+		assert.Equal(test.statusURL, w.Header().Get("Content-Location"), test.method)
+		assert.Equal("no-store", w.Header().Get("Cache-Control"), test.method)
+		assert.Empty(w.Body.String(), test.method)
+	}
+}
+
+// Ensures that log lines emitted while handling a request carry its request ID, taken
+// from the X-Request-ID header or generated if it isn't set.
+func TestRequestIDLogged(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(MultiReadResourceHandler{})
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"id": "1", "bar": "baz"}`))
+	req.Header.Set("X-Request-ID", "abc123")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal("[abc123] Discarding field 'bar'\n", buf.String())
+
+	buf.Reset()
+	req, _ = http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"id": "1", "bar": "baz"}`))
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Regexp(`^\[[0-9a-f]{16}\] Discarding field 'bar'\n$`, buf.String())
+}
+
+// Ensures that request log lines are written to the Configuration's Logger and that
+// request ids containing format verbs are logged verbatim.
+func TestRequestLoggerUsesConfiguredLogger(t *testing.T) {
+	assert := assert.New(t)
+	var global bytes.Buffer
+	log.SetOutput(&global)
+	defer log.SetOutput(os.Stderr)
+
+	var buf bytes.Buffer
+	api := NewAPI(&Configuration{
+		Logger:               log.New(&buf, "", 0),
+		SlowRequestThreshold: time.Nanosecond,
+	})
+	api.RegisterResourceHandler(MultiReadResourceHandler{})
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"id": "1", "bar": "baz"}`))
+	req.Header.Set("X-Request-ID", "%s%d%n")
+	api.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(lines, 2) {
+		assert.Equal("[%s%d%n] Discarding field 'bar'", lines[0])
+		assert.Regexp(`^\[%s%d%n\] Slow request: widgets create took \S+$`, lines[1])
+	}
+	assert.Empty(global.String())
+}
+
+type FlagResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (f FlagResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (f FlagResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	foo := "old"
+	if ctx.Flag("new-foo") && ctx.Flag("new-foo") {
+		foo = "new"
+	}
+	if ctx.Flag("beta") {
+		foo += "-beta"
+	}
+	return &TestResource{Foo: foo}, nil
+}
+
+// Ensures that feature flags are resolved per request using the FlagResolver and
+// cached for the rest of the request.
+func TestFlagResolver(t *testing.T) {
+	assert := assert.New(t)
+	calls := map[string]int{}
+	api := NewAPI(&Configuration{
+		FlagResolver: func(ctx RequestContext, name string) bool {
+			calls[name]++
+			r, _ := ctx.Request()
+			return name == "new-foo" && r.Header.Get("X-Tenant") == "acme"
+		},
+	})
+	api.RegisterResourceHandler(FlagResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	req.Header.Set("X-Tenant", "acme")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"foo":"new"`)
+	assert.Equal(map[string]int{"new-foo": 1, "beta": 1}, calls)
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	req.Header.Set("X-Tenant", "other")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Contains(w.Body.String(), `"foo":"old"`)
+	assert.Equal(map[string]int{"new-foo": 2, "beta": 2}, calls)
+}
+
+type BeforeHandleFlagResourceHandler struct {
+	FlagResourceHandler
+}
+
+func (f BeforeHandleFlagResourceHandler) BeforeHandle(ctx RequestContext,
+	method HandleMethod) error {
+	if !ctx.Flag("new-foo") {
+		return ResourceNotPermitted("new-foo is disabled")
+	}
+	return nil
+}
+
+// Ensures that feature flags read in BeforeHandle are resolved once per request,
+// sharing the cache with the ResourceHandler.
+func TestFlagResolverBeforeHandle(t *testing.T) {
+	assert := assert.New(t)
+	calls := map[string]int{}
+	api := NewAPI(&Configuration{
+		FlagResolver: func(ctx RequestContext, name string) bool {
+			calls[name]++
+			return name == "new-foo"
+		},
+	})
+	api.RegisterResourceHandler(BeforeHandleFlagResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"foo":"new"`)
+	assert.Equal(map[string]int{"new-foo": 1, "beta": 1}, calls)
+}
+
+// Ensures that feature flags are disabled when there is no FlagResolver.
+func TestFlagNoResolver(t *testing.T) {
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(FlagResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Contains(t, w.Body.String(), `"foo":"old"`)
+}
+
+type DeprecatedFieldResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (d DeprecatedFieldResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (d DeprecatedFieldResourceHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo"},
+		&Rule{Field: "Foo", FieldAlias: "legacy_foo", Deprecated: true, OutputOnly: true},
+		&Rule{FieldAlias: "old", Deprecated: true, DeprecatedMessage: "Use 'foo' instead", InputOnly: true},
+	)
+}
+
+func (d DeprecatedFieldResourceHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	return &TestResource{Foo: "bar"}, nil
+}
+
+func (d DeprecatedFieldResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	return []Resource{&TestResource{Foo: "a"}, &TestResource{Foo: "b"}}, "", nil
+}
+
+// Ensures that using deprecated fields adds a warning to the response.
+func TestDeprecatedFieldWarnings(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(DeprecatedFieldResourceHandler{})
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"foo": "bar", "old": true}`))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusCreated, w.Code)
+	assert.Equal([]string{
+		`299 - "Use 'foo' instead"`,
+		`299 - "Field 'legacy_foo' is deprecated"`,
+	}, w.Header()["Warning"])
+	assert.Contains(w.Body.String(),
+		`"messages":["Use 'foo' instead","Field 'legacy_foo' is deprecated"]`)
+
+	// Warnings are sent once per request.
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal([]string{`299 - "Field 'legacy_foo' is deprecated"`}, w.Header()["Warning"])
+	assert.Contains(w.Body.String(), `"messages":["Field 'legacy_foo' is deprecated"]`)
+}
+
+type PartialResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (p PartialResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (p PartialResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	all := []Resource{&TestResource{Foo: "a"}, &TestResource{Foo: "b"}, &TestResource{Foo: "c"}}
+	if limit >= len(all) {
+		return all, "", nil
+	}
+	ctx.SetPartial()
+	return all[:limit], "next", nil
+}
+
+// Ensures that list reads respond 200 when complete and 206 with a Content-Range
+// header when the handler marks them as partial.
+func TestHandleReadListPartial(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(PartialResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?limit=10", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Empty(w.Header().Get("Content-Range"))
+	assert.Contains(w.Body.String(), `"status":200`)
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets?limit=2", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusPartialContent, w.Code)
+	assert.Equal("items 0-1/*", w.Header().Get("Content-Range"))
+	assert.Contains(w.Body.String(), `"results":[{"foo":"a"},{"foo":"b"}]`)
+	assert.Contains(w.Body.String(), `"status":206`)
+	assert.Contains(w.Body.String(), `"next":"`)
+}
+
+type PartialOffsetResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (p PartialOffsetResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (p PartialOffsetResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	return nil, "", nil
+}
+
+func (p PartialOffsetResourceHandler) ReadResourceListOffset(ctx RequestContext, limit,
+	offset int, version string) ([]Resource, error) {
+	all := []Resource{&TestResource{Foo: "a"}, &TestResource{Foo: "b"},
+		&TestResource{Foo: "c"}, &TestResource{Foo: "d"}, &TestResource{Foo: "e"}}
+	end := offset + limit
+	if end > len(all) {
+		end = len(all)
+	}
+	if end < len(all) {
+		ctx.SetPartial()
+	}
+	return all[offset:end], nil
+}
+
+func (p PartialOffsetResourceHandler) CountResources(ctx RequestContext,
+	version string) (int, error) {
+	return 5, nil
+}
+
+// Ensures that the Content-Range of partial list reads paginated by offset starts at
+// the offset and includes the total when it's counted.
+func TestHandleReadListPartialOffset(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(PartialOffsetResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?limit=2&offset=2", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusPartialContent, w.Code)
+	assert.Equal("items 2-3/*", w.Header().Get("Content-Range"))
+
+	req, _ = http.NewRequest("GET",
+		"http://example.com/api/v1/widgets?limit=2&page=2&include=count", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusPartialContent, w.Code)
+	assert.Equal("items 2-3/5", w.Header().Get("Content-Range"))
+}
+
+type ItemsResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (i ItemsResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (i ItemsResourceHandler) Rules() Rules {
+	return NewRules((*TestResourceSlice)(nil),
+		&Rule{Field: "Foo", FieldAlias: "items", MinItems: 1, MaxItems: 2},
+	)
+}
+
+func (i ItemsResourceHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	return data, nil
+}
+
+// Ensures that slices outside of MinItems and MaxItems are rejected with a 422.
+func TestHandleCreateItemCount(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(ItemsResourceHandler{})
+
+	for body, status := range map[string]int{
+		`{"items": []}`:              statusUnprocessableEntity,
+		`{"items": ["a"]}`:           http.StatusCreated,
+		`{"items": ["a", "b", "c"]}`: statusUnprocessableEntity,
+	} {
+		req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+			bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(status, w.Code, body)
+	}
+}
+
+type AuditResource struct {
+	Foo    string `json:"foo"`
+	Secret string `json:"secret"`
+}
+
+type AuditResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (a AuditResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (a AuditResourceHandler) Rules() Rules {
+	return NewRules((*AuditResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo"},
+		&Rule{Field: "Secret", FieldAlias: "secret", Sensitive: true, InputOnly: true},
+	)
+}
+
+func (a AuditResourceHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	return &AuditResource{Foo: data["foo"].(string)}, nil
+}
+
+func (a AuditResourceHandler) UpdateResource(ctx RequestContext, id string, data Payload,
+	version string) (Resource, error) {
+	if id == "missing" {
+		return nil, ResourceNotFound("missing")
+	}
+	return &AuditResource{Foo: data["foo"].(string)}, nil
+}
+
+// Ensures that AuditWrite receives the inbound payload of successful writes with
+// Sensitive fields masked.
+func TestAuditWrite(t *testing.T) {
+	assert := assert.New(t)
+	audited := []string{}
+	api := NewAPI(&Configuration{
+		AuditWrite: func(ctx RequestContext, resource string, method HandleMethod,
+			changed Payload) {
+			audited = append(audited, fmt.Sprintf("%s %s %v", resource, method, changed))
+		},
+	})
+	api.RegisterResourceHandler(AuditResourceHandler{})
+
+	payload := `{"foo": "bar", "secret": "hunter2"}`
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		strings.NewReader(payload))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusCreated, w.Code)
+
+	req, _ = http.NewRequest("PUT", "http://example.com/api/v1/widgets/1",
+		strings.NewReader(payload))
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NotContains(w.Body.String(), "hunter2")
+
+	// Failed writes aren't audited.
+	req, _ = http.NewRequest("PUT", "http://example.com/api/v1/widgets/missing",
+		strings.NewReader(payload))
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	assert.Equal([]string{
+		"widgets create map[foo:bar secret:********]",
+		"widgets update map[foo:bar secret:********]",
+	}, audited)
+}
+
+type BatchResource struct {
+	Foo  int    `json:"foo"`
+	Bar  string `json:"bar"`
+	Skip string `json:"skip"`
+}
+
+type BatchResourceHandler struct {
+	BaseResourceHandler
+	received *[]Payload
+}
+
+func (b BatchResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (b BatchResourceHandler) Rules() Rules {
+	return NewRules((*BatchResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", Type: Int, Required: true},
+		&Rule{Field: "Bar", FieldAlias: "bar", Type: String, Versions: []string{"2"}},
+		&Rule{Field: "Skip", FieldAlias: "skip", OutputOnly: true},
+	)
+}
+
+func (b BatchResourceHandler) UpdateResourceList(ctx RequestContext, data []Payload,
+	version string) ([]Resource, error) {
+	*b.received = data
+	return []Resource{}, nil
+}
+
+// batchPayload returns a JSON update list payload with n items.
+func batchPayload(n int) []byte {
+	items := make([]string, n)
+	for i := range items {
+		items[i] = fmt.Sprintf(`{"foo": "%d", "bar": "b%d", "skip": "s"}`, i, i)
+	}
+	return []byte("[" + strings.Join(items, ",") + "]")
+}
+
+// Ensures that applying the inbound Rules to a batch yields the same Payloads as
+// applying them to each item individually.
+func TestHandleUpdateListBatchRules(t *testing.T) {
+	assert := assert.New(t)
+	received := []Payload{}
+	handler := BatchResourceHandler{received: &received}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler)
+
+	for _, version := range []string{"1", "2"} {
+		payload := batchPayload(3)
+		req, _ := http.NewRequest("PUT", "http://example.com/api/v"+version+"/widgets",
+			bytes.NewReader(payload))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		assert.Equal(http.StatusOK, w.Code, version)
+
+		items, err := decodePayloadSlice(payload)
+		assert.Nil(err)
+		expected := make([]Payload, len(items))
+		for i, item := range items {
+			expected[i], err = applyInboundRules(item, handler.Rules(), version)
+			assert.Nil(err)
+		}
+		assert.Equal(expected, received, version)
+	}
+}
+
+// Ensures that validation errors in a batch identify the failing item and stop
+// processing unless all errors are collected.
+func TestHandleUpdateListBatchRulesErrors(t *testing.T) {
+	assert := assert.New(t)
+	received := []Payload{}
+	payload := `[{"foo": 1}, {"foo": "x"}, {}]`
+
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(BatchResourceHandler{received: &received})
+	req, _ := http.NewRequest("PUT", "http://example.com/api/v1/widgets",
+		strings.NewReader(payload))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(w.Body.String(), `parsing \"x\": invalid syntax`)
+
+	api = NewAPI(&Configuration{CollectAllValidationErrors: true})
+	api.RegisterResourceHandler(BatchResourceHandler{received: &received})
+	req, _ = http.NewRequest("PUT", "http://example.com/api/v1/widgets",
+		strings.NewReader(payload))
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(w.Body.String(), `"field":"1.foo"`)
+	assert.Contains(w.Body.String(), `"field":"2.foo"`)
+	assert.Empty(received)
+}
+
+// Benchmarks a large update list request, which applies the inbound Rules to each
+// item.
+func BenchmarkHandleUpdateList(b *testing.B) {
+	received := []Payload{}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(BatchResourceHandler{received: &received})
+	payload := batchPayload(1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, _ := http.NewRequest("PUT", "http://example.com/api/v2/widgets",
+			bytes.NewReader(payload))
+		api.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+type Order struct {
+	ID         string `json:"id"`
+	CustomerID int    `json:"customer_id"`
+	SellerID   string `json:"seller_id"`
+}
+
+type OrderResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (o OrderResourceHandler) ResourceName() string {
+	return "orders"
+}
+
+func (o OrderResourceHandler) Rules() Rules {
+	return NewRules((*Order)(nil),
+		&Rule{Field: "ID", FieldAlias: "id"},
+		&Rule{Field: "CustomerID", FieldAlias: "customer_id", RelatedResource: "customers"},
+		&Rule{Field: "SellerID", FieldAlias: "seller_id", RelatedResource: "sellers"},
+	)
+}
+
+func (o OrderResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return &Order{ID: id, CustomerID: 42}, nil
+}
+
+type CustomerResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (c CustomerResourceHandler) ResourceName() string {
+	return "customers"
+}
+
+// Ensures that responses link to the related resources identified by fields with a
+// RelatedResource, omitting empty ids.
+func TestRelatedResourceLinks(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(OrderResourceHandler{})
+	api.RegisterResourceHandler(CustomerResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/orders/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"messages":[],"reason":"OK","result":{"customer_id":42,"id":"1",`+
+		`"links":{"customer":"http://example.com/api/v1/customers/42"},"seller_id":""},"status":200}`,
+		w.Body.String())
+}
+
+// Ensures that related resource links are merged into existing links and that
+// links to resources without a read route are skipped.
+func TestAddRelatedLinks(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(CustomerResourceHandler{})
+	req, _ := http.NewRequest("GET", "http://example.com/api/v2/orders/1", nil)
+	req = setValueOnRequestContext(req, "version", "2")
+	ctx := NewContextWithRouter(req, httptest.NewRecorder(), api.(*muxAPI).router)
+	rules := OrderResourceHandler{}.Rules()
+
+	self := map[string]interface{}{"self": "http://example.com/api/v2/orders/1"}
+	payload := Payload{"customer_id": 7, "seller_id": "3", "links": self}
+	addRelatedLinks(ctx, payload, rules)
+
+	assert.Equal(map[string]interface{}{
+		"self":     "http://example.com/api/v2/orders/1",
+		"customer": "http://example.com/api/v2/customers/7",
+	}, payload["links"])
+	assert.Len(self, 1)
+}
+
+// Ensures that the MetricsObserver receives the request and response body sizes
+// along with the status of each request.
+func TestMetricsObserver(t *testing.T) {
+	assert := assert.New(t)
+	observed := []RequestMetrics{}
+	api := NewAPI(&Configuration{
+		MetricsObserver: func(ctx RequestContext, metrics RequestMetrics) {
+			observed = append(observed, metrics)
+		},
+	})
+	api.RegisterResourceHandler(AuditResourceHandler{})
+
+	payload := `{"foo": "bar"}`
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		strings.NewReader(payload))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	r := httptest.NewRecorder()
+	api.ServeHTTP(r, req)
+
+	if assert.Len(observed, 2) {
+		assert.Equal("widgets", observed[0].Resource)
+		assert.Equal(HandleCreate, observed[0].Method)
+		assert.Equal(http.StatusCreated, observed[0].Status)
+		assert.Equal(len(payload), observed[0].RequestBytes)
+		assert.Equal(w.Body.Len(), observed[0].ResponseBytes)
+		assert.True(observed[0].Duration > 0)
+
+		assert.EqualValues(HandleRead, observed[1].Method)
+		assert.Equal(http.StatusMethodNotAllowed, observed[1].Status)
+		assert.Equal(0, observed[1].RequestBytes)
+		assert.Equal(r.Body.Len(), observed[1].ResponseBytes)
+	}
+}
+
+// Ensures that Routes lists the CRUD routes of a registered ResourceHandler and that
+// RouteHandler returns their handlers by name.
+func TestRoutes(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{EnableMethodOverride: new(bool)})
+	api.RegisterResourceHandler(TestResourceHandler{})
+
+	assert.Equal([]RouteInfo{
+		{"widgets:create", []string{"POST"}, "/api/v{version:[^/]+}/widgets"},
+		{"widgets:readResources", []string{"GET"}, "/api/v{version:[^/]+}/widgets"},
+		{"widgets:readList", []string{"GET"}, "/api/v{version:[^/]+}/widgets"},
+		{"widgets:read", []string{"GET"}, "/api/v{version:[^/]+}/widgets/{resource_id}"},
+		{"widgets:updateList", []string{"PUT"}, "/api/v{version:[^/]+}/widgets"},
+		{"widgets:update", []string{"PUT"}, "/api/v{version:[^/]+}/widgets/{resource_id}"},
+		{"widgets:patch", []string{"PATCH"}, "/api/v{version:[^/]+}/widgets/{resource_id}"},
+		{"widgets:delete", []string{"DELETE"}, "/api/v{version:[^/]+}/widgets/{resource_id}"},
+	}, api.Routes())
+
+	handler, err := api.RouteHandler("widgets:read")
+	assert.Nil(err)
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+
+	_, err = api.RouteHandler("widgets:nope")
+	assert.EqualError(err, "No API route with name widgets:nope")
+}
+
+type LimitResourceHandler struct {
+	BaseResourceHandler
+	name         string
+	defaultLimit int
+	maxLimit     int
+}
+
+func (l LimitResourceHandler) ResourceName() string {
+	if l.name != "" {
+		return l.name
+	}
+	return "widgets"
+}
+
+func (l LimitResourceHandler) DefaultLimit() int {
+	return l.defaultLimit
+}
+
+func (l LimitResourceHandler) MaxLimit() int {
+	return l.maxLimit
+}
+
+func (l LimitResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	return []Resource{limit}, "", nil
+}
+
+// Ensures that list reads without a limit use the ResourceHandler's default limit,
+// falling back to the configured one.
+func TestDefaultLimit(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		config   int
+		handler  int
+		query    string
+		expected string
+	}{
+		{0, 0, "", `"results":[100]`},
+		{50, 0, "", `"results":[50]`},
+		{50, 10, "", `"results":[10]`},
+		{50, 10, "?limit=3", `"results":[3]`},
+		{50, 10, "?limit=0", `"results":[10]`},
+		{50, 10, "?limit=-3", `"results":[10]`},
+	}
+
+	for _, test := range tests {
+		api := NewAPI(&Configuration{DefaultLimit: test.config})
+		api.RegisterResourceHandler(LimitResourceHandler{defaultLimit: test.handler})
+
+		req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets"+test.query, nil)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(http.StatusOK, w.Code)
+		assert.Contains(w.Body.String(), test.expected)
+	}
+}
+
+// Ensures that list read limits are capped at the ResourceHandler's maximum limit,
+// falling back to the configured one.
+func TestMaxLimit(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{MaxLimit: 500})
+	api.RegisterResourceHandler(LimitResourceHandler{name: "light", maxLimit: 10000})
+	api.RegisterResourceHandler(LimitResourceHandler{name: "heavy", maxLimit: 50})
+	api.RegisterResourceHandler(LimitResourceHandler{name: "default"})
+	api.RegisterResourceHandler(LimitResourceHandler{name: "small", defaultLimit: 200, maxLimit: 20})
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"light?limit=10000", `"results":[10000]`},
+		{"light?limit=20000", `"results":[10000]`},
+		{"heavy?limit=10", `"results":[10]`},
+		{"heavy?limit=10000", `"results":[50]`},
+		{"default?limit=400", `"results":[400]`},
+		{"default?limit=10000", `"results":[500]`},
+		{"small", `"results":[20]`},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com/api/v1/"+test.path, nil)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(http.StatusOK, w.Code, test.path)
+		assert.Contains(w.Body.String(), test.expected, test.path)
+	}
+}
+
+type BeforeHandleResourceHandler struct {
+	BaseResourceHandler
+	before func(RequestContext, HandleMethod) error
+	read   func(RequestContext)
+}
+
+func (b BeforeHandleResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (b BeforeHandleResourceHandler) BeforeHandle(ctx RequestContext, method HandleMethod) error {
+	return b.before(ctx, method)
+}
+
+func (b BeforeHandleResourceHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	return data, nil
+}
+
+func (b BeforeHandleResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	if b.read != nil {
+		b.read(ctx)
+	}
+	return map[string]interface{}{"id": id}, nil
+}
+
+// Ensures that BeforeHandle is invoked with the HandleMethod before the request is
+// handled and that requests it allows are passed to the ResourceHandler.
+func TestBeforeHandleAllows(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	var methods []HandleMethod
+	api.RegisterResourceHandler(BeforeHandleResourceHandler{
+		before: func(ctx RequestContext, method HandleMethod) error {
+			methods = append(methods, method)
+			return nil
+		},
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"id":"1"`)
+
+	// The request body is still available to the ResourceHandler.
+	req, _ = http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"name":"foo"}`))
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusCreated, w.Code)
+	assert.Contains(w.Body.String(), `"result":{"name":"foo"}`)
+	assert.Equal([]HandleMethod{HandleMethod(HandleRead), HandleCreate}, methods)
+}
+
+// Ensures that an error returned by BeforeHandle short-circuits the request with the
+// error's status.
+func TestBeforeHandleRejects(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(BeforeHandleResourceHandler{
+		before: func(ctx RequestContext, method HandleMethod) error {
+			return ResourceNotPermitted("Unknown tenant")
+		},
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusForbidden, w.Code)
+	assert.Equal(`{"messages":["Unknown tenant"],"reason":"Forbidden","status":403}`,
+		w.Body.String())
+}
+
+// Ensures that URLs built by BuildURL for ids containing slashes and other escaped
+// characters are routed back to the ResourceHandler with the original id.
+func TestBuildURLRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(BeforeHandleResourceHandler{
+		before: func(ctx RequestContext, method HandleMethod) error {
+			return nil
+		},
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	req = setValueOnRequestContext(req, "version", "1")
+	ctx := NewContextWithRouter(req, httptest.NewRecorder(), api.(*muxAPI).router)
+
+	for _, id := range []string{"a/b", "a b", "café", "100%", "a?b#c"} {
+		u, err := ctx.BuildURL("widgets", HandleRead, RouteVars{"resource_id": id})
+		assert.Nil(err)
+
+		req, _ := http.NewRequest("GET", u.String(), nil)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(http.StatusOK, w.Code, id)
+		var payload map[string]interface{}
+		assert.Nil(json.Unmarshal(w.Body.Bytes(), &payload))
+		assert.Equal(map[string]interface{}{"id": id}, payload["result"], id)
+	}
+}
+
+// Ensures that the RequestContext passed to BeforeHandle is the one passed to the
+// ResourceHandler, so messages added to it are sent with the response.
+func TestBeforeHandleContext(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	var before, read RequestContext
+	api.RegisterResourceHandler(BeforeHandleResourceHandler{
+		before: func(ctx RequestContext, method HandleMethod) error {
+			before = ctx
+			ctx.AddMessage("Resolved tenant")
+			return nil
+		},
+		read: func(ctx RequestContext) {
+			read = ctx
+		},
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"messages":["Resolved tenant"],"reason":"OK",`+
+		`"result":{"id":"1"},"status":200}`, w.Body.String())
+	assert.NotNil(before)
+	assert.Equal(before.RequestID(), read.RequestID())
+}
+
+type AfterHandleResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (a AfterHandleResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (a AfterHandleResourceHandler) AfterHandle(ctx RequestContext, method HandleMethod) {
+	ctx.AddMessage(fmt.Sprintf("%s handled", method))
+	ctx.ResponseWriter().Header().Set("X-RateLimit-Remaining", "41")
+}
+
+func (a AfterHandleResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	if id == "missing" {
+		return nil, ResourceNotFound("No widget")
+	}
+	return map[string]interface{}{"id": id}, nil
+}
+
+// Ensures that AfterHandle is invoked before the response is sent and that messages
+// and headers it adds are included in the response.
+func TestAfterHandle(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(AfterHandleResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("41", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(`{"messages":["read handled"],"reason":"OK",`+
+		`"result":{"id":"1"},"status":200}`, w.Body.String())
+
+	// AfterHandle is also invoked for errors.
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets/missing", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.Equal("41", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(`{"messages":["read handled","No widget"],"reason":"Not Found",`+
+		`"status":404}`, w.Body.String())
+}
+
+type ContextErrorResourceHandler struct {
+	BaseResourceHandler
+	err error
+}
+
+func (c ContextErrorResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (c ContextErrorResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return nil, fmt.Errorf("Reading widget %s: %w", id, c.err)
+}
+
+// Ensures that errors caused by the request context being canceled or exceeding its
+// deadline are sent with the configured status instead of a 500.
+func TestContextErrorStatus(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		config   *Configuration
+		err      error
+		expected int
+	}{
+		{&Configuration{}, context.Canceled, StatusClientClosedRequest},
+		{&Configuration{}, context.DeadlineExceeded, http.StatusServiceUnavailable},
+		{&Configuration{CanceledStatus: http.StatusRequestTimeout}, context.Canceled,
+			http.StatusRequestTimeout},
+		{&Configuration{DeadlineExceededStatus: http.StatusGatewayTimeout},
+			context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{&Configuration{}, errors.New("boom"), http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		api := NewAPI(test.config)
+		api.RegisterResourceHandler(ContextErrorResourceHandler{err: test.err})
+
+		req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(test.expected, w.Code)
+		assert.Contains(w.Body.String(), "Reading widget 1: "+test.err.Error())
+	}
+}
+
+// Ensures that no body is written when the client has disconnected.
+func TestContextErrorStatusClientGone(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(ContextErrorResourceHandler{err: context.Canceled})
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	req = req.WithContext(reqCtx)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(StatusClientClosedRequest, w.Code)
+	assert.Equal("", w.Body.String())
+}
+
+// Ensures that Shutdown stops a started server, which makes Start return nil, and is a
+// no-op if the server hasn't been started.
+func TestShutdown(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{}).(*muxAPI)
+
+	assert.Nil(api.Shutdown(context.Background()))
+
+	done := make(chan error)
+	go func() {
+		done <- api.Start("127.0.0.1:0")
+	}()
+
+	for started := false; !started; {
+		api.mu.RLock()
+		started = api.server != nil
+		api.mu.RUnlock()
+		time.Sleep(time.Millisecond)
+	}
+	assert.Nil(api.Shutdown(context.Background()))
+
+	select {
+	case err := <-done:
+		assert.Nil(err)
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after Shutdown")
+	}
+}
+
+// Ensures that the API Handler serves the API through the Middleware.
+func TestAPIHandler(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(LimitResourceHandler{})
+	calls := 0
+	handler := api.Handler(func(w http.ResponseWriter, r *http.Request) *MiddlewareError {
+		calls++
+		if r.Header.Get("Authorization") == "" {
+			return &MiddlewareError{Code: http.StatusUnauthorized, Response: []byte("no")}
+		}
+		return nil
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusUnauthorized, w.Code)
+	assert.Equal("no", w.Body.String())
+
+	req.Header.Set("Authorization", "yes")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(2, calls)
+	assert.NotPanics(func() { api.Handler() })
+}
+
+// Ensures that the API Handler validates the Rules.
+func TestAPIHandlerBadRules(t *testing.T) {
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(TestResourceHandler{})
+	api.RegisterTypeRules(NewRules((*TestResource)(nil), &Rule{Field: "Foo", Pattern: "("}))
+
+	assert.Panics(t, func() { api.Handler() })
+}
+
+type CountResourceHandler struct {
+	LimitResourceHandler
+	count int
+	err   error
+}
+
+func (c CountResourceHandler) CountResources(ctx RequestContext, version string) (int, error) {
+	return c.count, c.err
+}
+
+// Ensures that list reads requested with ?include=count include the total from
+// CountResources.
+func TestCountResources(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		handler  ResourceHandler
+		query    string
+		status   int
+		expected string
+	}{
+		{CountResourceHandler{count: 42}, "?include=count", http.StatusOK,
+			`{"messages":[],"reason":"OK","results":[100],"status":200,"total":42}`},
+		{CountResourceHandler{count: 42}, "?include=foo,count", http.StatusOK,
+			`{"messages":[],"reason":"OK","results":[100],"status":200,"total":42}`},
+		{CountResourceHandler{count: 42}, "", http.StatusOK,
+			`{"messages":[],"reason":"OK","results":[100],"status":200}`},
+		{LimitResourceHandler{}, "?include=count", http.StatusOK,
+			`{"messages":[],"reason":"OK","results":[100],"status":200}`},
+		{CountResourceHandler{err: ResourceNotPermitted("No counting")}, "?include=count",
+			http.StatusForbidden,
+			`{"messages":["No counting"],"reason":"Forbidden","status":403}`},
+	}
+
+	for _, test := range tests {
+		api := NewAPI(&Configuration{})
+		api.RegisterResourceHandler(test.handler)
+
+		req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets"+test.query, nil)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(test.status, w.Code)
+		assert.Equal(test.expected, w.Body.String())
+	}
+}
+
+// Ensures that errors about unavailable formats are sent in the registered format the
+// client accepts, falling back to json.
+func TestUnknownFormatErrorNegotiated(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{XMLResponses: true})
+	api.RegisterResourceHandler(NDJSONResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?format=bogus", nil)
+	req.Header.Set("Accept", "*/*, application/xml; q=0.5")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Equal("application/xml", w.Header().Get("Content-Type"))
+	assert.Equal(xml.Header+"<response><messages><item>Format not implemented: bogus</item>"+
+		"</messages><reason>Bad Request</reason><status>400</status></response>",
+		w.Body.String())
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets?format=bogus", nil)
+	req.Header.Set("Accept", "*/*")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.Equal(`{"messages":["Format not implemented: bogus"],"reason":"Bad Request",`+
+		`"status":400}`, w.Body.String())
+}
+
+type PanicResourceHandler struct {
+	BaseResourceHandler
+	panicValue interface{}
+}
+
+func (p PanicResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (p PanicResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	panic(p.panicValue)
+}
+
+// Ensures that panics in a ResourceHandler are recovered and respond with a 500 in
+// the error envelope, without the panic details unless IncludePanicDetails is enabled,
+// even with the default Configuration, which enables Debug.
+func TestRecoveryMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(NewConfiguration())
+	api.RegisterResourceHandler(PanicResourceHandler{panicValue: "boom"})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.Equal(`{"messages":["An unexpected error occurred."],`+
+		`"reason":"Internal Server Error","status":500}`, w.Body.String())
+}
+
+// Ensures that recovered panics are logged and respond with the panic value and
+// stack trace if IncludePanicDetails is enabled.
+func TestRecoveryMiddlewarePanicDetails(t *testing.T) {
+	assert := assert.New(t)
+	var logged bytes.Buffer
+	api := NewAPI(&Configuration{IncludePanicDetails: true, Logger: log.New(&logged, "", 0)})
+	api.RegisterResourceHandler(PanicResourceHandler{panicValue: "boom"})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	var payload map[string]interface{}
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &payload))
+	assert.Equal([]interface{}{"Unexpected error: boom"}, payload["messages"])
+	assert.Equal("Internal Server Error", payload["reason"])
+	assert.Contains(payload["stack"], "PanicResourceHandler")
+	assert.Contains(logged.String(), "Recovered from panic serving GET /api/v1/widgets/1: boom")
+}
+
+// Ensures that http.ErrAbortHandler panics are not recovered.
+func TestRecoveryMiddlewareAbortHandler(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(PanicResourceHandler{panicValue: http.ErrAbortHandler})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+
+	assert.PanicsWithValue(http.ErrAbortHandler, func() { api.ServeHTTP(w, req) })
+}
+
+// Ensures that recovered panics are logged using the log package if there is no
+// Logger.
+func TestRecoveryMiddlewareNoLogger(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(PanicResourceHandler{panicValue: "boom"})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	api.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Contains(t, logged.String(), "Recovered from panic serving GET /api/v1/widgets/1: boom")
+}
+
+// Ensures that panics after the response was started abort the connection instead of
+// writing a second response.
+func TestRecoveryMiddlewareStartedResponse(t *testing.T) {
+	assert := assert.New(t)
+	var logged bytes.Buffer
+	handler := RecoveryMiddleware(&Configuration{Logger: log.New(&logged, "", 0)})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"status":200`))
+			panic("boom")
+		}))
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+
+	assert.PanicsWithValue(http.ErrAbortHandler, func() { handler.ServeHTTP(w, req) })
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"status":200`, w.Body.String())
+	assert.Contains(logged.String(), "Recovered from panic serving GET /api/v1/widgets/1: boom")
+}
+
+// Ensures that the MessageTranslator localizes validation messages and status reasons
+// into the request's locale, identifying validation messages by their code.
+func TestMessageTranslator(t *testing.T) {
+	assert := assert.New(t)
+	translations := map[string]map[string]string{
+		"fr": {
+			"Unprocessable Entity": "Entité non traitable",
+			CodeTypeMismatch:       "Le champ '%s' a un type invalide",
+		},
+	}
+	api := NewAPI(&Configuration{
+		CollectAllValidationErrors: true,
+		MessageTranslator: func(locale string, message Message) string {
+			translated, ok := translations[locale][message.Key]
+			if !ok {
+				return message.Text
+			}
+			if field, ok := message.Args["field"]; ok {
+				return fmt.Sprintf(translated, field)
+			}
+			return translated
+		},
+	})
+	handler := new(MockResourceHandler)
+	handler.On("ResourceName").Return("widgets")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "id", Type: UUID},
+	))
+	api.RegisterResourceHandler(handler)
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"id": "abc"}`))
+	req.Header.Set("Accept-Language", "fr;q=0.9, en;q=0.5")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(422, w.Code)
+	var body map[string]interface{}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal("Entité non traitable", body["reason"])
+	assert.Equal([]interface{}{"Le champ 'id' a un type invalide"}, body["messages"])
+	assert.Equal([]interface{}{
+		map[string]interface{}{
+			"field":   "id",
+			"code":    "type_mismatch",
+			"message": "Le champ 'id' a un type invalide",
+		},
+	}, body["errors"])
+
+	// Requests in other locales get the untranslated messages.
+	req, _ = http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"id": "abc"}`))
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal("Unprocessable Entity", body["reason"])
+	assert.Equal([]interface{}{"Invalid UUID 'abc'"}, body["messages"])
+}
+
+// Ensures that the MessageTranslator receives the values validation messages refer to
+// as args.
+func TestMessageTranslatorArgs(t *testing.T) {
+	assert := assert.New(t)
+	var translated []Message
+	api := NewAPI(&Configuration{
+		MessageTranslator: func(locale string, message Message) string {
+			translated = append(translated, message)
+			if message.Key == CodeOutOfRange {
+				return fmt.Sprintf("'%s' doit être au moins %v", message.Args["field"],
+					message.Args["min"])
+			}
+			return message.Text
+		},
+	})
+	min := 1.0
+	handler := new(MockResourceHandler)
+	handler.On("ResourceName").Return("widgets")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "count", Type: Int, Min: &min},
+	))
+	api.RegisterResourceHandler(handler)
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"count": 0}`))
+	req.Header.Set("Accept-Language", "fr")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(422, w.Code)
+	assert.Contains(translated, Message{
+		Key:  CodeOutOfRange,
+		Args: map[string]interface{}{"field": "count", "value": 0, "min": 1.0},
+		Text: "Field 'count' is 0, less than the minimum of 1",
+	})
+	var body map[string]interface{}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal([]interface{}{"'count' doit être au moins 1"}, body["messages"])
+}
+
+type DeadlineResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (d DeadlineResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (d DeadlineResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// Ensures that the RequestContext passed to ResourceHandlers is canceled once the
+// RequestTimeout elapses and the request is answered with a 503.
+func TestRequestTimeout(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{RequestTimeout: 10 * time.Millisecond})
+	api.RegisterResourceHandler(DeadlineResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+	assert.Equal(`{"messages":["Request timed out after 10ms: context deadline exceeded"],`+
+		`"reason":"Service Unavailable","status":503}`, w.Body.String())
+}
+
+// Ensures that requests whose RequestTimeout elapses are answered with the
+// DeadlineExceededStatus even if the ResourceHandler ignores the deadline.
+func TestRequestTimeoutIgnored(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{
+		RequestTimeout:         10 * time.Millisecond,
+		DeadlineExceededStatus: http.StatusGatewayTimeout,
+	})
+	api.RegisterResourceHandler(SlowResourceHandler{delay: 20 * time.Millisecond})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusGatewayTimeout, w.Code)
+	assert.Contains(w.Body.String(), "Request timed out after 10ms")
+}
+
+// Ensures that the timeout response is sent as soon as the RequestTimeout elapses,
+// without waiting for a ResourceHandler which ignores the deadline, and that the
+// handler's response is discarded.
+func TestRequestTimeoutDoesNotWait(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{RequestTimeout: 10 * time.Millisecond})
+	api.RegisterResourceHandler(SlowResourceHandler{delay: time.Second})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	req.Header.Set("X-Request-ID", "abc")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	api.ServeHTTP(w, req)
+
+	assert.True(time.Since(start) < 500*time.Millisecond)
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+	assert.Equal("abc", w.Header().Get("X-Request-ID"))
+	assert.Equal(`{"messages":["Request timed out after 10ms: context deadline exceeded"],`+
+		`"reason":"Service Unavailable","status":503}`, w.Body.String())
+}
+
+// Ensures that responses sent within the RequestTimeout are passed through with their
+// headers.
+func TestRequestTimeoutNotExceeded(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{RequestTimeout: time.Second})
+	api.RegisterResourceHandler(SlowResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.NotEmpty(w.Header().Get("X-Request-ID"))
+	assert.Contains(w.Body.String(), `"foo":"1"`)
+}
+
+// Ensures that panics in handlers run under the RequestTimeout are recovered.
+func TestRequestTimeoutPanic(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{RequestTimeout: time.Second})
+	api.RegisterResourceHandler(PanicResourceHandler{panicValue: "boom"})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusInternalServerError, w.Code)
+}
+
+// Ensures that update list requests with an empty batch are passed to the handler by
+// default and rejected with RejectEmptyBatch.
+func TestHandleUpdateListEmptyBatch(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(MockResourceHandler)
+	api := NewAPI(&Configuration{})
+
+	handler.On("ResourceName").Return("foo")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(&rules{})
+	handler.On("UpdateResourceList").Return([]Resource{}, nil)
+
+	api.RegisterResourceHandler(handler)
+
+	req, _ := http.NewRequest("PUT", "http://foo.com/api/v0.1/foo", bytes.NewBufferString(`[]`))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	handler.AssertCalled(t, "UpdateResourceList")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(`{"messages":[],"reason":"OK","results":[],"status":200}`, resp.Body.String())
+}
+
+// Ensures that update list requests with an empty batch are rejected with a 400
+// without invoking the handler when RejectEmptyBatch is enabled.
+func TestHandleUpdateListRejectEmptyBatch(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(MockResourceHandler)
+	api := NewAPI(&Configuration{RejectEmptyBatch: true})
+
+	handler.On("ResourceName").Return("foo")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(&rules{})
+	handler.On("UpdateResourceList").Return([]Resource{}, nil)
+
+	api.RegisterResourceHandler(handler)
+
+	req, _ := http.NewRequest("PUT", "http://foo.com/api/v0.1/foo", bytes.NewBufferString(`[]`))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	handler.AssertNotCalled(t, "UpdateResourceList")
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	assert.Equal(`{"messages":["Payload must contain at least one resource"],`+
+		`"reason":"Bad Request","status":400}`, resp.Body.String())
+
+	// Non-empty batches are unaffected.
+	req, _ = http.NewRequest("PUT", "http://foo.com/api/v0.1/foo", bytes.NewBufferString(`[{}]`))
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	handler.AssertCalled(t, "UpdateResourceList")
+	assert.Equal(http.StatusOK, resp.Code)
+}
+
+type OffsetResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (o OffsetResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (o OffsetResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	resources := []Resource{}
+	for i := ctx.Offset(); i < ctx.Offset()+limit && i < 5; i++ {
+		resources = append(resources, i)
+	}
+	return resources, "", nil
+}
+
+// Ensures that the next URL of list reads requests the following offset when the
+// ResourceHandler is an OffsetReader, until a page isn't full.
+func TestOffsetPagination(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(PageResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?limit=2", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"messages":[],"next":"http://example.com/api/v1/widgets?limit=2\u0026offset=2",`+
+		`"reason":"OK","results":[0,1],"status":200}`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets?limit=2&offset=2", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Contains(w.Body.String(), `"next":"http://example.com/api/v1/widgets?limit=2\u0026offset=4"`)
+	assert.Contains(w.Body.String(), `"results":[2,3]`)
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets?limit=2&offset=4", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(`{"messages":[],"reason":"OK","results":[4],"status":200}`, w.Body.String())
+}
+
+// Ensures that the next URL of list reads uses the cursor when the ResourceHandler
+// isn't an OffsetReader, even if it reads the requested offset.
+func TestOffsetPaginationDisabled(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(OffsetResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?limit=2", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"messages":[],"reason":"OK","results":[0,1],"status":200}`, w.Body.String())
+}
+
+type FailingResponseSerializer struct{}
+
+func (f FailingResponseSerializer) Serialize(Payload) ([]byte, error) {
+	return nil, errors.New("yaml: cannot marshal type chan int")
+}
+
+func (f FailingResponseSerializer) ContentType() string {
+	return "application/x-yaml"
+}
+
+// Ensures that a serialization failure produces a 500 in the JSON error envelope
+// without the serialization error.
+func TestSerializationFailure(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResponseSerializer("yaml", FailingResponseSerializer{})
+	api.RegisterResourceHandler(MultiReadResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1?format=yaml", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	var payload map[string]interface{}
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &payload))
+	assert.Equal(map[string]interface{}{
+		"messages": []interface{}{"Unable to serialize response"},
+		"reason":   "Internal Server Error",
+		"status":   float64(500),
+	}, payload)
+}
+
+// Ensures that list reads set a Link header pointing to the next and first pages when
+// paginating by cursor.
+func TestPaginationLinksCursor(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(CursorResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?limit=1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`<http://example.com/api/v1/widgets?limit=1&next=1>; rel="next", `+
+		`<http://example.com/api/v1/widgets?limit=1>; rel="first"`, w.Header().Get("Link"))
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets?limit=1&next=1", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(`<http://example.com/api/v1/widgets?limit=1&next=11>; rel="next", `+
+		`<http://example.com/api/v1/widgets?limit=1>; rel="first"`, w.Header().Get("Link"))
+}
+
+// Ensures that list reads set a Link header pointing to the next, previous, and
+// first pages when paginating by offset.
+func TestPaginationLinksOffset(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(PageResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?limit=2&offset=3", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`<http://example.com/api/v1/widgets?limit=2&offset=5>; rel="next", `+
+		`<http://example.com/api/v1/widgets?limit=2&offset=1>; rel="prev", `+
+		`<http://example.com/api/v1/widgets?limit=2>; rel="first"`, w.Header().Get("Link"))
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets?limit=2&offset=1", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(`<http://example.com/api/v1/widgets?limit=2&offset=3>; rel="next", `+
+		`<http://example.com/api/v1/widgets?limit=2>; rel="prev", `+
+		`<http://example.com/api/v1/widgets?limit=2>; rel="first"`, w.Header().Get("Link"))
+}
+
+// Ensures that no Link header is set on list reads with a single page of results.
+func TestPaginationLinksSinglePage(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(OffsetResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Empty(w.Header().Get("Link"))
+}
+
+type WriteOnceResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (wo WriteOnceResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (wo WriteOnceResourceHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", WriteOnce: true, Required: true},
+		&Rule{FieldAlias: "name"},
+	)
+}
+
+func (wo WriteOnceResourceHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	return &TestResource{Foo: data["foo"].(string)}, nil
+}
+
+func (wo WriteOnceResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	if id == "missing" {
+		return nil, ResourceNotFound("No widget " + id)
+	}
+	return &TestResource{Foo: "original"}, nil
+}
+
+func (wo WriteOnceResourceHandler) UpdateResource(ctx RequestContext, id string,
+	data Payload, version string) (Resource, error) {
+	return &TestResource{Foo: "original"}, nil
+}
+
+func (wo WriteOnceResourceHandler) PartialUpdateResource(ctx RequestContext, id string,
+	data Payload, version string) (Resource, error) {
+	return &TestResource{Foo: "original"}, nil
+}
+
+// Ensures that write-once fields are accepted on create and rejected on updates which
+// change them, where they aren't required.
+func TestWriteOnceField(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(WriteOnceResourceHandler{})
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"foo": "original"}`))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusCreated, w.Code)
+	assert.Contains(w.Body.String(), `"foo":"original"`)
+
+	for _, method := range []string{"PUT", "PATCH"} {
+		req, _ = http.NewRequest(method, "http://example.com/api/v1/widgets/1",
+			bytes.NewBufferString(`{"foo": "changed"}`))
+		w = httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(422, w.Code, method)
+		assert.Contains(w.Body.String(), `"messages":["Field 'foo' can't be changed once set"]`, method)
+
+		req, _ = http.NewRequest(method, "http://example.com/api/v1/widgets/1",
+			bytes.NewBufferString(`{"name": "widget"}`))
+		w = httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(http.StatusOK, w.Code, method)
+
+		// Sending back the stored value is allowed.
+		req, _ = http.NewRequest(method, "http://example.com/api/v1/widgets/1",
+			bytes.NewBufferString(`{"foo": "original", "name": "widget"}`))
+		w = httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(http.StatusOK, w.Code, method)
+
+		// Errors reading the stored resource are sent back.
+		req, _ = http.NewRequest(method, "http://example.com/api/v1/widgets/missing",
+			bytes.NewBufferString(`{"foo": "original"}`))
+		w = httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		assert.Equal(http.StatusNotFound, w.Code, method)
+		assert.Contains(w.Body.String(), `"messages":["No widget missing"]`, method)
+	}
+}
+
+// Ensures that with CollectAllValidationErrors write-once fields sent on updates are
+// reported with the other invalid fields.
+func TestWriteOnceFieldCollectAllValidationErrors(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{CollectAllValidationErrors: true})
+	api.RegisterResourceHandler(WriteOnceResourceHandler{})
+
+	req, _ := http.NewRequest("PUT", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`[{"name": "widget"}, {"foo": "changed"}]`))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(422, w.Code)
+	var body map[string]interface{}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal([]interface{}{
+		map[string]interface{}{
+			"field":   "1.foo",
+			"code":    "write_once",
+			"message": "Field 'foo' can't be changed once set",
+		},
+	}, body["errors"])
+}
+
+// Ensures that the request ID is read from and echoed in the configured
+// RequestIDHeader, and that generated ids are echoed too.
+func TestRequestIDHeader(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	api := NewAPI(&Configuration{RequestIDHeader: "X-Correlation-ID"})
+	api.RegisterResourceHandler(MultiReadResourceHandler{})
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"id": "1", "bar": "baz"}`))
+	req.Header.Set("X-Correlation-ID", "abc123")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal("[abc123] Discarding field 'bar'\n", buf.String())
+	assert.Equal("abc123", w.Header().Get("X-Correlation-ID"))
+	assert.Empty(w.Header().Get("X-Request-ID"))
+
+	// The default header is ignored when a custom one is configured.
+	buf.Reset()
+	req, _ = http.NewRequest("POST", "http://example.com/api/v1/widgets",
+		bytes.NewBufferString(`{"id": "1", "bar": "baz"}`))
+	req.Header.Set("X-Request-ID", "abc123")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	id := w.Header().Get("X-Correlation-ID")
+	assert.Regexp(`^[0-9a-f]{16}$`, id)
+	assert.Equal("["+id+"] Discarding field 'bar'\n", buf.String())
+}
+
+// Ensures that the request ID is echoed in the X-Request-ID header by default.
+func TestRequestIDHeaderDefault(t *testing.T) {
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(MultiReadResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	req.Header.Set("X-Request-ID", "abc123")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(t, "abc123", w.Header().Get("X-Request-ID"))
+}
+
+type PageResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (p PageResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (p PageResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	return []Resource{"cursor:" + cursor}, "", nil
+}
+
+func (p PageResourceHandler) ReadResourceListOffset(ctx RequestContext, limit, offset int,
+	version string) ([]Resource, error) {
+	resources := []Resource{}
+	for i := offset; i < offset+limit && i < 5; i++ {
+		resources = append(resources, i)
+	}
+	return resources, nil
+}
+
+// Ensures that list reads without a cursor use ReadResourceListOffset, whose next
+// URL requests the following page, while list reads with a cursor use
+// ReadResourceList.
+func TestReadResourceListOffset(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(PageResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets?page=2&per_page=2", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"messages":[],"next":"http://example.com/api/v1/widgets?page=3\u0026per_page=2",`+
+		`"reason":"OK","results":[2,3],"status":200}`, w.Body.String())
+	assert.Equal(`<http://example.com/api/v1/widgets?page=3&per_page=2>; rel="next", `+
+		`<http://example.com/api/v1/widgets?per_page=2>; rel="prev", `+
+		`<http://example.com/api/v1/widgets?per_page=2>; rel="first"`, w.Header().Get("Link"))
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets?offset=4&limit=2", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"messages":[],"reason":"OK","results":[4],"status":200}`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets?next=abc", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"results":["cursor:abc"]`)
+}
+
+type RetryAfterResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (r RetryAfterResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (r RetryAfterResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return nil, TooManyRequests("Downstream is rate limiting requests", 90*time.Second)
+}
+
+// Ensures that a TooManyRequests error returned by a handler is sent as a 429 with a
+// Retry-After header.
+func TestTooManyRequestsRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(RetryAfterResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(429, w.Code)
+	assert.Equal("90", w.Header().Get("Retry-After"))
+	assert.Equal(`{"messages":["Downstream is rate limiting requests"],`+
+		`"reason":"Too Many Requests","status":429}`, w.Body.String())
+}
+
+type WrappedRetryAfterResourceHandler struct {
+	RetryAfterResourceHandler
+}
+
+func (w WrappedRetryAfterResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	_, err := w.RetryAfterResourceHandler.ReadResource(ctx, id, version)
+	return nil, fmt.Errorf("Reading widget %s: %w", id, err)
+}
+
+// Ensures that a wrapped TooManyRequests error is still sent as a 429 with a
+// Retry-After header.
+func TestTooManyRequestsRetryAfterWrapped(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(WrappedRetryAfterResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets/1", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(429, w.Code)
+	assert.Equal("90", w.Header().Get("Retry-After"))
+	assert.Equal(`{"messages":["Reading widget 1: Downstream is rate limiting requests"],`+
+		`"reason":"Too Many Requests","status":429}`, w.Body.String())
+}
+
+// Ensures that the Headers of a MiddlewareError are added to the response.
+func TestMiddlewareErrorHeaders(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(LimitResourceHandler{})
+	handler := api.Handler(func(w http.ResponseWriter, r *http.Request) *MiddlewareError {
+		return &MiddlewareError{
+			Code:     429,
+			Response: []byte("slow down"),
+			Headers:  http.Header{"Retry-After": []string{"5"}},
+		}
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(429, w.Code)
+	assert.Equal("5", w.Header().Get("Retry-After"))
+	assert.Equal("slow down", w.Body.String())
+}
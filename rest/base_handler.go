@@ -17,10 +17,20 @@ limitations under the License.
 package rest
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
+// errReadResourcesNotImplemented is returned by the BaseResourceHandler
+// ReadResources stub to signal that the default behavior should be used.
+var errReadResourcesNotImplemented = errors.New("ReadResources not implemented")
+
+// errCountResourcesNotImplemented is returned by the BaseResourceHandler
+// CountResources stub to signal that no total should be included.
+var errCountResourcesNotImplemented = errors.New("CountResources not implemented")
+
 // BaseResourceHandler is a base implementation of ResourceHandler with stubs for the
 // CRUD operations. This allows ResourceHandler implementations to only implement
 // what they need.
@@ -31,6 +41,11 @@ func (b BaseResourceHandler) ResourceName() string {
 	return ""
 }
 
+// Namespace is a stub. Implement if necessary. No namespace is used by default.
+func (b BaseResourceHandler) Namespace() string {
+	return ""
+}
+
 // CreateURI is a stub. Implement if necessary. The default create URI is
 // /api/v{version:[^/]+}/resourceName.
 func (b BaseResourceHandler) CreateURI() string {
@@ -75,6 +90,17 @@ func (b BaseResourceHandler) UpdateDocumentation() string {
 	return ""
 }
 
+// PatchURI is a stub. Implement if necessary. The default patch URI is
+// /api/v{version:[^/]+}/resourceName/{resource_id}.
+func (b BaseResourceHandler) PatchURI() string {
+	return ""
+}
+
+// PatchDocumentation is a stub. Implement if necessary.
+func (b BaseResourceHandler) PatchDocumentation() string {
+	return ""
+}
+
 // UpdateListURI is a stub. Implement if necessary. The default update list URI is
 // /api/v{version:[^/]+}/resourceName.
 func (b BaseResourceHandler) UpdateListURI() string {
@@ -109,12 +135,25 @@ func (b BaseResourceHandler) ReadResourceList(ctx RequestContext, limit int,
 	return nil, "", MethodNotAllowed("ReadResourceList not implemented")
 }
 
+// CountResources is a stub. Implement if necessary. By default, list reads don't
+// include a total.
+func (b BaseResourceHandler) CountResources(ctx RequestContext, version string) (int, error) {
+	return 0, errCountResourcesNotImplemented
+}
+
 // ReadResource is a stub. Implement if necessary.
 func (b BaseResourceHandler) ReadResource(ctx RequestContext, id string,
 	version string) (Resource, error) {
 	return nil, MethodNotAllowed("ReadResource not implemented")
 }
 
+// ReadResources is a stub. Implement if necessary. By default, ReadResource is
+// called for each id.
+func (b BaseResourceHandler) ReadResources(ctx RequestContext, ids []string,
+	version string) ([]Resource, error) {
+	return nil, errReadResourcesNotImplemented
+}
+
 // UpdateResourceList is a stub. Implement if necessary.
 func (b BaseResourceHandler) UpdateResourceList(ctx RequestContext, data []Payload,
 	version string) ([]Resource, error) {
@@ -127,6 +166,12 @@ func (b BaseResourceHandler) UpdateResource(ctx RequestContext, id string,
 	return nil, MethodNotAllowed("UpdateResource not implemented")
 }
 
+// PartialUpdateResource is a stub. Implement if necessary.
+func (b BaseResourceHandler) PartialUpdateResource(ctx RequestContext, id string,
+	data Payload, version string) (Resource, error) {
+	return nil, MethodNotAllowed("PartialUpdateResource not implemented")
+}
+
 // DeleteResource is a stub. Implement if necessary.
 func (b BaseResourceHandler) DeleteResource(ctx RequestContext, id string,
 	version string) (Resource, error) {
@@ -139,6 +184,12 @@ func (b BaseResourceHandler) Authenticate(r *http.Request) error {
 	return nil
 }
 
+// AuthExemptMethods returns the HandleMethods which skip authentication. No methods
+// are exempt by default. Implement if necessary.
+func (b BaseResourceHandler) AuthExemptMethods() []HandleMethod {
+	return nil
+}
+
 func (b BaseResourceHandler) ValidVersions() []string {
 	return nil
 }
@@ -149,6 +200,48 @@ func (b BaseResourceHandler) Rules() Rules {
 	return &rules{}
 }
 
+// ResourceID returns the id of the given resource. No id is returned by default,
+// meaning created resources are not sent with a Location header. Implement if
+// necessary.
+func (b BaseResourceHandler) ResourceID(resource Resource) string {
+	return ""
+}
+
+// ForceFormat returns the response format to force for the request. The format
+// requested by the client is respected by default. Implement if necessary.
+func (b BaseResourceHandler) ForceFormat(ctx RequestContext) string {
+	return ""
+}
+
+// CacheControl returns the Cache-Control policy for read responses. No header is
+// sent by default. Implement if necessary.
+func (b BaseResourceHandler) CacheControl() string {
+	return ""
+}
+
+// DefaultLimit returns the number of results fetched by list reads which don't
+// specify a limit. Configuration.DefaultLimit is used by default. Implement if
+// necessary.
+func (b BaseResourceHandler) DefaultLimit() int {
+	return 0
+}
+
+// MaxLimit returns the maximum number of results fetched by list reads.
+// Configuration.MaxLimit is used by default. Implement if necessary.
+func (b BaseResourceHandler) MaxLimit() int {
+	return 0
+}
+
+// BeforeHandle is invoked before each request is handled. Every request proceeds by
+// default. Implement if necessary.
+func (b BaseResourceHandler) BeforeHandle(ctx RequestContext, method HandleMethod) error {
+	return nil
+}
+
+// AfterHandle is invoked after each request is handled, before the response is sent.
+// It does nothing by default. Implement if necessary.
+func (b BaseResourceHandler) AfterHandle(ctx RequestContext, method HandleMethod) {}
+
 // resourceHandlerProxy wraps a ResourceHandler and allows the framework to provide
 // additional logic around the proxied ResourceHandler, including default logic such
 // as REST URIs.
@@ -166,12 +259,31 @@ func (r resourceHandlerProxy) ResourceName() string {
 	return name
 }
 
+// Namespace returns the wrapped ResourceHandler's namespace if it's a Namespacer,
+// otherwise an empty string.
+func (r resourceHandlerProxy) Namespace() string {
+	if namespacer, ok := r.ResourceHandler.(Namespacer); ok {
+		return namespacer.Namespace()
+	}
+	return ""
+}
+
+// resourcePath returns the path segment used in the default URIs, which is the
+// resource name prefixed with the handler's namespace, if any.
+func (r resourceHandlerProxy) resourcePath() string {
+	namespace := strings.Trim(r.Namespace(), "/")
+	if namespace == "" {
+		return r.ResourceName()
+	}
+	return namespace + "/" + r.ResourceName()
+}
+
 // CreateURI returns the URI for creating a resource using the handler-specified
 // URI while falling back to a sensible default if not provided.
 func (r resourceHandlerProxy) CreateURI() string {
 	uri := r.ResourceHandler.CreateURI()
 	if uri == "" {
-		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s", versionKey, r.ResourceName())
+		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s", versionKey, r.resourcePath())
 	}
 	return uri
 }
@@ -181,7 +293,7 @@ func (r resourceHandlerProxy) CreateURI() string {
 func (r resourceHandlerProxy) ReadURI() string {
 	uri := r.ResourceHandler.ReadURI()
 	if uri == "" {
-		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s/{%s}", versionKey, r.ResourceName(),
+		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s/{%s}", versionKey, r.resourcePath(),
 			resourceIDKey)
 	}
 	return uri
@@ -192,7 +304,7 @@ func (r resourceHandlerProxy) ReadURI() string {
 func (r resourceHandlerProxy) ReadListURI() string {
 	uri := r.ResourceHandler.ReadListURI()
 	if uri == "" {
-		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s", versionKey, r.ResourceName())
+		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s", versionKey, r.resourcePath())
 	}
 	return uri
 }
@@ -202,18 +314,41 @@ func (r resourceHandlerProxy) ReadListURI() string {
 func (r resourceHandlerProxy) UpdateURI() string {
 	uri := r.ResourceHandler.UpdateURI()
 	if uri == "" {
-		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s/{%s}", versionKey, r.ResourceName(),
+		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s/{%s}", versionKey, r.resourcePath(),
+			resourceIDKey)
+	}
+	return uri
+}
+
+// PatchURI returns the URI for partially updating a specific resource using the
+// handler-specified URI while falling back to a sensible default if not provided.
+func (r resourceHandlerProxy) PatchURI() string {
+	uri := ""
+	if updater, ok := r.ResourceHandler.(PartialUpdater); ok {
+		uri = updater.PatchURI()
+	}
+	if uri == "" {
+		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s/{%s}", versionKey, r.resourcePath(),
 			resourceIDKey)
 	}
 	return uri
 }
 
+// PatchDocumentation returns the wrapped ResourceHandler's patch documentation if
+// it's a PartialUpdater, otherwise an empty string.
+func (r resourceHandlerProxy) PatchDocumentation() string {
+	if updater, ok := r.ResourceHandler.(PartialUpdater); ok {
+		return updater.PatchDocumentation()
+	}
+	return ""
+}
+
 // UpdateListURI returns the URI for updating a list of resources using the handler-
 // specified URI while falling back to a sensible default if not provided.
 func (r resourceHandlerProxy) UpdateListURI() string {
 	uri := r.ResourceHandler.UpdateListURI()
 	if uri == "" {
-		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s", versionKey, r.ResourceName())
+		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s", versionKey, r.resourcePath())
 	}
 	return uri
 }
@@ -223,8 +358,124 @@ func (r resourceHandlerProxy) UpdateListURI() string {
 func (r resourceHandlerProxy) DeleteURI() string {
 	uri := r.ResourceHandler.DeleteURI()
 	if uri == "" {
-		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s/{%s}", versionKey,
-			r.ResourceHandler.ResourceName(), resourceIDKey)
+		uri = fmt.Sprintf("/api/v{%s:[^/]+}/%s/{%s}", versionKey, r.resourcePath(),
+			resourceIDKey)
 	}
 	return uri
 }
+
+// ReadResources returns the resources with the given ids using the handler's
+// ReadResources while falling back to calling ReadResource for each id if it's not
+// implemented. If reading any of the resources fails, the error is returned.
+func (r resourceHandlerProxy) ReadResources(ctx RequestContext, ids []string,
+	version string) ([]Resource, error) {
+	if reader, ok := r.ResourceHandler.(ResourcesReader); ok {
+		resources, err := reader.ReadResources(ctx, ids, version)
+		if err != errReadResourcesNotImplemented {
+			return resources, err
+		}
+	}
+
+	resources := make([]Resource, 0, len(ids))
+	for _, id := range ids {
+		resource, err := r.ResourceHandler.ReadResource(ctx, id, version)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+
+	return resources, nil
+}
+
+// CountResources returns the number of resources across all pages using the wrapped
+// ResourceHandler if it's a ResourceCounter, otherwise errCountResourcesNotImplemented
+// to signal that no total should be included.
+func (r resourceHandlerProxy) CountResources(ctx RequestContext, version string) (int, error) {
+	if counter, ok := r.ResourceHandler.(ResourceCounter); ok {
+		return counter.CountResources(ctx, version)
+	}
+	return 0, errCountResourcesNotImplemented
+}
+
+// PartialUpdateResource partially updates the resource with the given id using the
+// wrapped ResourceHandler if it's a PartialUpdater, otherwise it returns a
+// MethodNotAllowed error.
+func (r resourceHandlerProxy) PartialUpdateResource(ctx RequestContext, id string,
+	data Payload, version string) (Resource, error) {
+	if updater, ok := r.ResourceHandler.(PartialUpdater); ok {
+		return updater.PartialUpdateResource(ctx, id, data, version)
+	}
+	return nil, MethodNotAllowed("PartialUpdateResource not implemented")
+}
+
+// AuthExemptMethods returns the HandleMethods which skip authentication using the
+// wrapped ResourceHandler if it's an AuthExempter, otherwise nil.
+func (r resourceHandlerProxy) AuthExemptMethods() []HandleMethod {
+	if exempter, ok := r.ResourceHandler.(AuthExempter); ok {
+		return exempter.AuthExemptMethods()
+	}
+	return nil
+}
+
+// ResourceID returns the id of the given resource using the wrapped ResourceHandler
+// if it's a ResourceIdentifier, otherwise an empty string.
+func (r resourceHandlerProxy) ResourceID(resource Resource) string {
+	if identifier, ok := r.ResourceHandler.(ResourceIdentifier); ok {
+		return identifier.ResourceID(resource)
+	}
+	return ""
+}
+
+// CacheControl returns the Cache-Control policy for read responses using the
+// wrapped ResourceHandler if it's a CacheController, otherwise an empty string.
+func (r resourceHandlerProxy) CacheControl() string {
+	if controller, ok := r.ResourceHandler.(CacheController); ok {
+		return controller.CacheControl()
+	}
+	return ""
+}
+
+// DefaultLimit returns the wrapped ResourceHandler's default list limit if it's a
+// ListLimiter, otherwise 0.
+func (r resourceHandlerProxy) DefaultLimit() int {
+	if limiter, ok := r.ResourceHandler.(ListLimiter); ok {
+		return limiter.DefaultLimit()
+	}
+	return 0
+}
+
+// MaxLimit returns the wrapped ResourceHandler's maximum list limit if it's a
+// ListLimiter, otherwise 0.
+func (r resourceHandlerProxy) MaxLimit() int {
+	if limiter, ok := r.ResourceHandler.(ListLimiter); ok {
+		return limiter.MaxLimit()
+	}
+	return 0
+}
+
+// ForceFormat returns the response format forced by the wrapped ResourceHandler if
+// it's a FormatForcer, otherwise an empty string.
+func (r resourceHandlerProxy) ForceFormat(ctx RequestContext) string {
+	if forcer, ok := r.ResourceHandler.(FormatForcer); ok {
+		return forcer.ForceFormat(ctx)
+	}
+	return ""
+}
+
+// BeforeHandle invokes the wrapped ResourceHandler's BeforeHandle if it's a
+// BeforeHandler.
+func (r resourceHandlerProxy) BeforeHandle(ctx RequestContext, method HandleMethod) error {
+	if before, ok := r.ResourceHandler.(BeforeHandler); ok {
+		return before.BeforeHandle(ctx, method)
+	}
+	return nil
+}
+
+// AfterHandle invokes the wrapped ResourceHandler's AfterHandle if it's an
+// AfterHandler.
+func (r resourceHandlerProxy) AfterHandle(ctx RequestContext, method HandleMethod) {
+	if after, ok := r.ResourceHandler.(AfterHandler); ok {
+		after.AfterHandle(ctx, method)
+	}
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package rest
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -62,6 +64,14 @@ func TestUpdateURIDefault(t *testing.T) {
 	assert.Equal("/api/v{version:[^/]+}/foo/{resource_id}", proxy.UpdateURI())
 }
 
+// Ensures that PatchURI falls back to the correct default.
+func TestPatchURIDefault(t *testing.T) {
+	assert := assert.New(t)
+	proxy := resourceHandlerProxy{TestDefaultHandler{}}
+
+	assert.Equal("/api/v{version:[^/]+}/foo/{resource_id}", proxy.PatchURI())
+}
+
 // Ensures that DeleteURI falls back to the correct default.
 func TestDeleteURIDefault(t *testing.T) {
 	assert := assert.New(t)
@@ -94,6 +104,10 @@ func (t TestHandler) UpdateURI() string {
 	return "/api/{version}/update_foo/{resource_id}"
 }
 
+func (t TestHandler) PatchURI() string {
+	return "/api/{version}/patch_foo/{resource_id}"
+}
+
 func (t TestHandler) DeleteURI() string {
 	return "/api/{version}/delete_foo/{resource_id}"
 }
@@ -130,6 +144,14 @@ func TestUpdateURICustom(t *testing.T) {
 	assert.Equal("/api/{version}/update_foo/{resource_id}", proxy.UpdateURI())
 }
 
+// Ensures that PatchURI returns the custom URI.
+func TestPatchURICustom(t *testing.T) {
+	assert := assert.New(t)
+	proxy := resourceHandlerProxy{TestHandler{}}
+
+	assert.Equal("/api/{version}/patch_foo/{resource_id}", proxy.PatchURI())
+}
+
 // Ensures that DeleteURI returns the custom URI.
 func TestDeleteURICustom(t *testing.T) {
 	assert := assert.New(t)
@@ -137,3 +159,137 @@ func TestDeleteURICustom(t *testing.T) {
 
 	assert.Equal("/api/{version}/delete_foo/{resource_id}", proxy.DeleteURI())
 }
+
+type TestNamespacedHandler struct {
+	BaseResourceHandler
+}
+
+func (t TestNamespacedHandler) ResourceName() string {
+	return "invoices"
+}
+
+func (t TestNamespacedHandler) Namespace() string {
+	return "/billing/"
+}
+
+// Ensures that the default URIs are prefixed with the handler's namespace.
+func TestNamespacedURIDefaults(t *testing.T) {
+	assert := assert.New(t)
+	proxy := resourceHandlerProxy{TestNamespacedHandler{}}
+
+	assert.Equal("/api/v{version:[^/]+}/billing/invoices", proxy.CreateURI())
+	assert.Equal("/api/v{version:[^/]+}/billing/invoices/{resource_id}", proxy.ReadURI())
+	assert.Equal("/api/v{version:[^/]+}/billing/invoices", proxy.ReadListURI())
+	assert.Equal("/api/v{version:[^/]+}/billing/invoices/{resource_id}", proxy.UpdateURI())
+	assert.Equal("/api/v{version:[^/]+}/billing/invoices/{resource_id}", proxy.PatchURI())
+	assert.Equal("/api/v{version:[^/]+}/billing/invoices", proxy.UpdateListURI())
+	assert.Equal("/api/v{version:[^/]+}/billing/invoices/{resource_id}", proxy.DeleteURI())
+}
+
+// TestMinimalHandler implements only the methods required by ResourceHandler, none of
+// the optional interfaces.
+type TestMinimalHandler struct{}
+
+func (t TestMinimalHandler) ResourceName() string             { return "minimal" }
+func (t TestMinimalHandler) CreateURI() string                { return "" }
+func (t TestMinimalHandler) CreateDocumentation() string      { return "" }
+func (t TestMinimalHandler) ReadURI() string                  { return "" }
+func (t TestMinimalHandler) ReadDocumentation() string        { return "" }
+func (t TestMinimalHandler) ReadListURI() string              { return "" }
+func (t TestMinimalHandler) ReadListDocumentation() string    { return "" }
+func (t TestMinimalHandler) UpdateURI() string                { return "" }
+func (t TestMinimalHandler) UpdateDocumentation() string      { return "" }
+func (t TestMinimalHandler) UpdateListURI() string            { return "" }
+func (t TestMinimalHandler) UpdateListDocumentation() string  { return "" }
+func (t TestMinimalHandler) DeleteURI() string                { return "" }
+func (t TestMinimalHandler) DeleteDocumentation() string      { return "" }
+func (t TestMinimalHandler) Authenticate(*http.Request) error { return nil }
+func (t TestMinimalHandler) ValidVersions() []string          { return nil }
+func (t TestMinimalHandler) Rules() Rules                     { return NewRules((*TestResource)(nil)) }
+
+func (t TestMinimalHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	return &TestResource{Foo: "created"}, nil
+}
+
+func (t TestMinimalHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	return []Resource{&TestResource{Foo: "listed"}}, "", nil
+}
+
+func (t TestMinimalHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return &TestResource{Foo: id}, nil
+}
+
+func (t TestMinimalHandler) UpdateResourceList(ctx RequestContext, data []Payload,
+	version string) ([]Resource, error) {
+	return nil, MethodNotAllowed("UpdateResourceList not implemented")
+}
+
+func (t TestMinimalHandler) UpdateResource(ctx RequestContext, id string,
+	data Payload, version string) (Resource, error) {
+	return nil, MethodNotAllowed("UpdateResource not implemented")
+}
+
+func (t TestMinimalHandler) DeleteResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return nil, MethodNotAllowed("DeleteResource not implemented")
+}
+
+// Ensures that the proxy provides the default behavior of the optional interfaces
+// the wrapped ResourceHandler doesn't implement.
+func TestProxyOptionalInterfaceDefaults(t *testing.T) {
+	assert := assert.New(t)
+	proxy := resourceHandlerProxy{TestMinimalHandler{}}
+
+	assert.Equal("", proxy.Namespace())
+	assert.Equal("/api/v{version:[^/]+}/minimal/{resource_id}", proxy.PatchURI())
+	assert.Equal("", proxy.PatchDocumentation())
+	assert.Nil(proxy.AuthExemptMethods())
+	assert.Equal("", proxy.ResourceID(&TestResource{Foo: "a"}))
+	assert.Equal("", proxy.CacheControl())
+	assert.Equal(0, proxy.DefaultLimit())
+	assert.Equal(0, proxy.MaxLimit())
+	assert.Nil(proxy.BeforeHandle(nil, HandleRead))
+
+	_, err := proxy.CountResources(nil, "1")
+	assert.Equal(errCountResourcesNotImplemented, err)
+
+	_, err = proxy.PartialUpdateResource(nil, "a", Payload{}, "1")
+	assert.Equal(http.StatusMethodNotAllowed, err.(Error).Status())
+
+	resources, err := proxy.ReadResources(nil, []string{"a", "b"}, "1")
+	assert.Nil(err)
+	assert.Equal([]Resource{&TestResource{Foo: "a"}, &TestResource{Foo: "b"}}, resources)
+
+	// BaseResourceHandler doesn't read lists by offset unless overridden.
+	var handler ResourceHandler = BaseResourceHandler{}
+	_, ok := handler.(OffsetReader)
+	assert.False(ok)
+}
+
+// Ensures that a ResourceHandler implementing none of the optional interfaces can be
+// registered and serves requests.
+func TestMinimalHandlerServesRequests(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(TestMinimalHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/minimal/a", nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("", resp.Header().Get("Cache-Control"))
+
+	req, _ = http.NewRequest("POST", "http://example.com/api/v1/minimal", nil)
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(http.StatusCreated, resp.Code)
+	assert.Equal("", resp.Header().Get("Location"))
+
+	req, _ = http.NewRequest("PATCH", "http://example.com/api/v1/minimal/a", nil)
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+}
@@ -22,8 +22,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
 // Supported HTTP Methods
@@ -68,6 +71,104 @@ func NewRestClient(c HttpClient, middleware ...ClientMiddleware) RestClient {
 	return &client{c, middleware}
 }
 
+// Defaults used by NewPooledRestClient for zero-valued TransportOptions.
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultDialTimeout         = 30 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// TransportOptions configures the connection pooling and timeouts of the
+// http.Transport used by NewPooledRestClient. Zero values use sensible defaults
+// unless noted otherwise.
+type TransportOptions struct {
+	// MaxIdleConns limits the idle connections kept across all hosts. Defaults
+	// to 100.
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost limits the idle connections kept for each host.
+	// Defaults to 10.
+	MaxIdleConnsPerHost int
+
+	// MaxConnsPerHost limits the connections to each host, including those in
+	// use. Zero means no limit.
+	MaxConnsPerHost int
+
+	// IdleConnTimeout is how long idle connections are kept before being
+	// closed. Defaults to 90 seconds.
+	IdleConnTimeout time.Duration
+
+	// DialTimeout limits the time spent establishing a connection. Defaults to
+	// 30 seconds.
+	DialTimeout time.Duration
+
+	// KeepAlive is the interval between TCP keep-alive probes. Defaults to 30
+	// seconds.
+	KeepAlive time.Duration
+
+	// TLSHandshakeTimeout limits the time spent on the TLS handshake. Defaults
+	// to 10 seconds.
+	TLSHandshakeTimeout time.Duration
+
+	// ResponseHeaderTimeout limits the time spent waiting for response headers
+	// after the request is written. Zero means no limit.
+	ResponseHeaderTimeout time.Duration
+
+	// Timeout limits the total time of each request, including reading the
+	// response body. Zero means no limit.
+	Timeout time.Duration
+}
+
+// NewPooledRestClient returns a RestClient backed by an http.Client whose
+// http.Transport pools connections as configured by the TransportOptions.
+func NewPooledRestClient(opts TransportOptions, middleware ...ClientMiddleware) RestClient {
+	c := &http.Client{
+		Transport: newTransport(opts),
+		Timeout:   opts.Timeout,
+	}
+	return NewRestClient(c, middleware...)
+}
+
+// newTransport builds an http.Transport from the TransportOptions, falling back to
+// the defaults for zero values.
+func newTransport(opts TransportOptions) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   durationOrDefault(opts.DialTimeout, defaultDialTimeout),
+		KeepAlive: durationOrDefault(opts.KeepAlive, defaultKeepAlive),
+	}
+
+	maxIdleConns := opts.MaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	maxIdleConnsPerHost := opts.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		IdleConnTimeout:       durationOrDefault(opts.IdleConnTimeout, defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   durationOrDefault(opts.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+	}
+}
+
+// durationOrDefault returns the duration if it's positive, otherwise the default.
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
 // Client is the type that encapsulates and uses the Authorizer to sign any REST
 // requests that are performed.
 type Client struct {
@@ -90,6 +191,10 @@ type Response struct {
 	Next     string         // A cursor to the next result set.
 	Result   interface{}    // The decoded result of the REST request.
 	Raw      *http.Response // The raw HTTP response.
+
+	// StructuredMessages contains all server messages attached to the Response,
+	// including structured ones which are omitted from Messages.
+	StructuredMessages []interface{}
 }
 
 // Wraps response decoding error in a helpful way
@@ -105,6 +210,52 @@ func (rde *ResponseDecodeError) Error() string {
 		rde.DecodeError.Error(), rde.StatusCode, rde.Status, string(rde.Response))
 }
 
+// ResponseStatusError is returned by EnsureSuccess and Err for Responses with a
+// non-2xx status.
+type ResponseStatusError struct {
+	Status   int      // Response status code
+	Reason   string   // Reason message for the status code
+	Messages []string // Server messages attached to the Response
+
+	// StructuredMessages contains all server messages attached to the Response,
+	// including structured ones which are omitted from Messages.
+	StructuredMessages []interface{}
+}
+
+func (rse *ResponseStatusError) Error() string {
+	msg := fmt.Sprintf("Unsuccessful response status %d %s", rse.Status, rse.Reason)
+	if len(rse.Messages) > 0 {
+		msg += ": " + strings.Join(rse.Messages, "; ")
+	}
+	return msg
+}
+
+// EnsureSuccess returns a ResponseStatusError describing the Response, including
+// any server messages, if its status is not in the 2xx range. Otherwise nil is
+// returned.
+func (r *Response) EnsureSuccess() error {
+	if r.Status >= 200 && r.Status < 300 {
+		return nil
+	}
+
+	return &ResponseStatusError{
+		Status:             r.Status,
+		Reason:             r.Reason,
+		Messages:           r.Messages,
+		StructuredMessages: r.StructuredMessages,
+	}
+}
+
+// APIError describes the error envelope of a Response with a non-2xx status. It's the
+// ResponseStatusError returned by Err.
+type APIError = ResponseStatusError
+
+// Err returns an APIError constructed from the Response envelope if its status is
+// not in the 2xx range. Otherwise nil is returned. It's equivalent to EnsureSuccess.
+func (r *Response) Err() error {
+	return r.EnsureSuccess()
+}
+
 // applyMiddleware wraps a given InvocationHandler with all of the middleware in the client
 func (c *client) applyMiddleware(method InvocationHandler) InvocationHandler {
 	for _, middleware := range c.middleware {
@@ -224,8 +375,11 @@ func decodeResponse(response []byte, r *http.Response) (*Response, error) {
 	}
 
 	messages := []string{}
-	for _, message := range payload["messages"].([]interface{}) {
-		messages = append(messages, message.(string))
+	structuredMessages, _ := payload["messages"].([]interface{})
+	for _, message := range structuredMessages {
+		if str, ok := message.(string); ok {
+			messages = append(messages, str)
+		}
 	}
 
 	next := ""
@@ -239,12 +393,13 @@ func decodeResponse(response []byte, r *http.Response) (*Response, error) {
 	}
 
 	resp := &Response{
-		Status:   int(payload["status"].(float64)),
-		Reason:   payload["reason"].(string),
-		Messages: messages,
-		Next:     next,
-		Result:   result,
-		Raw:      r,
+		Status:             int(payload["status"].(float64)),
+		Reason:             payload["reason"].(string),
+		Messages:           messages,
+		Next:               next,
+		Result:             result,
+		Raw:                r,
+		StructuredMessages: structuredMessages,
 	}
 
 	return resp, nil
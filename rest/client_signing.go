@@ -0,0 +1,90 @@
+/*
+Copyright 2014 - 2015 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SignatureHeader is the name of the request header containing the HMAC signature
+// attached by HMACSigningMiddleware.
+const SignatureHeader = "X-Signature"
+
+// HMACSigningMiddleware returns a ClientMiddleware which signs outgoing requests with
+// an HMAC-SHA256 over a canonical request string and attaches the result to the
+// SignatureHeader. The canonical string is the HTTP method, the request path and query,
+// and the hex-encoded SHA-256 digest of the JSON body, separated by newlines.
+func HMACSigningMiddleware(keyID, secret string) ClientMiddleware {
+	return func(next InvocationHandler) InvocationHandler {
+		return func(c *http.Client, method, url string, body interface{}, header http.Header) (*Response, error) {
+			canonical, err := canonicalRequest(method, url, body)
+			if err != nil {
+				return nil, err
+			}
+
+			if header == nil {
+				header = http.Header{}
+			}
+			header.Set(SignatureHeader, fmt.Sprintf("keyId=%q,algorithm=%q,signature=%q",
+				keyID, "hmac-sha256", signRequest(canonical, secret)))
+
+			return next(c, method, url, body, header)
+		}
+	}
+}
+
+// canonicalRequest builds the string which is signed by HMACSigningMiddleware. The body
+// is marshaled the same way do marshals it so the digest matches what goes over the
+// wire. Requests without a body use the digest of an empty string.
+func canonicalRequest(method, rawURL string, body interface{}) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	var payload []byte
+	switch method {
+	case httpPost, httpPut:
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	digest := sha256.Sum256(payload)
+	return strings.Join([]string{
+		method,
+		u.RequestURI(),
+		hex.EncodeToString(digest[:]),
+	}, "\n"), nil
+}
+
+// signRequest returns the base64-encoded HMAC-SHA256 of the canonical request using
+// the provided secret.
+func signRequest(canonical, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(canonical))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2014 - 2015 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureSignature returns an InvocationHandler which records the signature header
+// of the request it receives.
+func captureSignature(signature *string) InvocationHandler {
+	return func(c *http.Client, method, url string, body interface{}, header http.Header) (*Response, error) {
+		*signature = header.Get(SignatureHeader)
+		return &Response{}, nil
+	}
+}
+
+// Ensures that HMACSigningMiddleware attaches a signature header to the request.
+func TestHMACSigningMiddlewareAttachesSignature(t *testing.T) {
+	assert := assert.New(t)
+	var signature string
+	handler := HMACSigningMiddleware("key", "secret")(captureSignature(&signature))
+
+	_, err := handler(http.DefaultClient, httpPost, "http://localhost/api/v1/foo?a=b",
+		map[string]string{"foo": "bar"}, nil)
+
+	assert.Nil(err)
+	canonical, _ := canonicalRequest(httpPost, "http://localhost/api/v1/foo?a=b",
+		map[string]string{"foo": "bar"})
+	assert.Equal(
+		`keyId="key",algorithm="hmac-sha256",signature="`+signRequest(canonical, "secret")+`"`,
+		signature,
+	)
+}
+
+// Ensures that HMACSigningMiddleware produces the same signature for the same
+// request and different signatures when the method, path, body, or secret change.
+func TestHMACSigningMiddlewareDeterministic(t *testing.T) {
+	assert := assert.New(t)
+	sign := func(secret, method, url string, body interface{}) string {
+		var signature string
+		handler := HMACSigningMiddleware("key", secret)(captureSignature(&signature))
+		handler(http.DefaultClient, method, url, body, http.Header{})
+		return signature
+	}
+
+	body := map[string]interface{}{"foo": "bar", "baz": 1}
+	first := sign("secret", httpPut, "http://localhost/foo/1", body)
+	second := sign("secret", httpPut, "http://localhost/foo/1", body)
+
+	assert.NotEqual("", first)
+	assert.Equal(first, second)
+	assert.NotEqual(first, sign("other", httpPut, "http://localhost/foo/1", body))
+	assert.NotEqual(first, sign("secret", httpPost, "http://localhost/foo/1", body))
+	assert.NotEqual(first, sign("secret", httpPut, "http://localhost/foo/2", body))
+	assert.NotEqual(first, sign("secret", httpPut, "http://localhost/foo/1",
+		map[string]interface{}{"foo": "qux"}))
+}
+
+// Ensures that canonicalRequest ignores the host and uses an empty body digest for
+// requests without a body.
+func TestCanonicalRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	canonical, err := canonicalRequest(httpGet, "https://example.com/api/v1/foo?a=b", "ignored")
+
+	assert.Nil(err)
+	assert.Equal(
+		"GET\n/api/v1/foo?a=b\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		canonical,
+	)
+}
+
+// Ensures that HMACSigningMiddleware returns an error if the URL is malformed.
+func TestHMACSigningMiddlewareBadURL(t *testing.T) {
+	handler := HMACSigningMiddleware("key", "secret")(func(c *http.Client, method,
+		url string, body interface{}, header http.Header) (*Response, error) {
+		t.Fatal("Request should not be sent")
+		return nil, nil
+	})
+
+	_, err := handler(http.DefaultClient, httpGet, "http://[::1]:namedport", nil, nil)
+	assert.Error(t, err)
+}
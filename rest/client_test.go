@@ -21,6 +21,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -429,3 +430,130 @@ func TestNewClientDelete(t *testing.T) {
 
 	do = before
 }
+
+// Ensures that EnsureSuccess returns nil for 2xx Responses.
+func TestEnsureSuccessOK(t *testing.T) {
+	resp := &Response{Status: http.StatusOK, Reason: "OK", Messages: []string{}}
+	assert.Nil(t, resp.EnsureSuccess())
+}
+
+// Ensures that EnsureSuccess returns an error including the server messages for
+// non-2xx Responses.
+func TestEnsureSuccessError(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := Payload{
+			status:   http.StatusBadRequest,
+			reason:   http.StatusText(http.StatusBadRequest),
+			messages: []string{"Missing required field 'foo'", "Invalid bar"},
+		}
+		responseJSON, _ := json.Marshal(response)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(responseJSON)
+	}))
+	defer ts.Close()
+
+	resp, err := do(http.DefaultClient, httpGet, ts.URL, nil, nil)
+	assert.Nil(err)
+
+	err = resp.EnsureSuccess()
+	assert.Equal(&ResponseStatusError{
+		Status:             http.StatusBadRequest,
+		Reason:             "Bad Request",
+		Messages:           []string{"Missing required field 'foo'", "Invalid bar"},
+		StructuredMessages: []interface{}{"Missing required field 'foo'", "Invalid bar"},
+	}, err)
+	assert.EqualError(err, "Unsuccessful response status 400 Bad Request: "+
+		"Missing required field 'foo'; Invalid bar")
+
+	resp = &Response{Status: http.StatusInternalServerError, Reason: "Internal Server Error"}
+	assert.EqualError(resp.EnsureSuccess(), "Unsuccessful response status 500 Internal Server Error")
+}
+
+// Ensures that NewPooledRestClient uses a transport with the configured settings,
+// falling back to the defaults for zero values.
+func TestNewPooledRestClient(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewPooledRestClient(TransportOptions{
+		MaxIdleConns:          50,
+		MaxIdleConnsPerHost:   5,
+		MaxConnsPerHost:       20,
+		IdleConnTimeout:       time.Minute,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 2 * time.Second,
+		Timeout:               15 * time.Second,
+	}).(*client)
+	httpClient := c.HttpClient.(*http.Client)
+	transport := httpClient.Transport.(*http.Transport)
+
+	assert.Equal(15*time.Second, httpClient.Timeout)
+	assert.Equal(50, transport.MaxIdleConns)
+	assert.Equal(5, transport.MaxIdleConnsPerHost)
+	assert.Equal(20, transport.MaxConnsPerHost)
+	assert.Equal(time.Minute, transport.IdleConnTimeout)
+	assert.Equal(5*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(2*time.Second, transport.ResponseHeaderTimeout)
+	assert.NotNil(transport.DialContext)
+
+	transport = newTransport(TransportOptions{})
+	assert.Equal(defaultMaxIdleConns, transport.MaxIdleConns)
+	assert.Equal(defaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	assert.Equal(0, transport.MaxConnsPerHost)
+	assert.Equal(defaultIdleConnTimeout, transport.IdleConnTimeout)
+	assert.Equal(defaultTLSHandshakeTimeout, transport.TLSHandshakeTimeout)
+}
+
+// Ensures that a pooled client performs requests with middleware applied.
+func TestNewPooledRestClientGet(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":200,"reason":"OK","messages":[],"result":"` +
+			r.Header.Get("X-Foo") + `"}`))
+	}))
+	defer ts.Close()
+
+	middleware := func(next InvocationHandler) InvocationHandler {
+		return func(c *http.Client, method, url string, body interface{},
+			header http.Header) (*Response, error) {
+			header.Set("X-Foo", "bar")
+			return next(c, method, url, body, header)
+		}
+	}
+	c := NewPooledRestClient(TransportOptions{Timeout: time.Second}, middleware)
+
+	resp, err := c.Get(ts.URL, http.Header{})
+
+	assert.Nil(err)
+	assert.Equal("bar", resp.Result)
+}
+
+// Ensures that Err returns an APIError describing non-2xx Responses and nil for 2xx
+// Responses.
+func TestResponseErr(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"status":422,"reason":"Unprocessable Entity","messages":[` +
+			`"Invalid foo",{"field":"bar","message":"Invalid bar"}]}`))
+	}))
+	defer ts.Close()
+
+	resp, err := NewRestClient(http.DefaultClient).Get(ts.URL, nil)
+	assert.Nil(err)
+
+	err = resp.Err()
+	apiErr, ok := err.(*APIError)
+	if assert.True(ok) {
+		assert.Equal(http.StatusUnprocessableEntity, apiErr.Status)
+		assert.Equal("Unprocessable Entity", apiErr.Reason)
+		assert.Equal([]string{"Invalid foo"}, apiErr.Messages)
+		assert.Equal([]interface{}{"Invalid foo",
+			map[string]interface{}{"field": "bar", "message": "Invalid bar"}},
+			apiErr.StructuredMessages)
+	}
+	assert.EqualError(err, "Unsuccessful response status 422 Unprocessable Entity: Invalid foo")
+
+	resp = &Response{Status: http.StatusCreated, Reason: "Created"}
+	assert.Nil(resp.Err())
+}
@@ -21,9 +21,14 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -32,6 +37,9 @@ const (
 	// resourceIDKey is the name of the URL path variable for a resource ID.
 	resourceIDKey = "resource_id"
 
+	// idsKey is the name of the query string variable for the resource IDs to read.
+	idsKey = "ids"
+
 	// formatKey is the name of the query string variable for the response format.
 	formatKey = "format"
 
@@ -44,10 +52,44 @@ const (
 	// limitKey is the name of the query string variable for the results limit.
 	limitKey = "limit"
 
+	// offsetKey is the name of the query string variable for the results offset.
+	offsetKey = "offset"
+
+	// pageKey is the name of the query string variable for the results page.
+	pageKey = "page"
+
+	// perPageKey is the name of the query string variable for the results limit when
+	// paginating by page.
+	perPageKey = "per_page"
+
+	// includeKey is the name of the query string variable for the optional parts of
+	// the response to include, e.g. "count".
+	includeKey = "include"
+
+	// includeCount is the include value requesting the total number of results.
+	includeCount = "count"
+
+	// defaultLocale is the locale of requests which don't specify one using the
+	// Accept-Language header.
+	defaultLocale = "en"
+
 	requestKey int = iota
 	statusKey
 	errorKey
 	resultKey
+	omitBodyKey
+	envelopeKeysKey
+	durationKey
+	requestIDKey
+	defaultLimitKey
+	totalKey
+	translatorKey
+	versionsKey
+	offsetPaginationKey
+	maxLimitKey
+	loggerKey
+	typeSerializersKey
+	requestContextKey
 )
 
 // RequestContext contains the context information for the current HTTP request. Context
@@ -58,6 +100,18 @@ type RequestContext interface {
 	// the same key returns the same result.
 	Value(key interface{}) interface{}
 
+	// Deadline returns the time when the request's context is canceled, e.g. when
+	// Configuration.RequestTimeout elapses, and true, or false if there's no deadline.
+	Deadline() (deadline time.Time, ok bool)
+
+	// Done returns a channel which is closed when the request's context is canceled,
+	// either because the client disconnected or because its deadline passed.
+	Done() <-chan struct{}
+
+	// Err returns nil if Done isn't closed yet, or the reason the request's context
+	// was canceled, context.Canceled or context.DeadlineExceeded, if it is.
+	Err() error
+
 	// WithValue returns a new RequestContext with the provided key-value pair and this context
 	// as the parent.
 	WithValue(interface{}, interface{}) RequestContext
@@ -70,7 +124,8 @@ type RequestContext interface {
 	Request() (*http.Request, bool)
 
 	// NextURL returns the URL to use to request the next page of results using the current
-	// cursor. If there is no cursor for this request or the URL fails to be built, an empty
+	// cursor, or the following offset if the ResourceHandler is an OffsetReader. If
+	// there is no next page for this request or the URL fails to be built, an empty
 	// string is returned with the error set.
 	NextURL() (string, error)
 
@@ -93,10 +148,59 @@ type RequestContext interface {
 	// there isn't one.
 	ResourceID() string
 
+	// ResourceIDs returns the resource ids requested using the "ids" query parameter,
+	// e.g. ?ids=1,2,3, defaulting to an empty slice if there aren't any.
+	ResourceIDs() []string
+
+	// QueryParam returns the first value of the query string parameter with the given
+	// key and true, or an empty string and false if the request doesn't have it.
+	QueryParam(key string) (string, bool)
+
+	// QueryParamInt returns the first value of the query string parameter with the
+	// given key as an int, or an error if the request doesn't have it or it isn't an
+	// integer.
+	QueryParamInt(key string) (int, error)
+
+	// QueryParamSlice returns the values of the query string parameter with the given
+	// key, which may be repeated or comma-separated, e.g. ?foo=a,b&foo=c yields
+	// [a, b, c], defaulting to an empty slice if the request doesn't have it.
+	QueryParamSlice(key string) []string
+
 	// Version returns the API version for the request, defaulting to an empty string if
 	// one is not specified in the request path.
 	Version() string
 
+	// RequestID returns the id correlating the request with its log lines, taken from
+	// the Configuration's RequestIDHeader, X-Request-ID by default, or generated if the
+	// header isn't set. It defaults to an empty string if the request wasn't routed to
+	// a ResourceHandler.
+	RequestID() string
+
+	// Locale returns the client's preferred locale, the lowercased language tag with
+	// the highest q-value in the Accept-Language header, e.g. "fr-ca", defaulting to
+	// "en" if the header doesn't specify one.
+	Locale() string
+
+	// Flag returns whether the feature flag with the given name is enabled for the
+	// request. Flags are resolved using the Configuration's FlagResolver the first time
+	// they're requested and cached for the rest of the request. Flags are disabled if
+	// there is no FlagResolver.
+	Flag(name string) bool
+
+	// RouteName returns the name of the route matched for the request, e.g.
+	// "widgets:read", defaulting to an empty string if the request wasn't routed or
+	// the route is unnamed.
+	RouteName() string
+
+	// ResourceName returns the name of the resource whose route matched the request,
+	// defaulting to an empty string if the request wasn't routed to a ResourceHandler.
+	ResourceName() string
+
+	// HandleMethod returns the HandleMethod of the route matched for the request,
+	// defaulting to an empty string if the request wasn't routed to a ResourceHandler.
+	// Requests routed using X-HTTP-Method-Override report the overridden method.
+	HandleMethod() HandleMethod
+
 	// Status returns the current HTTP status code that will be returned for the request,
 	// defaulting to 200 if one hasn't been set yet.
 	Status() int
@@ -126,13 +230,38 @@ type RequestContext interface {
 	// Limit returns the maximum number of results that should be fetched.
 	Limit() int
 
-	// Messages returns all of the messages set by the request handler to be included in
-	// the response.
+	// Offset returns the number of results to skip, read from the "offset" query
+	// parameter or derived from the "page" query parameter, defaulting to 0 if neither
+	// is specified or valid.
+	Offset() int
+
+	// Page returns the 1-based page of results to fetch, each Limit results long, read
+	// from the "page" query parameter or derived from the "offset" query parameter,
+	// defaulting to 1 if neither is specified or valid.
+	Page() int
+
+	// Messages returns all of the string messages set by the request handler to be
+	// included in the response.
 	Messages() []string
 
 	// AddMessage adds a message to the request messages to be included in the response.
 	AddMessage(string)
 
+	// StructuredMessages returns all of the messages set by the request handler to be
+	// included in the response, both strings and structured messages, in the order
+	// they were added.
+	StructuredMessages() []interface{}
+
+	// AddStructuredMessage adds a message which is serialized as an object, e.g. one
+	// with a code and field reference, to the request messages to be included in the
+	// response.
+	AddStructuredMessage(interface{})
+
+	// SetPartial marks the list of resources read by the request as incomplete, e.g.
+	// truncated by the limit. If there is a next page, the response is a 206 Partial
+	// Content instead of a 200 OK.
+	SetPartial()
+
 	// Header returns the header key-value pairs for the request.
 	Header() http.Header
 
@@ -143,13 +272,38 @@ type RequestContext interface {
 	ResponseWriter() http.ResponseWriter
 }
 
+// baseValueContext is a context.Context which falls back to the values of a base
+// context for keys its parent doesn't have. Deadlines and cancellation are those of
+// the parent.
+type baseValueContext struct {
+	context.Context
+	base context.Context
+}
+
+// withBaseContext returns a copy of the parent context whose values include those of
+// the base context. Values on the parent take precedence.
+func withBaseContext(parent, base context.Context) context.Context {
+	return baseValueContext{parent, base}
+}
+
+// Value returns the parent's value for the key, falling back to the base context's.
+func (c baseValueContext) Value(key interface{}) interface{} {
+	if value := c.Context.Value(key); value != nil {
+		return value
+	}
+	return c.base.Value(key)
+}
+
 // requestContext is an implementation of the RequestContext interface.
 type requestContext struct {
 	req      *http.Request
 	body     *bytes.Buffer
 	writer   http.ResponseWriter
 	router   *mux.Router
-	messages []string
+	messages []interface{}
+	start    time.Time
+	flags    *flagCache
+	partial  bool
 }
 
 func setValueOnRequestContext(req *http.Request, key, val interface{}) *http.Request {
@@ -174,7 +328,7 @@ func NewContext(req *http.Request, writer http.ResponseWriter) RequestContext {
 		req = setValueOnRequestContext(req, key, val)
 	}
 
-	for key, value := range mux.Vars(req) {
+	for key, value := range routeVars(req) {
 		req = setValueOnRequestContext(req, key, value)
 	}
 
@@ -195,7 +349,8 @@ func NewContext(req *http.Request, writer http.ResponseWriter) RequestContext {
 		body:     bytes.NewBuffer(body),
 		writer:   writer,
 		router:   nil,
-		messages: []string{},
+		messages: []interface{}{},
+		start:    time.Now(),
 	}
 }
 
@@ -216,6 +371,9 @@ func (ctx *requestContext) WithValue(key, value interface{}) RequestContext {
 			writer:   ctx.writer,
 			router:   ctx.router,
 			messages: ctx.messages,
+			start:    ctx.start,
+			flags:    ctx.flags,
+			partial:  ctx.partial,
 		}
 	}
 
@@ -223,6 +381,22 @@ func (ctx *requestContext) WithValue(key, value interface{}) RequestContext {
 	panic("Unable to set value on context: no request")
 }
 
+// Deadline returns the time when the request's context is canceled and true, or false
+// if there's no deadline.
+func (ctx *requestContext) Deadline() (time.Time, bool) {
+	return ctx.req.Context().Deadline()
+}
+
+// Done returns a channel which is closed when the request's context is canceled.
+func (ctx *requestContext) Done() <-chan struct{} {
+	return ctx.req.Context().Done()
+}
+
+// Err returns the reason the request's context was canceled, or nil if it wasn't.
+func (ctx *requestContext) Err() error {
+	return ctx.req.Context().Err()
+}
+
 // Value returns this Context's request's value for the given key, or the request if
 // passed the request key. It will return nil if no value is associated with the key.
 func (ctx *requestContext) Value(key interface{}) interface{} {
@@ -246,6 +420,15 @@ func (ctx *requestContext) ValueWithDefault(key, defaultVal interface{}) interfa
 	return value
 }
 
+// requestStart returns the time at which the RequestContext was created for the
+// request, or the zero time if it's unknown.
+func requestStart(ctx RequestContext) time.Time {
+	if c, ok := ctx.(*requestContext); ok {
+		return c.start
+	}
+	return time.Time{}
+}
+
 // ResponseFormat returns the response format for the request, defaulting to "json"
 // if one is not specified using the "format" query parameter.
 func (ctx *requestContext) ResponseFormat() string {
@@ -258,12 +441,122 @@ func (ctx *requestContext) ResourceID() string {
 	return ctx.ValueWithDefault(resourceIDKey, "").(string)
 }
 
+// ResourceIDs returns the resource ids requested using the "ids" query parameter,
+// e.g. ?ids=1,2,3, defaulting to an empty slice if there aren't any. Ids may be
+// comma-separated, repeated (?ids=1&ids=2), or both.
+func (ctx *requestContext) ResourceIDs() []string {
+	return ctx.QueryParamSlice(idsKey)
+}
+
+// QueryParam returns the first value of the query string parameter with the given key
+// and true, or an empty string and false if the request doesn't have it.
+func (ctx *requestContext) QueryParam(key string) (string, bool) {
+	values, ok := ctx.req.URL.Query()[key]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// QueryParamInt returns the first value of the query string parameter with the given
+// key as an int, or an error if the request doesn't have it or it isn't an integer.
+func (ctx *requestContext) QueryParamInt(key string) (int, error) {
+	value, ok := ctx.QueryParam(key)
+	if !ok {
+		return 0, fmt.Errorf("Missing query parameter '%s'", key)
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Query parameter '%s' is not an integer: %s", key, value)
+	}
+	return i, nil
+}
+
+// QueryParamSlice returns the values of the query string parameter with the given key,
+// which may be repeated or comma-separated, e.g. ?foo=a,b&foo=c yields [a, b, c],
+// defaulting to an empty slice if the request doesn't have it. Empty values are
+// omitted.
+func (ctx *requestContext) QueryParamSlice(key string) []string {
+	values := []string{}
+	for _, value := range ctx.req.URL.Query()[key] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
 // Version returns the API version for the request, defaulting to an empty string
 // if one is not specified in the request path.
 func (ctx *requestContext) Version() string {
 	return ctx.ValueWithDefault(versionKey, "").(string)
 }
 
+// RequestID returns the id correlating the request with its log lines, taken from the
+// Configuration's RequestIDHeader, X-Request-ID by default, or generated if the header
+// isn't set. It defaults to an empty string if the request wasn't routed to a
+// ResourceHandler.
+func (ctx *requestContext) RequestID() string {
+	return ctx.ValueWithDefault(requestIDKey, "").(string)
+}
+
+// Locale returns the client's preferred locale, the lowercased language tag with the
+// highest q-value in the Accept-Language header, e.g. "fr-ca", defaulting to "en" if
+// the header doesn't specify one.
+func (ctx *requestContext) Locale() string {
+	for _, tag := range parseAccept(ctx.Header()["Accept-Language"]) {
+		if tag != "*" {
+			return tag
+		}
+	}
+	return defaultLocale
+}
+
+// Flag returns whether the feature flag with the given name is enabled for the
+// request. Flags are resolved using the Configuration's FlagResolver the first time
+// they're requested and cached for the rest of the request. Flags are disabled if
+// there is no FlagResolver.
+func (ctx *requestContext) Flag(name string) bool {
+	if ctx.flags == nil {
+		return false
+	}
+	return ctx.flags.resolve(ctx, name)
+}
+
+// RouteName returns the name of the route matched for the request, e.g.
+// "widgets:read", defaulting to an empty string if the request wasn't routed or the
+// route is unnamed.
+func (ctx *requestContext) RouteName() string {
+	r, ok := ctx.Request()
+	if !ok {
+		return ""
+	}
+
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+
+	return route.GetName()
+}
+
+// ResourceName returns the name of the resource whose route matched the request,
+// defaulting to an empty string if the request wasn't routed to a ResourceHandler.
+func (ctx *requestContext) ResourceName() string {
+	resource, _ := parseRouteName(ctx.RouteName())
+	return resource
+}
+
+// HandleMethod returns the HandleMethod of the route matched for the request,
+// defaulting to an empty string if the request wasn't routed to a ResourceHandler.
+// Requests routed using X-HTTP-Method-Override report the overridden method.
+func (ctx *requestContext) HandleMethod() HandleMethod {
+	_, method := parseRouteName(ctx.RouteName())
+	return method
+}
+
 // Status returns the current HTTP status code that will be returned for the request,
 // defaulting to 200 if one hasn't been set yet.
 func (ctx *requestContext) Status() int {
@@ -336,22 +629,81 @@ func (ctx *requestContext) Request() (*http.Request, bool) {
 	return req, ok
 }
 
-// Limit returns the maximum number of results that should be fetched.
+// Limit returns the maximum number of results that should be fetched, read from the
+// "limit" query parameter or, when paginating by page, the "per_page" query parameter.
+// It falls back to the default limit for the request if one isn't specified or isn't
+// a positive integer, and is capped at the maximum limit for the request, if there is one.
 func (ctx *requestContext) Limit() int {
-	limitStr := ctx.ValueWithDefault(limitKey, "100")
-	limit, err := strconv.Atoi(limitStr.(string))
-	if err != nil {
-		limit = 100
+	fallback, ok := ctx.Value(defaultLimitKey).(int)
+	if !ok || fallback <= 0 {
+		fallback = defaultLimit
+	}
+
+	limit := fallback
+	if limitStr, ok := ctx.Value(limitKey).(string); ok {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	} else if l, err := ctx.QueryParamInt(perPageKey); err == nil && l > 0 {
+		limit = l
+	}
+
+	if max, ok := ctx.Value(maxLimitKey).(int); ok && max > 0 && limit > max {
+		return max
 	}
 	return limit
 }
 
+// Offset returns the number of results to skip, read from the "offset" query
+// parameter or derived from the "page" query parameter, defaulting to 0 if neither is
+// specified or valid.
+func (ctx *requestContext) Offset() int {
+	if offset, err := ctx.QueryParamInt(offsetKey); err == nil && offset >= 0 {
+		return offset
+	}
+	if page, err := ctx.QueryParamInt(pageKey); err == nil && page > 1 {
+		return (page - 1) * ctx.Limit()
+	}
+	return 0
+}
+
+// Page returns the 1-based page of results to fetch, each Limit results long, read
+// from the "page" query parameter or derived from the "offset" query parameter,
+// defaulting to 1 if neither is specified or valid.
+func (ctx *requestContext) Page() int {
+	if page, err := ctx.QueryParamInt(pageKey); err == nil && page > 0 {
+		return page
+	}
+	if limit := ctx.Limit(); limit > 0 {
+		return ctx.Offset()/limit + 1
+	}
+	return 1
+}
+
+// pagingByPage returns true if the request specifies the results to fetch using the
+// "page" query parameter rather than the "offset" query parameter.
+func pagingByPage(ctx RequestContext) bool {
+	_, byPage := ctx.QueryParam(pageKey)
+	_, byOffset := ctx.QueryParam(offsetKey)
+	return byPage && !byOffset
+}
+
 // NextURL returns the URL to use to request the next page of results using the current
-// cursor. If there is no cursor for this request or the URL fails to be built, an empty
-// string is returned with the error set.
+// cursor, or the offset following the current page if the list was read by offset,
+// or the following page if the request paginates by page. If there is no cursor for
+// this request, or no next page when paginating by offset, or the URL fails to be
+// built, an empty string is returned with the error set.
 func (ctx *requestContext) NextURL() (string, error) {
-	cursor := ctx.Cursor()
-	if cursor == "" {
+	param, value := cursorKey, ctx.Cursor()
+	if byOffset, _ := ctx.Value(offsetPaginationKey).(bool); byOffset {
+		if resultCount(ctx) < ctx.Limit() {
+			return "", fmt.Errorf("Unable to build next url: no more results")
+		}
+		param, value = offsetKey, strconv.Itoa(ctx.Offset()+ctx.Limit())
+		if pagingByPage(ctx) {
+			param, value = pageKey, strconv.Itoa(ctx.Page()+1)
+		}
+	} else if value == "" {
 		return "", fmt.Errorf("Unable to build next url: no cursor")
 	}
 
@@ -360,27 +712,45 @@ func (ctx *requestContext) NextURL() (string, error) {
 		return "", fmt.Errorf("Unable to build next url: no request")
 	}
 
-	var scheme string
-	scheme = r.URL.Scheme
-	if scheme == "" {
-		scheme = "http"
-	}
+	return pageURL(r, map[string]string{param: value}), nil
+}
 
-	urlStr := fmt.Sprintf("%s://%s%s", scheme, r.Host, r.RequestURI)
-	u, err := url.Parse(urlStr)
-	if err != nil {
-		return "", fmt.Errorf("Unable to build next url: %s", urlStr)
+// pageURL returns the URL of the request with the given query parameters set, or
+// removed if their value is empty, e.g. to request another page of results.
+func pageURL(r *http.Request, params map[string]string) string {
+	// Copy the request URL, which keeps the path as escaped by the client.
+	u := *r.URL
+	if u.Scheme == "" {
+		u.Scheme = "http"
 	}
+	u.Host = r.Host
+	u.User = nil
+	u.Fragment = ""
 
 	q := u.Query()
-	q.Set("next", cursor)
+	for param, value := range params {
+		if value == "" {
+			q.Del(param)
+		} else {
+			q.Set(param, value)
+		}
+	}
 	u.RawQuery = q.Encode()
-	return u.String(), nil
+	return u.String()
+}
+
+// resultCount returns the number of results set for the request, or 0 if the result
+// isn't a slice.
+func resultCount(ctx RequestContext) int {
+	if result := reflect.ValueOf(ctx.Result()); result.Kind() == reflect.Slice {
+		return result.Len()
+	}
+	return 0
 }
 
 // RouteVars is a map of URL route variables to values.
 //
-//     vars = RouteVars{"category": "widgets", "resource_id": "42"}
+//	vars = RouteVars{"category": "widgets", "resource_id": "42"}
 //
 // Variables are defined in CreateURI and the other URI methods.
 type RouteVars map[string]string
@@ -404,31 +774,47 @@ func (ctx *requestContext) BuildURL(resourceName string,
 
 	routeName := resourceName + ":" + string(method)
 	route := ctx.router.Get(routeName)
+	if route == nil {
+		return nil, fmt.Errorf("unable to build URL for resource name %q: no route named %q",
+			resourceName, routeName)
+	}
 
-	// Transform RouteVars map to list of key, val pairs for Gorilla's API
-	pairs := make([]string, (len(vars)*2)+2)
+	// Transform RouteVars map to list of key, val pairs for Gorilla's API. Values are
+	// escaped so they're confined to their path segment, e.g. ids containing slashes.
+	pairs := make([]string, 0, (len(vars)*2)+2)
 	for key, val := range vars {
-		pairs = append(pairs, key, val)
+		pairs = append(pairs, key, url.PathEscape(val))
 	}
-	pairs = append(pairs, "version", ctx.Version())
-	url, err := route.URL(pairs...)
+	pairs = append(pairs, versionKey, url.PathEscape(ctx.Version()))
+	u, err := route.URL(pairs...)
 	if err != nil {
 		return nil, err
 	}
-	url.Host = r.Host
 
-	url.Scheme = "http"
+	// Keep the escaped path so escaped slashes aren't mistaken for separators.
+	u.RawPath = u.Path
+	if u.Path, err = url.PathUnescape(u.RawPath); err != nil {
+		return nil, err
+	}
+	u.Host = r.Host
+
+	u.Scheme = "http"
 	if r.TLS != nil {
-		url.Scheme += "s"
+		u.Scheme += "s"
 	}
 
-	return url, nil
+	return u, nil
 }
 
-// Messages returns all of the messages set by the request handler to be included in
-// the response.
+// Messages returns all of the string messages set by the request handler to be
+// included in the response.
 func (ctx *requestContext) Messages() []string {
-	messages := ctx.messages
+	messages := []string{}
+	for _, message := range ctx.messages {
+		if str, ok := message.(string); ok {
+			messages = append(messages, str)
+		}
+	}
 	if err := ctx.Error(); err != nil {
 		messages = append(messages, err.Error())
 	}
@@ -440,6 +826,170 @@ func (ctx *requestContext) AddMessage(message string) {
 	ctx.messages = append(ctx.messages, message)
 }
 
+// SetPartial marks the list of resources read by the request as incomplete, e.g.
+// truncated by the limit. If there is a next page, the response is a 206 Partial
+// Content instead of a 200 OK.
+func (ctx *requestContext) SetPartial() {
+	ctx.partial = true
+}
+
+// isPartial returns true if the list of resources read by the request was marked as
+// incomplete using SetPartial.
+func isPartial(ctx RequestContext) bool {
+	if c, ok := ctx.(*requestContext); ok {
+		return c.partial
+	}
+	return false
+}
+
+// addedMessages returns the messages added to the RequestContext, excluding the
+// message of its error.
+func addedMessages(ctx RequestContext) []interface{} {
+	if c, ok := ctx.(*requestContext); ok {
+		return append([]interface{}{}, c.messages...)
+	}
+	return []interface{}{}
+}
+
+// StructuredMessages returns all of the messages set by the request handler to be
+// included in the response, both strings and structured messages, in the order they
+// were added.
+func (ctx *requestContext) StructuredMessages() []interface{} {
+	messages := append([]interface{}{}, ctx.messages...)
+	if err := ctx.Error(); err != nil {
+		messages = append(messages, err.Error())
+	}
+	return messages
+}
+
+// AddStructuredMessage adds a message which is serialized as an object to the
+// request messages to be included in the response.
+func (ctx *requestContext) AddStructuredMessage(message interface{}) {
+	ctx.messages = append(ctx.messages, message)
+}
+
 func (ctx *requestContext) ResponseWriter() http.ResponseWriter {
 	return ctx.writer
 }
+
+// requestLogger writes log lines emitted while handling a request to the
+// Configuration's Logger, prefixed with the request's id so they can be correlated.
+// The zero value writes unprefixed lines to the standard logger.
+type requestLogger struct {
+	prefix string
+	logger StdLogger
+}
+
+// newRequestLogger returns a requestLogger for the request with the given
+// RequestContext.
+func newRequestLogger(ctx RequestContext) requestLogger {
+	logger, _ := ctx.Value(loggerKey).(StdLogger)
+	id := ctx.RequestID()
+	if id == "" {
+		return requestLogger{logger: logger}
+	}
+	return requestLogger{prefix: "[" + id + "] ", logger: logger}
+}
+
+// Printf writes a log line formatted according to the format specifier.
+func (l requestLogger) Printf(format string, v ...interface{}) {
+	l.print(fmt.Sprintf(format, v...))
+}
+
+// Println writes a log line formatted using the default formats for its operands.
+func (l requestLogger) Println(v ...interface{}) {
+	l.print(fmt.Sprintln(v...))
+}
+
+// print writes the prefixed line. The prefix is never used as a format since the
+// request id is provided by the client.
+func (l requestLogger) print(line string) {
+	if l.logger == nil {
+		log.Print(l.prefix + line)
+		return
+	}
+	l.logger.Print(l.prefix + line)
+}
+
+// Message is a response message, such as a validation message or status reason, to
+// be translated by a MessageTranslator.
+type Message struct {
+	// Key identifies the message independently of its wording. It's the Code of a
+	// FieldError and the English text of other messages and status reasons.
+	Key string
+
+	// Args holds the values the message refers to, e.g. the "field" of a FieldError
+	// along with its Args.
+	Args map[string]interface{}
+
+	// Text is the message in English.
+	Text string
+}
+
+// MessageTranslator translates a response Message into the given locale. It should
+// return the Message's Text if it has no translation.
+type MessageTranslator func(locale string, message Message) string
+
+// translate returns the message translated into the request's locale using the
+// Configuration's MessageTranslator, or its Text if there is none.
+func translate(ctx RequestContext, message Message) string {
+	if translator, ok := ctx.Value(translatorKey).(MessageTranslator); ok {
+		return translator(ctx.Locale(), message)
+	}
+	return message.Text
+}
+
+// translateText returns the message, which is identified by its English text,
+// translated into the request's locale.
+func translateText(ctx RequestContext, text string) string {
+	return translate(ctx, Message{Key: text, Text: text})
+}
+
+// translateFieldError returns the FieldError's message translated into the request's
+// locale. It's identified by the FieldError's Code, or its message if it has none.
+func translateFieldError(ctx RequestContext, err *FieldError) string {
+	key := err.Code
+	if key == "" {
+		key = err.Message
+	}
+	args := map[string]interface{}{"field": err.Field}
+	for name, value := range err.Args {
+		args[name] = value
+	}
+	return translate(ctx, Message{Key: key, Args: args, Text: err.Message})
+}
+
+// FlagResolver resolves whether the feature flag with the given name is enabled for
+// the request with the given RequestContext, e.g. based on its tenant or user.
+type FlagResolver func(ctx RequestContext, name string) bool
+
+// flagCache caches the feature flags resolved for a request.
+type flagCache struct {
+	mu       sync.Mutex
+	resolver FlagResolver
+	flags    map[string]bool
+}
+
+// newFlagCache returns a flagCache which resolves flags using the FlagResolver.
+func newFlagCache(resolver FlagResolver) *flagCache {
+	return &flagCache{resolver: resolver, flags: map[string]bool{}}
+}
+
+// resolve returns the cached value of the flag with the given name, resolving it if it
+// hasn't been yet.
+func (c *flagCache) resolve(ctx RequestContext, name string) bool {
+	c.mu.Lock()
+	enabled, ok := c.flags[name]
+	c.mu.Unlock()
+	if ok {
+		return enabled
+	}
+
+	// Resolve without holding the lock so resolvers may read other flags.
+	enabled = c.resolver(ctx, name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flags[name] = enabled
+	return enabled
+}
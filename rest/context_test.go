@@ -23,6 +23,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -146,6 +148,34 @@ func TestLimitBadValue(t *testing.T) {
 	assert.Equal(100, ctx.Limit())
 }
 
+// Ensures that the default is returned for limits which aren't positive.
+func TestLimitNotPositive(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, query := range []string{"?limit=0", "?limit=-5", "?per_page=0", "?per_page=-5"} {
+		req, _ := http.NewRequest("GET", "http://example.com/foo"+query, nil)
+		ctx := NewContext(req, httptest.NewRecorder())
+		if limit := req.URL.Query().Get(limitKey); limit != "" {
+			ctx = ctx.WithValue(limitKey, limit)
+		}
+		assert.Equal(defaultLimit, ctx.Limit(), query)
+		assert.Equal(25, ctx.WithValue(defaultLimitKey, 25).Limit(), query)
+	}
+}
+
+// Ensures that the default limit on the context is used if no valid limit is set.
+func TestLimitCustomDefault(t *testing.T) {
+	assert := assert.New(t)
+	req, err := http.NewRequest("GET", "http://example.com/foo", nil)
+	require.NoError(t, err)
+
+	writer := httptest.NewRecorder()
+	ctx := NewContext(req, writer).WithValue(defaultLimitKey, 25)
+	assert.Equal(25, ctx.Limit())
+	assert.Equal(25, ctx.WithValue(limitKey, "blah").Limit())
+	assert.Equal(5, ctx.WithValue(limitKey, "5").Limit())
+}
+
 // Ensures that the correct limit is returned from the context.
 func TestLimit(t *testing.T) {
 	assert := assert.New(t)
@@ -158,6 +188,49 @@ func TestLimit(t *testing.T) {
 	assert.Equal(5, ctx.Limit())
 }
 
+// Ensures that Offset returns the offset query parameter, defaulting to 0 if it's
+// missing or invalid.
+func TestOffset(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest("GET", "http://example.com/foo?offset=20", nil)
+	assert.Equal(20, NewContext(req, httptest.NewRecorder()).Offset())
+
+	for _, query := range []string{"", "?offset=blah", "?offset=-5"} {
+		req, _ = http.NewRequest("GET", "http://example.com/foo"+query, nil)
+		assert.Equal(0, NewContext(req, httptest.NewRecorder()).Offset(), query)
+	}
+}
+
+// Ensures that Page and Offset are read from the page and offset query parameters,
+// each derived from the other, with per_page as the limit.
+func TestPage(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, test := range []struct {
+		query  string
+		limit  int
+		page   int
+		offset int
+	}{
+		{"", defaultLimit, 1, 0},
+		{"?page=3&per_page=50", 50, 3, 100},
+		{"?page=3&limit=20", 20, 3, 40},
+		{"?page=2&limit=20&per_page=50", 20, 2, 20},
+		{"?offset=40&limit=20", 20, 3, 40},
+		{"?offset=45&limit=20", 20, 3, 45},
+		{"?page=0&per_page=10", 10, 1, 0},
+		{"?page=blah&per_page=10", 10, 1, 0},
+	} {
+		req, _ := http.NewRequest("GET", "http://example.com/foo"+test.query, nil)
+		ctx := NewContext(req, httptest.NewRecorder())
+
+		assert.Equal(test.limit, ctx.Limit(), test.query)
+		assert.Equal(test.page, ctx.Page(), test.query)
+		assert.Equal(test.offset, ctx.Offset(), test.query)
+	}
+}
+
 // Ensures that Messages returns the messages set on the context.
 func TestMessagesNoError(t *testing.T) {
 	assert := assert.New(t)
@@ -203,6 +276,25 @@ func TestMessagesWithError(t *testing.T) {
 	}
 }
 
+// Ensures that StructuredMessages returns string and structured messages in the
+// order they were added while Messages returns only the strings.
+func TestStructuredMessages(t *testing.T) {
+	assert := assert.New(t)
+	req, err := http.NewRequest("GET", "http://example.com/foo", nil)
+	require.NoError(t, err)
+
+	ctx := NewContext(req, httptest.NewRecorder())
+	structured := map[string]string{"code": "deprecated", "field": "foo"}
+
+	ctx.AddMessage("first")
+	ctx.AddStructuredMessage(structured)
+	ctx.AddMessage("last")
+	ctx = ctx.setError(fmt.Errorf("blah"))
+
+	assert.Equal([]interface{}{"first", structured, "last", "blah"}, ctx.StructuredMessages())
+	assert.Equal([]string{"first", "last", "blah"}, ctx.Messages())
+}
+
 // Ensures that Header returns the request Header.
 func TestHeader(t *testing.T) {
 	assert := assert.New(t)
@@ -215,6 +307,24 @@ func TestHeader(t *testing.T) {
 	assert.Equal(req.Header, ctx.Header())
 }
 
+// Ensures that Locale returns the language tag with the highest q-value in the
+// Accept-Language header, defaulting to "en".
+func TestLocale(t *testing.T) {
+	assert := assert.New(t)
+	req, err := http.NewRequest("GET", "http://example.com/foo", nil)
+	require.NoError(t, err)
+	ctx := NewContext(req, httptest.NewRecorder())
+	assert.Equal("en", ctx.Locale())
+
+	req.Header.Set("Accept-Language", "*, de;q=0.5, fr-CA;q=0.9")
+	ctx = NewContext(req, httptest.NewRecorder())
+	assert.Equal("fr-ca", ctx.Locale())
+
+	req.Header.Set("Accept-Language", "*")
+	ctx = NewContext(req, httptest.NewRecorder())
+	assert.Equal("en", ctx.Locale())
+}
+
 // Ensures that Body returns a buffer containing the request Body.
 func TestBody(t *testing.T) {
 	assert := assert.New(t)
@@ -269,3 +379,254 @@ func TestBuildURL(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(url.String(), "https://example.com/api/v2/acme/anvils/resources")
 }
+
+// Ensures that BuildURL escapes route variables so they round-trip through the URL.
+func TestBuildURLEscaping(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(NewConfiguration())
+	api.RegisterResourceHandler(TestResourceHandler{})
+
+	req, err := http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	require.NoError(t, err)
+	req = setValueOnRequestContext(req, "version", "1")
+	ctx := NewContextWithRouter(req, httptest.NewRecorder(), api.(*muxAPI).router)
+
+	for _, id := range []string{"a/b", "a b", "café", "100%", "a?b#c"} {
+		u, err := ctx.BuildURL("widgets", HandleRead, RouteVars{"resource_id": id})
+		require.NoError(t, err)
+
+		parsed, err := url.Parse(u.String())
+		require.NoError(t, err)
+		segments := strings.Split(parsed.EscapedPath(), "/")
+		assert.Len(segments, 5, id)
+		escaped := segments[len(segments)-1]
+		unescaped, err := url.PathUnescape(escaped)
+		require.NoError(t, err)
+		assert.Equal(id, unescaped)
+	}
+
+	u, err := ctx.BuildURL("widgets", HandleRead, RouteVars{"resource_id": "a/b c"})
+	require.NoError(t, err)
+	assert.Equal("http://example.com/api/v1/widgets/a%2Fb%20c", u.String())
+}
+
+// Ensures that NextURL escapes the cursor and keeps the request's escaping so the
+// request path, query values, and cursor round-trip through the URL.
+func TestNextURLEscaping(t *testing.T) {
+	assert := assert.New(t)
+	req, err := http.NewRequest("GET",
+		"http://example.com/api/v1/widgets/a%2Fb%20caf%C3%A9?q=caf%C3%A9+au+lait", nil)
+	require.NoError(t, err)
+	ctx := NewContext(req, httptest.NewRecorder()).setCursor("a/b c&d=é")
+
+	next, err := ctx.NextURL()
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(next)
+	require.NoError(t, err)
+	assert.Equal("/api/v1/widgets/a%2Fb%20caf%C3%A9", parsed.EscapedPath())
+	assert.Equal("café au lait", parsed.Query().Get("q"))
+	assert.Equal("a/b c&d=é", parsed.Query().Get("next"))
+}
+
+type NamespacedTestResourceHandler struct {
+	TestResourceHandler
+}
+
+func (n NamespacedTestResourceHandler) ResourceName() string {
+	return "invoices"
+}
+
+func (n NamespacedTestResourceHandler) Namespace() string {
+	return "billing"
+}
+
+// Ensures that namespaced resources are routed and that BuildURL includes the
+// namespace.
+func TestBuildURLNamespace(t *testing.T) {
+	assert := assert.New(t)
+
+	api := NewAPI(NewConfiguration())
+	api.RegisterResourceHandler(NamespacedTestResourceHandler{})
+
+	req, err := http.NewRequest("GET", "http://example.com/api/v1/billing/invoices/111", nil)
+	require.NoError(t, err)
+	resp := httptest.NewRecorder()
+	api.(*muxAPI).router.ServeHTTP(resp, req)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(`{"messages":[],"reason":"OK","result":{"test":"resource"},"status":200}`,
+		resp.Body.String())
+
+	req = setValueOnRequestContext(req, "version", "1")
+	ctx := NewContextWithRouter(req, httptest.NewRecorder(), api.(*muxAPI).router)
+	url, err := ctx.BuildURL("invoices", HandleRead, RouteVars{"resource_id": "111"})
+	require.NoError(t, err)
+	assert.Equal("http://example.com/api/v1/billing/invoices/111", url.String())
+}
+
+// RouteRecordingResourceHandler records the route information available on the
+// RequestContext for each request it handles.
+type RouteRecordingResourceHandler struct {
+	BaseResourceHandler
+	routes *[]string
+}
+
+func (r RouteRecordingResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (r RouteRecordingResourceHandler) record(ctx RequestContext) {
+	*r.routes = append(*r.routes, fmt.Sprintf("%s %s %s",
+		ctx.RouteName(), ctx.ResourceName(), ctx.HandleMethod()))
+}
+
+func (r RouteRecordingResourceHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	r.record(ctx)
+	return nil, nil
+}
+
+func (r RouteRecordingResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	r.record(ctx)
+	return nil, nil
+}
+
+func (r RouteRecordingResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	r.record(ctx)
+	return nil, "", nil
+}
+
+func (r RouteRecordingResourceHandler) UpdateResource(ctx RequestContext, id string,
+	data Payload, version string) (Resource, error) {
+	r.record(ctx)
+	return nil, nil
+}
+
+func (r RouteRecordingResourceHandler) UpdateResourceList(ctx RequestContext,
+	data []Payload, version string) ([]Resource, error) {
+	r.record(ctx)
+	return nil, nil
+}
+
+func (r RouteRecordingResourceHandler) DeleteResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	r.record(ctx)
+	return nil, nil
+}
+
+// Ensures that RouteName, ResourceName, and HandleMethod return the matched route
+// information for each CRUD route.
+func TestRouteInformation(t *testing.T) {
+	assert := assert.New(t)
+	routes := []string{}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(RouteRecordingResourceHandler{routes: &routes})
+
+	requests := []struct {
+		method   string
+		url      string
+		override string
+	}{
+		{"POST", "http://example.com/api/v1/widgets", ""},
+		{"GET", "http://example.com/api/v1/widgets/1", ""},
+		{"GET", "http://example.com/api/v1/widgets", ""},
+		{"PUT", "http://example.com/api/v1/widgets/1", ""},
+		{"PUT", "http://example.com/api/v1/widgets", ""},
+		{"DELETE", "http://example.com/api/v1/widgets/1", ""},
+		{"POST", "http://example.com/api/v1/widgets/1", "DELETE"},
+	}
+	for _, r := range requests {
+		req, err := http.NewRequest(r.method, r.url, nil)
+		require.NoError(t, err)
+		if r.override != "" {
+			req.Header.Set("X-HTTP-Method-Override", r.override)
+		}
+		api.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal([]string{
+		"widgets:create widgets create",
+		"widgets:read widgets read",
+		"widgets:readList widgets readList",
+		"widgets:update widgets update",
+		"widgets:updateList widgets updateList",
+		"widgets:delete widgets delete",
+		"widgets:deleteOverride widgets delete",
+	}, routes)
+}
+
+// Ensures that the route information is empty for requests which weren't routed.
+func TestRouteInformationNoRoute(t *testing.T) {
+	assert := assert.New(t)
+	req, err := http.NewRequest("GET", "http://example.com/foo", nil)
+	require.NoError(t, err)
+
+	ctx := NewContext(req, httptest.NewRecorder())
+
+	assert.Equal("", ctx.RouteName())
+	assert.Equal("", ctx.ResourceName())
+	assert.Equal(HandleMethod(""), ctx.HandleMethod())
+}
+
+// Ensures that ResourceIDs parses comma-separated and repeated ids.
+func TestResourceIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest("GET", "http://example.com/foo?ids=1,%202,,3&ids=4", nil)
+	assert.Equal([]string{"1", "2", "3", "4"},
+		NewContext(req, httptest.NewRecorder()).ResourceIDs())
+
+	req, _ = http.NewRequest("GET", "http://example.com/foo", nil)
+	assert.Equal([]string{}, NewContext(req, httptest.NewRecorder()).ResourceIDs())
+}
+
+// Ensures that QueryParam returns the first value of a query string parameter.
+func TestQueryParam(t *testing.T) {
+	assert := assert.New(t)
+	req, _ := http.NewRequest("GET", "http://example.com/foo?sort=name&sort=age&empty=", nil)
+	ctx := NewContext(req, httptest.NewRecorder())
+
+	value, ok := ctx.QueryParam("sort")
+	assert.True(ok)
+	assert.Equal("name", value)
+
+	value, ok = ctx.QueryParam("empty")
+	assert.True(ok)
+	assert.Equal("", value)
+
+	value, ok = ctx.QueryParam("missing")
+	assert.False(ok)
+	assert.Equal("", value)
+}
+
+// Ensures that QueryParamInt parses the value of a query string parameter, returning
+// an error if it's missing or not an integer.
+func TestQueryParamInt(t *testing.T) {
+	assert := assert.New(t)
+	req, _ := http.NewRequest("GET", "http://example.com/foo?page=3&size=big", nil)
+	ctx := NewContext(req, httptest.NewRecorder())
+
+	page, err := ctx.QueryParamInt("page")
+	assert.NoError(err)
+	assert.Equal(3, page)
+
+	_, err = ctx.QueryParamInt("size")
+	assert.EqualError(err, "Query parameter 'size' is not an integer: big")
+
+	_, err = ctx.QueryParamInt("missing")
+	assert.EqualError(err, "Missing query parameter 'missing'")
+}
+
+// Ensures that QueryParamSlice returns the repeated and comma-separated values of a
+// query string parameter.
+func TestQueryParamSlice(t *testing.T) {
+	assert := assert.New(t)
+	req, _ := http.NewRequest("GET", "http://example.com/foo?foo=a,b,c&bar=x&bar=y,%20z", nil)
+	ctx := NewContext(req, httptest.NewRecorder())
+
+	assert.Equal([]string{"a", "b", "c"}, ctx.QueryParamSlice("foo"))
+	assert.Equal([]string{"x", "y", "z"}, ctx.QueryParamSlice("bar"))
+	assert.Equal([]string{}, ctx.QueryParamSlice("missing"))
+}
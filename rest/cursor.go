@@ -0,0 +1,130 @@
+/*
+Copyright 2014 - 2015 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// cursorHeader is the header carrying encoded list cursors as an alternative to the
+// next query parameter.
+const cursorHeader = "X-Cursor"
+
+// maxDecodedCursorSize is the maximum size of a cursor decoded by the gzip
+// CursorCodec, which guards against cursors which decompress to huge sizes.
+const maxDecodedCursorSize = 64 << 10
+
+// CursorCodec encodes and decodes list cursors exchanged using the X-Cursor header,
+// e.g. to compress cursors which hold substantial state, such as filters, so they
+// don't bloat URLs and logs. See Configuration.CursorCodec.
+type CursorCodec interface {
+	// EncodeCursor returns the header value for the cursor.
+	EncodeCursor(string) (string, error)
+
+	// DecodeCursor returns the cursor for the header value or an error if it's
+	// invalid.
+	DecodeCursor(string) (string, error)
+}
+
+// gzipCursorCodec is a CursorCodec which gzip-compresses cursors and encodes them
+// using URL-safe base64.
+type gzipCursorCodec struct{}
+
+// NewGzipCursorCodec returns a CursorCodec which gzip-compresses cursors and encodes
+// them using unpadded, URL-safe base64.
+func NewGzipCursorCodec() CursorCodec {
+	return gzipCursorCodec{}
+}
+
+// EncodeCursor compresses the cursor and returns it base64 encoded.
+func (g gzipCursorCodec) EncodeCursor(cursor string) (string, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(cursor)); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeCursor decodes the base64 encoded value and returns the decompressed cursor.
+func (g gzipCursorCodec) DecodeCursor(value string) (string, error) {
+	compressed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	cursor, err := ioutil.ReadAll(io.LimitReader(reader, maxDecodedCursorSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(cursor) > maxDecodedCursorSize {
+		return "", fmt.Errorf("cursor exceeds %d bytes", maxDecodedCursorSize)
+	}
+	return string(cursor), nil
+}
+
+// decodeCursorHeader sets the cursor for the request from the X-Cursor header using
+// the configured CursorCodec. The next query parameter takes precedence. Since the
+// page returned depends on the header, the response varies on it. If the header
+// can't be decoded, an error is returned.
+func (h requestHandler) decodeCursorHeader(ctx RequestContext) (RequestContext, error) {
+	codec := h.Configuration().CursorCodec
+	if codec == nil {
+		return ctx, nil
+	}
+	addVary(ctx.ResponseWriter().Header(), cursorHeader)
+
+	value := ctx.Header().Get(cursorHeader)
+	if value == "" || ctx.Cursor() != "" {
+		return ctx, nil
+	}
+
+	cursor, err := codec.DecodeCursor(value)
+	if err != nil {
+		return ctx, fmt.Errorf("Invalid %s header: %s", cursorHeader, err)
+	}
+	return ctx.setCursor(cursor), nil
+}
+
+// encodeCursorHeader sets the X-Cursor response header to the cursor for the next
+// page of results encoded using the configured CursorCodec, if any.
+func (h requestHandler) encodeCursorHeader(ctx RequestContext, cursor string) {
+	codec := h.Configuration().CursorCodec
+	if codec == nil || cursor == "" {
+		return
+	}
+
+	value, err := codec.EncodeCursor(cursor)
+	if err != nil {
+		newRequestLogger(ctx).Printf("Unable to encode cursor: %s", err)
+		return
+	}
+	ctx.ResponseWriter().Header().Set(cursorHeader, value)
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2014 - 2015 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that the gzip CursorCodec round-trips cursors and rejects invalid values.
+func TestGzipCursorCodec(t *testing.T) {
+	assert := assert.New(t)
+	codec := NewGzipCursorCodec()
+	cursor := `{"after":"widget-100","filters":{"color":["red","blue"]}}`
+
+	encoded, err := codec.EncodeCursor(cursor)
+	assert.Nil(err)
+	assert.NotContains(encoded, "=")
+	decoded, err := codec.DecodeCursor(encoded)
+	assert.Nil(err)
+	assert.Equal(cursor, decoded)
+
+	_, err = codec.DecodeCursor("not a cursor")
+	assert.NotNil(err)
+
+	huge, _ := codec.EncodeCursor(strings.Repeat("a", maxDecodedCursorSize+1))
+	_, err = codec.DecodeCursor(huge)
+	assert.EqualError(err, "cursor exceeds 65536 bytes")
+}
+
+type CursorResourceHandler struct {
+	BaseResourceHandler
+}
+
+func (c CursorResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (c CursorResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	return []Resource{&TestResource{Foo: cursor}}, cursor + "1", nil
+}
+
+// Ensures that list cursors round-trip through the X-Cursor header when a
+// CursorCodec is configured.
+func TestCursorHeader(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{CursorCodec: NewGzipCursorCodec()})
+	api.RegisterResourceHandler(CursorResourceHandler{})
+	read := func(url, cursor string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		if cursor != "" {
+			req.Header.Set("X-Cursor", cursor)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	w := read("http://example.com/api/v1/widgets", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("X-Cursor, Accept", w.Header().Get("Vary"))
+	cursor := w.Header().Get("X-Cursor")
+	decoded, _ := NewGzipCursorCodec().DecodeCursor(cursor)
+	assert.Equal("1", decoded)
+
+	w = read("http://example.com/api/v1/widgets", cursor)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"results":[{"foo":"1"}]`)
+	decoded, _ = NewGzipCursorCodec().DecodeCursor(w.Header().Get("X-Cursor"))
+	assert.Equal("11", decoded)
+
+	// The next query parameter takes precedence.
+	w = read("http://example.com/api/v1/widgets?next=query", cursor)
+	assert.Contains(w.Body.String(), `"results":[{"foo":"query"}]`)
+
+	w = read("http://example.com/api/v1/widgets", "not a cursor")
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), "Invalid X-Cursor header")
+}
+
+// Ensures that the X-Cursor header is ignored without a CursorCodec.
+func TestCursorHeaderNoCodec(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(CursorResourceHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	req.Header.Set("X-Cursor", "abc")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("", w.Header().Get("X-Cursor"))
+	assert.Equal("Accept", w.Header().Get("Vary"))
+	assert.Contains(w.Body.String(), `"results":[{"foo":""}]`)
+}
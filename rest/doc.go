@@ -79,26 +79,27 @@ type docContextGenerator interface {
 	generate(ResourceHandler, string) (map[string]interface{}, error)
 }
 
-// docWriter writes rendered documentation to a persistent medium.
-type docWriter interface {
-	// mkdir creates a directory to store documentation in.
-	mkdir(string, os.FileMode) error
-
-	// write saves the rendered documentation.
-	write(string, []byte, os.FileMode) error
+// DocWriter writes rendered documentation to a persistent medium, e.g. the local file
+// system, memory, or object storage.
+type DocWriter interface {
+	// Mkdir creates a directory to store documentation in.
+	Mkdir(string, os.FileMode) error
+
+	// Write saves the rendered documentation.
+	Write(string, []byte, os.FileMode) error
 }
 
-// fsDocWriter is an implementation of the docWriter interface which writes documentation to
+// fsDocWriter is an implementation of the DocWriter interface which writes documentation to
 // the local file system.
 type fsDocWriter struct{}
 
-// mkdir creates a directory to store documentation in.
-func (f *fsDocWriter) mkdir(dir string, mode os.FileMode) error {
+// Mkdir creates a directory to store documentation in.
+func (f *fsDocWriter) Mkdir(dir string, mode os.FileMode) error {
 	return os.MkdirAll(dir, mode)
 }
 
-// write saves the rendered documentation.
-func (f *fsDocWriter) write(file string, data []byte, mode os.FileMode) error {
+// Write saves the rendered documentation.
+func (f *fsDocWriter) Write(file string, data []byte, mode os.FileMode) error {
 	return ioutil.WriteFile(file, data, mode)
 }
 
@@ -107,18 +108,31 @@ func (f *fsDocWriter) write(file string, data []byte, mode os.FileMode) error {
 type docGenerator struct {
 	templateParser
 	docContextGenerator
-	docWriter
+	DocWriter
 }
 
 // newDocGenerator creates a new docGenerator instance which relies on mustache templating.
-func newDocGenerator() *docGenerator {
+// The pluralize function derives collection names from resource names. Documentation is
+// written using the DocWriter, or to the local file system if it's nil.
+func newDocGenerator(pluralize func(string) string, writer DocWriter) *docGenerator {
+	if writer == nil {
+		writer = &fsDocWriter{}
+	}
 	return &docGenerator{
 		&mustacheParser{},
-		&defaultContextGenerator{},
-		&fsDocWriter{},
+		&defaultContextGenerator{pluralize},
+		writer,
 	}
 }
 
+// GenerateDocs creates the HTML documentation for the provided API and writes it to the
+// DocsDirectory specified by the API Configuration using the DocWriter. This allows
+// documentation to be generated somewhere other than the local file system, e.g. into
+// memory. Returns an error if generating the documentation failed, nil otherwise.
+func GenerateDocs(api API, writer DocWriter) error {
+	return newDocGenerator(api.Configuration().PluralName, writer).generateDocs(api)
+}
+
 // generateDocs creates the HTML documentation for the provided API. The resulting HTML files
 // will be placed in the directory specified by the API Configuration. Returns an error if
 // generating the documentation failed, nil otherwise.
@@ -128,19 +142,20 @@ func (d *docGenerator) generateDocs(api API) error {
 		dir = dir + "/"
 	}
 
-	if err := d.mkdir(dir, os.FileMode(0777)); err != nil {
+	if err := d.Mkdir(dir, os.FileMode(0777)); err != nil {
 		api.Configuration().Logger.Println(err)
 		return err
 	}
 
+	indexTpl, handlerTpl := docTemplates(api.Configuration())
 	handlers := api.ResourceHandlers()
 	docs := map[string][]handlerDoc{}
-	versions := versions(handlers)
+	documented := []string{}
 
-	for _, version := range versions {
+	for _, version := range versions(handlers) {
 		versionDocs := make([]handlerDoc, 0, len(handlers))
 		for _, handler := range handlers {
-			doc, err := d.generateHandlerDoc(handler, version, dir)
+			doc, err := d.generateHandlerDoc(handler, version, dir, handlerTpl)
 			if err != nil {
 				api.Configuration().Logger.Println(err)
 				return err
@@ -149,10 +164,15 @@ func (d *docGenerator) generateDocs(api API) error {
 			}
 		}
 
+		if len(versionDocs) == 0 {
+			// No handler has documented endpoints for this version.
+			continue
+		}
 		docs[version] = versionDocs
+		documented = append(documented, version)
 	}
 
-	if err := d.generateIndexDocs(docs, versions, dir); err != nil {
+	if err := d.generateIndexDocs(docs, documented, dir, indexTpl); err != nil {
 		api.Configuration().Logger.Println(err)
 		return err
 	}
@@ -161,11 +181,56 @@ func (d *docGenerator) generateDocs(api API) error {
 	return nil
 }
 
-// generateIndexDocs creates index files for each API version with documented endpoints.
+// validateDocs performs a dry run of documentation generation for the provided API. It
+// parses the templates and generates the context for each ResourceHandler and version
+// without writing any files. Returns the first error encountered, nil otherwise.
+func (d *docGenerator) validateDocs(api API) error {
+	indexTpl, handlerTpl := docTemplates(api.Configuration())
+	if _, err := d.parse(indexTpl); err != nil {
+		return err
+	}
+
+	tpl, err := d.parse(handlerTpl)
+	if err != nil {
+		return err
+	}
+
+	handlers := api.ResourceHandlers()
+	for _, version := range versions(handlers) {
+		for _, handler := range handlers {
+			context, err := d.generate(handler, version)
+			if err != nil {
+				return err
+			}
+			if context != nil {
+				tpl.render(context)
+			}
+		}
+	}
+
+	return nil
+}
+
+// docTemplates returns the index and handler templates to render documentation with,
+// which are the Configuration's IndexTemplate and HandlerTemplate or the built-in
+// templates if they aren't set.
+func docTemplates(config *Configuration) (string, string) {
+	index, handler := indexTemplate, handlerTemplate
+	if config.IndexTemplate != "" {
+		index = config.IndexTemplate
+	}
+	if config.HandlerTemplate != "" {
+		handler = config.HandlerTemplate
+	}
+	return index, handler
+}
+
+// generateIndexDocs creates index files for each API version with documented endpoints
+// using the provided index template.
 func (d *docGenerator) generateIndexDocs(docs map[string][]handlerDoc, versions []string,
-	dir string) error {
+	dir, template string) error {
 
-	tpl, err := d.parse(indexTemplate)
+	tpl, err := d.parse(template)
 	if err != nil {
 		return err
 	}
@@ -176,7 +241,7 @@ func (d *docGenerator) generateIndexDocs(docs map[string][]handlerDoc, versions
 			"version":  version,
 			"versions": versions,
 		})
-		if err := d.write(fmt.Sprintf("%sindex_v%s.html", dir, version),
+		if err := d.Write(fmt.Sprintf("%sindex_v%s.html", dir, version),
 			[]byte(rendered), 0644); err != nil {
 			return err
 		}
@@ -185,12 +250,13 @@ func (d *docGenerator) generateIndexDocs(docs map[string][]handlerDoc, versions
 	return nil
 }
 
-// generateHandlerDoc creates a documentation file for the versioned ResourceHandler.
-// Returns nil if the handler contains no documented endpoints or has no output fields.
+// generateHandlerDoc creates a documentation file for the versioned ResourceHandler
+// using the provided handler template. Returns nil if the handler contains no
+// documented endpoints or has no output fields.
 func (d *docGenerator) generateHandlerDoc(handler ResourceHandler, version,
-	dir string) (handlerDoc, error) {
+	dir, template string) (handlerDoc, error) {
 
-	tpl, err := d.parse(handlerTemplate)
+	tpl, err := d.parse(template)
 	if err != nil {
 		return nil, err
 	}
@@ -203,7 +269,7 @@ func (d *docGenerator) generateHandlerDoc(handler ResourceHandler, version,
 
 	name := handlerTypeName(handler)
 	file := fileName(name, version)
-	if err := d.write(fmt.Sprintf("%s%s", dir, file), []byte(rendered), 0644); err != nil {
+	if err := d.Write(fmt.Sprintf("%s%s", dir, file), []byte(rendered), 0644); err != nil {
 		return nil, err
 	}
 
@@ -212,7 +278,11 @@ func (d *docGenerator) generateHandlerDoc(handler ResourceHandler, version,
 }
 
 // defaultContextGenerator is an implementation of the docContextGenerator interface.
-type defaultContextGenerator struct{}
+type defaultContextGenerator struct {
+	// pluralize derives collection names from resource names. If nil, "s" is
+	// appended to the resource name.
+	pluralize func(string) string
+}
 
 // generate creates a template context for the provided ResourceHandler.
 func (d *defaultContextGenerator) generate(handler ResourceHandler, version string) (
@@ -226,6 +296,10 @@ func (d *defaultContextGenerator) generate(handler ResourceHandler, version stri
 		return nil, nil
 	}
 
+	if err := validateExamples(handler.Rules(), version); err != nil {
+		return nil, err
+	}
+
 	index := 0
 	endpoints := []endpoint{}
 	if handler.CreateDocumentation() != "" {
@@ -304,6 +378,22 @@ func (d *defaultContextGenerator) generate(handler ResourceHandler, version stri
 	}
 	index++
 
+	if updater, ok := handler.(PartialUpdater); ok && updater.PatchDocumentation() != "" {
+		endpoints = append(endpoints, endpoint{
+			"uri":             formatURI(updater.PatchURI(), version),
+			"method":          "PATCH",
+			"label":           "warning",
+			"description":     updater.PatchDocumentation(),
+			"hasInput":        true,
+			"inputFields":     inputFields,
+			"outputFields":    outputFields,
+			"exampleRequest":  buildExampleRequest(handler.Rules(), false, version),
+			"exampleResponse": buildExampleResponse(handler.Rules(), false, version),
+			"index":           index,
+		})
+	}
+	index++
+
 	if handler.DeleteDocumentation() != "" {
 		endpoints = append(endpoints, endpoint{
 			"uri":             formatURI(handler.DeleteURI(), version),
@@ -326,6 +416,7 @@ func (d *defaultContextGenerator) generate(handler ResourceHandler, version stri
 	name := handlerTypeName(handler)
 	context := map[string]interface{}{
 		"resource":       name,
+		"collection":     d.collectionName(handler.ResourceName()),
 		"version":        version,
 		"versions":       handlerVersions(handler),
 		"endpoints":      endpoints,
@@ -335,6 +426,14 @@ func (d *defaultContextGenerator) generate(handler ResourceHandler, version stri
 	return context, nil
 }
 
+// collectionName returns the collection name for the resource name.
+func (d *defaultContextGenerator) collectionName(name string) string {
+	if d.pluralize == nil {
+		return defaultPluralize(name)
+	}
+	return d.pluralize(name)
+}
+
 // formatURI returns the specified URI replacing templated variable names with their
 // human-readable documentation equivalent. It also replaces the version regex with
 // the actual version string.
@@ -374,6 +473,11 @@ func getInputFields(rules Rules) []field {
 			"type":        ruleTypeName(rule, Inbound),
 			"description": rule.DocString,
 		}
+		addConstraints(field, rule)
+		addDeprecation(field, rule)
+		if rule.WriteOnce {
+			field["writeOnce"] = true
+		}
 
 		fields = append(fields, field)
 	}
@@ -381,6 +485,62 @@ func getInputFields(rules Rules) []field {
 	return fields
 }
 
+// addConstraints adds the validation constraints specified by the Rule to the field
+// description. Each constraint is added under its own key, i.e. "pattern", "min",
+// "max", "enum", "minItems", and "maxItems", and they are summarized under
+// "constraints" for display. Keys are only added for constraints the Rule specifies.
+func addConstraints(f field, rule *Rule) {
+	constraints := []string{}
+
+	if rule.Pattern != "" {
+		f["pattern"] = rule.Pattern
+		constraints = append(constraints, fmt.Sprintf("pattern: %s", rule.Pattern))
+	}
+	if rule.Min != nil {
+		f["min"] = *rule.Min
+		constraints = append(constraints, fmt.Sprintf("min: %v", *rule.Min))
+	}
+	if rule.Max != nil {
+		f["max"] = *rule.Max
+		constraints = append(constraints, fmt.Sprintf("max: %v", *rule.Max))
+	}
+	if len(rule.AllowedValues) > 0 {
+		f["enum"] = rule.AllowedValues
+		constraints = append(constraints,
+			fmt.Sprintf("one of: %s", joinValues(rule.AllowedValues)))
+	}
+	if rule.MinItems > 0 {
+		f["minItems"] = rule.MinItems
+		constraints = append(constraints, fmt.Sprintf("min items: %d", rule.MinItems))
+	}
+	if rule.MaxItems > 0 {
+		f["maxItems"] = rule.MaxItems
+		constraints = append(constraints, fmt.Sprintf("max items: %d", rule.MaxItems))
+	}
+
+	if len(constraints) > 0 {
+		f["constraints"] = strings.Join(constraints, ", ")
+	}
+}
+
+// addAllowedValues adds the Rule's AllowedValues to the output field description under
+// the "enum" key, and summarized under "allowedValues" for display, if it has any.
+// Input fields list them with the other constraints.
+func addAllowedValues(f field, rule *Rule) {
+	if len(rule.AllowedValues) > 0 {
+		f["enum"] = rule.AllowedValues
+		f["allowedValues"] = fmt.Sprintf("one of: %s", joinValues(rule.AllowedValues))
+	}
+}
+
+// addDeprecation flags the field description as deprecated under the "deprecated"
+// key, holding the deprecation warning, if the Rule is deprecated.
+func addDeprecation(f field, rule *Rule) {
+	if rule.Deprecated {
+		f["deprecated"] = rule.deprecationWarning()
+	}
+}
+
 // getInputFields returns output field descriptions.
 func getOutputFields(rules Rules) []field {
 	rules = rules.Filter(Outbound)
@@ -392,6 +552,11 @@ func getOutputFields(rules Rules) []field {
 			"type":        ruleTypeName(rule, Outbound),
 			"description": rule.DocString,
 		}
+		addAllowedValues(field, rule)
+		addDeprecation(field, rule)
+		if rule.OutputOnly || rule.isVirtualRule() {
+			field["readOnly"] = true
+		}
 
 		fields = append(fields, field)
 	}
@@ -482,12 +647,28 @@ func buildExamplePayload(rules Rules, filter Filter, list bool, version string)
 	return string(serialized)
 }
 
-// getExampleValue returns an example value for the provided Rule.
+// validateExamples returns an error if the example value for any of the Rules
+// applicable to the version cannot be serialized.
+func validateExamples(rules Rules, version string) error {
+	for _, r := range rules.ForVersion(version).Contents() {
+		if _, err := json.Marshal(getExampleValue(r, version)); err != nil {
+			return fmt.Errorf("Invalid documentation example for field '%s': %v",
+				r.Name(), err)
+		}
+	}
+	return nil
+}
+
+// getExampleValue returns an example value for the provided Rule. The DocExample is
+// preferred, followed by the first of the Rule's AllowedValues.
 func getExampleValue(r *Rule, version string) interface{} {
 	value := r.DocExample
 	if value != nil {
 		return value
 	}
+	if len(r.AllowedValues) > 0 {
+		return r.AllowedValues[0]
+	}
 
 	switch r.Type {
 	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64:
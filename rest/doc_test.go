@@ -19,9 +19,11 @@ package rest
 import (
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/hoisie/mustache"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -45,12 +47,12 @@ type mockDocWriter struct {
 	mock.Mock
 }
 
-func (m *mockDocWriter) mkdir(dir string, mode os.FileMode) error {
+func (m *mockDocWriter) Mkdir(dir string, mode os.FileMode) error {
 	args := m.Mock.Called(dir, mode)
 	return args.Error(0)
 }
 
-func (m *mockDocWriter) write(file string, data []byte, mode os.FileMode) error {
+func (m *mockDocWriter) Write(file string, data []byte, mode os.FileMode) error {
 	args := m.Mock.Called(file, data, mode)
 	return args.Error(0)
 }
@@ -284,7 +286,7 @@ func TestGenerateDocsHandlesBadTemplate(t *testing.T) {
 	mockDocWriter := new(mockDocWriter)
 	mockParser.On("parse", indexTemplate).Return(nil, fmt.Errorf("error"))
 	mockParser.On("parse", handlerTemplate).Return(nil, fmt.Errorf("error"))
-	mockDocWriter.On("mkdir", "_docs/", os.FileMode(0777)).Return(nil)
+	mockDocWriter.On("Mkdir", "_docs/", os.FileMode(0777)).Return(nil)
 	docGenerator := &docGenerator{mockParser, mockContextGenerator, mockDocWriter}
 
 	assert.NotNil(docGenerator.generateDocs(api), "Return value should not be nil")
@@ -297,7 +299,7 @@ func TestGenerateDocsHandlesDirectoryFail(t *testing.T) {
 	mockParser := new(mockTemplateParser)
 	mockContextGenerator := new(mockContextGenerator)
 	mockDocWriter := new(mockDocWriter)
-	mockDocWriter.On("mkdir", "_docs/", os.FileMode(0777)).Return(fmt.Errorf("error"))
+	mockDocWriter.On("Mkdir", "_docs/", os.FileMode(0777)).Return(fmt.Errorf("error"))
 	docGenerator := &docGenerator{mockParser, mockContextGenerator, mockDocWriter}
 
 	assert.NotNil(docGenerator.generateDocs(api), "Return value should not be nil")
@@ -352,11 +354,11 @@ func TestGenerateDocsWriteFail(t *testing.T) {
 	mockIndexTemplate.On("render", indexV1Context).Return(indexV1Rendered).Once()
 	mockIndexTemplate.On("render", indexV2Context).Return(indexV2Rendered).Once()
 
-	mockDocWriter.On("mkdir", "_docs/", os.FileMode(0777)).Return(nil)
-	mockDocWriter.On("write", "_docs/fooresource_v1.html", []byte(fooV1Rendered), os.FileMode(0644)).Return(nil)
-	mockDocWriter.On("write", "_docs/barresource_v1.html", []byte(barV1Rendered), os.FileMode(0644)).Return(nil)
-	mockDocWriter.On("write", "_docs/barresource_v2.html", []byte(barV2Rendered), os.FileMode(0644)).Return(nil)
-	mockDocWriter.On("write", "_docs/index_v1.html", []byte(indexV1Rendered), os.FileMode(0644)).Return(fmt.Errorf("error"))
+	mockDocWriter.On("Mkdir", "_docs/", os.FileMode(0777)).Return(nil)
+	mockDocWriter.On("Write", "_docs/fooresource_v1.html", []byte(fooV1Rendered), os.FileMode(0644)).Return(nil)
+	mockDocWriter.On("Write", "_docs/barresource_v1.html", []byte(barV1Rendered), os.FileMode(0644)).Return(nil)
+	mockDocWriter.On("Write", "_docs/barresource_v2.html", []byte(barV2Rendered), os.FileMode(0644)).Return(nil)
+	mockDocWriter.On("Write", "_docs/index_v1.html", []byte(indexV1Rendered), os.FileMode(0644)).Return(fmt.Errorf("error"))
 
 	docGenerator := &docGenerator{mockParser, mockContextGenerator, mockDocWriter}
 
@@ -382,8 +384,8 @@ func TestGenerateDocsGenerateFail(t *testing.T) {
 	fooV1Rendered := "foov1"
 	mockHandlerTemplate.On("render", fooV1Context).Return(fooV1Rendered).Once()
 
-	mockDocWriter.On("mkdir", "_docs/", os.FileMode(0777)).Return(nil)
-	mockDocWriter.On("write", "_docs/fooresource_v1.html", []byte(fooV1Rendered), os.FileMode(0644)).Return(nil)
+	mockDocWriter.On("Mkdir", "_docs/", os.FileMode(0777)).Return(nil)
+	mockDocWriter.On("Write", "_docs/fooresource_v1.html", []byte(fooV1Rendered), os.FileMode(0644)).Return(nil)
 
 	docGenerator := &docGenerator{mockParser, mockContextGenerator, mockDocWriter}
 
@@ -439,18 +441,72 @@ func TestGenerateDocsHappyPath(t *testing.T) {
 	mockIndexTemplate.On("render", indexV1Context).Return(indexV1Rendered).Once()
 	mockIndexTemplate.On("render", indexV2Context).Return(indexV2Rendered).Once()
 
-	mockDocWriter.On("mkdir", "_docs/", os.FileMode(0777)).Return(nil)
-	mockDocWriter.On("write", "_docs/fooresource_v1.html", []byte(fooV1Rendered), os.FileMode(0644)).Return(nil)
-	mockDocWriter.On("write", "_docs/barresource_v1.html", []byte(barV1Rendered), os.FileMode(0644)).Return(nil)
-	mockDocWriter.On("write", "_docs/barresource_v2.html", []byte(barV2Rendered), os.FileMode(0644)).Return(nil)
-	mockDocWriter.On("write", "_docs/index_v1.html", []byte(indexV1Rendered), os.FileMode(0644)).Return(nil)
-	mockDocWriter.On("write", "_docs/index_v2.html", []byte(indexV2Rendered), os.FileMode(0644)).Return(nil)
+	mockDocWriter.On("Mkdir", "_docs/", os.FileMode(0777)).Return(nil)
+	mockDocWriter.On("Write", "_docs/fooresource_v1.html", []byte(fooV1Rendered), os.FileMode(0644)).Return(nil)
+	mockDocWriter.On("Write", "_docs/barresource_v1.html", []byte(barV1Rendered), os.FileMode(0644)).Return(nil)
+	mockDocWriter.On("Write", "_docs/barresource_v2.html", []byte(barV2Rendered), os.FileMode(0644)).Return(nil)
+	mockDocWriter.On("Write", "_docs/index_v1.html", []byte(indexV1Rendered), os.FileMode(0644)).Return(nil)
+	mockDocWriter.On("Write", "_docs/index_v2.html", []byte(indexV2Rendered), os.FileMode(0644)).Return(nil)
 
 	docGenerator := &docGenerator{mockParser, mockContextGenerator, mockDocWriter}
 
 	assert.Nil(docGenerator.generateDocs(api), "Return value should be nil")
 }
 
+type badExampleHandler struct {
+	fooHandler
+}
+
+func (b *badExampleHandler) Rules() Rules {
+	return NewRules((*fooResource)(nil),
+		&Rule{
+			Field:      "Foo",
+			FieldAlias: "foo",
+			Type:       String,
+			Versions:   []string{"1"},
+			DocExample: func() {},
+		},
+	)
+}
+
+// Ensures that validateDocs returns nil and writes nothing when documentation can be
+// generated.
+func TestValidateDocsHappyPath(t *testing.T) {
+	assert := assert.New(t)
+	api := setupAPI()
+	mockDocWriter := new(mockDocWriter)
+	docGenerator := &docGenerator{&mustacheParser{}, &defaultContextGenerator{}, mockDocWriter}
+
+	assert.Nil(docGenerator.validateDocs(api))
+	mockDocWriter.AssertNotCalled(t, "Mkdir", "_docs/", os.FileMode(0777))
+	assert.Nil(api.ValidateDocs())
+}
+
+// Ensures that validateDocs returns an error when template parsing fails.
+func TestValidateDocsHandlesBadTemplate(t *testing.T) {
+	assert := assert.New(t)
+	api := setupAPI()
+	mockParser := new(mockTemplateParser)
+	mockParser.On("parse", indexTemplate).Return(nil, fmt.Errorf("error"))
+	docGenerator := &docGenerator{mockParser, new(mockContextGenerator), new(mockDocWriter)}
+
+	assert.Equal(fmt.Errorf("error"), docGenerator.validateDocs(api))
+}
+
+// Ensures that ValidateDocs returns an error when a handler's documentation context
+// cannot be generated.
+func TestValidateDocsBadExample(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(NewConfiguration())
+	api.RegisterResourceHandler(&badExampleHandler{})
+
+	err := api.ValidateDocs()
+
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "Invalid documentation example for field 'foo'")
+	}
+}
+
 // Ensures that generate returns nil context and nil error when there are no fields for a
 // version.
 func TestGenerateNoOutput(t *testing.T) {
@@ -486,6 +542,7 @@ func TestGenerateHappyPath(t *testing.T) {
 		assert.Equal("1", context["version"])
 		assert.Equal([]string{"1"}, context["versions"])
 		assert.Equal("fooresource", context["fileNamePrefix"])
+		assert.Equal("foos", context["collection"])
 		endpoints := []endpoint{
 			endpoint{
 				"description":     "Creates a new foo",
@@ -740,3 +797,270 @@ func TestGenerateHappyPath(t *testing.T) {
 	}
 	assert.Nil(err, "Error should be nil")
 }
+
+// Ensures that getInputFields includes the constraints specified by Rules.
+func TestGetInputFieldsConstraints(t *testing.T) {
+	assert := assert.New(t)
+	min := 1.0
+	max := 10.5
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", Pattern: "^[a-z]+$", Min: &min, Max: &max},
+		&Rule{FieldAlias: "bar", Type: Int, Min: &min},
+		&Rule{FieldAlias: "baz", Type: String, DocString: "baz"},
+		&Rule{FieldAlias: "status", Type: String, AllowedValues: []interface{}{"a", "b"}},
+	)
+
+	assert.Equal([]field{
+		{
+			"name":        "foo",
+			"required":    "optional",
+			"type":        "interface{}",
+			"description": "",
+			"pattern":     "^[a-z]+$",
+			"min":         1.0,
+			"max":         10.5,
+			"constraints": "pattern: ^[a-z]+$, min: 1, max: 10.5",
+		},
+		{
+			"name":        "bar",
+			"required":    "optional",
+			"type":        "int",
+			"description": "",
+			"min":         1.0,
+			"constraints": "min: 1",
+		},
+		{
+			"name":        "baz",
+			"required":    "optional",
+			"type":        "string",
+			"description": "baz",
+		},
+		{
+			"name":        "status",
+			"required":    "optional",
+			"type":        "string",
+			"description": "",
+			"enum":        []interface{}{"a", "b"},
+			"constraints": "one of: a, b",
+		},
+	}, getInputFields(rules))
+}
+
+// Ensures that the handler template renders field constraints.
+func TestHandlerTemplateConstraints(t *testing.T) {
+	rendered := mustache.Render(handlerTemplate, map[string]interface{}{
+		"endpoints": []map[string]interface{}{{
+			"hasInput": true,
+			"inputFields": []field{
+				{"name": "foo", "constraints": "pattern: ^[a-z]+$"},
+				{"name": "bar"},
+			},
+		}},
+	})
+
+	assert.Contains(t, rendered, "pattern: ^[a-z]+$")
+	assert.Equal(t, 1, strings.Count(rendered, "pattern:"))
+}
+
+// Ensures that field descriptions document a Rule's AllowedValues and that its first
+// allowed value is used as its example.
+func TestFieldsAllowedValues(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "status", Type: String,
+			AllowedValues: []interface{}{"active", "archived"}},
+		&Rule{Field: "Foo", FieldAlias: "kind", Type: String,
+			AllowedValues: []interface{}{"a", "b"}, DocExample: "b"},
+	)
+
+	inputFields := getInputFields(rules)
+	outputFields := getOutputFields(rules)
+
+	assert.Equal([]interface{}{"active", "archived"}, inputFields[0]["enum"])
+	assert.Equal("one of: active, archived", inputFields[0]["constraints"])
+	assert.Equal([]interface{}{"active", "archived"}, outputFields[0]["enum"])
+	assert.Equal("one of: active, archived", outputFields[0]["allowedValues"])
+
+	assert.Equal("active", getExampleValue(rules.Contents()[0], "1"))
+	assert.Equal("b", getExampleValue(rules.Contents()[1], "1"))
+
+	rendered := mustache.Render(handlerTemplate, map[string]interface{}{
+		"endpoints": []map[string]interface{}{{
+			"hasInput":     true,
+			"inputFields":  inputFields,
+			"outputFields": outputFields,
+		}},
+	})
+
+	assert.Equal(2, strings.Count(rendered, "one of: active, archived"))
+}
+
+// Ensures that generate derives the collection name using the pluralize function.
+func TestGenerateCollectionName(t *testing.T) {
+	assert := assert.New(t)
+	config := &Configuration{IrregularPlurals: map[string]string{"foo": "feet"}}
+	generator := &defaultContextGenerator{config.PluralName}
+
+	context, err := generator.generate(&resourceHandlerProxy{&fooHandler{}}, "1")
+
+	assert.Nil(err)
+	if assert.NotNil(context, "Context should not be nil") {
+		assert.Equal("feet", context["collection"])
+	}
+}
+
+// Ensures that field descriptions flag deprecated Rules and the handler template
+// renders them.
+func TestFieldsDeprecated(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", Deprecated: true},
+		&Rule{Field: "Foo", FieldAlias: "bar", Deprecated: true, DeprecatedMessage: "Use 'baz'"},
+		&Rule{Field: "Foo", FieldAlias: "baz"},
+	)
+
+	inputFields := getInputFields(rules)
+	outputFields := getOutputFields(rules)
+
+	assert.Equal("Field 'foo' is deprecated", inputFields[0]["deprecated"])
+	assert.Equal("Use 'baz'", outputFields[1]["deprecated"])
+	assert.NotContains(outputFields[2], "deprecated")
+
+	rendered := mustache.Render(handlerTemplate, map[string]interface{}{
+		"endpoints": []map[string]interface{}{{
+			"hasInput":     true,
+			"inputFields":  inputFields,
+			"outputFields": outputFields,
+		}},
+	})
+
+	assert.Equal(2, strings.Count(rendered, "Deprecated: Field &#39;foo&#39; is deprecated"))
+	assert.Equal(2, strings.Count(rendered, "Deprecated: Use &#39;baz&#39;"))
+}
+
+// Ensures that field descriptions flag write-once and read-only Rules and the handler
+// template renders them.
+func TestFieldsWriteOnceReadOnly(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", WriteOnce: true},
+		&Rule{Field: "Foo", FieldAlias: "bar", OutputOnly: true},
+	)
+
+	inputFields := getInputFields(rules)
+	outputFields := getOutputFields(rules)
+
+	assert.Len(inputFields, 1)
+	assert.Equal(true, inputFields[0]["writeOnce"])
+	assert.NotContains(outputFields[0], "readOnly")
+	assert.Equal(true, outputFields[1]["readOnly"])
+
+	rendered := mustache.Render(handlerTemplate, map[string]interface{}{
+		"endpoints": []map[string]interface{}{{
+			"hasInput":     true,
+			"inputFields":  inputFields,
+			"outputFields": outputFields,
+		}},
+	})
+
+	assert.Equal(1, strings.Count(rendered, "Write-once: can only be set on create"))
+	assert.Equal(1, strings.Count(rendered, "Read-only"))
+}
+
+type memoryDocWriter struct {
+	dirs  []string
+	files map[string][]byte
+}
+
+func (m *memoryDocWriter) Mkdir(dir string, mode os.FileMode) error {
+	m.dirs = append(m.dirs, dir)
+	return nil
+}
+
+func (m *memoryDocWriter) Write(file string, data []byte, mode os.FileMode) error {
+	m.files[file] = data
+	return nil
+}
+
+// Ensures that GenerateDocs writes the documentation using the provided DocWriter.
+func TestGenerateDocsWriter(t *testing.T) {
+	assert := assert.New(t)
+	api := setupAPI()
+	writer := &memoryDocWriter{files: map[string][]byte{}}
+
+	assert.Nil(GenerateDocs(api, writer))
+
+	assert.Equal([]string{"_docs/"}, writer.dirs)
+	assert.Contains(writer.files, "_docs/index_v1.html")
+	assert.Contains(writer.files, "_docs/index_v2.html")
+	assert.Contains(writer.files, "_docs/fooresource_v1.html")
+	assert.Contains(string(writer.files["_docs/fooresource_v1.html"]), "Creates a new foo")
+}
+
+type undocumentedHandler struct {
+	BaseResourceHandler
+}
+
+func (u *undocumentedHandler) ResourceName() string {
+	return "undocumented"
+}
+
+func (u *undocumentedHandler) Rules() Rules {
+	return NewRules((*fooResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", Type: String, Versions: []string{"3"}},
+	)
+}
+
+// Ensures that generateDocs doesn't write index pages for versions without any
+// documented endpoints or link to them.
+func TestGenerateDocsSkipsUndocumentedVersions(t *testing.T) {
+	assert := assert.New(t)
+	config := NewConfiguration()
+	config.IndexTemplate = `{{#versions}}v{{.}} {{/versions}}`
+	api := NewAPI(config)
+	api.RegisterResourceHandler(&fooHandler{})
+	api.RegisterResourceHandler(&undocumentedHandler{})
+	writer := &memoryDocWriter{files: map[string][]byte{}}
+
+	assert.Nil(GenerateDocs(api, writer))
+
+	assert.Equal("v1 ", string(writer.files["_docs/index_v1.html"]))
+	assert.NotContains(writer.files, "_docs/index_v3.html")
+	assert.NotContains(writer.files, "_docs/undocumentedhandler_v3.html")
+}
+
+// Ensures that newDocGenerator writes to the local file system by default.
+func TestNewDocGeneratorDefaultWriter(t *testing.T) {
+	assert.IsType(t, &fsDocWriter{}, newDocGenerator(defaultPluralize, nil).DocWriter)
+}
+
+// Ensures that generateDocs renders the Configuration's custom templates.
+func TestGenerateDocsCustomTemplates(t *testing.T) {
+	assert := assert.New(t)
+	config := NewConfiguration()
+	config.IndexTemplate = `<h1>Acme v{{version}}</h1>{{#handlers}}<a href="{{file}}">{{name}}</a>{{/handlers}}`
+	config.HandlerTemplate = `<h1>Acme {{resource}}</h1>`
+	api := NewAPI(config)
+	api.RegisterResourceHandler(&fooHandler{})
+	writer := &memoryDocWriter{files: map[string][]byte{}}
+
+	assert.Nil(GenerateDocs(api, writer))
+
+	assert.Equal(`<h1>Acme v1</h1><a href="fooresource_v1.html">fooResource</a>`,
+		string(writer.files["_docs/index_v1.html"]))
+	assert.Equal(`<h1>Acme fooResource</h1>`, string(writer.files["_docs/fooresource_v1.html"]))
+}
+
+// Ensures that generateDocs returns an error when a custom template is malformed.
+func TestGenerateDocsMalformedCustomTemplate(t *testing.T) {
+	assert := assert.New(t)
+	config := NewConfiguration()
+	config.HandlerTemplate = `{{#endpoints}}`
+	api := NewAPI(config)
+	api.RegisterResourceHandler(&fooHandler{})
+	writer := &memoryDocWriter{files: map[string][]byte{}}
+
+	assert.NotNil(GenerateDocs(api, writer))
+	assert.Empty(writer.files)
+	assert.NotNil(api.ValidateDocs())
+}
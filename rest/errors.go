@@ -16,65 +16,164 @@ limitations under the License.
 
 package rest
 
-import "net/http"
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // statusUnprocessableEntity indicates the request was well-formed but was
 // unable to be followed due to semantic errors.
 const statusUnprocessableEntity = 422
 
+// StatusClientClosedRequest indicates the client closed the connection before the
+// response was sent. It's a nonstandard status popularized by nginx.
+const StatusClientClosedRequest = 499
+
 // Error is an implementation of the error interface representing an HTTP error.
 type Error struct {
-	reason string
-	status int
+	reason     string
+	status     int
+	retryAfter time.Duration
+	cause      error
 }
 
 // Error returns the Error message.
 func (r Error) Error() string { return r.reason }
 
+// Unwrap returns the error the Error was created from, if any, e.g. the FieldError
+// for an invalid request field.
+func (r Error) Unwrap() error { return r.cause }
+
 // Status returns the HTTP status code.
 func (r Error) Status() int { return r.status }
 
+// RetryAfter returns how long the client should wait before retrying the request, or
+// zero if it isn't specified.
+func (r Error) RetryAfter() time.Duration { return r.retryAfter }
+
 // ResourceNotFound returns a Error for a 404 Not Found error.
 func ResourceNotFound(reason string) Error {
-	return Error{reason, http.StatusNotFound}
+	return Error{reason: reason, status: http.StatusNotFound}
 }
 
 // ResourceNotPermitted returns a Error for a 403 Forbidden error.
 func ResourceNotPermitted(reason string) Error {
-	return Error{reason, http.StatusForbidden}
+	return Error{reason: reason, status: http.StatusForbidden}
 }
 
 // ResourceConflict returns a Error for a 409 Conflict error.
 func ResourceConflict(reason string) Error {
-	return Error{reason, http.StatusConflict}
+	return Error{reason: reason, status: http.StatusConflict}
 }
 
 // BadRequest returns a Error for a 400 Bad Request error.
 func BadRequest(reason string) Error {
-	return Error{reason, http.StatusBadRequest}
+	return Error{reason: reason, status: http.StatusBadRequest}
 }
 
 // UnprocessableRequest returns a Error for a 422 Unprocessable Entity error.
 func UnprocessableRequest(reason string) Error {
-	return Error{reason, statusUnprocessableEntity}
+	return Error{reason: reason, status: statusUnprocessableEntity}
 }
 
 // UnauthorizedRequest returns a Error for a 401 Unauthorized error.
 func UnauthorizedRequest(reason string) Error {
-	return Error{reason, http.StatusUnauthorized}
+	return Error{reason: reason, status: http.StatusUnauthorized}
 }
 
 // MethodNotAllowed returns a Error for a 405 Method Not Allowed error.
 func MethodNotAllowed(reason string) Error {
-	return Error{reason, http.StatusMethodNotAllowed}
+	return Error{reason: reason, status: http.StatusMethodNotAllowed}
 }
 
 // InternalServerError returns a Error for a 500 Internal Server error.
 func InternalServerError(reason string) Error {
-	return Error{reason, http.StatusInternalServerError}
+	return Error{reason: reason, status: http.StatusInternalServerError}
+}
+
+// TooManyRequests returns an Error for a 429 Too Many Requests error, e.g. when a
+// downstream service is rate limiting requests. If retryAfter is positive, the
+// response has a Retry-After header telling the client how long to wait before
+// retrying the request.
+func TooManyRequests(reason string, retryAfter time.Duration) Error {
+	return Error{reason: reason, status: http.StatusTooManyRequests, retryAfter: retryAfter}
+}
+
+// retryAfterSeconds returns the value of a Retry-After header for the duration, which
+// is the number of seconds rounded up.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
 }
 
 // CustomError returns an Error for the given HTTP status code.
 func CustomError(reason string, status int) Error {
-	return Error{reason, status}
+	return Error{reason: reason, status: status}
+}
+
+// Codes identifying the type of validation failure described by a FieldError. They
+// are stable, unlike messages, so clients can rely on them, e.g. to localize errors.
+const (
+	// CodeFieldRequired indicates that a required field is missing.
+	CodeFieldRequired = "field_required"
+
+	// CodeTypeMismatch indicates that a value can't be coerced to the Rule's Type.
+	CodeTypeMismatch = "type_mismatch"
+
+	// CodeTooFewItems and CodeTooManyItems indicate that a slice has fewer elements
+	// than the Rule's MinItems or more than its MaxItems.
+	CodeTooFewItems  = "too_few_items"
+	CodeTooManyItems = "too_many_items"
+
+	// CodePatternMismatch indicates that a string value doesn't match the Rule's
+	// Pattern.
+	CodePatternMismatch = "pattern_mismatch"
+
+	// CodeOutOfRange indicates that a numeric value is less than the Rule's Min or
+	// greater than its Max.
+	CodeOutOfRange = "out_of_range"
+
+	// CodeValueNotAllowed indicates that a value isn't one of the Rule's
+	// AllowedValues.
+	CodeValueNotAllowed = "value_not_allowed"
+
+	// CodeInvalidJSON indicates that a string value for a Rule with ParseJSONString
+	// set isn't valid JSON.
+	CodeInvalidJSON = "invalid_json"
+
+	// CodeWriteOnce indicates that an update sends a field whose Rule is WriteOnce.
+	CodeWriteOnce = "write_once"
+
+	// CodeInvalidValue indicates any other invalid value, such as a nested value
+	// which doesn't satisfy its nested Rules.
+	CodeInvalidValue = "invalid_value"
+)
+
+// FieldError describes why the value provided for a single request field is
+// invalid. Code identifies the type of failure, while Message describes it in a
+// human-readable form. Args holds the values the Message refers to besides the
+// field, e.g. the "min" a value is less than, for use by a MessageTranslator.
+type FieldError struct {
+	Field   string                 `json:"field"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Args    map[string]interface{} `json:"-"`
+}
+
+// Error returns the FieldError message.
+func (f *FieldError) Error() string { return f.Message }
+
+// ValidationErrors is an error describing every invalid field in a request. When a
+// ValidationErrors is set as the error for a request, the response is a 422
+// Unprocessable Entity which lists each FieldError under the "errors" key.
+type ValidationErrors []*FieldError
+
+// Error returns the messages of each FieldError separated by semicolons.
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, err := range v {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
 }
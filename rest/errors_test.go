@@ -19,6 +19,7 @@ package rest
 import (
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -62,4 +63,32 @@ func TestErrors(t *testing.T) {
 	err = InternalServerError("foo")
 	assert.Equal("foo", err.Error())
 	assert.Equal(http.StatusInternalServerError, err.Status())
+
+	err = TooManyRequests("foo", 30*time.Second)
+	assert.Equal("foo", err.Error())
+	assert.Equal(http.StatusTooManyRequests, err.Status())
+	assert.Equal(30*time.Second, err.RetryAfter())
+	assert.Zero(BadRequest("foo").RetryAfter())
+}
+
+// Ensures that retryAfterSeconds rounds durations up to whole seconds.
+func TestRetryAfterSeconds(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("0", retryAfterSeconds(0))
+	assert.Equal("1", retryAfterSeconds(time.Millisecond))
+	assert.Equal("30", retryAfterSeconds(30*time.Second))
+	assert.Equal("31", retryAfterSeconds(30*time.Second+time.Nanosecond))
+}
+
+// Ensures that ValidationErrors joins the messages of each FieldError.
+func TestValidationErrors(t *testing.T) {
+	assert := assert.New(t)
+	err := ValidationErrors{
+		{Field: "foo", Message: "Invalid foo"},
+		{Field: "bar", Message: "Invalid bar"},
+	}
+
+	assert.Equal("Invalid foo; Invalid bar", err.Error())
+	assert.Equal("Invalid foo", err[0].Error())
 }
@@ -0,0 +1,151 @@
+/*
+Copyright 2014 - 2015 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// eventStreamContentType is the MIME type of server-sent event streams.
+const eventStreamContentType = "text/event-stream"
+
+// Event is a server-sent event describing a change to a Resource.
+type Event struct {
+	// ID of the event, sent so clients can resume the stream. Optional.
+	ID string
+
+	// Name of the event type, e.g. "updated". Optional.
+	Name string
+
+	// Resource sent as the event's data. Outbound Rules are applied to it.
+	Resource Resource
+}
+
+// EventStream is implemented by ResourceHandlers which stream live updates to their
+// resources as server-sent events. Requests to the read list URI which accept
+// text/event-stream are routed to Events, and each Event received from the returned
+// channel is written to the client and flushed. The stream ends when the channel is
+// closed or the client disconnects, which is signaled by the Done channel of the
+// request's context (see RequestContext.Request). Implementations must stop sending
+// Events once it's closed.
+type EventStream interface {
+	// Events returns the channel of Events to stream for the request or an error if
+	// the stream can't be started, in which case an error response is sent.
+	Events(RequestContext, string) (<-chan Event, error)
+}
+
+// acceptsEventStream matches requests which accept server-sent event streams.
+func acceptsEventStream(r *http.Request, rm *mux.RouteMatch) bool {
+	for _, accept := range r.Header["Accept"] {
+		for _, mediaType := range strings.Split(accept, ",") {
+			if i := strings.Index(mediaType, ";"); i >= 0 {
+				mediaType = mediaType[:i]
+			}
+			if strings.EqualFold(strings.TrimSpace(mediaType), eventStreamContentType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleEvents returns a Handler which writes the Events streamed by the EventStream
+// as server-sent events, applying outbound Rules to each Event's Resource.
+func (h requestHandler) handleEvents(handler resourceHandlerProxy, stream EventStream) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := h.newContext(r, w)
+		version := ctx.Version()
+		rules := handler.Rules()
+
+		events, err := stream.Events(ctx, version)
+		if err != nil {
+			// The client accepts an event stream, which errors can't be sent as, so
+			// send the error in the requested format rather than negotiating one.
+			ctx = ctx.WithValue(formatKey, ctx.ResponseFormat())
+			ctx = ctx.setError(err)
+			h.sendResponse(ctx, handler)
+			return
+		}
+
+		header := w.Header()
+		header.Set("Content-Type", eventStreamContentType)
+		header.Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		logger := newRequestLogger(ctx)
+		for {
+			select {
+			case <-r.Context().Done():
+				// The client disconnected.
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+
+				resource := h.applyOutboundRules(
+					ctx, event.Resource, h.outboundRules(event.Resource, rules), version)
+				data, err := formatEvent(event, resource)
+				if err != nil {
+					logger.Printf("Event serialization failed: %s", err)
+					continue
+				}
+				if _, err := w.Write(data); err != nil {
+					return
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+	})
+}
+
+// formatEvent returns the Event in the server-sent event format with the serialized
+// Resource as its data.
+func formatEvent(event Event, resource Resource) ([]byte, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if event.ID != "" {
+		fmt.Fprintf(&buf, "id: %s\n", singleLine(event.ID))
+	}
+	if event.Name != "" {
+		fmt.Fprintf(&buf, "event: %s\n", singleLine(event.Name))
+	}
+	// Serialized JSON never contains raw newlines, so the data fits on one line.
+	fmt.Fprintf(&buf, "data: %s\n\n", data)
+	return buf.Bytes(), nil
+}
+
+// singleLine strips line breaks, which would end an event field early.
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2014 - 2015 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type EventStreamResourceHandler struct {
+	BaseResourceHandler
+	events func(RequestContext) (<-chan Event, error)
+}
+
+func (e EventStreamResourceHandler) ResourceName() string {
+	return "widgets"
+}
+
+func (e EventStreamResourceHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil), &Rule{Field: "Foo", FieldAlias: "name"})
+}
+
+func (e EventStreamResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	return []Resource{&TestResource{Foo: "a"}}, "", nil
+}
+
+func (e EventStreamResourceHandler) Events(ctx RequestContext,
+	version string) (<-chan Event, error) {
+	return e.events(ctx)
+}
+
+// Ensures that Events are written in the server-sent event format with outbound Rules
+// applied to their Resources.
+func TestEventStream(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(EventStreamResourceHandler{
+		events: func(ctx RequestContext) (<-chan Event, error) {
+			events := make(chan Event, 2)
+			events <- Event{ID: "1", Name: "created", Resource: &TestResource{Foo: "a"}}
+			events <- Event{Resource: &TestResource{Foo: "b\nc"}}
+			close(events)
+			return events, nil
+		},
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal("no-cache", w.Header().Get("Cache-Control"))
+	assert.True(w.Flushed)
+	assert.Equal("id: 1\nevent: created\ndata: {\"name\":\"a\"}\n\n"+
+		"data: {\"name\":\"b\\nc\"}\n\n", w.Body.String())
+
+	// Requests which don't accept event streams are read lists.
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"messages":[],"reason":"OK","results":[{"name":"a"}],"status":200}`,
+		w.Body.String())
+}
+
+// Ensures that an error starting the stream is sent as an error response.
+func TestEventStreamError(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(EventStreamResourceHandler{
+		events: func(ctx RequestContext) (<-chan Event, error) {
+			return nil, ResourceNotPermitted("No streaming for you")
+		},
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusForbidden, w.Code)
+	assert.Equal(`{"messages":["No streaming for you"],"reason":"Forbidden","status":403}`,
+		w.Body.String())
+}
+
+// Ensures that the stream ends when the client disconnects.
+func TestEventStreamDisconnect(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	reqCtx, cancel := context.WithCancel(context.Background())
+	api.RegisterResourceHandler(EventStreamResourceHandler{
+		events: func(ctx RequestContext) (<-chan Event, error) {
+			events := make(chan Event)
+			go func() {
+				events <- Event{Resource: &TestResource{Foo: "a"}}
+				cancel()
+			}()
+			return events, nil
+		},
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+	req = req.WithContext(reqCtx)
+	req.Header.Set("Accept", "text/plain, text/event-stream; q=0.9")
+	w := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		api.ServeHTTP(w, req)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Event stream did not end after the client disconnected")
+	}
+	assert.Equal("data: {\"name\":\"a\"}\n\n", w.Body.String())
+}
@@ -17,10 +17,18 @@ limitations under the License.
 package rest
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -29,7 +37,10 @@ import (
 type Resource interface{}
 
 // ResourceHandler specifies the endpoint handlers for working with a resource. This
-// consists of the business logic for performing CRUD operations.
+// consists of the business logic for performing CRUD operations. ResourceHandlers may
+// opt into additional behavior by implementing the optional interfaces below, e.g.
+// Namespacer or CacheController. BaseResourceHandler implements all of them with
+// their default behavior.
 type ResourceHandler interface {
 	// ResourceName is used to identify what resource a handler corresponds to and is
 	// used in the endpoint URLs, i.e. /api/:version/resourceName. This should be
@@ -100,7 +111,9 @@ type ResourceHandler interface {
 	// UpdateResource is the logic that corresponds to updating an existing resource at
 	// PUT /api/:version/resourceName/{id}. Typically, this would make some sort of
 	// database update call. It returns the updated resource or an error if the update
-	// failed.
+	// failed. If the Payload sent by the client contains write-once fields,
+	// ReadResource is called first to compare their values, and its error is sent
+	// back if it fails.
 	UpdateResource(RequestContext, string, Payload, string) (Resource, error)
 
 	// DeleteResource is the logic that corresponds to deleting an existing resource at
@@ -126,6 +139,321 @@ type ResourceHandler interface {
 	Rules() Rules
 }
 
+// Namespacer is implemented by ResourceHandlers whose default endpoint URLs are
+// prefixed with a namespace, i.e. /api/:version/namespace/resourceName, allowing
+// resources to be grouped without hardcoding the prefix in each URI. Handler-specified
+// URIs are not affected. Without it, no namespace is used.
+type Namespacer interface {
+	// Namespace returns the path prepended to the ResourceName in the default
+	// endpoint URLs.
+	Namespace() string
+}
+
+// PartialUpdater is implemented by ResourceHandlers which support partially updating
+// resources. Without it, PATCH requests are rejected with a 405.
+type PartialUpdater interface {
+	// PatchURI returns the URI for partially updating a specific resource.
+	PatchURI() string
+
+	// PatchDocumentation returns a string describing the handler's patch endpoint.
+	PatchDocumentation() string
+
+	// PartialUpdateResource is the logic that corresponds to partially updating an
+	// existing resource at PATCH /api/:version/resourceName/{id}. Only the fields
+	// present in the Payload should be changed, so Required Rules are not enforced.
+	// It returns the updated resource or an error if the update failed. Like
+	// UpdateResource, it's preceded by a ReadResource call if the Payload contains
+	// write-once fields.
+	PartialUpdateResource(RequestContext, string, Payload, string) (Resource, error)
+}
+
+// ResourcesReader is implemented by ResourceHandlers which read a set of resources by
+// their IDs at GET /api/:version/resourceName?ids=1,2,3 more efficiently than one at a
+// time. Without it, ReadResource is called for each ID.
+type ResourcesReader interface {
+	// ReadResources returns the resources in the order of the IDs or an error if
+	// they couldn't be read.
+	ReadResources(RequestContext, []string, string) ([]Resource, error)
+}
+
+// OffsetReader is implemented by ResourceHandlers which read pages of resources by
+// offset, for clients paginating with ?page or ?offset, e.g. for jump-to-page UIs.
+// List reads without a cursor use it instead of ReadResourceList, and their next URL
+// requests the following page. Without it, lists are paginated by cursor.
+type OffsetReader interface {
+	// ReadResourceListOffset returns the page of resources with the given limit
+	// starting at the given offset, or an error if they couldn't be read. There is a
+	// next page if the page is full.
+	ReadResourceListOffset(RequestContext, int, int, string) ([]Resource, error)
+}
+
+// ResourceCounter is implemented by ResourceHandlers which count the resources read
+// by ReadResourceList across all pages. The count is included in the response to list
+// reads requested with ?include=count under "total". Without it, no total is included.
+type ResourceCounter interface {
+	// CountResources returns the number of resources across all pages.
+	CountResources(RequestContext, string) (int, error)
+}
+
+// AuthExempter is implemented by ResourceHandlers which skip Authenticate for some
+// HandleMethods, e.g. HandleRead and HandleReadList for a resource with public reads
+// and authenticated writes. Requests using X-HTTP-Method-Override are exempt if the
+// method they override is. Without it, every request is authenticated.
+type AuthExempter interface {
+	// AuthExemptMethods returns the HandleMethods which skip Authenticate.
+	AuthExemptMethods() []HandleMethod
+}
+
+// ResourceIdentifier is implemented by ResourceHandlers which identify their
+// resources, allowing create responses to send a Location header pointing at the
+// newly created resource's read endpoint. Without it, no Location header is sent.
+type ResourceIdentifier interface {
+	// ResourceID returns the id of the given resource as it appears in the read URI,
+	// or an empty string if it has none.
+	ResourceID(Resource) string
+}
+
+// CacheController is implemented by ResourceHandlers which allow successful read
+// responses to be cached. Responses to create, update, and delete requests are always
+// sent with "no-store". Without it, no Cache-Control header is sent with reads.
+type CacheController interface {
+	// CacheControl returns the Cache-Control policy sent with successful read
+	// responses, e.g. "max-age=60, public".
+	CacheControl() string
+}
+
+// ListLimiter is implemented by ResourceHandlers which override the Configuration's
+// DefaultLimit and MaxLimit for their list reads.
+type ListLimiter interface {
+	// DefaultLimit returns the number of results fetched by list reads which don't
+	// specify a limit, or 0 to use Configuration.DefaultLimit.
+	DefaultLimit() int
+
+	// MaxLimit returns the maximum number of results fetched by list reads, to which
+	// larger requested limits are reduced, or 0 to use Configuration.MaxLimit.
+	MaxLimit() int
+}
+
+// FormatForcer is implemented by ResourceHandlers which choose the response format
+// of some requests regardless of the format requested by the client.
+type FormatForcer interface {
+	// ForceFormat returns the response format to use for the request, e.g. "json",
+	// or an empty string to respect the requested format.
+	ForceFormat(RequestContext) string
+}
+
+// BeforeHandler is implemented by ResourceHandlers which run logic before each
+// request is handled, after middleware, e.g. to resolve a tenant or load a parent
+// resource.
+type BeforeHandler interface {
+	// BeforeHandle is invoked before the request is passed to the ResourceHandler
+	// method for the given HandleMethod. The same RequestContext is passed to the
+	// method, so messages added to it are sent with the response. Returning an error
+	// short-circuits the request, and the error is sent back with the response, using
+	// its status if it's an Error.
+	BeforeHandle(RequestContext, HandleMethod) error
+}
+
+// AfterHandler is implemented by ResourceHandlers which run logic after each request
+// is handled, e.g. to add messages or headers to every response.
+type AfterHandler interface {
+	// AfterHandle is invoked after the ResourceHandler method for the given
+	// HandleMethod has set the request's result or error, just before the response
+	// is sent.
+	AfterHandle(RequestContext, HandleMethod)
+}
+
+// timeout returns a RequestMiddleware which runs the rest of the handler chain under
+// a timer, like http.TimeoutHandler, canceling the request's context once the
+// Configuration's RequestTimeout elapses. The handler's response is buffered, and if
+// it hasn't returned in time, the timeout error is sent instead and anything it
+// writes afterward is discarded. Event streams are exempt.
+func (h requestHandler) timeout(handler resourceHandlerProxy) RequestMiddleware {
+	exempt := []HandleMethod{HandleEvents}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isExemptMethod(r, exempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), h.Configuration().RequestTimeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			// Assign the request id up front so the timeout response carries the one
+			// the handler logs with.
+			header := h.requestIDHeader()
+			if r.Header.Get(header) == "" {
+				r.Header = r.Header.Clone()
+				r.Header.Set(header, newRequestID())
+			}
+
+			tw := &timeoutWriter{header: w.Header().Clone()}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next.ServeHTTP(tw, r)
+				close(done)
+			}()
+
+			select {
+			case p := <-panicked:
+				// Panic on the request's goroutine so RecoveryMiddleware handles it.
+				panic(p)
+			case <-done:
+				tw.flushTo(w)
+			case <-ctx.Done():
+				tw.timeOut()
+				// The handler may still be reading the body, so don't read it again.
+				timedOut := r.WithContext(ctx)
+				timedOut.Body = http.NoBody
+				h.writeResponse(h.newContext(timedOut, w), handler)
+			}
+		})
+	}
+}
+
+// timeoutWriter buffers the response written by a handler run under a timer, which is
+// discarded if the handler times out.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+// Header returns the response headers.
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// Write buffers the data, returning http.ErrHandlerTimeout if the handler timed out.
+func (tw *timeoutWriter) Write(data []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.buf.Write(data)
+}
+
+// WriteHeader records the response status if one hasn't been written.
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
+
+// timeOut marks the handler as timed out, discarding anything it writes.
+func (tw *timeoutWriter) timeOut() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timedOut = true
+}
+
+// isTimedOut returns true if the handler timed out.
+func (tw *timeoutWriter) isTimedOut() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.timedOut
+}
+
+// flushTo writes the buffered response to the ResponseWriter.
+func (tw *timeoutWriter) flushTo(w http.ResponseWriter) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	dst := w.Header()
+	for key, values := range tw.header {
+		dst[key] = values
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	w.WriteHeader(tw.status)
+	w.Write(tw.buf.Bytes())
+}
+
+// newContext returns a RequestContext for the request. The RequestContext built by
+// beforeHandle is reused if the request carries one, so values set on it before the
+// request is handled reach the ResourceHandler and the body is only read once. If a
+// BaseContext is configured, its values are reachable from the RequestContext.
+func (h requestHandler) newContext(r *http.Request, w http.ResponseWriter) RequestContext {
+	if ctx, ok := r.Context().Value(requestContextKey).(RequestContext); ok {
+		return ctx
+	}
+	if baseContext := h.Configuration().BaseContext; baseContext != nil {
+		r = r.WithContext(withBaseContext(r.Context(), baseContext()))
+	}
+	header := h.requestIDHeader()
+	id := r.Header.Get(header)
+	if id == "" {
+		id = newRequestID()
+	}
+	w.Header().Set(header, id)
+	ctx := NewContextWithRouter(r, w, h.router).WithValue(requestIDKey, id)
+	if resolver := h.Configuration().FlagResolver; resolver != nil {
+		ctx.(*requestContext).flags = newFlagCache(resolver)
+	}
+	if translator := h.Configuration().MessageTranslator; translator != nil {
+		ctx = ctx.WithValue(translatorKey, translator)
+	}
+	if logger := h.Configuration().Logger; logger != nil {
+		ctx = ctx.WithValue(loggerKey, logger)
+	}
+	ctx = ctx.WithValue(typeSerializersKey, typeSerializerLookup(h.typeSerializer))
+	return ctx
+}
+
+// beforeHandle returns a RequestMiddleware which builds the request's RequestContext
+// and invokes the ResourceHandler's BeforeHandle with it, sending an error response if
+// it returns an error. Otherwise the RequestContext is attached to the request so the
+// handler reuses it.
+func (h requestHandler) beforeHandle(handler resourceHandlerProxy) RequestMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := h.newContext(r, w)
+			if err := handler.BeforeHandle(ctx, ctx.HandleMethod()); err != nil {
+				ctx = ctx.setError(err)
+				h.sendResponse(ctx, handler)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(
+				context.WithValue(r.Context(), requestContextKey, ctx)))
+		})
+	}
+}
+
+// newRequestID returns a random id for a request which doesn't provide one.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// defaultRequestIDHeader is the header carrying the id correlating a request with its
+// log lines if the Configuration doesn't specify one.
+const defaultRequestIDHeader = "X-Request-ID"
+
+// noStore is the Cache-Control policy for responses which must not be cached.
+const noStore = "no-store"
+
+// links is the resource key holding links to related resources.
+const links = "links"
+
 // requestHandler constructs http.HandlerFuncs responsible for handling HTTP requests.
 type requestHandler struct {
 	API
@@ -135,28 +463,40 @@ type requestHandler struct {
 // handleCreate returns a HandlerFunc which will deserialize the request payload, pass
 // it to the provided create function, and then serialize and dispatch the response.
 // The serialization mechanism used is specified by the "format" query parameter.
-func (h requestHandler) handleCreate(handler ResourceHandler) http.Handler {
+func (h requestHandler) handleCreate(handler resourceHandlerProxy) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := NewContextWithRouter(r, w, h.router)
+		ctx := h.newContext(r, w)
 		version := ctx.Version()
 		rules := handler.Rules()
 
-		data, err := decodePayload(ctx.Body().Bytes())
+		data, err := h.decodeRequestPayload(ctx)
 		if err != nil {
 			// Payload decoding failed.
 			ctx = ctx.setError(BadRequest(err.Error()))
 		} else {
-			data, err := applyInboundRules(data, rules, version)
+			data, err := h.applyInboundRules(ctx, data, rules, version)
 			if err != nil {
 				// Type coercion failed.
-				ctx = ctx.setError(UnprocessableRequest(err.Error()))
+				ctx = ctx.setError(validationError(err))
 			} else {
 				resource, err := handler.CreateResource(ctx, data, ctx.Version())
+				location := ""
 				if err == nil {
-					resource = applyOutboundRules(resource, rules, version)
+					h.auditWrite(ctx, handler, data, rules, version)
+					location = h.resourceLocation(ctx, handler, resource)
+					resource = h.applyOutboundRules(ctx, resource, rules, version)
 				}
 
-				if resource != nil {
+				if location != "" {
+					ctx.ResponseWriter().Header().Set("Location", location)
+				}
+
+				if accepted, ok := resource.(*AcceptedResource); ok && err == nil {
+					ctx = setAccepted(ctx, accepted)
+				} else if location != "" && h.Configuration().CreateReturnsLocationOnly {
+					ctx = ctx.setStatus(http.StatusCreated)
+					ctx = ctx.WithValue(omitBodyKey, true)
+				} else if resource != nil {
 					ctx = ctx.setResult(resource)
 					ctx = ctx.setStatus(http.StatusCreated)
 				} else {
@@ -169,57 +509,203 @@ func (h requestHandler) handleCreate(handler ResourceHandler) http.Handler {
 			}
 		}
 
-		h.sendResponse(ctx)
+		setCacheControl(ctx, noStore)
+		h.sendResponse(ctx, handler)
 	})
 }
 
 // handleReadList returns a Handler which will pass the request context to the
 // provided read function and then serialize and dispatch the response. The
 // serialization mechanism used is specified by the "format" query parameter.
-func (h requestHandler) handleReadList(handler ResourceHandler) http.Handler {
+func (h requestHandler) handleReadList(handler resourceHandlerProxy) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := NewContextWithRouter(r, w, h.router)
+		ctx := h.newContext(r, w).WithValue(defaultLimitKey, h.defaultLimit(handler)).
+			WithValue(maxLimitKey, h.maxLimit(handler))
 		version := ctx.Version()
 		rules := handler.Rules()
 
-		resources, cursor, err := handler.ReadResourceList(
-			ctx, ctx.Limit(), ctx.Cursor(), version)
+		ctx, err := h.decodeCursorHeader(ctx)
+		if err != nil {
+			ctx = ctx.setError(BadRequest(err.Error()))
+			h.sendResponse(ctx, handler)
+			return
+		}
+
+		var (
+			resources []Resource
+			cursor    string
+		)
+		if reader, ok := handler.ResourceHandler.(OffsetReader); ok && ctx.Cursor() == "" {
+			// The next URL requests the following page by offset.
+			ctx = ctx.WithValue(offsetPaginationKey, true)
+			resources, err = reader.ReadResourceListOffset(
+				ctx, ctx.Limit(), ctx.Offset(), version)
+		} else {
+			resources, cursor, err = handler.ReadResourceList(
+				ctx, ctx.Limit(), ctx.Cursor(), version)
+		}
 
 		if err == nil {
 			// Apply rules to results.
 			for idx, resource := range resources {
-				resources[idx] = applyOutboundRules(resource, rules, version)
+				resources[idx] = h.applyOutboundRules(
+					ctx, resource, h.outboundRules(resource, rules), version)
+			}
+		}
+
+		if err == nil {
+			h.encodeCursorHeader(ctx, cursor)
+		}
+		if err == nil && includes(ctx, includeCount) {
+			var total int
+			total, err = handler.CountResources(ctx, version)
+			if err == errCountResourcesNotImplemented {
+				err = nil
+			} else if err == nil {
+				ctx = ctx.WithValue(totalKey, total)
 			}
 		}
 
 		ctx = ctx.setResult(resources)
 		ctx = ctx.setCursor(cursor)
 		ctx = ctx.setError(err)
-		ctx = ctx.setStatus(http.StatusOK)
+		if _, nextErr := ctx.NextURL(); err == nil && nextErr == nil && len(resources) > 0 &&
+			isPartial(ctx) {
+			ctx.ResponseWriter().Header().Set("Content-Range", contentRange(ctx, len(resources)))
+			ctx = ctx.setStatus(http.StatusPartialContent)
+		} else {
+			ctx = ctx.setStatus(http.StatusOK)
+		}
+		if err == nil {
+			setPaginationLinks(ctx)
+		}
 
-		h.sendResponse(ctx)
+		setCacheControl(ctx, handler.CacheControl())
+		h.sendResponse(ctx, handler)
 	})
 }
 
+// contentRange returns the Content-Range of a partial list read response holding the
+// given number of results. Ranges start at the offset of the page when paginating by
+// offset and are relative to the page otherwise, since cursors don't have a position.
+// The total is included if it was counted.
+func contentRange(ctx RequestContext, count int) string {
+	start := 0
+	if byOffset, _ := ctx.Value(offsetPaginationKey).(bool); byOffset {
+		start = ctx.Offset()
+	}
+	size := "*"
+	if total, ok := ctx.Value(totalKey).(int); ok {
+		size = strconv.Itoa(total)
+	}
+	return fmt.Sprintf("items %d-%d/%s", start, start+count-1, size)
+}
+
+// setPaginationLinks sets a Link header on list read responses pointing to the next
+// page of results, if there is one, along with the previous page when paginating by
+// offset and the first page when the response isn't the only page.
+func setPaginationLinks(ctx RequestContext) {
+	r, ok := ctx.Request()
+	if !ok {
+		return
+	}
+
+	links := []string{}
+	next, _ := ctx.NextURL()
+	if next != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, next))
+	}
+	byOffset, _ := ctx.Value(offsetPaginationKey).(bool)
+	if offset := ctx.Offset(); byOffset && offset > 0 {
+		prev := map[string]string{offsetKey: ""}
+		if pagingByPage(ctx) {
+			prev = map[string]string{pageKey: ""}
+			if page := ctx.Page(); page > 2 {
+				prev[pageKey] = strconv.Itoa(page - 1)
+			}
+		} else if offset > ctx.Limit() {
+			prev[offsetKey] = strconv.Itoa(offset - ctx.Limit())
+		}
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(r, prev)))
+	}
+	if _, hasCursor := ctx.QueryParam(cursorKey); next != "" || hasCursor || ctx.Offset() > 0 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="first"`,
+			pageURL(r, map[string]string{cursorKey: "", offsetKey: "", pageKey: ""})))
+	}
+
+	if len(links) > 0 {
+		ctx.ResponseWriter().Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// includes returns whether the given value was requested using the "include" query
+// parameter, e.g. ?include=count.
+func includes(ctx RequestContext, value string) bool {
+	r, ok := ctx.Request()
+	if !ok {
+		return false
+	}
+	for _, include := range r.URL.Query()[includeKey] {
+		for _, v := range strings.Split(include, ",") {
+			if strings.TrimSpace(v) == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // handleRead returns a Handler which will pass the resource id to the provided
 // read function and then serialize and dispatch the response. The serialization
 // mechanism used is specified by the "format" query parameter.
-func (h requestHandler) handleRead(handler ResourceHandler) http.Handler {
+func (h requestHandler) handleRead(handler resourceHandlerProxy) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := NewContextWithRouter(r, w, h.router)
+		ctx := h.newContext(r, w)
 		version := ctx.Version()
 		rules := handler.Rules()
 
 		resource, err := handler.ReadResource(ctx, ctx.ResourceID(), version)
+		if err == nil && isNil(resource) && h.Configuration().NilResultIsNotFound {
+			err = ResourceNotFound(fmt.Sprintf("%s %s not found",
+				handler.ResourceName(), ctx.ResourceID()))
+		}
 		if err == nil {
-			resource = applyOutboundRules(resource, rules, version)
+			resource = h.applyOutboundRules(ctx, resource, rules, version)
 		}
 
 		ctx = ctx.setResult(resource)
 		ctx = ctx.setError(err)
 		ctx = ctx.setStatus(http.StatusOK)
 
-		h.sendResponse(ctx)
+		setCacheControl(ctx, handler.CacheControl())
+		h.sendResponse(ctx, handler)
+	})
+}
+
+// handleReadResources returns a Handler which will pass the resource ids to the
+// provided read function and then serialize and dispatch the response. The
+// serialization mechanism used is specified by the "format" query parameter.
+func (h requestHandler) handleReadResources(handler resourceHandlerProxy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := h.newContext(r, w)
+		version := ctx.Version()
+		rules := handler.Rules()
+
+		resources, err := handler.ReadResources(ctx, ctx.ResourceIDs(), version)
+		if err == nil {
+			// Apply rules to results.
+			for idx, resource := range resources {
+				resources[idx] = h.applyOutboundRules(
+					ctx, resource, h.outboundRules(resource, rules), version)
+			}
+		}
+
+		ctx = ctx.setResult(resources)
+		ctx = ctx.setError(err)
+		ctx = ctx.setStatus(http.StatusOK)
+
+		setCacheControl(ctx, handler.CacheControl())
+		h.sendResponse(ctx, handler)
 	})
 }
 
@@ -227,38 +713,47 @@ func (h requestHandler) handleRead(handler ResourceHandler) http.Handler {
 // pass it to the provided update function, and then serialize and dispatch the
 // response. The serialization mechanism used is specified by the "format" query
 // parameter.
-func (h requestHandler) handleUpdateList(handler ResourceHandler) http.Handler {
+func (h requestHandler) handleUpdateList(handler resourceHandlerProxy) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := NewContextWithRouter(r, w, h.router)
+		ctx := h.newContext(r, w)
 		version := ctx.Version()
 		rules := handler.Rules()
 
-		payloadStr := ctx.Body().Bytes()
 		var data []Payload
-		var err error
-		data, err = decodePayloadSlice(payloadStr)
-		if err != nil {
-			var p Payload
-			p, err = decodePayload(payloadStr)
-			data = []Payload{p}
+		payloadStr, err := h.requestBody(ctx)
+		if err == nil {
+			data, err = decodePayloadSlice(payloadStr)
+			if err != nil {
+				var p Payload
+				p, err = decodePayload(payloadStr)
+				data = []Payload{p}
+			}
+		}
+		if err == nil && len(data) == 0 && h.Configuration().RejectEmptyBatch {
+			err = fmt.Errorf("Payload must contain at least one resource")
+		}
+		if err == nil {
+			err = h.checkPayload(payloadStr)
 		}
 
 		if err != nil {
 			// Payload decoding failed.
 			ctx = ctx.setError(BadRequest(err.Error()))
 		} else {
-			for i := range data {
-				data[i], err = applyInboundRules(data[i], rules, version)
-			}
+			err = h.applyBatchInboundRules(ctx, data, rules, version)
 			if err != nil {
 				// Type coercion failed.
-				ctx = ctx.setError(UnprocessableRequest(err.Error()))
+				ctx = ctx.setError(validationError(err))
 			} else {
 				resources, err := handler.UpdateResourceList(ctx, data, version)
 				if err == nil {
+					for _, payload := range data {
+						h.auditWrite(ctx, handler, payload, rules, version)
+					}
 					// Apply rules to results.
 					for idx, resource := range resources {
-						resources[idx] = applyOutboundRules(resource, rules, version)
+						resources[idx] = h.applyOutboundRules(
+							ctx, resource, h.outboundRules(resource, rules), version)
 					}
 				}
 
@@ -268,7 +763,8 @@ func (h requestHandler) handleUpdateList(handler ResourceHandler) http.Handler {
 			}
 		}
 
-		h.sendResponse(ctx)
+		setCacheControl(ctx, noStore)
+		h.sendResponse(ctx, handler)
 	})
 }
 
@@ -276,94 +772,834 @@ func (h requestHandler) handleUpdateList(handler ResourceHandler) http.Handler {
 // pass it to the provided update function, and then serialize and dispatch the
 // response. The serialization mechanism used is specified by the "format" query
 // parameter.
-func (h requestHandler) handleUpdate(handler ResourceHandler) http.Handler {
+func (h requestHandler) handleUpdate(handler resourceHandlerProxy) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := NewContextWithRouter(r, w, h.router)
+		ctx := h.newContext(r, w)
 		version := ctx.Version()
 		rules := handler.Rules()
 
-		data, err := decodePayload(ctx.Body().Bytes())
+		data, err := h.decodeRequestPayload(ctx)
 		if err != nil {
 			// Payload decoding failed.
 			ctx = ctx.setError(BadRequest(err.Error()))
+		} else if data, err = h.withoutUnchangedWriteOnce(
+			ctx, handler, data, rules, version); err != nil {
+			// Reading the stored resource failed.
+			ctx = ctx.setError(err)
 		} else {
-			data, err := applyInboundRules(data, rules, version)
+			data, err := h.applyInboundRules(ctx, data, rules, version)
 			if err != nil {
 				// Type coercion failed.
-				ctx = ctx.setError(UnprocessableRequest(err.Error()))
+				ctx = ctx.setError(validationError(err))
 			} else {
 				resource, err := handler.UpdateResource(
 					ctx, ctx.ResourceID(), data, version)
 				if err == nil {
-					resource = applyOutboundRules(resource, rules, version)
+					h.auditWrite(ctx, handler, data, rules, version)
+					resource = h.applyOutboundRules(ctx, resource, rules, version)
 				}
 
-				ctx = ctx.setResult(resource)
-				ctx = ctx.setError(err)
-				ctx = ctx.setStatus(http.StatusOK)
+				if accepted, ok := resource.(*AcceptedResource); ok && err == nil {
+					ctx = setAccepted(ctx, accepted)
+				} else {
+					ctx = ctx.setResult(resource)
+					ctx = ctx.setError(err)
+					ctx = ctx.setStatus(http.StatusOK)
+				}
+			}
+		}
+
+		setCacheControl(ctx, noStore)
+		h.sendResponse(ctx, handler)
+	})
+}
+
+// handlePatch returns a Handler which will deserialize the request payload, pass
+// it to the provided partial update function, and then serialize and dispatch the
+// response. Unlike handleUpdate, required fields are not enforced since the payload
+// only contains the fields being changed. The serialization mechanism used is
+// specified by the "format" query parameter.
+func (h requestHandler) handlePatch(handler resourceHandlerProxy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := h.newContext(r, w)
+		version := ctx.Version()
+		rules := handler.Rules()
+
+		data, err := h.decodeRequestPayload(ctx)
+		if err != nil {
+			// Payload decoding failed.
+			ctx = ctx.setError(BadRequest(err.Error()))
+		} else if data, err = h.withoutUnchangedWriteOnce(
+			ctx, handler, data, rules, version); err != nil {
+			// Reading the stored resource failed.
+			ctx = ctx.setError(err)
+		} else {
+			data, err := h.applyPartialInboundRules(ctx, data, rules, version)
+			if err != nil {
+				// Type coercion failed.
+				ctx = ctx.setError(validationError(err))
+			} else {
+				resource, err := handler.PartialUpdateResource(
+					ctx, ctx.ResourceID(), data, version)
+				if err == nil {
+					h.auditWrite(ctx, handler, data, rules, version)
+					resource = h.applyOutboundRules(ctx, resource, rules, version)
+				}
+
+				if accepted, ok := resource.(*AcceptedResource); ok && err == nil {
+					ctx = setAccepted(ctx, accepted)
+				} else {
+					ctx = ctx.setResult(resource)
+					ctx = ctx.setError(err)
+					ctx = ctx.setStatus(http.StatusOK)
+				}
 			}
 		}
 
-		h.sendResponse(ctx)
+		setCacheControl(ctx, noStore)
+		h.sendResponse(ctx, handler)
 	})
 }
 
 // handleDelete returns a Handler which will pass the resource id to the provided
 // delete function and then serialize and dispatch the response. The serialization
 // mechanism used is specified by the "format" query parameter.
-func (h requestHandler) handleDelete(handler ResourceHandler) http.Handler {
+func (h requestHandler) handleDelete(handler resourceHandlerProxy) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := NewContextWithRouter(r, w, h.router)
+		ctx := h.newContext(r, w)
 		version := ctx.Version()
 		rules := handler.Rules()
 
 		resource, err := handler.DeleteResource(ctx, ctx.ResourceID(), version)
 		if err == nil {
-			resource = applyOutboundRules(resource, rules, version)
+			resource = h.applyOutboundRules(ctx, resource, rules, version)
 		}
 
-		ctx = ctx.setResult(resource)
-		ctx = ctx.setError(err)
-		ctx = ctx.setStatus(http.StatusOK)
+		if accepted, ok := resource.(*AcceptedResource); ok && err == nil {
+			ctx = setAccepted(ctx, accepted)
+		} else {
+			ctx = ctx.setResult(resource)
+			ctx = ctx.setError(err)
+			ctx = ctx.setStatus(http.StatusOK)
+		}
 
-		h.sendResponse(ctx)
+		setCacheControl(ctx, noStore)
+		h.sendResponse(ctx, handler)
 	})
 }
 
+// AcceptedResource is returned by ResourceHandlers in place of a Resource from
+// create, update, and delete operations which were accepted for asynchronous
+// processing. The response is a 202 Accepted with no body and Location and
+// Content-Location headers pointing at StatusURL, a resource clients can poll for the
+// status of the operation.
+type AcceptedResource struct {
+	StatusURL string
+}
+
+// Accepted returns an AcceptedResource with the given status polling URL.
+func Accepted(statusURL string) *AcceptedResource {
+	return &AcceptedResource{StatusURL: statusURL}
+}
+
+// setAccepted sets up a 202 Accepted response pointing at the AcceptedResource's
+// status URL.
+func setAccepted(ctx RequestContext, accepted *AcceptedResource) RequestContext {
+	if accepted.StatusURL != "" {
+		header := ctx.ResponseWriter().Header()
+		header.Set("Location", accepted.StatusURL)
+		header.Set("Content-Location", accepted.StatusURL)
+	}
+	ctx = ctx.setStatus(http.StatusAccepted)
+	return ctx.WithValue(omitBodyKey, true)
+}
+
+// resourceLocation returns the URL of the read endpoint for the given resource or an
+// empty string if the handler doesn't provide an id for it or the URL can't be
+// built.
+func (h requestHandler) resourceLocation(ctx RequestContext, handler resourceHandlerProxy,
+	resource Resource) string {
+
+	if _, ok := resource.(*AcceptedResource); ok || isNil(resource) {
+		return ""
+	}
+
+	id := handler.ResourceID(resource)
+	if id == "" {
+		return ""
+	}
+
+	u, err := ctx.BuildURL(handler.ResourceName(), HandleRead, RouteVars{resourceIDKey: id})
+	if err != nil {
+		newRequestLogger(ctx).Printf("Unable to build location for resource '%s': %s", id, err)
+		return ""
+	}
+
+	return u.String()
+}
+
 // sendResponse writes a success or error response to the provided http.ResponseWriter
-// based on the contents of the RequestContext.
-func (h requestHandler) sendResponse(ctx RequestContext) {
+// based on the contents of the RequestContext. The response format requested by the
+// client is used unless the ResourceHandler forces a different one. The format is
+// negotiated using the Accept header, falling back to the "format" query parameter
+// and then json. The ResourceHandler's AfterHandle is invoked before anything is sent.
+// Nothing is sent if the request already timed out.
+func (h requestHandler) sendResponse(ctx RequestContext, handler resourceHandlerProxy) {
+	handler.AfterHandle(ctx, ctx.HandleMethod())
+
+	if tw, ok := ctx.ResponseWriter().(*timeoutWriter); ok && tw.isTimedOut() {
+		// The timeout response was sent in place of this one.
+		return
+	}
+	h.writeResponse(ctx, handler)
+}
+
+// writeResponse writes the response for the RequestContext like sendResponse, without
+// invoking the ResourceHandler's AfterHandle.
+func (h requestHandler) writeResponse(ctx RequestContext, handler resourceHandlerProxy) {
+	if timeout := h.Configuration().RequestTimeout; timeout > 0 &&
+		errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		ctx = ctx.setError(fmt.Errorf("Request timed out after %s: %w", timeout, ctx.Err()))
+	}
+
+	if status, ok := h.contextErrorStatus(ctx.Error()); ok {
+		ctx = ctx.setError(CustomError(ctx.Error().Error(), status))
+		if errors.Is(ctx.Err(), context.Canceled) {
+			// The client is gone, so there's no one to write a body for.
+			ctx.ResponseWriter().WriteHeader(status)
+			h.logIfSlow(ctx, handler)
+			h.observe(ctx, handler, status, 0)
+			return
+		}
+	}
+
+	var restErr Error
+	if errors.As(ctx.Error(), &restErr) && restErr.RetryAfter() > 0 {
+		ctx.ResponseWriter().Header().Set("Retry-After", retryAfterSeconds(restErr.RetryAfter()))
+	}
+
+	var accept []string
+	if r, ok := ctx.Request(); ok {
+		accept = r.Header["Accept"]
+	}
+	accepted, notAcceptable := h.acceptedFormat(accept)
+	if accepted != "" {
+		ctx = ctx.WithValue(formatKey, accepted)
+	} else if ctx.Value(formatKey) != nil {
+		// Fall back to the format query parameter.
+		notAcceptable = nil
+	}
+
+	if forced := handler.ForceFormat(ctx); forced != "" {
+		ctx = ctx.WithValue(formatKey, forced)
+		notAcceptable = nil
+	}
+
+	if config := h.Configuration(); config.ResourceNamedEnvelopes {
+		name := handler.ResourceName()
+		ctx = ctx.WithValue(envelopeKeysKey, envelopeKeys{name, config.PluralName(name)})
+	}
+
+	if h.Configuration().IncludeTiming {
+		if start := requestStart(ctx); !start.IsZero() {
+			elapsed := time.Since(start)
+			ctx.ResponseWriter().Header().Set("X-Response-Time",
+				strconv.FormatFloat(durationMillis(elapsed), 'f', 3, 64)+"ms")
+			ctx = ctx.WithValue(durationKey, elapsed)
+		}
+	}
+
+	if h.Configuration().IncludeVersions {
+		if versions := supportedVersions(handler); len(versions) > 0 {
+			ctx.ResponseWriter().Header().Set(versionsHeader, strings.Join(versions, ", "))
+			ctx = ctx.WithValue(versionsKey, versions)
+		}
+	}
+
+	addVary(ctx.ResponseWriter().Header(), h.varyHeaders()...)
+
 	format := ctx.ResponseFormat()
 	serializer, err := h.responseSerializer(format)
+	fallback := h.Configuration().UnknownFormatBehavior == UnknownFormatFallbackToDefault
 	if err != nil {
-		// Fall back to json serialization.
+		// Fall back to json serialization, or send the error in the format the client
+		// prefers of those available.
 		serializer = jsonSerializer{}
-		ctx = ctx.setError(BadRequest(fmt.Sprintf("Format not implemented: %s", format)))
+		if !fallback {
+			ctx = ctx.setError(BadRequest(fmt.Sprintf("Format not implemented: %s", format)))
+			if s, err := h.responseSerializer(h.errorFormat(accept)); err == nil {
+				serializer = s
+			}
+		}
+	} else if notAcceptable != nil && !fallback {
+		ctx = ctx.setError(BadRequest(notAcceptable.Error()))
+	}
+
+	if ctx.Error() != nil {
+		// Error responses are specific to the request, so they're never cached.
+		ctx.ResponseWriter().Header().Set("Cache-Control", noStore)
 	}
 
-	sendResponse(ctx.ResponseWriter(), NewResponse(ctx), serializer)
+	status, size := sendResponse(
+		ctx.ResponseWriter(), NewResponse(ctx), serializer, newRequestLogger(ctx))
+	h.logIfSlow(ctx, handler)
+	h.observe(ctx, handler, status, size)
 }
 
-// sendResponse writes a response to the http.ResponseWriter.
-func sendResponse(w http.ResponseWriter, r response, serializer ResponseSerializer) {
-	status := r.Status
-	contentType := serializer.ContentType()
+// versionsHeader is the header listing the versions supported by a resource.
+const versionsHeader = "X-API-Versions"
+
+// supportedVersions returns the versions supported by the ResourceHandler, its
+// ValidVersions if it has any, or else the sorted versions its Rules apply to. It
+// returns an empty slice if the ResourceHandler supports any version.
+func supportedVersions(handler resourceHandlerProxy) []string {
+	if valid := handler.ValidVersions(); valid != nil {
+		return valid
+	}
+	if handler.Rules() == nil {
+		return []string{}
+	}
+	return handlerVersions(handler)
+}
+
+// contextErrorStatus returns the configured status for errors caused by the request
+// context being canceled or exceeding its deadline and true, or false if the error
+// isn't one of them.
+func (h requestHandler) contextErrorStatus(err error) (int, bool) {
+	config := h.Configuration()
+	switch {
+	case err == nil:
+		return 0, false
+	case errors.Is(err, context.Canceled):
+		if config.CanceledStatus != 0 {
+			return config.CanceledStatus, true
+		}
+		return StatusClientClosedRequest, true
+	case errors.Is(err, context.DeadlineExceeded):
+		if config.DeadlineExceededStatus != 0 {
+			return config.DeadlineExceededStatus, true
+		}
+		return http.StatusServiceUnavailable, true
+	}
+	return 0, false
+}
+
+// RequestMetrics describes a handled request for a MetricsObserver.
+type RequestMetrics struct {
+	Resource      string        // Name of the resource handling the request.
+	Method        HandleMethod  // HandleMethod used to handle the request.
+	Status        int           // Response status code.
+	Duration      time.Duration // Time taken to handle the request.
+	RequestBytes  int           // Length of the request body.
+	ResponseBytes int           // Length of the serialized response body.
+}
+
+// MetricsObserver is invoked with the RequestMetrics of each request once its
+// response has been sent.
+type MetricsObserver func(ctx RequestContext, metrics RequestMetrics)
+
+// observe passes the RequestMetrics for the request to the configured
+// MetricsObserver, if any.
+func (h requestHandler) observe(ctx RequestContext, handler resourceHandlerProxy,
+	status, responseBytes int) {
+
+	observer := h.Configuration().MetricsObserver
+	if observer == nil {
+		return
+	}
+
+	metrics := RequestMetrics{
+		Resource:      handler.ResourceName(),
+		Method:        ctx.HandleMethod(),
+		Status:        status,
+		ResponseBytes: responseBytes,
+	}
+	if start := requestStart(ctx); !start.IsZero() {
+		metrics.Duration = time.Since(start)
+	}
+	if body := ctx.Body(); body != nil {
+		metrics.RequestBytes = body.Len()
+	}
+	observer(ctx, metrics)
+}
+
+// defaultLimit returns the number of results fetched by list reads which don't
+// specify a limit, preferring the ResourceHandler's over the Configuration's.
+func (h requestHandler) defaultLimit(handler resourceHandlerProxy) int {
+	if limit := handler.DefaultLimit(); limit > 0 {
+		return limit
+	}
+	if limit := h.Configuration().DefaultLimit; limit > 0 {
+		return limit
+	}
+	return defaultLimit
+}
+
+// maxLimit returns the maximum number of results fetched by list reads, preferring the
+// ResourceHandler's over the Configuration's, or 0 if there is no maximum.
+func (h requestHandler) maxLimit(handler resourceHandlerProxy) int {
+	if limit := handler.MaxLimit(); limit > 0 {
+		return limit
+	}
+	return h.Configuration().MaxLimit
+}
+
+// maxNestingDepth returns the maximum number of levels of nested Rules applied to
+// resources in responses, which is the configured MaxNestingDepth or 32 if it isn't
+// set, or 0 if there is no maximum.
+func (h requestHandler) maxNestingDepth() int {
+	depth := h.Configuration().MaxNestingDepth
+	if depth < 0 {
+		return 0
+	}
+	if depth == 0 {
+		return defaultNestingDepth
+	}
+	return depth
+}
+
+// requestIDHeader returns the header carrying request ids, which is the configured
+// RequestIDHeader or X-Request-ID if it isn't set.
+func (h requestHandler) requestIDHeader() string {
+	if header := h.Configuration().RequestIDHeader; header != "" {
+		return header
+	}
+	return defaultRequestIDHeader
+}
+
+// varyHeaders returns the request headers which influence the representation of the
+// response given the API Configuration.
+func (h requestHandler) varyHeaders() []string {
+	// The response format is negotiated using the Accept header.
+	vary := []string{"Accept"}
+	if h.Configuration().MediaTypeVersioning {
+		// The version may be read from the vendor media type in either header.
+		vary = append(vary, "Content-Type")
+	}
+	return vary
+}
+
+// addVary adds the given request headers to the Vary header, preserving any already
+// listed, e.g. by middleware.
+func addVary(header http.Header, fields ...string) {
+	if len(fields) == 0 {
+		return
+	}
+
+	vary := []string{}
+	seen := map[string]bool{}
+	for _, value := range header["Vary"] {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field != "" && !seen[strings.ToLower(field)] {
+				seen[strings.ToLower(field)] = true
+				vary = append(vary, field)
+			}
+		}
+	}
+	for _, field := range fields {
+		if !seen[strings.ToLower(field)] {
+			seen[strings.ToLower(field)] = true
+			vary = append(vary, field)
+		}
+	}
+
+	header.Set("Vary", strings.Join(vary, ", "))
+}
+
+// logIfSlow logs a warning if handling the request took longer than the configured
+// SlowRequestThreshold.
+func (h requestHandler) logIfSlow(ctx RequestContext, handler resourceHandlerProxy) {
+	config := h.Configuration()
+	start := requestStart(ctx)
+	if config.SlowRequestThreshold <= 0 || start.IsZero() {
+		return
+	}
+
+	elapsed := time.Since(start)
+	if elapsed <= config.SlowRequestThreshold {
+		return
+	}
+
+	newRequestLogger(ctx).Printf("Slow request: %s %s took %s",
+		handler.ResourceName(), ctx.HandleMethod(), elapsed)
+}
+
+// sendResponse writes a response to the http.ResponseWriter. It returns the status
+// code and the length of the body written.
+func sendResponse(w http.ResponseWriter, r response, serializer ResponseSerializer,
+	logger requestLogger) (int, int) {
+	if streaming, ok := serializer.(StreamingResponseSerializer); ok && r.Payload != nil {
+		return streamResponse(w, r, streaming, logger)
+	}
 
 	var response []byte
 	if r.Payload != nil {
 		var err error
 		response, err = serializer.Serialize(r.Payload)
 		if err != nil {
-			log.Printf("Response serialization failed: %s", err)
-			status = http.StatusInternalServerError
-			contentType = "text/plain"
-			response = []byte(err.Error())
+			logger.Printf("Response serialization failed: %s", err)
+			return sendSerializationError(w)
 		}
 	}
 
-	w.Header().Set("Content-Type", contentType)
-	w.WriteHeader(status)
+	w.Header().Set("Content-Type", serializer.ContentType())
+	w.WriteHeader(r.Status)
 	w.Write(response)
+	return r.Status, len(response)
+}
+
+// sendSerializationError writes a 500 response in the JSON error envelope for a
+// response which failed to serialize. The serialization error is logged rather than
+// sent, so its details don't leak to clients. It returns the status code and the
+// length of the body written.
+func sendSerializationError(w http.ResponseWriter) (int, int) {
+	s := http.StatusInternalServerError
+	response, _ := jsonSerializer{}.Serialize(Payload{
+		status:   s,
+		reason:   http.StatusText(s),
+		messages: []interface{}{"Unable to serialize response"},
+	})
+
+	w.Header().Set("Content-Type", jsonSerializer{}.ContentType())
+	w.WriteHeader(s)
+	w.Write(response)
+	return s, len(response)
+}
+
+// streamResponse writes a response to the http.ResponseWriter as it's serialized. The
+// status is written along with the first bytes of the response, so a failure before
+// then still produces a clean 500. Once part of the response has been sent, a failure
+// or panic aborts the connection rather than leaving a truncated body which clients
+// could parse as valid. It returns the status code and the length of the body
+// written.
+func streamResponse(w http.ResponseWriter, r response,
+	serializer StreamingResponseSerializer, logger requestLogger) (int, int) {
+
+	w.Header().Set("Content-Type", serializer.ContentType())
+	stream := &streamWriter{ResponseWriter: w, status: r.Status}
+
+	var err error
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("Response serialization panicked: %v", p)
+			}
+		}()
+		err = serializer.SerializeTo(stream, r.Payload)
+	}()
+
+	if err == nil {
+		if !stream.started {
+			w.WriteHeader(r.Status)
+		}
+		return r.Status, stream.written
+	}
+
+	logger.Printf("Response serialization failed: %s", err)
+	if stream.started {
+		// The status has been sent, so abort the connection to signal the failure.
+		panic(http.ErrAbortHandler)
+	}
+
+	return sendSerializationError(w)
+}
+
+// streamWriter writes the response status along with the first bytes of a streamed
+// response, flushing each write to the client.
+type streamWriter struct {
+	http.ResponseWriter
+	status  int
+	started bool
+	written int
+}
+
+// Write writes the data to the response, writing the status first if it hasn't been.
+func (s *streamWriter) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if !s.started {
+		s.ResponseWriter.WriteHeader(s.status)
+		s.started = true
+	}
+	n, err := s.ResponseWriter.Write(data)
+	s.written += n
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+// setCacheControl sets the Cache-Control header of successful responses to the given
+// policy. Nothing is set if the policy is empty. Error responses are always sent with
+// no-store by sendResponse.
+func setCacheControl(ctx RequestContext, policy string) {
+	if policy != "" && ctx.Error() == nil {
+		ctx.ResponseWriter().Header().Set("Cache-Control", policy)
+	}
+}
+
+// requestBody returns the raw request body after applying the configured
+// RequestBodyTransformer, if any.
+func (h requestHandler) requestBody(ctx RequestContext) ([]byte, error) {
+	body := ctx.Body().Bytes()
+	if transform := h.Configuration().RequestBodyTransformer; transform != nil {
+		return transform(body)
+	}
+	return body, nil
+}
+
+// decodeRequestPayload transforms, decodes, and checks the request body. If any of
+// these fail, nil is returned with an error.
+func (h requestHandler) decodeRequestPayload(ctx RequestContext) (Payload, error) {
+	body, err := h.requestBody(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := decodePayload(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.checkPayload(body); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// checkPayload verifies that the raw request payload satisfies the decoding
+// constraints set in the API Configuration.
+func (h requestHandler) checkPayload(payload []byte) error {
+	if h.Configuration().RejectDuplicateKeys {
+		return checkDuplicateKeys(payload)
+	}
+	return nil
+}
+
+// auditWrite passes the Payload of a successful write to the configured AuditWrite
+// hook, if any, with the values of Sensitive fields masked.
+func (h requestHandler) auditWrite(ctx RequestContext, handler resourceHandlerProxy,
+	payload Payload, rules Rules, version string) {
+
+	audit := h.Configuration().AuditWrite
+	if audit == nil {
+		return
+	}
+	audit(ctx, handler.ResourceName(), ctx.HandleMethod(),
+		maskSensitive(payload, rules, version))
+}
+
+// applyInboundRules applies the inbound Rules to the Payload. If
+// CollectAllValidationErrors is enabled, a failure does not stop validation of the
+// remaining fields and the returned error is a ValidationErrors.
+func (h requestHandler) applyInboundRules(ctx RequestContext,
+	payload Payload, rules Rules, version string) (Payload, error) {
+
+	rules = rules.Filter(Inbound).ForVersion(version)
+	return h.applyRequestInboundRules(
+		ctx, payload, rules, version, false, newRequestLogger(ctx))
+}
+
+// applyPartialInboundRules applies the inbound Rules to the Payload of a partial
+// update like applyInboundRules, except required fields are not enforced.
+func (h requestHandler) applyPartialInboundRules(ctx RequestContext,
+	payload Payload, rules Rules, version string) (Payload, error) {
+
+	rules = rules.Filter(Inbound).ForVersion(version)
+	return h.applyRequestInboundRules(
+		ctx, payload, rules, version, true, newRequestLogger(ctx))
+}
+
+// applyBatchInboundRules applies the inbound Rules to each Payload in the batch in
+// place. The Rules are filtered once and shared across the batch. If
+// CollectAllValidationErrors is enabled, the returned ValidationErrors identify which
+// item each field belongs to, e.g. "1.foo".
+func (h requestHandler) applyBatchInboundRules(ctx RequestContext, data []Payload,
+	rules Rules, version string) error {
+
+	rules = rules.Filter(Inbound).ForVersion(version)
+	logger := newRequestLogger(ctx)
+	errs := ValidationErrors{}
+	for i := range data {
+		var err error
+		data[i], err = h.applyRequestInboundRules(ctx, data[i], rules, version, false, logger)
+		var fieldErrs ValidationErrors
+		if errors.As(err, &fieldErrs) {
+			// Identify which item in the list each field belongs to.
+			for _, fieldErr := range fieldErrs {
+				fieldErr.Field = fmt.Sprintf("%d.%s", i, fieldErr.Field)
+			}
+			errs = append(errs, fieldErrs...)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// withoutUnchangedWriteOnce returns the Payload of an update without the write-once
+// fields whose values are unchanged, so clients can send back a resource they read.
+// If the Payload has any, the stored resource is read using the ResourceHandler's
+// ReadResource, and an error reading it is returned.
+func (h requestHandler) withoutUnchangedWriteOnce(ctx RequestContext,
+	handler resourceHandlerProxy, payload Payload, rules Rules,
+	version string) (Payload, error) {
+
+	rules = rules.Filter(Inbound).ForVersion(version)
+	if len(writeOnceFieldErrors(payload, rules)) == 0 {
+		return payload, nil
+	}
+
+	stored, err := handler.ReadResource(ctx, ctx.ResourceID(), version)
+	if err != nil {
+		return nil, err
+	}
+	return withoutUnchanged(payload, rules, stored), nil
+}
+
+// applyRequestInboundRules applies Rules already filtered to the inbound Rules for
+// the version to the Payload of a create or update request, warning about any
+// deprecated fields it contains. If partial is true, required fields are not
+// enforced. Updates which send write-once fields are rejected.
+func (h requestHandler) applyRequestInboundRules(ctx RequestContext, payload Payload,
+	rules Rules, version string, partial bool, logger requestLogger) (Payload, error) {
+
+	warnDeprecatedFields(ctx, payload, rules)
+	collect := h.Configuration().CollectAllValidationErrors
+
+	var writeOnceErrs ValidationErrors
+	switch ctx.HandleMethod() {
+	case HandleUpdate, HandleUpdateList, HandlePatch:
+		writeOnceErrs = writeOnceFieldErrors(payload, rules)
+		rules = withoutWriteOnce(rules)
+	}
+	if len(writeOnceErrs) > 0 && !collect {
+		logger.Println(writeOnceErrs[0])
+		return nil, writeOnceErrs[0]
+	}
+
+	payload, err := applyFilteredInboundRules(payload, rules, version, collect, partial, logger)
+	if len(writeOnceErrs) == 0 {
+		return payload, err
+	}
+	var fieldErrs ValidationErrors
+	if errors.As(err, &fieldErrs) {
+		writeOnceErrs = append(writeOnceErrs, fieldErrs...)
+	} else if err != nil {
+		return nil, err
+	}
+	logger.Println(writeOnceErrs)
+	return nil, writeOnceErrs
+}
+
+// applyOutboundRules applies the outbound Rules to the Resource, limiting nested
+// Rules to the configured MaxNestingDepth. AcceptedResources are returned as-is.
+func (h requestHandler) applyOutboundRules(ctx RequestContext,
+	resource Resource, rules Rules, version string) Resource {
+
+	if _, ok := resource.(*AcceptedResource); ok {
+		return resource
+	}
+	resource = applyOutboundRulesDepth(ctx, resource, rules, version, 0,
+		h.maxNestingDepth(), newRequestLogger(ctx))
+	if payload, ok := resource.(Payload); ok {
+		outbound := rules.Filter(Outbound).ForVersion(version)
+		warnDeprecatedFields(ctx, payload, outbound)
+		addRelatedLinks(ctx, payload, outbound)
+	}
+	return resource
+}
+
+// addRelatedLinks adds links to the read endpoints of the related resources
+// identified by the Payload's fields to its "links" object.
+func addRelatedLinks(ctx RequestContext, payload Payload, rules Rules) {
+	related := map[string]interface{}{}
+	for _, rule := range rules.Contents() {
+		if rule.RelatedResource == "" {
+			continue
+		}
+		value, ok := payload[rule.Name()]
+		if !ok || isNil(value) || isEmptyValue(value) {
+			continue
+		}
+
+		id := fmt.Sprintf("%v", value)
+		u, err := ctx.BuildURL(rule.RelatedResource, HandleRead, RouteVars{resourceIDKey: id})
+		if err != nil {
+			newRequestLogger(ctx).Printf("Unable to link related resource: %s", err)
+			continue
+		}
+		related[strings.TrimSuffix(rule.Name(), "_id")] = u.String()
+	}
+
+	if len(related) == 0 {
+		return
+	}
+	if existing, ok := payload[links].(map[string]interface{}); ok {
+		// Don't modify the resource's own links.
+		for name, link := range existing {
+			if _, ok := related[name]; !ok {
+				related[name] = link
+			}
+		}
+	} else if _, ok := payload[links]; ok {
+		return
+	}
+	payload[links] = related
+}
+
+// warnDeprecatedFields adds a warning to the response for each deprecated Rule whose
+// field is in the Payload. Each warning is sent in a Warning header and as a message,
+// once per request.
+func warnDeprecatedFields(ctx RequestContext, payload Payload, rules Rules) {
+	for _, rule := range rules.Contents() {
+		if !rule.Deprecated {
+			continue
+		}
+		if _, ok := payload[rule.Name()]; !ok {
+			continue
+		}
+
+		warning := rule.deprecationWarning()
+		value := fmt.Sprintf("299 - %q", warning)
+		header := ctx.ResponseWriter().Header()
+		if containsString(header["Warning"], value) {
+			continue
+		}
+		header.Add("Warning", value)
+		ctx.AddMessage(warning)
+	}
+}
+
+// containsString returns true if the slice contains the string.
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validationError returns the error to set on the RequestContext when applying
+// inbound Rules fails. ValidationErrors are returned as-is since they produce a 422
+// listing each invalid field, while any other error becomes an UnprocessableRequest
+// wrapping it.
+func validationError(err error) error {
+	var errs ValidationErrors
+	if errors.As(err, &errs) {
+		return errs
+	}
+	unprocessable := UnprocessableRequest(err.Error())
+	unprocessable.cause = err
+	return unprocessable
 }
 
 // decodePayload unmarshals the JSON payload and returns the resulting map. If the
@@ -376,7 +1612,7 @@ func decodePayload(payload []byte) (Payload, error) {
 
 	var data Payload
 	if err := json.Unmarshal(payload, &data); err != nil {
-		return nil, err
+		return nil, jsonErrorWithPosition(payload, err)
 	}
 
 	return data, nil
@@ -392,8 +1628,93 @@ func decodePayloadSlice(payload []byte) ([]Payload, error) {
 
 	var data []Payload
 	if err := json.Unmarshal(payload, &data); err != nil {
-		return nil, err
+		return nil, jsonErrorWithPosition(payload, err)
 	}
 
 	return data, nil
 }
+
+// jsonErrorWithPosition adds the line and column at which decoding the JSON payload
+// failed to syntax and type errors, which only report a byte offset. Other errors are
+// returned as-is.
+func jsonErrorWithPosition(payload []byte, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return err
+	}
+
+	// The offset is past the offending byte.
+	if offset > int64(len(payload)) {
+		offset = int64(len(payload))
+	}
+	if offset > 0 {
+		offset--
+	}
+	consumed := payload[:offset]
+	line := bytes.Count(consumed, []byte("\n")) + 1
+	column := len(consumed) - bytes.LastIndexByte(consumed, '\n')
+	return fmt.Errorf("%s (line %d, column %d)", err, line, column)
+}
+
+// checkDuplicateKeys returns an error if the JSON payload contains an object with the
+// same key more than once at the top level or, for a list payload, at the top level
+// of any of its elements. json.Unmarshal silently keeps the last value for duplicate
+// keys, which makes such payloads ambiguous.
+func checkDuplicateKeys(payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		return checkObjectKeys(dec)
+	case json.Delim('['):
+		for dec.More() {
+			var element json.RawMessage
+			if err := dec.Decode(&element); err != nil {
+				return err
+			}
+			if err := checkDuplicateKeys(element); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkObjectKeys consumes the keys and values of a JSON object whose opening delimiter
+// has already been read from the decoder, returning an error if a key is repeated.
+func checkObjectKeys(dec *json.Decoder) error {
+	seen := map[string]bool{}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		key, _ := token.(string)
+		if seen[key] {
+			return fmt.Errorf("Duplicate key '%s'", key)
+		}
+		seen[key] = true
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -92,3 +92,49 @@ func TestDecodePayloadSliceHappyPath(t *testing.T) {
 	assert.Equal([]Payload{Payload{"foo": "bar", "baz": float64(1)}}, decoded)
 	assert.Nil(err)
 }
+
+// Ensures that checkDuplicateKeys returns an error for objects with repeated keys.
+func TestCheckDuplicateKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.EqualError(checkDuplicateKeys([]byte(`{"amount": 1, "amount": 999}`)),
+		"Duplicate key 'amount'")
+	assert.EqualError(checkDuplicateKeys([]byte(`[{"a": 1}, {"b": {"c": 1}, "b": 2}]`)),
+		"Duplicate key 'b'")
+}
+
+// Ensures that checkDuplicateKeys returns nil for payloads without repeated keys,
+// including keys repeated in nested objects.
+func TestCheckDuplicateKeysClean(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(checkDuplicateKeys([]byte(``)))
+	assert.Nil(checkDuplicateKeys([]byte(`{"amount": 1, "nested": {"amount": 2}}`)))
+	assert.Nil(checkDuplicateKeys([]byte(`[{"a": 1}, {"a": 2}]`)))
+}
+
+// Ensures that decodePayload reports the line and column of syntax errors.
+func TestDecodePayloadBadJSONPosition(t *testing.T) {
+	assert := assert.New(t)
+	body := "{\n  \"foo\": \"bar\",\n  \"baz\": 1,\n}"
+
+	decoded, err := decodePayload([]byte(body))
+
+	assert.Nil(decoded)
+	assert.EqualError(err,
+		"invalid character '}' looking for beginning of object key string (line 4, column 1)")
+}
+
+// Ensures that decodePayloadSlice reports the line and column of type errors.
+func TestDecodePayloadSliceTypeErrorPosition(t *testing.T) {
+	assert := assert.New(t)
+	body := "[\n  {\"foo\": 1},\n  2\n]"
+
+	decoded, err := decodePayloadSlice([]byte(body))
+
+	assert.Nil(decoded)
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "cannot unmarshal number")
+		assert.Contains(err.Error(), "(line 3, column 3)")
+	}
+}
@@ -74,7 +74,7 @@ const handlerTemplate = `
             </div>
 
             <div class="page-header">
-                <h1>{{resource}} <span class="label label-primary">v{{version}}</span></h1>
+                <h1>{{resource}} <small>{{collection}}</small> <span class="label label-primary">v{{version}}</span></h1>
             </div>
 
             {{#endpoints}}
@@ -105,6 +105,15 @@ const handlerTemplate = `
                                         </span>
                                         <p style="margin-left:220px;">
                                             (<em>{{type}}</em>) {{description}}
+                                            {{#constraints}}
+                                            <span style="display:block;color:#999;">{{constraints}}</span>
+                                            {{/constraints}}
+                                            {{#writeOnce}}
+                                            <span style="display:block;color:#999;">Write-once: can only be set on create</span>
+                                            {{/writeOnce}}
+                                            {{#deprecated}}
+                                            <span style="display:block;color:#c00;">Deprecated: {{deprecated}}</span>
+                                            {{/deprecated}}
                                         </p>
                                     </div>
                                     {{/inputFields}}
@@ -140,6 +149,15 @@ const handlerTemplate = `
                                         </span>
                                         <p style="margin-left:220px;">
                                             (<em>{{type}}</em>) {{description}}
+                                            {{#allowedValues}}
+                                            <span style="display:block;color:#999;">{{allowedValues}}</span>
+                                            {{/allowedValues}}
+                                            {{#readOnly}}
+                                            <span style="display:block;color:#999;">Read-only</span>
+                                            {{/readOnly}}
+                                            {{#deprecated}}
+                                            <span style="display:block;color:#c00;">Deprecated: {{deprecated}}</span>
+                                            {{/deprecated}}
                                         </p>
                                     </div>
                                     {{/outputFields}}
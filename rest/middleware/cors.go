@@ -12,6 +12,21 @@ import (
 // Origin must match the supplied whitelist (which supports wildcards). Returns
 // a MiddlewareError if the request should be terminated.
 func NewCORSMiddleware(originWhitelist []string) rest.Middleware {
+	return newCORSMiddleware(originWhitelist, false)
+}
+
+// NewCORSPreflightMiddleware returns a Middleware which enables cross-origin
+// requests like NewCORSMiddleware, but passes OPTIONS preflight requests through
+// to the API rather than answering them itself. Use it with
+// Configuration.CORSPreflight so the allowed methods reflect those registered at
+// the requested URI.
+func NewCORSPreflightMiddleware(originWhitelist []string) rest.Middleware {
+	return newCORSMiddleware(originWhitelist, true)
+}
+
+// newCORSMiddleware returns a Middleware which enables cross-origin requests. If
+// passPreflight is true, OPTIONS requests are left for the API to answer.
+func newCORSMiddleware(originWhitelist []string, passPreflight bool) rest.Middleware {
 	return func(w http.ResponseWriter, r *http.Request) *rest.MiddlewareError {
 		origin := r.Header.Get("Origin")
 		if origin == "" && r.Method != "OPTIONS" {
@@ -28,6 +43,9 @@ func NewCORSMiddleware(originWhitelist []string) rest.Middleware {
 		}
 
 		if r.Method == "OPTIONS" {
+			if passPreflight {
+				return nil
+			}
 			return &rest.MiddlewareError{Code: http.StatusOK}
 		}
 
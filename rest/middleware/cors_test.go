@@ -73,3 +73,15 @@ func TestCORSMiddlewareNoOrigin(t *testing.T) {
 	err := NewCORSMiddleware([]string{"blah.wdesk.org", "*.wdesk.com"})(w, req)
 	assert.Nil(t, err)
 }
+
+// Ensures that CORSPreflightMiddleware applies the CORS headers to OPTIONS requests
+// but leaves them for the API to answer.
+func TestCORSPreflightMiddlewareOptionsRequest(t *testing.T) {
+	assert := assert.New(t)
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+	req.Header.Set("Origin", "http://foo.com")
+	w := httptest.NewRecorder()
+
+	assert.Nil(NewCORSPreflightMiddleware([]string{"foo.com"})(w, req))
+	assert.Equal("http://foo.com", w.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,386 @@
+/*
+Copyright 2014 - 2015 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// openAPIVersion is the version of the OpenAPI Specification generated specs follow.
+const openAPIVersion = "3.0.3"
+
+// openAPITypes maps the names in typeToName to OpenAPI schemas.
+var openAPITypes = map[string]field{
+	"int":                    {"type": "integer"},
+	"int8":                   {"type": "integer", "format": "int32"},
+	"int16":                  {"type": "integer", "format": "int32"},
+	"int32":                  {"type": "integer", "format": "int32"},
+	"int64":                  {"type": "integer", "format": "int64"},
+	"uint":                   {"type": "integer", "minimum": 0},
+	"uint8":                  {"type": "integer", "format": "int32", "minimum": 0},
+	"uint16":                 {"type": "integer", "format": "int32", "minimum": 0},
+	"uint32":                 {"type": "integer", "format": "int64", "minimum": 0},
+	"uint64":                 {"type": "integer", "minimum": 0},
+	"float32":                {"type": "number", "format": "float"},
+	"float64":                {"type": "number", "format": "double"},
+	"string":                 {"type": "string"},
+	"bool":                   {"type": "boolean"},
+	"[]interface{}":          {"type": "array", "items": field{}},
+	"map[string]interface{}": {"type": "object"},
+	"time.Duration":          {"type": "integer", "format": "int64"},
+	"time.Time":              {"type": "string", "format": "date-time"},
+	"uuid":                   {"type": "string", "format": "uuid"},
+}
+
+// uriParamPattern matches the templated variables in a URI, e.g. {resource_id} or
+// {version:[^/]+}, capturing the variable name.
+var uriParamPattern = regexp.MustCompile(`{([^:}]+)(?::[^}]*)?}`)
+
+// openAPIGenerator produces OpenAPI specs for APIs by introspecting ResourceHandlers and
+// their Rules.
+type openAPIGenerator struct {
+	DocWriter
+}
+
+// newOpenAPIGenerator creates a new openAPIGenerator instance which writes specs to the
+// local file system.
+func newOpenAPIGenerator() *openAPIGenerator {
+	return &openAPIGenerator{&fsDocWriter{}}
+}
+
+// generateSpecs creates an OpenAPI spec for each version of the provided API. The
+// resulting openapi_v<version>.json files will be placed in the directory specified by
+// the API Configuration. Returns an error if generating the specs failed, nil otherwise.
+func (o *openAPIGenerator) generateSpecs(api API) error {
+	dir := api.Configuration().DocsDirectory
+	if !strings.HasSuffix(dir, "/") {
+		dir = dir + "/"
+	}
+
+	if err := o.Mkdir(dir, os.FileMode(0777)); err != nil {
+		api.Configuration().Logger.Println(err)
+		return err
+	}
+
+	handlers := api.ResourceHandlers()
+	for _, version := range versions(handlers) {
+		spec, err := json.MarshalIndent(openAPISpec(api, handlers, version), "", "    ")
+		if err != nil {
+			api.Configuration().Logger.Println(err)
+			return err
+		}
+		if err := o.Write(fmt.Sprintf("%sopenapi_v%s.json", dir, version), spec, 0644); err != nil {
+			api.Configuration().Logger.Println(err)
+			return err
+		}
+	}
+
+	api.Configuration().Debugf("OpenAPI specs generated in %s", dir)
+	return nil
+}
+
+// openAPIEndpoint describes an endpoint of a ResourceHandler as an OpenAPI operation.
+type openAPIEndpoint struct {
+	uri, method, doc, operationID string
+	input, list                   bool
+	status                        int
+}
+
+// openAPISpec returns the OpenAPI spec for the version of the API served by the
+// ResourceHandlers. Like the HTML documentation, only endpoints with documentation are
+// included.
+func openAPISpec(api API, handlers []ResourceHandler, version string) map[string]interface{} {
+	paths := map[string]field{}
+	operationIDs := map[string]bool{}
+	for _, handler := range handlers {
+		rules := handler.Rules()
+		if len(getInputFields(rules.ForVersion(version))) == 0 &&
+			len(getOutputFields(rules.ForVersion(version))) == 0 {
+			// Handler has no fields for this version.
+			continue
+		}
+
+		config := api.Configuration()
+		name := handler.ResourceName()
+		plural := config.PluralName(name)
+		keys := envelopeKeys{result, results}
+		if config.ResourceNamedEnvelopes {
+			keys = envelopeKeys{name, plural}
+		}
+
+		namespace := ""
+		if namespacer, ok := handler.(Namespacer); ok {
+			namespace = strings.Trim(namespacer.Namespace(), "/")
+		}
+		tag := namespace
+		if tag == "" {
+			tag = name
+		}
+
+		// Operation ids include the namespace since resources in different namespaces
+		// may share a name.
+		single := camelCase(namespace) + camelCase(name)
+		many := camelCase(namespace) + camelCase(plural)
+		if many == single {
+			many += "List"
+		}
+
+		endpoints := []openAPIEndpoint{
+			{handler.CreateURI(), "post", handler.CreateDocumentation(),
+				"create" + single, true, false, http.StatusCreated},
+			{handler.ReadListURI(), "get", handler.ReadListDocumentation(),
+				"list" + many, false, true, http.StatusOK},
+			{handler.ReadURI(), "get", handler.ReadDocumentation(),
+				"get" + single, false, false, http.StatusOK},
+			{handler.UpdateListURI(), "put", handler.UpdateListDocumentation(),
+				"update" + many, true, true, http.StatusOK},
+			{handler.UpdateURI(), "put", handler.UpdateDocumentation(),
+				"update" + single, true, false, http.StatusOK},
+			{handler.DeleteURI(), "delete", handler.DeleteDocumentation(),
+				"delete" + single, false, false, http.StatusOK},
+		}
+		if updater, ok := handler.(PartialUpdater); ok {
+			endpoints = append(endpoints, openAPIEndpoint{updater.PatchURI(), "patch",
+				updater.PatchDocumentation(), "patch" + single, true, false,
+				http.StatusOK})
+		}
+
+		for _, e := range endpoints {
+			if e.doc == "" {
+				continue
+			}
+
+			operation := field{
+				"operationId": uniqueOperationID(operationIDs, e.operationID),
+				"description": e.doc,
+				"tags":        []string{tag},
+				"responses": field{
+					strconv.Itoa(e.status): openAPIResponse(rules, keys, e.list, version),
+				},
+			}
+			path, params := openAPIPath(e.uri, version)
+			if e.method == "get" && e.list {
+				params = append(params,
+					openAPIParameter(limitKey, "query", "Maximum number of results to return.", false),
+					openAPIParameter(cursorKey, "query", "Cursor for the next page of results.", false))
+			}
+			if len(params) > 0 {
+				operation["parameters"] = params
+			}
+			if e.input {
+				operation["requestBody"] = openAPIRequestBody(rules, e.list, version)
+			}
+
+			if paths[path] == nil {
+				paths[path] = field{}
+			}
+			paths[path][e.method] = operation
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": field{
+			"title":   "API",
+			"version": version,
+		},
+		"paths": paths,
+	}
+}
+
+// uniqueOperationID returns the id, suffixed with a number if it was already used by
+// another operation, and records it as used. OpenAPI requires operation ids to be
+// unique within a spec.
+func uniqueOperationID(used map[string]bool, id string) string {
+	unique := id
+	for i := 2; used[unique]; i++ {
+		unique = id + strconv.Itoa(i)
+	}
+	used[unique] = true
+	return unique
+}
+
+// camelCase returns the name with the first letter of each word capitalized and the
+// separators between words removed, e.g. "line_items" becomes "LineItems".
+func camelCase(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+	return strings.Join(words, "")
+}
+
+// openAPIPath returns the OpenAPI path for the URI with the version variable replaced
+// by the version, along with the descriptions of its remaining variables.
+func openAPIPath(uri, version string) (string, []field) {
+	uri = strings.Replace(uri, "{version:[^/]+}", version, -1)
+
+	params := []field{}
+	for _, match := range uriParamPattern.FindAllStringSubmatch(uri, -1) {
+		params = append(params, openAPIParameter(match[1], "path", "", true))
+	}
+
+	return uriParamPattern.ReplaceAllString(uri, "{$1}"), params
+}
+
+// openAPIParameter returns the description of a string parameter of an operation.
+func openAPIParameter(name, in, description string, required bool) field {
+	param := field{
+		"name":     name,
+		"in":       in,
+		"required": required,
+		"schema":   field{"type": "string"},
+	}
+	if description != "" {
+		param["description"] = description
+	}
+	return param
+}
+
+// openAPIRequestBody returns the description of the JSON request body accepted by an
+// operation, which is a list of resources if list is true.
+func openAPIRequestBody(rules Rules, list bool, version string) field {
+	schema := openAPISchema(rules, Inbound, version)
+	if list {
+		schema = field{"type": "array", "items": schema}
+	}
+
+	media := field{"schema": schema}
+	if example := buildExampleRequest(rules, list, version); example != "" {
+		media["example"] = json.RawMessage(example)
+	}
+
+	return field{
+		"required": true,
+		"content":  field{"application/json": media},
+	}
+}
+
+// openAPIResponse returns the description of the JSON response envelope sent by an
+// operation, holding a list of resources if list is true.
+func openAPIResponse(rules Rules, keys envelopeKeys, list bool, version string) field {
+	resultKey := keys.result
+	schema := openAPISchema(rules, Outbound, version)
+	if list {
+		resultKey = keys.results
+		schema = field{"type": "array", "items": schema}
+	}
+	if example := buildExampleResponse(rules, list, version); example != "" {
+		schema["example"] = json.RawMessage(example)
+	}
+
+	envelope := field{
+		"type": "object",
+		"properties": field{
+			status:    field{"type": "integer"},
+			reason:    field{"type": "string"},
+			messages:  field{"type": "array", "items": field{}},
+			resultKey: schema,
+		},
+	}
+
+	return field{
+		"description": "Success",
+		"content":     field{"application/json": field{"schema": envelope}},
+	}
+}
+
+// openAPISchema returns the schema of the object described by the Rules which apply to
+// the version in the direction given by the Filter.
+func openAPISchema(rules Rules, filter Filter, version string) field {
+	properties := field{}
+	required := []string{}
+	for _, rule := range rules.ForVersion(version).Filter(filter).Contents() {
+		properties[rule.Name()] = openAPIFieldSchema(rule, filter, version)
+		if filter == Inbound && rule.Required {
+			required = append(required, rule.Name())
+		}
+	}
+
+	schema := field{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// openAPIFieldSchema returns the schema of the field described by the Rule, derived
+// from its Type, nested Rules, and constraints.
+func openAPIFieldSchema(rule *Rule, filter Filter, version string) field {
+	schema := field{}
+	for key, value := range openAPITypes[typeToName[rule.Type]] {
+		schema[key] = value
+	}
+
+	if nested := rule.Rules; nested != nil && nested.Filter(filter).Size() > 0 {
+		schema = openAPISchema(nested, filter, version)
+		switch {
+		case rule.Type == Slice && rule.KeyBy != "" && filter == Outbound:
+			// Items are keyed by one of their fields.
+			schema = field{"type": "object", "additionalProperties": schema}
+		case rule.Type == Slice:
+			schema = field{"type": "array", "items": schema}
+		case rule.Type == Map:
+			// The nested Rules describe each value of the map.
+			schema = field{"type": "object", "additionalProperties": schema}
+		}
+	}
+
+	if rule.DocString != "" {
+		schema["description"] = rule.DocString
+	}
+	if rule.Pattern != "" {
+		schema["pattern"] = rule.Pattern
+	}
+	if rule.Min != nil {
+		schema["minimum"] = *rule.Min
+	}
+	if rule.Max != nil {
+		schema["maximum"] = *rule.Max
+	}
+	if len(rule.AllowedValues) > 0 {
+		schema["enum"] = rule.AllowedValues
+	}
+	if rule.MinItems > 0 {
+		schema["minItems"] = rule.MinItems
+	}
+	if rule.MaxItems > 0 {
+		schema["maxItems"] = rule.MaxItems
+	}
+	if rule.Default != nil {
+		schema["default"] = rule.Default
+	}
+	if rule.Deprecated {
+		schema["deprecated"] = true
+	}
+	if rule.OutputOnly || rule.isVirtualRule() {
+		schema["readOnly"] = true
+	}
+
+	return schema
+}
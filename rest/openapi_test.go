@@ -0,0 +1,238 @@
+/*
+Copyright 2014 - 2015 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Ensures that generateSpecs writes an OpenAPI spec per version with an operation
+// for each documented endpoint.
+func TestGenerateSpecs(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(NewConfiguration())
+	api.RegisterResourceHandler(&fooHandler{})
+	mockDocWriter := new(mockDocWriter)
+	mockDocWriter.On("Mkdir", "_docs/", os.FileMode(0777)).Return(nil)
+	mockDocWriter.On("Write", "_docs/openapi_v1.json", mock.Anything, os.FileMode(0644)).Return(nil)
+	generator := &openAPIGenerator{mockDocWriter}
+
+	assert.Nil(generator.generateSpecs(api))
+	mockDocWriter.AssertExpectations(t)
+
+	var spec map[string]interface{}
+	data := mockDocWriter.Calls[1].Arguments.Get(1).([]byte)
+	assert.Nil(json.Unmarshal(data, &spec))
+	assert.Equal(openAPIVersion, spec["openapi"])
+	assert.Equal("1", spec["info"].(map[string]interface{})["version"])
+
+	paths := spec["paths"].(map[string]interface{})
+	assert.Len(paths, 2)
+	list := paths["/api/v1/foo"].(map[string]interface{})
+	assert.Contains(list, "get")
+	assert.Contains(list, "post")
+	assert.Contains(list, "put")
+	single := paths["/api/v1/foo/{resource_id}"].(map[string]interface{})
+	assert.Contains(single, "get")
+	assert.Contains(single, "put")
+	assert.NotContains(single, "delete")
+
+	read := single["get"].(map[string]interface{})
+	assert.Equal("Retrieves a foo", read["description"])
+	params := read["parameters"].([]interface{})
+	assert.Len(params, 1)
+	assert.Equal("resource_id", params[0].(map[string]interface{})["name"])
+	assert.Equal("path", params[0].(map[string]interface{})["in"])
+
+	create := list["post"].(map[string]interface{})
+	assert.Contains(create["responses"], "201")
+	body := create["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})
+	schema := body["schema"].(map[string]interface{})
+	assert.Equal([]interface{}{"foo", "baz", "qux"}, schema["required"])
+	properties := schema["properties"].(map[string]interface{})
+	assert.Equal(map[string]interface{}{"type": "string", "description": "foo"}, properties["foo"])
+	assert.Equal(map[string]interface{}{"type": "integer", "description": "bar"}, properties["bar"])
+	assert.Equal("array", properties["baz"].(map[string]interface{})["type"])
+	assert.Equal("date-time", properties["qux"].(map[string]interface{})["format"])
+	assert.NotNil(body["example"])
+
+	readList := list["get"].(map[string]interface{})
+	response := readList["responses"].(map[string]interface{})["200"].(map[string]interface{})
+	envelope := response["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	results := envelope["properties"].(map[string]interface{})["results"].(map[string]interface{})
+	assert.Equal("array", results["type"])
+	assert.Equal("object", results["items"].(map[string]interface{})["type"])
+}
+
+// Ensures that generateSpecs returns an error when the directory fails to be created.
+func TestGenerateSpecsHandlesDirectoryFail(t *testing.T) {
+	api := setupAPI()
+	mockDocWriter := new(mockDocWriter)
+	mockDocWriter.On("Mkdir", "_docs/", os.FileMode(0777)).Return(fmt.Errorf("error"))
+	generator := &openAPIGenerator{mockDocWriter}
+
+	assert.NotNil(t, generator.generateSpecs(api))
+}
+
+// Ensures that openAPIPath substitutes the version and strips variable patterns.
+func TestOpenAPIPath(t *testing.T) {
+	assert := assert.New(t)
+
+	path, params := openAPIPath("/api/v{version:[^/]+}/foo/{foo_id:[0-9]+}/bar/{resource_id}", "2")
+
+	assert.Equal("/api/v2/foo/{foo_id}/bar/{resource_id}", path)
+	assert.Len(params, 2)
+	assert.Equal("foo_id", params[0]["name"])
+	assert.Equal("resource_id", params[1]["name"])
+}
+
+// Ensures that openAPIFieldSchema maps Rule constraints and nested Rules.
+func TestOpenAPIFieldSchema(t *testing.T) {
+	assert := assert.New(t)
+	min, max := 1.0, 10.0
+	rule := &Rule{
+		Field:         "Foo",
+		Type:          Int,
+		Min:           &min,
+		Max:           &max,
+		AllowedValues: []interface{}{1, 5, 10},
+	}
+
+	assert.Equal(field{
+		"type":    "integer",
+		"minimum": 1.0,
+		"maximum": 10.0,
+		"enum":    []interface{}{1, 5, 10},
+	}, openAPIFieldSchema(rule, Inbound, "1"))
+
+	nested := &Rule{
+		Field:    "Foos",
+		Type:     Slice,
+		MaxItems: 3,
+		Rules:    NewRules((*fooResource)(nil), &Rule{Field: "Foo", Type: String}),
+	}
+	schema := openAPIFieldSchema(nested, Outbound, "1")
+	assert.Equal("array", schema["type"])
+	assert.Equal(3, schema["maxItems"])
+	items := schema["items"].(field)
+	assert.Equal(field{"Foo": field{"type": "string"}}, items["properties"])
+}
+
+type namespacedFooHandler struct {
+	fooHandler
+}
+
+func (n *namespacedFooHandler) ResourceName() string {
+	return "line_item"
+}
+
+func (n *namespacedFooHandler) Namespace() string {
+	return "/billing/"
+}
+
+// Ensures that each operation has a deterministic operationId derived from its method,
+// namespace, and resource name, and is tagged with the resource or its namespace.
+func TestGenerateSpecsOperationIDsAndTags(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(NewConfiguration())
+	api.RegisterResourceHandler(&fooHandler{})
+	api.RegisterResourceHandler(&namespacedFooHandler{})
+	handlers := api.ResourceHandlers()
+
+	spec := openAPISpec(api, handlers, "1")
+	operations := map[string][]string{}
+	for path, methods := range spec["paths"].(map[string]field) {
+		for method, operation := range methods {
+			operation := operation.(field)
+			key := method + " " + path
+			operations[key] = []string{operation["operationId"].(string)}
+			operations[key] = append(operations[key], operation["tags"].([]string)...)
+		}
+	}
+
+	assert.Equal(map[string][]string{
+		"post /api/v1/foo":                            {"createFoo", "foo"},
+		"get /api/v1/foo":                             {"listFoos", "foo"},
+		"put /api/v1/foo":                             {"updateFoos", "foo"},
+		"get /api/v1/foo/{resource_id}":               {"getFoo", "foo"},
+		"put /api/v1/foo/{resource_id}":               {"updateFoo", "foo"},
+		"post /api/v1/billing/line_item":              {"createBillingLineItem", "billing"},
+		"get /api/v1/billing/line_item":               {"listBillingLineItems", "billing"},
+		"put /api/v1/billing/line_item":               {"updateBillingLineItems", "billing"},
+		"get /api/v1/billing/line_item/{resource_id}": {"getBillingLineItem", "billing"},
+		"put /api/v1/billing/line_item/{resource_id}": {"updateBillingLineItem", "billing"},
+	}, operations)
+
+	first, _ := json.Marshal(spec)
+	second, _ := json.Marshal(openAPISpec(api, handlers, "1"))
+	assert.Equal(string(first), string(second))
+}
+
+type sheepHandler struct {
+	fooHandler
+}
+
+func (s *sheepHandler) ResourceName() string {
+	return "sheep"
+}
+
+type otherFooHandler struct {
+	fooHandler
+}
+
+func (o *otherFooHandler) ReadURI() string {
+	return "/api/v{version:[^/]+}/other_foo/{resource_id}"
+}
+
+// Ensures that operationIds are unique when a resource's plural name is the same as its
+// name and when resources share a name.
+func TestGenerateSpecsOperationIDsUnique(t *testing.T) {
+	assert := assert.New(t)
+	config := NewConfiguration()
+	config.IrregularPlurals = map[string]string{"sheep": "sheep"}
+	api := NewAPI(config)
+	api.RegisterResourceHandler(&sheepHandler{})
+
+	spec := openAPISpec(api, api.ResourceHandlers(), "1")
+	paths := spec["paths"].(map[string]field)
+	assert.Equal("updateSheepList", paths["/api/v1/sheep"]["put"].(field)["operationId"])
+	assert.Equal("updateSheep", paths["/api/v1/sheep/{resource_id}"]["put"].(field)["operationId"])
+
+	api = NewAPI(NewConfiguration())
+	api.RegisterResourceHandler(&fooHandler{})
+	api.RegisterResourceHandler(&otherFooHandler{})
+	spec = openAPISpec(api, api.ResourceHandlers(), "1")
+	paths = spec["paths"].(map[string]field)
+	assert.Equal("getFoo", paths["/api/v1/foo/{resource_id}"]["get"].(field)["operationId"])
+	assert.Equal("getFoo2", paths["/api/v1/other_foo/{resource_id}"]["get"].(field)["operationId"])
+}
+
+// Ensures that camelCase capitalizes each word and removes separators.
+func TestCamelCase(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("Foo", camelCase("foo"))
+	assert.Equal("LineItems", camelCase("line_items"))
+	assert.Equal("BillingLineItem", camelCase("billing/line-item"))
+	assert.Equal("", camelCase(""))
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2014 - 2015 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimitExceeded is the body of responses to rate-limited requests.
+const rateLimitExceeded = "Rate limit exceeded."
+
+// overrideSuffix is appended to the names of X-HTTP-Method-Override routes.
+const overrideSuffix = "Override"
+
+// parseRouteName splits the name of a route registered by RegisterResourceHandler,
+// i.e. resourceName:method, into the resource name and HandleMethod. Routes using
+// X-HTTP-Method-Override report the method they override. Both are empty if the name
+// isn't a resource route name.
+func parseRouteName(name string) (string, HandleMethod) {
+	i := strings.LastIndex(name, ":")
+	if i < 0 {
+		return "", ""
+	}
+	return name[:i], HandleMethod(strings.TrimSuffix(name[i+1:], overrideSuffix))
+}
+
+// currentRoute returns the resource name and HandleMethod of the route matched for
+// the request, which are empty if it wasn't routed to a ResourceHandler.
+func currentRoute(r *http.Request) (string, HandleMethod) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", ""
+	}
+	return parseRouteName(route.GetName())
+}
+
+// sweepInterval is how often a rateLimiter evicts the buckets which have refilled.
+const sweepInterval = time.Minute
+
+// RateLimitPolicy describes a token-bucket rate limit. RequestsPerSecond is the rate
+// at which tokens are replenished and Burst is the maximum number of tokens which can
+// accumulate. A policy with a non-positive RequestsPerSecond is unlimited. A
+// non-positive Burst defaults to one second's worth of requests, rounded up, and at
+// least 1.
+type RateLimitPolicy struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// unlimited returns true if the policy does not restrict requests.
+func (p RateLimitPolicy) unlimited() bool {
+	return p.RequestsPerSecond <= 0
+}
+
+// burst returns the maximum number of tokens which can accumulate under the policy.
+func (p RateLimitPolicy) burst() float64 {
+	if p.Burst > 0 {
+		return float64(p.Burst)
+	}
+	return math.Max(1, math.Ceil(p.RequestsPerSecond))
+}
+
+// tokenBucket tracks the available tokens for a single rate-limited key.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // When the bucket will have refilled to the policy's burst.
+}
+
+// rateLimiter is a collection of token buckets keyed by an arbitrary string, e.g. a
+// client IP. Buckets which have refilled are indistinguishable from new ones, so they
+// are evicted periodically, keeping only the keys which were recently limited.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+// newRateLimiter returns a rateLimiter with no buckets.
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*tokenBucket{}, now: time.Now}
+}
+
+// allow returns true if a request for the given key is permitted under the policy,
+// consuming a token if so. Otherwise, it returns false and how long to wait until a
+// token is available.
+func (l *rateLimiter) allow(key string, policy RateLimitPolicy) (bool, time.Duration) {
+	if policy.unlimited() {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.evict(now)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: policy.burst(), last: now}
+		l.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens += elapsed * policy.RequestsPerSecond
+	if burst := policy.burst(); bucket.tokens > burst {
+		bucket.tokens = burst
+	}
+	bucket.last = now
+
+	allowed := bucket.tokens >= 1
+	var wait time.Duration
+	if allowed {
+		bucket.tokens--
+	} else {
+		wait = secondsToDuration((1 - bucket.tokens) / policy.RequestsPerSecond)
+	}
+	refill := (policy.burst() - bucket.tokens) / policy.RequestsPerSecond
+	bucket.full = now.Add(secondsToDuration(refill))
+	return allowed, wait
+}
+
+// secondsToDuration returns the number of seconds as a Duration.
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// evict removes the buckets which have refilled since they were last used, at most
+// once per sweepInterval. The caller must hold the lock.
+func (l *rateLimiter) evict(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, bucket := range l.buckets {
+		if !now.Before(bucket.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitHeaders returns the headers of a response to a request which was rate
+// limited and must wait before it's retried.
+func rateLimitHeaders(wait time.Duration) http.Header {
+	return http.Header{"Retry-After": []string{retryAfterSeconds(wait)}}
+}
+
+// writeRateLimitExceeded writes the response to a request which was rate limited and
+// must wait before it's retried.
+func writeRateLimitExceeded(w http.ResponseWriter, wait time.Duration) {
+	for key, values := range rateLimitHeaders(wait) {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(rateLimitExceeded))
+}
+
+// EndpointRateLimit returns a RequestMiddleware which rate limits each client per
+// endpoint. Limits are keyed by route name, i.e. resourceName:method as registered by
+// RegisterResourceHandler (e.g. "widgets:readList"), and X-HTTP-Method-Override routes
+// share the limit of the method they override. Routes without an entry use the
+// fallback policy. Requests exceeding the limit receive a 429 Too Many Requests with
+// a Retry-After header.
+func EndpointRateLimit(limits map[string]RateLimitPolicy, fallback RateLimitPolicy) RequestMiddleware {
+	limiter := newRateLimiter()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := ""
+			if resource, method := currentRoute(r); resource != "" {
+				name = resource + ":" + string(method)
+			}
+
+			policy, ok := limits[name]
+			if !ok {
+				policy = fallback
+			}
+
+			if allowed, wait := limiter.allow(name+"|"+clientIP(r), policy); !allowed {
+				writeRateLimitExceeded(w, wait)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimit returns a Middleware which limits each client, identified by IP, to
+// requestsPerSecond requests across the API, allowing bursts of up to burst requests.
+// A non-positive burst defaults to requestsPerSecond, and at least 1. Requests
+// exceeding the limit receive a 429 Too Many Requests with a Retry-After header. Use
+// ResourceRateLimit to limit requests to each resource separately.
+func RateLimit(requestsPerSecond int, burst int) Middleware {
+	limiter := newRateLimiter()
+	policy := RateLimitPolicy{RequestsPerSecond: float64(requestsPerSecond), Burst: burst}
+	return func(w http.ResponseWriter, r *http.Request) *MiddlewareError {
+		if allowed, wait := limiter.allow(clientIP(r), policy); !allowed {
+			return &MiddlewareError{
+				Code:     http.StatusTooManyRequests,
+				Response: []byte(rateLimitExceeded),
+				Headers:  rateLimitHeaders(wait),
+			}
+		}
+		return nil
+	}
+}
+
+// ResourceRateLimit returns a RequestMiddleware which limits each client, identified
+// by IP, to requestsPerSecond requests to each resource it's registered with using
+// RegisterResourceHandler, allowing bursts of up to burst requests. A non-positive
+// burst defaults to requestsPerSecond, and at least 1. Requests exceeding the limit
+// receive a 429 Too Many Requests with a Retry-After header.
+func ResourceRateLimit(requestsPerSecond int, burst int) RequestMiddleware {
+	limiter := newRateLimiter()
+	policy := RateLimitPolicy{RequestsPerSecond: float64(requestsPerSecond), Burst: burst}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resource, _ := currentRoute(r)
+			if allowed, wait := limiter.allow(resource+"|"+clientIP(r), policy); !allowed {
+				writeRateLimitExceeded(w, wait)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP returns the IP address of the client which sent the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2014 - 2015 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// allowed returns true if the rateLimiter permits a request for the key.
+func allowed(limiter *rateLimiter, key string, policy RateLimitPolicy) bool {
+	ok, _ := limiter.allow(key, policy)
+	return ok
+}
+
+// Ensures that rateLimiter permits bursts up to the policy limit and replenishes
+// tokens over time.
+func TestRateLimiterAllow(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter()
+	limiter.now = func() time.Time { return now }
+	policy := RateLimitPolicy{RequestsPerSecond: 1, Burst: 2}
+
+	assert.True(allowed(limiter, "a", policy))
+	assert.True(allowed(limiter, "a", policy))
+	assert.False(allowed(limiter, "a", policy))
+
+	// Other keys have their own bucket.
+	assert.True(allowed(limiter, "b", policy))
+
+	now = now.Add(time.Second)
+	assert.True(allowed(limiter, "a", policy))
+	assert.False(allowed(limiter, "a", policy))
+}
+
+// Ensures that a policy without a positive Burst defaults to one second's worth of
+// requests, and at least 1, rather than limiting every request.
+func TestRateLimiterDefaultBurst(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter()
+	limiter.now = func() time.Time { return now }
+
+	policy := RateLimitPolicy{RequestsPerSecond: 2.5}
+	for i := 0; i < 3; i++ {
+		assert.True(allowed(limiter, "a", policy))
+	}
+	assert.False(allowed(limiter, "a", policy))
+
+	policy = RateLimitPolicy{RequestsPerSecond: 0.5, Burst: -1}
+	assert.True(allowed(limiter, "b", policy))
+	assert.False(allowed(limiter, "b", policy))
+	now = now.Add(2 * time.Second)
+	assert.True(allowed(limiter, "b", policy))
+}
+
+// Ensures that rateLimiter returns how long to wait for a token when it limits a
+// request.
+func TestRateLimiterWait(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter()
+	limiter.now = func() time.Time { return now }
+	policy := RateLimitPolicy{RequestsPerSecond: 0.5, Burst: 1}
+
+	ok, wait := limiter.allow("a", policy)
+	assert.True(ok)
+	assert.Equal(time.Duration(0), wait)
+
+	ok, wait = limiter.allow("a", policy)
+	assert.False(ok)
+	assert.Equal(2*time.Second, wait)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, wait = limiter.allow("a", policy)
+	assert.False(ok)
+	assert.Equal(1500*time.Millisecond, wait)
+}
+
+// Ensures that rateLimiter evicts buckets once they've refilled, so keys which are no
+// longer limited don't accumulate.
+func TestRateLimiterEvictsRefilledBuckets(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter()
+	limiter.now = func() time.Time { return now }
+	policy := RateLimitPolicy{RequestsPerSecond: 1, Burst: 100}
+
+	assert.True(allowed(limiter, "a", policy))
+	for i := 0; i < 100; i++ {
+		allowed(limiter, "b", policy)
+	}
+	assert.Len(limiter.buckets, 2)
+
+	// "a" refills after a second, but "b" takes 100 seconds.
+	now = now.Add(sweepInterval)
+	assert.True(allowed(limiter, "c", policy))
+	assert.Len(limiter.buckets, 2)
+	assert.NotContains(limiter.buckets, "a")
+	assert.Contains(limiter.buckets, "b")
+
+	// "b" still has to wait for tokens after the sweep.
+	assert.True(allowed(limiter, "b", policy))
+	now = now.Add(sweepInterval)
+	assert.True(allowed(limiter, "c", policy))
+	assert.Len(limiter.buckets, 1)
+}
+
+// Ensures that rateLimiter never limits an unlimited policy.
+func TestRateLimiterUnlimited(t *testing.T) {
+	limiter := newRateLimiter()
+	for i := 0; i < 100; i++ {
+		assert.True(t, allowed(limiter, "a", RateLimitPolicy{}))
+	}
+}
+
+// Ensures that EndpointRateLimit enforces a separate limit per endpoint.
+func TestEndpointRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	handler := new(MockResourceHandler)
+	handler.On("ResourceName").Return("widgets")
+	handler.On("Authenticate").Return(nil)
+	handler.On("ValidVersions").Return(nil)
+	handler.On("Rules").Return(&rules{})
+	handler.On("ReadResource").Return(&TestResource{Foo: "hello"}, nil)
+	handler.On("ReadResourceList").Return([]Resource{}, "", nil)
+
+	api.RegisterResourceHandler(handler, EndpointRateLimit(map[string]RateLimitPolicy{
+		"widgets:read":     {RequestsPerSecond: 0.001, Burst: 1},
+		"widgets:readList": {RequestsPerSecond: 0.001, Burst: 3},
+	}, RateLimitPolicy{}))
+
+	var w *httptest.ResponseRecorder
+	serve := func(method, url string, header http.Header) int {
+		req, _ := http.NewRequest(method, url, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if header != nil {
+			req.Header = header
+		}
+		w = httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(http.StatusOK, serve("GET", "http://example.com/api/v1/widgets/1", nil))
+	assert.Equal(429, serve("GET", "http://example.com/api/v1/widgets/1", nil))
+	assert.Equal("1000", w.Header().Get("Retry-After"))
+
+	// Method override routes share the limit of the overridden method.
+	assert.Equal(429, serve("POST", "http://example.com/api/v1/widgets/1",
+		http.Header{"X-Http-Method-Override": []string{"GET"}}))
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(http.StatusOK, serve("GET", "http://example.com/api/v1/widgets", nil))
+	}
+	assert.Equal(429, serve("GET", "http://example.com/api/v1/widgets", nil))
+}
+
+// Ensures that RateLimit limits each client across the API.
+func TestRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(LimitResourceHandler{})
+	handler := api.Handler(RateLimit(1, 3))
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "http://example.com/api/v1/widgets", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(http.StatusOK, serve("10.0.0.1:1234").Code)
+	}
+	w := serve("10.0.0.1:5678")
+	assert.Equal(429, w.Code)
+	assert.Equal("1", w.Header().Get("Retry-After"))
+	assert.Equal("Rate limit exceeded.", w.Body.String())
+
+	// Other clients have their own limit.
+	assert.Equal(http.StatusOK, serve("10.0.0.2:1234").Code)
+
+	// A non-positive burst defaults to the rate.
+	handler = api.Handler(RateLimit(1, 0))
+	assert.Equal(http.StatusOK, serve("10.0.0.3:1234").Code)
+	assert.Equal(429, serve("10.0.0.3:1234").Code)
+}
+
+type GadgetResourceHandler struct {
+	MultiReadResourceHandler
+}
+
+func (g GadgetResourceHandler) ResourceName() string {
+	return "gadgets"
+}
+
+// Ensures that ResourceRateLimit limits each client per resource.
+func TestResourceRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	limit := ResourceRateLimit(1, 2)
+	api.RegisterResourceHandler(LimitResourceHandler{}, limit)
+	api.RegisterResourceHandler(GadgetResourceHandler{}, limit)
+
+	serve := func(url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(http.StatusOK, serve("http://example.com/api/v1/widgets").Code)
+	assert.Equal(http.StatusOK, serve("http://example.com/api/v1/widgets?limit=1").Code)
+	w := serve("http://example.com/api/v1/widgets")
+	assert.Equal(429, w.Code)
+	assert.Equal("1", w.Header().Get("Retry-After"))
+
+	// Other resources have their own limit.
+	assert.Equal(http.StatusOK, serve("http://example.com/api/v1/gadgets/1").Code)
+}
@@ -17,10 +17,15 @@ limitations under the License.
 package rest
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // TODO:
@@ -28,8 +33,6 @@ import (
 //    E.g. https://github.com/Assembli/beautiful-validity
 //    This would allow for semantic validation and custom validation logic.
 //    For now, we are only providing type validation.
-//
-//  - Make type coercion pluggable (i.e. conversion/validation of custom types).
 
 // Filter is a category for filtering Rules.
 type Filter bool
@@ -40,6 +43,13 @@ const (
 	Outbound Filter = false
 )
 
+// sensitiveMask replaces the values of Sensitive fields in audited Payloads.
+const sensitiveMask = "********"
+
+// patterns caches the compiled regular expressions of Rule Patterns by pattern so
+// each is compiled once.
+var patterns sync.Map
+
 // Rules is a collection of Rules and a reflect.Type which they correspond to.
 type Rules interface {
 	// Contents returns the contained Rules.
@@ -95,6 +105,45 @@ func (r *rules) Validate() error {
 			return fmt.Errorf("Invalid Rule: must have Field or FieldAlias")
 		}
 
+		if rule.Pattern != "" {
+			if _, err := compilePattern(rule.Pattern); err != nil {
+				return fmt.Errorf(
+					"Invalid Rule for %s: field '%s' has invalid pattern: %s",
+					resourceType, rule.Name(), err)
+			}
+		}
+
+		if rule.Min != nil || rule.Max != nil {
+			if !isNumeric(rule.Type) {
+				return fmt.Errorf(
+					"Invalid Rule for %s: field '%s' has Min or Max but is type %s, "+
+						"not a numeric type", resourceType, rule.Name(), typeToName[rule.Type])
+			}
+			if rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max {
+				return fmt.Errorf(
+					"Invalid Rule for %s: field '%s' has Min %v greater than Max %v",
+					resourceType, rule.Name(), *rule.Min, *rule.Max)
+			}
+		}
+
+		if rule.Default != nil {
+			value, err := defaultValue(rule)
+			if err == nil {
+				err = checkPattern(value, rule)
+			}
+			if err == nil {
+				err = checkRange(value, rule)
+			}
+			if err == nil {
+				err = checkAllowedValues(value, rule)
+			}
+			if err != nil {
+				return fmt.Errorf(
+					"Invalid Rule for %s: field '%s' has invalid default: %s",
+					resourceType, rule.Name(), err)
+			}
+		}
+
 		if rule.isResourceRule() {
 			if field, ok := resourceType.FieldByName(rule.Field); !ok {
 				return fmt.Errorf(
@@ -107,6 +156,25 @@ func (r *rules) Validate() error {
 			}
 		}
 
+		if rule.Coerce != nil && rule.Rules != nil {
+			return fmt.Errorf(
+				"Invalid Rule for %s: field '%s' has both Coerce and nested Rules",
+				resourceType, rule.Name())
+		}
+
+		if rule.KeyBy != "" {
+			if typeToKind[rule.Type] != reflect.Slice || rule.Rules == nil {
+				return fmt.Errorf(
+					"Invalid Rule for %s: field '%s' has KeyBy but is not a Slice with "+
+						"nested Rules", resourceType, rule.Name())
+			}
+			if !hasRuleNamed(rule.Rules.Filter(Outbound), rule.KeyBy) {
+				return fmt.Errorf(
+					"Invalid Rule for %s: field '%s' is keyed by '%s', which is not a "+
+						"nested output field", resourceType, rule.Name(), rule.KeyBy)
+			}
+		}
+
 		// Validate nested Rules.
 		if rule.Rules != nil {
 			// If a rule is on a slice, check to see what the underlying type is.
@@ -119,6 +187,16 @@ func (r *rules) Validate() error {
 					}
 				}
 			}
+			// If a rule is on a map, the nested Rules describe each value. If the
+			// values are primitive, there is nothing to validate.
+			if typeToKind[rule.Type] == reflect.Map {
+				nestedType := rule.Rules.ResourceType().Kind()
+				if nestedType == reflect.Struct || nestedType == reflect.Map {
+					if err := rule.Rules.Validate(); err != nil {
+						return err
+					}
+				}
+			}
 		}
 	}
 
@@ -130,11 +208,11 @@ func (r *rules) Validate() error {
 func (r *rules) Filter(filter Filter) Rules {
 	filtered := make([]*Rule, 0, len(r.contents))
 	for _, rule := range r.contents {
-		if filter == Inbound && rule.OutputOnly {
-			// Filter out outbound Rules.
+		if filter == Inbound && (rule.OutputOnly || rule.isVirtualRule()) {
+			// Filter out outbound Rules, including virtual fields.
 			continue
 		} else if filter == Outbound && rule.InputOnly ||
-			filter == Outbound && !rule.isResourceRule() {
+			filter == Outbound && !rule.isResourceRule() && !rule.isVirtualRule() {
 			// Filter out inbound Rules.
 			continue
 		}
@@ -197,9 +275,20 @@ type Rule struct {
 	// an interface{} value.
 	Type Type
 
-	// Indicates if the field must have a value. Defaults to false.
+	// Indicates if the field must have a value. Defaults to false. Empty strings,
+	// slices, and maps don't satisfy it unless AllowEmptyRequired is set.
 	Required bool
 
+	// Indicates if empty string, slice, and map values satisfy Required.
+	AllowEmptyRequired bool
+
+	// Value to receive, coerced to the Type, when requests omit the field, so
+	// handlers can rely on its key being present. A field explicitly sent as null
+	// keeps its null value. Not applied to partial updates. It must satisfy the
+	// Pattern, Min and Max, and AllowedValues, and Map and Slice values are copied
+	// for each request.
+	Default interface{}
+
 	// Versions is a list of the API versions this Rule applies to. If empty, it will
 	// be applied to all versions.
 	Versions []string
@@ -210,15 +299,101 @@ type Rule struct {
 	// Indicates if the Rule should only be applied to responses.
 	OutputOnly bool
 
+	// Indicates if the field can only be set when the resource is created. When an
+	// update or patch request sends the field, the resource is first read using
+	// ReadResource, whose error is sent back if it fails. Requests which change the
+	// field are rejected with a 422, while unchanged values are dropped from their
+	// payload. Update list requests which send the field are always rejected, since
+	// their items aren't read. It's flagged in documentation.
+	WriteOnce bool
+
+	// Indicates if the field should be omitted from responses when its value is
+	// empty, following the semantics of encoding/json's omitempty option: false, 0,
+	// a nil pointer or interface, and any empty string, slice, array, or map.
+	OmitEmpty bool
+
+	// Indicates if numeric field values should be sent as strings, e.g. so JavaScript
+	// clients don't lose precision on int64 ids beyond 2^53. Only affects responses.
+	SerializeAsString bool
+
+	// Indicates if the field is deprecated. Requests which send the field and
+	// responses which include it carry a warning, and it's flagged in documentation.
+	Deprecated bool
+
+	// Message explaining the field's deprecation, e.g. which field replaces it.
+	// Defaults to "Field '<name>' is deprecated".
+	DeprecatedMessage string
+
+	// Indicates if the field holds sensitive data, such as credentials or personal
+	// information. Its value is masked in the payload passed to
+	// Configuration.AuditWrite.
+	Sensitive bool
+
+	// Function which converts incoming field values, e.g. a string to a uuid.UUID,
+	// returning an error if the value is invalid. It's used in place of the Type's
+	// built-in coercion, can't be combined with nested Rules, and runs before
+	// InputHandler. It isn't invoked for null values.
+	Coerce func(interface{}) (interface{}, error)
+
 	// Function which produces the field value to receive.
 	InputHandler func(interface{}) interface{}
 
 	// Function which produces the field value to send.
 	OutputHandler func(interface{}) interface{}
 
-	// Nested Rules to apply to field value.
+	// Function which produces the field value to send given the RequestContext. It
+	// takes precedence over OutputHandler. On a non-resource Rule, it's passed the
+	// whole resource as returned by the ResourceHandler, e.g. a pointer to a struct,
+	// and its result is sent as a virtual field, e.g. is_active derived from an
+	// expiration date. Virtual fields are output only, so values received for them
+	// are discarded. The RequestContext is nil when Rules are applied outside of a
+	// request, e.g. to the examples in generated documentation.
+	OutputHandlerCtx func(RequestContext, interface{}) interface{}
+
+	// Name of the resource identified by the field's value, e.g. "customers" for a
+	// customer_id field. Responses include a link to the related resource's read
+	// endpoint in their "links" object, keyed by the field name without its "_id"
+	// suffix. Only affects responses.
+	RelatedResource string
+
+	// Nested Rules to apply to field value. For a Map field, they're applied to each
+	// value of the map, or each item of values which are slices.
 	Rules Rules
 
+	// Name of a nested Rule's field by which the items of a Slice field are keyed in
+	// responses, e.g. "id" to send {"<id>": {...}} instead of an array for lookup by
+	// id. Items without a value for the field are omitted, as are items whose value
+	// was already used by an earlier item. Only affects responses.
+	KeyBy string
+
+	// Indicates if string values should be decoded as JSON before nested Rules or a
+	// Map or Slice Type are applied, for clients which double-encode nested objects,
+	// e.g. {"meta": "{\"a\": 1}"}. Only affects requests.
+	ParseJSONString bool
+
+	// Regular expression which string values of the field must match, checked
+	// after type coercion. Requests with values which don't match are rejected. It
+	// is listed as a constraint in documentation.
+	Pattern string
+
+	// Minimum and maximum values accepted for numeric fields, if any, checked after
+	// type coercion. Requests with values out of range are rejected. They require a
+	// numeric Type and are listed as constraints in documentation.
+	Min *float64
+	Max *float64
+
+	// Values accepted for the field, e.g. []interface{}{"active", "archived"},
+	// checked after type coercion. Numbers match regardless of their Go type, so
+	// int values are accepted for an Int64 field. Requests with other values are
+	// rejected. They are listed as a constraint in documentation.
+	AllowedValues []interface{}
+
+	// Minimum and maximum number of elements accepted for slice fields. Requests
+	// with fewer or more elements are rejected before the elements are processed.
+	// Zero means unlimited.
+	MinItems int
+	MaxItems int
+
 	// Description used in documentation.
 	DocString string
 
@@ -261,6 +436,14 @@ func (r Rule) validType(fieldType reflect.Type) bool {
 	return fieldType.Kind() == kind
 }
 
+// deprecationWarning returns the warning sent when the deprecated field is used.
+func (r Rule) deprecationWarning() string {
+	if r.DeprecatedMessage != "" {
+		return r.DeprecatedMessage
+	}
+	return fmt.Sprintf("Field '%s' is deprecated", r.Name())
+}
+
 // isResourceRule returns true if this Rule corresponds to a resource field, false
 // if not. Non-resource Rules allow you to specify input fields that do not directly
 // correspond to a resource.
@@ -268,6 +451,12 @@ func (r Rule) isResourceRule() bool {
 	return r.Field != ""
 }
 
+// isVirtualRule returns true if this Rule is a non-resource Rule whose output value
+// is computed from the whole resource by its OutputHandlerCtx.
+func (r Rule) isVirtualRule() bool {
+	return !r.isResourceRule() && r.OutputHandlerCtx != nil
+}
+
 // applyInboundRules applies Rules which are not specified as output only to the
 // provided Payload. If the Payload is nil, an empty Payload will be returned. If no
 // Rules are provided, this acts as an identity function. If Rules are provided, any
@@ -276,6 +465,17 @@ func (r Rule) isResourceRule() bool {
 // returned. If Rules specify nested Rules, they will be recursively applied to the
 // field value, taking precedence over a type coercion.
 func applyInboundRules(payload Payload, rules Rules, version string) (Payload, error) {
+	return applyInboundRulesCollect(payload, rules, version, false, requestLogger{})
+}
+
+// applyInboundRulesCollect applies inbound Rules like applyInboundRules. If collect
+// is true, validation continues past the first failure and the returned error is a
+// ValidationErrors describing every invalid field. Otherwise the first error
+// encountered is returned. Discarded fields and failures are logged using the
+// requestLogger.
+func applyInboundRulesCollect(payload Payload, rules Rules, version string,
+	collect bool, logger requestLogger) (Payload, error) {
+
 	if payload == nil {
 		return Payload{}, nil
 	}
@@ -283,57 +483,414 @@ func applyInboundRules(payload Payload, rules Rules, version string) (Payload, e
 	// Apply only inbound Rules.
 	rules = rules.Filter(true).ForVersion(version)
 
+	return applyFilteredInboundRules(payload, rules, version, collect, false, logger)
+}
+
+// applyFilteredInboundRules applies inbound Rules like applyInboundRulesCollect,
+// except the Rules must already be filtered to the inbound Rules for the version.
+// This allows the filtered Rules to be shared across the Payloads of a batch. If
+// partial is true, as for partial updates, required fields are not enforced.
+func applyFilteredInboundRules(payload Payload, rules Rules, version string,
+	collect, partial bool, logger requestLogger) (Payload, error) {
+
+	if payload == nil {
+		return Payload{}, nil
+	}
+
 	if rules.Size() == 0 {
 		return payload, nil
 	}
 
 	newPayload := Payload{}
+	errs := ValidationErrors{}
 
 fieldLoop:
 	for field, value := range payload {
 		for _, rule := range rules.Contents() {
 			if rule.Name() == field {
-				if nestedInboundRulesApply(value, rule.Rules, version) {
+				name := inboundName(rule, rules)
+				if _, ok := newPayload[name]; ok && name != field {
+					// The primary alias takes precedence over secondary aliases.
+					continue fieldLoop
+				}
+
+				value, err := parseJSONString(value, rule)
+
+				// Check the item count before processing any slice elements.
+				if err == nil {
+					err = checkItemCount(value, rule)
+				}
+				code := CodeInvalidValue
+				if err == nil && rule.Coerce != nil {
+					// Custom coercion takes precedence over types.
+					if value != nil {
+						code = CodeTypeMismatch
+						value, err = rule.Coerce(value)
+					}
+					if err != nil && !collect {
+						err = fmt.Errorf("Field '%s' is invalid: %w", rule.Name(), err)
+					}
+				} else if err == nil && nestedInboundRulesApply(value, rule.Rules, version) {
 					// Nested Rules take precedence over type coercion.
-					v, err := applyNestedInboundRules(value, rule.Rules, version)
-					if err != nil {
-						return nil, err
+					if rule.Type == Map {
+						value, err = applyNestedMapInboundRules(value, rule.Rules, version, logger)
+					} else {
+						value, err = applyNestedInboundRules(value, rule.Rules, version, logger)
 					}
-					value = v
-				} else if rule.Type != Unspecified {
+				} else if err == nil && rule.Type != Unspecified {
 					// Coerce to specified type.
-					coerced, err := coerceType(value, rule.Type)
-					if err != nil {
+					code = CodeTypeMismatch
+					value, err = coerceType(value, rule.Type)
+				}
+				if err == nil {
+					err = checkPattern(value, rule)
+				}
+				if err == nil {
+					err = checkRange(value, rule)
+				}
+				if err == nil {
+					err = checkAllowedValues(value, rule)
+				}
+				if err != nil {
+					if !collect {
 						return nil, err
 					}
-					value = coerced
+					errs = append(errs, newFieldError(field, code, err))
+					continue fieldLoop
 				}
 
 				if rule.InputHandler != nil {
 					value = rule.InputHandler(value)
 				}
 
-				newPayload[field] = value
+				newPayload[name] = value
 				continue fieldLoop
 			}
 		}
 
-		log.Printf("Discarding field '%s'", field)
+		logger.Printf("Discarding field '%s'", field)
 	}
 
-	// Ensure no required fields are missing.
-	if err := enforceRequiredFields(rules, newPayload); err != nil {
-		log.Println(err)
-		return nil, err
+	if !partial {
+		if err := applyDefaults(rules, newPayload); err != nil {
+			return nil, err
+		}
+	}
+
+	if !collect {
+		// Ensure no required fields are missing.
+		if partial {
+			return newPayload, nil
+		}
+		if err := enforceRequiredFields(rules, newPayload); err != nil {
+			logger.Println(err)
+			return nil, err
+		}
+		return newPayload, nil
+	}
+
+	// Payload iteration order is random, so report field errors in a stable order.
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	if !partial {
+		errs = append(errs, missingRequiredFields(rules, newPayload)...)
+	}
+	if len(errs) > 0 {
+		logger.Println(errs)
+		return nil, errs
 	}
 
 	return newPayload, nil
 }
 
+// applyDefaults sets the Default of each Rule which has one on the Payload if the
+// Payload doesn't have a value for the Rule.
+func applyDefaults(rules Rules, payload Payload) error {
+	for _, rule := range rules.Contents() {
+		if rule.Default == nil {
+			continue
+		}
+
+		name := inboundName(rule, rules)
+		if _, ok := payload[name]; ok {
+			continue
+		}
+
+		value, err := defaultValue(rule)
+		if err != nil {
+			return fmt.Errorf("Invalid default for field '%s': %s", rule.Name(), err)
+		}
+		payload[name] = value
+	}
+	return nil
+}
+
+// defaultValue returns the Rule's Default coerced to its Type. Numeric defaults of
+// any Go type are coerced, e.g. an int Default for an Int64 field, and defaults which
+// can't be coerced but already have the Type's kind, e.g. a time.Time, are kept. Map
+// and Slice defaults are copied so payloads never share them.
+func defaultValue(rule *Rule) (interface{}, error) {
+	value := copyValue(rule.Default)
+	if n, ok := numericValue(value); ok {
+		value = n
+	}
+
+	coerced, err := coerceType(value, rule.Type)
+	if err != nil {
+		if reflect.ValueOf(rule.Default).Kind() == typeToKind[rule.Type] {
+			return value, nil
+		}
+		return nil, err
+	}
+	return coerced, nil
+}
+
+// copyValue returns a deep copy of the value if it's a map or slice, copying nested
+// maps and slices. Other values are returned as is.
+func copyValue(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			return value
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			copied.SetMapIndex(key, copyElem(v.MapIndex(key), v.Type().Elem()))
+		}
+		return copied.Interface()
+	case reflect.Slice:
+		if v.IsNil() {
+			return value
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(copyElem(v.Index(i), v.Type().Elem()))
+		}
+		return copied.Interface()
+	}
+	return value
+}
+
+// copyElem returns a deep copy of the map or slice element, converted back to the
+// element type.
+func copyElem(elem reflect.Value, elemType reflect.Type) reflect.Value {
+	if !elem.IsValid() || (elem.Kind() == reflect.Interface && elem.IsNil()) {
+		return reflect.Zero(elemType)
+	}
+	copied := reflect.ValueOf(copyValue(elem.Interface()))
+	if copied.Type() != elemType {
+		copied = copied.Convert(elemType)
+	}
+	return copied
+}
+
+// parseJSONString decodes the value as JSON if it's a string and the Rule has
+// ParseJSONString set along with nested Rules or a Map or Slice Type. Otherwise, the
+// value is returned as is.
+func parseJSONString(value interface{}, rule *Rule) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok || !rule.ParseJSONString {
+		return value, nil
+	}
+
+	if kind := typeToKind[rule.Type]; rule.Rules == nil &&
+		kind != reflect.Map && kind != reflect.Slice {
+		return value, nil
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+		return nil, &FieldError{
+			Field:   rule.Name(),
+			Code:    CodeInvalidJSON,
+			Message: fmt.Sprintf("Field '%s' is not valid JSON: %s", rule.Name(), err),
+			Args:    map[string]interface{}{"error": err.Error()},
+		}
+	}
+	return decoded, nil
+}
+
+// checkItemCount returns an error if the value is a slice with fewer elements than
+// the Rule's MinItems or more than its MaxItems.
+func checkItemCount(value interface{}, rule *Rule) error {
+	if rule.MinItems <= 0 && rule.MaxItems <= 0 {
+		return nil
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice {
+		return nil
+	}
+
+	if n := v.Len(); rule.MaxItems > 0 && n > rule.MaxItems {
+		return &FieldError{
+			Field: rule.Name(),
+			Code:  CodeTooManyItems,
+			Message: fmt.Sprintf("Field '%s' has %d items, more than the maximum of %d",
+				rule.Name(), n, rule.MaxItems),
+			Args: map[string]interface{}{"count": n, "max": rule.MaxItems},
+		}
+	} else if n < rule.MinItems {
+		return &FieldError{
+			Field: rule.Name(),
+			Code:  CodeTooFewItems,
+			Message: fmt.Sprintf("Field '%s' has %d items, fewer than the minimum of %d",
+				rule.Name(), n, rule.MinItems),
+			Args: map[string]interface{}{"count": n, "min": rule.MinItems},
+		}
+	}
+	return nil
+}
+
+// checkPattern returns an error if the value is a string which doesn't match the
+// Rule's Pattern.
+func checkPattern(value interface{}, rule *Rule) error {
+	s, ok := value.(string)
+	if !ok || rule.Pattern == "" {
+		return nil
+	}
+
+	re, err := compilePattern(rule.Pattern)
+	if err != nil {
+		return err
+	}
+	if !re.MatchString(s) {
+		return &FieldError{
+			Field:   rule.Name(),
+			Code:    CodePatternMismatch,
+			Message: fmt.Sprintf("Field '%s' does not match pattern %s", rule.Name(), rule.Pattern),
+			Args:    map[string]interface{}{"pattern": rule.Pattern},
+		}
+	}
+	return nil
+}
+
+// checkRange returns an error if the value is a number less than the Rule's Min or
+// greater than its Max. The value is compared in its coerced type.
+func checkRange(value interface{}, rule *Rule) error {
+	if rule.Min == nil && rule.Max == nil {
+		return nil
+	}
+
+	n, ok := numericValue(value)
+	if !ok {
+		return nil
+	}
+
+	if rule.Min != nil && n < *rule.Min {
+		return &FieldError{
+			Field: rule.Name(),
+			Code:  CodeOutOfRange,
+			Message: fmt.Sprintf("Field '%s' is %v, less than the minimum of %v",
+				rule.Name(), value, *rule.Min),
+			Args: map[string]interface{}{"value": value, "min": *rule.Min},
+		}
+	}
+	if rule.Max != nil && n > *rule.Max {
+		return &FieldError{
+			Field: rule.Name(),
+			Code:  CodeOutOfRange,
+			Message: fmt.Sprintf("Field '%s' is %v, more than the maximum of %v",
+				rule.Name(), value, *rule.Max),
+			Args: map[string]interface{}{"value": value, "max": *rule.Max},
+		}
+	}
+	return nil
+}
+
+// numericValue returns the value of any integer or floating-point type as a float64
+// and true, or false if the value isn't a number.
+func numericValue(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// checkAllowedValues returns an error if the Rule specifies AllowedValues and the
+// value isn't one of them. Numbers are compared by value rather than Go type.
+func checkAllowedValues(value interface{}, rule *Rule) error {
+	if len(rule.AllowedValues) == 0 || value == nil {
+		return nil
+	}
+
+	for _, allowed := range rule.AllowedValues {
+		if equalValues(value, allowed) {
+			return nil
+		}
+	}
+
+	return &FieldError{
+		Field: rule.Name(),
+		Code:  CodeValueNotAllowed,
+		Message: fmt.Sprintf("Field '%s' must be one of: %s",
+			rule.Name(), joinValues(rule.AllowedValues)),
+		Args: map[string]interface{}{"value": value, "allowed": rule.AllowedValues},
+	}
+}
+
+// equalValues returns true if the values are equal. Numbers are compared by value
+// rather than Go type.
+func equalValues(a, b interface{}) bool {
+	if n, ok := numericValue(a); ok {
+		if m, ok := numericValue(b); ok {
+			return n == m
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// joinValues returns the values formatted as a comma-separated list.
+func joinValues(values []interface{}) string {
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		formatted[i] = fmt.Sprint(value)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// compilePattern returns the compiled regular expression for the pattern, compiling
+// it only the first time it's used.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+// newFieldError returns a FieldError for the field describing the error. If the
+// error is a FieldError with a Code, its Code and Args are kept. Otherwise the given
+// code is used. Errors for a key of a Map field are reported for the "field.key" path.
+func newFieldError(field, code string, err error) *FieldError {
+	var args map[string]interface{}
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) && fieldErr.Code != "" {
+		code = fieldErr.Code
+		args = fieldErr.Args
+	}
+	var keyErr *mapKeyError
+	if errors.As(err, &keyErr) {
+		field = field + "." + keyErr.key
+	}
+	return &FieldError{Field: field, Code: code, Message: err.Error(), Args: args}
+}
+
 // applyNestedInboundRules recursively applies nested Rules which are not specified as
 // output only to the provided value.
-func applyNestedInboundRules(
-	value interface{}, rules Rules, version string) (interface{}, error) {
+func applyNestedInboundRules(value interface{}, rules Rules, version string,
+	logger requestLogger) (interface{}, error) {
 
 	var fieldValue interface{}
 	valueType := reflect.TypeOf(value).Kind()
@@ -364,7 +921,8 @@ func applyNestedInboundRules(
 					return nil, err
 				}
 				var payload map[string]interface{}
-				payload, err = applyInboundRules(payloadIFace.(map[string]interface{}), rules, version)
+				payload, err = applyInboundRulesCollect(
+					payloadIFace.(map[string]interface{}), rules, version, false, logger)
 				if err != nil {
 					return nil, err
 				}
@@ -378,7 +936,8 @@ func applyNestedInboundRules(
 			return nil, err
 		}
 		var payload map[string]interface{}
-		payload, err = applyInboundRules(payloadIFace.(map[string]interface{}), rules, version)
+		payload, err = applyInboundRulesCollect(
+			payloadIFace.(map[string]interface{}), rules, version, false, logger)
 		if err != nil {
 			return nil, err
 		}
@@ -388,6 +947,52 @@ func applyNestedInboundRules(
 	return fieldValue, nil
 }
 
+// applyNestedMapInboundRules applies nested Rules which are not specified as output
+// only to each value in the provided map. Map values may be objects, slices, whose
+// items the Rules are applied to, or primitives, which are coerced to the type of the
+// first Rule.
+func applyNestedMapInboundRules(value interface{}, rules Rules, version string,
+	logger requestLogger) (interface{}, error) {
+
+	m, err := coerceType(value, Map)
+	if err != nil {
+		return nil, err
+	}
+
+	ruleType := rules.Filter(Inbound).ForVersion(version).Contents()[0].Type
+	nestedValues := map[string]interface{}{}
+	for key, val := range m.(map[string]interface{}) {
+		if val == nil {
+			return nil, &mapKeyError{key, errors.New("nested value is nil")}
+		}
+
+		switch reflect.TypeOf(val).Kind() {
+		case reflect.Map, reflect.Slice:
+			val, err = applyNestedInboundRules(val, rules, version, logger)
+		default:
+			val, err = coerceType(val, ruleType)
+		}
+		if err != nil {
+			return nil, &mapKeyError{key, err}
+		}
+		nestedValues[key] = val
+	}
+
+	return nestedValues, nil
+}
+
+// mapKeyError is an error for the value of a key in a Map field.
+type mapKeyError struct {
+	key string
+	err error
+}
+
+// Error returns the error message prefixed with the key.
+func (m *mapKeyError) Error() string { return fmt.Sprintf("%s: %s", m.key, m.err) }
+
+// Unwrap returns the error for the value.
+func (m *mapKeyError) Unwrap() error { return m.err }
+
 // nestedInboundRulesApply returns true if the Rules contain inbound Rules and
 // the value is a map or slice.
 func nestedInboundRulesApply(value interface{}, rules Rules, version string) bool {
@@ -412,6 +1017,16 @@ func nestedInboundRulesApply(value interface{}, rules Rules, version string) boo
 // into old API versions. If Rules specify nested Rules, they will be recursively
 // applied to field values.
 func applyOutboundRules(resource Resource, rules Rules, version string) Resource {
+	return applyOutboundRulesDepth(nil, resource, rules, version, 0, 0, requestLogger{})
+}
+
+// applyOutboundRulesDepth applies outbound Rules like applyOutboundRules to a Resource
+// nested depth levels deep. If maxDepth is positive, nested Rules are not applied
+// beyond it and the fields they specify are omitted, which stops recursion through
+// self-referential Rules. Missing fields are logged using the requestLogger, and the
+// RequestContext, which may be nil, is passed to OutputHandlerCtx functions.
+func applyOutboundRulesDepth(ctx RequestContext, resource Resource, rules Rules, version string,
+	depth, maxDepth int, logger requestLogger) Resource {
 	// Apply only outbound Rules.
 	rules = rules.Filter(false).ForVersion(version)
 
@@ -420,26 +1035,33 @@ func applyOutboundRules(resource Resource, rules Rules, version string) Resource
 		return resource
 	}
 
-	// Get the underlying value by dereferencing the pointer if there is one.
+	// Get the underlying value by dereferencing the pointer if there is one. Virtual
+	// Rules are passed the resource as given.
+	original := resource
 	resourceValue := reflect.Indirect(reflect.ValueOf(resource))
 	resource = resourceValue.Interface()
 	resourceType := reflect.TypeOf(resource)
-	var payload Resource
+	var payload Payload
 
 	if resourceType.Kind() == reflect.Map {
-		if resourceMap, ok := resource.(map[string]interface{}); ok {
-			payload = applyOutboundRulesForMap(resourceMap, rules, version)
-		} else {
+		resourceMap, ok := resource.(map[string]interface{})
+		if !ok {
 			// Nothing we can do if the keys aren't strings.
-			payload = resource
+			return resource
 		}
+		payload = applyOutboundRulesForMap(ctx, resourceMap, rules, version, depth, maxDepth, logger)
 	} else if resourceType.Kind() == reflect.Struct {
-		payload = applyOutboundRulesForStruct(resourceValue, rules, version)
+		payload = applyOutboundRulesForStruct(ctx, resourceValue, rules, version, depth, maxDepth, logger)
 	} else {
 		// Only apply Rules to resource structs and maps.
-		payload = resource
+		return resource
 	}
 
+	for _, rule := range rules.Contents() {
+		if rule.isVirtualRule() {
+			setVirtualField(ctx, payload, rule, original)
+		}
+	}
 	return payload
 }
 
@@ -447,28 +1069,42 @@ func applyOutboundRules(resource Resource, rules Rules, version string) Resource
 // provided map. If a Rule specifies a field which is not in the map, it will be skipped.
 // If a Rule specifies nested Rules, they will be recursively applied to the corresponding
 // value.
-func applyOutboundRulesForMap(
-	resource map[string]interface{}, rules Rules, version string) Payload {
+func applyOutboundRulesForMap(ctx RequestContext, resource map[string]interface{}, rules Rules,
+	version string, depth, maxDepth int, logger requestLogger) Payload {
 
 	payload := Payload{}
 	for _, rule := range rules.Contents() {
 		if !rule.isResourceRule() {
-			// Non-resource Rules don't apply to output.
+			// Virtual Rules are applied to the whole resource.
 			continue
 		}
 
 		fieldValue, ok := resource[rule.Field]
 		if !ok {
-			log.Printf("Map resource missing field '%s'", rule.Field)
+			logger.Printf("Map resource missing field '%s'", rule.Field)
 			continue
 		}
 
 		if rule.Rules != nil {
-			fieldValue = applyNestedOutboundRules(fieldValue, rule, version)
+			if maxDepth > 0 && depth >= maxDepth {
+				// Omit fields nested beyond the maximum depth.
+				continue
+			}
+			fieldValue = applyNestedOutboundRules(ctx, fieldValue, rule, version, depth+1, maxDepth, logger)
 		}
 
-		if rule.OutputHandler != nil {
+		if rule.OutputHandlerCtx != nil {
+			fieldValue = rule.OutputHandlerCtx(ctx, fieldValue)
+		} else if rule.OutputHandler != nil {
 			fieldValue = rule.OutputHandler(fieldValue)
+		} else {
+			fieldValue = serializeType(ctx, fieldValue)
+		}
+		if rule.OmitEmpty && isEmptyValue(fieldValue) {
+			continue
+		}
+		if rule.SerializeAsString {
+			fieldValue = numberToString(fieldValue)
 		}
 		payload[rule.Name()] = fieldValue
 	}
@@ -480,13 +1116,13 @@ func applyOutboundRulesForMap(
 // provided reflect.Value. The precondition for this function is that the value is an
 // instance of the type specified on the Rules. If a Rule specifies nested Rules, they
 // will be recursively applied to the corresponding value.
-func applyOutboundRulesForStruct(
-	resourceValue reflect.Value, rules Rules, version string) Payload {
+func applyOutboundRulesForStruct(ctx RequestContext, resourceValue reflect.Value, rules Rules,
+	version string, depth, maxDepth int, logger requestLogger) Payload {
 
 	payload := Payload{}
 	for _, rule := range rules.Contents() {
 		if !rule.isResourceRule() {
-			// Non-resource Rules don't apply to output.
+			// Virtual Rules are applied to the whole resource.
 			continue
 		}
 
@@ -495,11 +1131,25 @@ func applyOutboundRulesForStruct(
 		fieldValue := field.Interface()
 
 		if rule.Rules != nil {
-			fieldValue = applyNestedOutboundRules(fieldValue, rule, version)
+			if maxDepth > 0 && depth >= maxDepth {
+				// Omit fields nested beyond the maximum depth.
+				continue
+			}
+			fieldValue = applyNestedOutboundRules(ctx, fieldValue, rule, version, depth+1, maxDepth, logger)
 		}
 
-		if rule.OutputHandler != nil {
+		if rule.OutputHandlerCtx != nil {
+			fieldValue = rule.OutputHandlerCtx(ctx, fieldValue)
+		} else if rule.OutputHandler != nil {
 			fieldValue = rule.OutputHandler(fieldValue)
+		} else {
+			fieldValue = serializeType(ctx, fieldValue)
+		}
+		if rule.OmitEmpty && isEmptyValue(fieldValue) {
+			continue
+		}
+		if rule.SerializeAsString {
+			fieldValue = numberToString(fieldValue)
 		}
 		payload[rule.Name()] = fieldValue
 	}
@@ -507,49 +1157,385 @@ func applyOutboundRulesForStruct(
 	return payload
 }
 
+// setVirtualField sets the field specified by the given virtual Rule on the Payload to
+// the value its OutputHandlerCtx computes from the resource.
+func setVirtualField(ctx RequestContext, payload Payload, rule *Rule, resource interface{}) {
+	value := rule.OutputHandlerCtx(ctx, resource)
+	if rule.OmitEmpty && isEmptyValue(value) {
+		return
+	}
+	if rule.SerializeAsString {
+		value = numberToString(value)
+	}
+	payload[rule.Name()] = value
+}
+
+// isEmptyValue returns true if the given value would be omitted by encoding/json
+// when the omitempty option is set.
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+// numberToString returns the decimal string representation of a numeric value or a
+// pointer to one. Other values are returned as-is.
+func numberToString(value interface{}) interface{} {
+	if value == nil {
+		return value
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return value
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32)
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	}
+
+	return value
+}
+
 // applyNestedOutboundRules recursively applies nested Rules which are not specified as
-// input only to the provided Resource.
-func applyNestedOutboundRules(resource Resource, rule *Rule, version string) Resource {
+// input only to the provided Resource, which is nested depth levels deep.
+func applyNestedOutboundRules(ctx RequestContext, resource Resource, rule *Rule, version string,
+	depth, maxDepth int, logger requestLogger) Resource {
 	var fieldValue Resource
 
-	if reflect.TypeOf(resource).Kind() == reflect.Slice {
+	if rule.Type == Map && reflect.TypeOf(resource).Kind() == reflect.Map {
+		// Apply nested Rules to each value in the map, or each item of slice values.
+		m := reflect.ValueOf(resource)
+		if m.IsNil() {
+			return resource
+		}
+		nestedValues := make(map[string]interface{}, m.Len())
+		iter := m.MapRange()
+		for iter.Next() {
+			value := iter.Value().Interface()
+			if value != nil && reflect.TypeOf(value).Kind() == reflect.Slice {
+				value = applyNestedOutboundRules(ctx, value, &Rule{Rules: rule.Rules},
+					version, depth, maxDepth, logger)
+			} else {
+				value = applyOutboundRulesDepth(ctx,
+					value, rule.Rules, version, depth, maxDepth, logger)
+			}
+			nestedValues[fmt.Sprint(iter.Key().Interface())] = value
+		}
+		fieldValue = nestedValues
+	} else if reflect.TypeOf(resource).Kind() == reflect.Slice {
 		// Apply nested Rules to each item in the slice.
 		s := reflect.ValueOf(resource)
 		nestedValues := make([]interface{}, s.Len())
 		for i := 0; i < s.Len(); i++ {
-			nestedValues[i] = applyOutboundRules(
-				s.Index(i).Interface(), rule.Rules, version)
+			nestedValues[i] = applyOutboundRulesDepth(ctx,
+				s.Index(i).Interface(), rule.Rules, version, depth, maxDepth, logger)
 		}
 		fieldValue = nestedValues
+		if rule.KeyBy != "" {
+			fieldValue = keyBy(nestedValues, rule.KeyBy, logger)
+		}
 	} else {
-		fieldValue = applyOutboundRules(resource, rule.Rules, version)
+		fieldValue = applyOutboundRulesDepth(ctx,
+			resource, rule.Rules, version, depth, maxDepth, logger)
 	}
 
 	return fieldValue
 }
 
+// keyBy returns the items keyed by the string form of their value for the given
+// field. Items which aren't Payloads or have no value for the field are omitted, as
+// are items with the same value as an earlier item. Omitted items are logged once.
+func keyBy(items []interface{}, field string, logger requestLogger) map[string]interface{} {
+	keyed := make(map[string]interface{}, len(items))
+	missing := 0
+	duplicates := []string{}
+	for _, item := range items {
+		payload, ok := item.(Payload)
+		if !ok || payload[field] == nil {
+			missing++
+			continue
+		}
+		key := fmt.Sprint(payload[field])
+		if _, ok := keyed[key]; ok {
+			duplicates = append(duplicates, key)
+			continue
+		}
+		keyed[key] = item
+	}
+
+	if missing > 0 {
+		logger.Printf("Omitting %d items without field '%s'", missing, field)
+	}
+	if len(duplicates) > 0 {
+		logger.Printf("Omitting items with duplicate '%s': %s", field,
+			strings.Join(duplicates, ", "))
+	}
+	return keyed
+}
+
+// hasRuleNamed returns true if the Rules contain a Rule with the given name.
+func hasRuleNamed(rules Rules, name string) bool {
+	for _, rule := range rules.Contents() {
+		if rule.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// inboundName returns the Payload key used for values matched by the given inbound
+// Rule. When several Rules target the same resource field under different aliases,
+// values provided under any of them are keyed by the alias of the first such Rule so
+// that handlers see a single key regardless of which alias the client sent.
+func inboundName(rule *Rule, rules Rules) string {
+	if !rule.isResourceRule() {
+		return rule.Name()
+	}
+
+	for _, r := range rules.Contents() {
+		if r.Field == rule.Field {
+			return r.Name()
+		}
+	}
+
+	return rule.Name()
+}
+
 // enforceRequiredFields verifies that the provided Payload has values for any Rules
-// with the Required flag set to true. If any required fields are missing, an error
-// will be returned. Otherwise nil is returned.
+// with the Required flag set to true, which must not be empty unless the Rule allows
+// it. If any required fields are missing, an error will be returned. Otherwise nil is
+// returned.
 func enforceRequiredFields(rules Rules, payload Payload) error {
-ruleLoop:
 	for _, rule := range rules.Contents() {
 		if !rule.Required {
 			continue
 		}
 
-		for field := range payload {
-			if rule.Name() == field {
-				continue ruleLoop
+		if err := requiredFieldError(rule, rules, payload); err != nil {
+			return errors.New(err.Message)
+		}
+	}
+
+	return nil
+}
+
+// writeOnceFieldErrors returns a FieldError for each write-once Rule whose field is
+// in the provided Payload.
+func writeOnceFieldErrors(payload Payload, rules Rules) ValidationErrors {
+	errs := ValidationErrors{}
+	for _, rule := range rules.Contents() {
+		if !rule.WriteOnce {
+			continue
+		}
+		if _, ok := payload[rule.Name()]; ok {
+			errs = append(errs, &FieldError{
+				Field:   rule.Name(),
+				Code:    CodeWriteOnce,
+				Message: fmt.Sprintf("Field '%s' can't be changed once set", rule.Name()),
+			})
+		}
+	}
+
+	return errs
+}
+
+// withoutUnchanged returns a copy of the Payload without the fields of write-once
+// Rules whose values, coerced to the Rule's Type, equal those of the stored resource.
+func withoutUnchanged(payload Payload, rules Rules, stored Resource) Payload {
+	changed := Payload{}
+	for name, value := range payload {
+		changed[name] = value
+	}
+	for _, rule := range rules.Contents() {
+		if !rule.WriteOnce {
+			continue
+		}
+		value, ok := payload[rule.Name()]
+		if !ok {
+			continue
+		}
+		storedValue, ok := resourceFieldValue(stored, rule.Field)
+		if !ok {
+			continue
+		}
+		if rule.Type != Unspecified {
+			if coerced, err := coerceType(value, rule.Type); err == nil {
+				value = coerced
 			}
 		}
+		if equalValues(value, storedValue) {
+			delete(changed, rule.Name())
+		}
+	}
+	return changed
+}
+
+// resourceFieldValue returns the value of the named field of the struct or
+// map[string]interface{} resource and true, or false if it doesn't have the field.
+func resourceFieldValue(resource Resource, field string) (interface{}, bool) {
+	if isNil(resource) || field == "" {
+		return nil, false
+	}
+	value := reflect.Indirect(reflect.ValueOf(resource))
+	switch value.Kind() {
+	case reflect.Struct:
+		if f := value.FieldByName(field); f.IsValid() && f.CanInterface() {
+			return f.Interface(), true
+		}
+	case reflect.Map:
+		if m, ok := value.Interface().(map[string]interface{}); ok {
+			v, ok := m[field]
+			return v, ok
+		}
+	}
+	return nil, false
+}
+
+// withoutWriteOnce returns the Rules which aren't write-once, so write-once fields
+// aren't required on updates.
+func withoutWriteOnce(r Rules) Rules {
+	filtered := make([]*Rule, 0, r.Size())
+	for _, rule := range r.Contents() {
+		if !rule.WriteOnce {
+			filtered = append(filtered, rule)
+		}
+	}
+
+	return &rules{contents: filtered, resourceType: r.ResourceType()}
+}
 
-		return fmt.Errorf("Missing required field '%s'", rule.Name())
+// missingRequiredFields returns a FieldError for each Rule with the Required flag set
+// to true which does not have a value in the provided Payload.
+func missingRequiredFields(rules Rules, payload Payload) ValidationErrors {
+	errs := ValidationErrors{}
+	for _, rule := range rules.Contents() {
+		if !rule.Required {
+			continue
+		}
+
+		if err := requiredFieldError(rule, rules, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// requiredFieldError returns a FieldError if the Payload is missing a value for the
+// required Rule or, unless the Rule has AllowEmptyRequired set, its value is an empty
+// string, slice, or map. Otherwise nil is returned.
+func requiredFieldError(rule *Rule, rules Rules, payload Payload) *FieldError {
+	value, ok := payload[inboundName(rule, rules)]
+	if !ok {
+		return &FieldError{
+			Field:   rule.Name(),
+			Code:    CodeFieldRequired,
+			Message: fmt.Sprintf("Missing required field '%s'", rule.Name()),
+		}
+	}
+
+	if !rule.AllowEmptyRequired && isEmptyCollection(value) {
+		return &FieldError{
+			Field:   rule.Name(),
+			Code:    CodeFieldRequired,
+			Message: fmt.Sprintf("Required field '%s' must not be empty", rule.Name()),
+			Args:    map[string]interface{}{"empty": true},
+		}
 	}
 
 	return nil
 }
 
+// isEmptyCollection returns true if the given value is a string, slice, or map with
+// no elements.
+func isEmptyCollection(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	}
+	return false
+}
+
+// maskSensitive returns a copy of the inbound Payload with the values of fields
+// whose Rules are Sensitive replaced by sensitiveMask, including those in nested
+// Payloads.
+func maskSensitive(payload Payload, rules Rules, version string) Payload {
+	if payload == nil || rules == nil {
+		return payload
+	}
+
+	rules = rules.Filter(Inbound).ForVersion(version)
+	masked := make(Payload, len(payload))
+	for field, value := range payload {
+		masked[field] = value
+	}
+
+	for _, rule := range rules.Contents() {
+		name := inboundName(rule, rules)
+		value, ok := masked[name]
+		if !ok {
+			continue
+		}
+
+		if rule.Sensitive {
+			masked[name] = sensitiveMask
+		} else if rule.Rules != nil {
+			masked[name] = maskNestedSensitive(value, rule.Rules, version)
+		}
+	}
+
+	return masked
+}
+
+// maskNestedSensitive masks the Sensitive fields of a nested Payload or of each
+// Payload in a slice.
+func maskNestedSensitive(value interface{}, rules Rules, version string) interface{} {
+	switch nested := value.(type) {
+	case Payload:
+		return maskSensitive(nested, rules, version)
+	case map[string]interface{}:
+		return map[string]interface{}(maskSensitive(nested, rules, version))
+	case []interface{}:
+		maskedValues := make([]interface{}, len(nested))
+		for i, item := range nested {
+			maskedValues[i] = maskNestedSensitive(item, rules, version)
+		}
+		return maskedValues
+	default:
+		return value
+	}
+}
+
 // isNil returns true if the given Resource is a nil value or pointer, false if
 // not.
 func isNil(resource Resource) bool {
@@ -17,7 +17,12 @@ limitations under the License.
 package rest
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 	"time"
@@ -67,6 +72,140 @@ func TestApplyInboundRulesMissingRequiredField(t *testing.T) {
 	assert.Equal(fmt.Errorf("Missing required field 'baz'"), err, "Incorrect error")
 }
 
+// Ensures that applyInboundRules returns an error when a required field is empty.
+func TestApplyInboundRulesEmptyRequiredField(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "name", Type: String, Required: true},
+		&Rule{Field: "tags", Type: Slice, Required: true},
+	)
+
+	actual, err := applyInboundRules(
+		Payload{"name": "foo", "tags": []interface{}{"a"}}, rules, "1")
+	assert.Equal(Payload{"name": "foo", "tags": []interface{}{"a"}}, actual,
+		"Incorrect return value")
+	assert.Nil(err, "Error should be nil")
+
+	actual, err = applyInboundRules(
+		Payload{"name": "", "tags": []interface{}{"a"}}, rules, "1")
+	assert.Nil(actual, "Return value should be nil")
+	assert.EqualError(err, "Required field 'name' must not be empty")
+
+	actual, err = applyInboundRulesCollect(
+		Payload{"name": "", "tags": []interface{}{}}, rules, "1", true, requestLogger{})
+	assert.Nil(actual, "Return value should be nil")
+	assert.Equal(ValidationErrors{
+		{Field: "name", Code: CodeFieldRequired,
+			Message: "Required field 'name' must not be empty",
+			Args:    map[string]interface{}{"empty": true}},
+		{Field: "tags", Code: CodeFieldRequired,
+			Message: "Required field 'tags' must not be empty",
+			Args:    map[string]interface{}{"empty": true}},
+	}, err)
+}
+
+// Ensures that empty values satisfy required fields whose Rules set
+// AllowEmptyRequired.
+func TestApplyInboundRulesAllowEmptyRequired(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "name", Type: String, Required: true, AllowEmptyRequired: true},
+	)
+
+	actual, err := applyInboundRules(Payload{"name": ""}, rules, "1")
+
+	assert.Equal(Payload{"name": ""}, actual, "Incorrect return value")
+	assert.Nil(err, "Error should be nil")
+}
+
+// Ensures that applyInboundRulesCollect reports every invalid and missing field when
+// collecting errors.
+func TestApplyInboundRulesCollectErrors(t *testing.T) {
+	assert := assert.New(t)
+	payload := Payload{"foo": "abc", "bar": true, "qux": "1"}
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "foo", Type: Int},
+		&Rule{Field: "bar", Type: Float64},
+		&Rule{Field: "baz", Required: true},
+		&Rule{Field: "qux", Type: Int},
+	)
+
+	actual, err := applyInboundRulesCollect(payload, rules, "1", true, requestLogger{})
+
+	assert.Nil(actual, "Return value should be nil")
+	assert.Equal(ValidationErrors{
+		{Field: "bar", Code: CodeTypeMismatch, Message: "Unable to coerce bool to float64"},
+		{Field: "foo", Code: CodeTypeMismatch,
+			Message: `strconv.ParseInt: parsing "abc": invalid syntax`},
+		{Field: "baz", Code: CodeFieldRequired, Message: "Missing required field 'baz'"},
+	}, err, "Incorrect error")
+}
+
+// Ensures that each type of validation failure is reported with its code when
+// collecting errors.
+func TestApplyInboundRulesCollectErrorCodes(t *testing.T) {
+	assert := assert.New(t)
+	payload := Payload{
+		"count":  "abc",
+		"few":    []interface{}{},
+		"many":   []interface{}{1, 2, 3},
+		"meta":   "{",
+		"nested": map[string]interface{}{},
+		"code":   "abc",
+		"age":    -1.0,
+		"status": "deleted",
+	}
+	min := 0.0
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "count", Type: Int},
+		&Rule{Field: "few", Type: Slice, MinItems: 1},
+		&Rule{Field: "many", Type: Slice, MaxItems: 2},
+		&Rule{Field: "meta", Type: Map, ParseJSONString: true},
+		&Rule{Field: "name", Required: true},
+		&Rule{Field: "code", Pattern: "^[0-9]+$"},
+		&Rule{Field: "age", Type: Int, Min: &min},
+		&Rule{Field: "status", AllowedValues: []interface{}{"active"}},
+		&Rule{Field: "nested", Rules: NewRules((*TestResource)(nil),
+			&Rule{Field: "foo", Required: true})},
+	)
+
+	_, err := applyInboundRulesCollect(payload, rules, "1", true, requestLogger{})
+
+	codes := map[string]string{}
+	if errs, ok := err.(ValidationErrors); assert.True(ok, "Incorrect error type") {
+		for _, fieldErr := range errs {
+			codes[fieldErr.Field] = fieldErr.Code
+		}
+	}
+	assert.Equal(map[string]string{
+		"count":  CodeTypeMismatch,
+		"few":    CodeTooFewItems,
+		"many":   CodeTooManyItems,
+		"meta":   CodeInvalidJSON,
+		"name":   CodeFieldRequired,
+		"nested": CodeInvalidValue,
+		"code":   CodePatternMismatch,
+		"age":    CodeOutOfRange,
+		"status": CodeValueNotAllowed,
+	}, codes)
+}
+
+// Ensures that applyInboundRulesCollect returns the coerced payload when collecting
+// errors and every field is valid.
+func TestApplyInboundRulesCollectErrorsValid(t *testing.T) {
+	assert := assert.New(t)
+	payload := Payload{"foo": "1", "baz": "a"}
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "foo", Type: Int},
+		&Rule{Field: "baz", Required: true},
+	)
+
+	actual, err := applyInboundRulesCollect(payload, rules, "1", true, requestLogger{})
+
+	assert.Equal(Payload{"foo": 1, "baz": "a"}, actual, "Incorrect return value")
+	assert.Nil(err, "Error should be nil")
+}
+
 // Ensures that only inbound rules are applied and unspecified input fields are discarded.
 func TestApplyInboundRules(t *testing.T) {
 	assert := assert.New(t)
@@ -848,6 +987,87 @@ func TestApplyInboundRulesCoerceStringToTime(t *testing.T) {
 	assert.Nil(err, "Error should be nil")
 }
 
+// Ensures that inbound rules which specify UUID accept a canonical UUID string.
+func TestApplyInboundRulesCoerceStringToUUID(t *testing.T) {
+	assert := assert.New(t)
+	payload := Payload{"foo": "123e4567-e89b-12d3-a456-426614174000"}
+	rules := NewRules((*TestResource)(nil),
+		&Rule{
+			Field:      "foo",
+			FieldAlias: "foo",
+			Type:       UUID,
+		},
+	)
+
+	actual, err := applyInboundRules(payload, rules, "1")
+
+	assert.Equal(Payload{"foo": "123e4567-e89b-12d3-a456-426614174000"}, actual,
+		"Incorrect return value")
+	assert.Nil(err, "Error should be nil")
+}
+
+// Ensures that inbound rules which specify UUID normalize the UUID to lowercase.
+func TestApplyInboundRulesCoerceStringToUUIDNormalizesCase(t *testing.T) {
+	assert := assert.New(t)
+	payload := Payload{"foo": "123E4567-E89B-12D3-A456-426614174ABC"}
+	rules := NewRules((*TestResource)(nil),
+		&Rule{
+			Field:      "foo",
+			FieldAlias: "foo",
+			Type:       UUID,
+		},
+	)
+
+	actual, err := applyInboundRules(payload, rules, "1")
+
+	assert.Equal(Payload{"foo": "123e4567-e89b-12d3-a456-426614174abc"}, actual,
+		"Incorrect return value")
+	assert.Nil(err, "Error should be nil")
+}
+
+// Ensures that inbound rules which specify UUID return an error for malformed UUIDs.
+func TestApplyInboundRulesCoerceStringToUUIDError(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{
+			Field:      "foo",
+			FieldAlias: "foo",
+			Type:       UUID,
+		},
+	)
+
+	for _, uuid := range []string{
+		"",
+		"hello",
+		"123e4567e89b12d3a456426614174000",
+		"123e4567-e89b-12d3-a456-42661417400g",
+		"123e4567-e89b-12d3-a456_426614174000",
+		"{123e4567-e89b-12d3-a456-426614174000}",
+	} {
+		actual, err := applyInboundRules(Payload{"foo": uuid}, rules, "1")
+
+		assert.Nil(actual, "Return value should be nil")
+		assert.Equal(fmt.Errorf("Invalid UUID '%s'", uuid), err, "Incorrect error")
+	}
+}
+
+// Ensures that inbound rules which specify UUID don't coerce non-strings.
+func TestApplyInboundRulesCoerceFloatToUUIDError(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{
+			Field:      "foo",
+			FieldAlias: "foo",
+			Type:       UUID,
+		},
+	)
+
+	actual, err := applyInboundRules(Payload{"foo": float64(42)}, rules, "1")
+
+	assert.Nil(actual, "Return value should be nil")
+	assert.Equal(fmt.Errorf("Unable to coerce float to uuid"), err, "Incorrect error")
+}
+
 // Ensure that if type coercion from slice fails, the error is returned.
 func TestApplyInboundRulesCoerceSliceError(t *testing.T) {
 	assert := assert.New(t)
@@ -961,6 +1181,289 @@ func TestApplyInboundRulesNestedRulesMap(t *testing.T) {
 	assert.Nil(err, "Error should be nil")
 }
 
+// Ensures that string values are checked against the Rule's Pattern after type
+// coercion and that values which don't match are rejected.
+func TestApplyInboundRulesPattern(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "email", Pattern: `^[^@\s]+@[^@\s]+$`},
+		&Rule{FieldAlias: "code", Type: String, Pattern: `^\d{3}$`},
+		&Rule{FieldAlias: "count", Pattern: `^\d{3}$`},
+	)
+
+	actual, err := applyInboundRules(
+		Payload{"email": "a@example.com", "code": 123.0, "count": 5.0}, rules, "1")
+	assert.Nil(err)
+	assert.Equal(Payload{"email": "a@example.com", "code": "123", "count": 5.0}, actual)
+
+	actual, err = applyInboundRules(Payload{"email": "nope"}, rules, "1")
+	assert.Nil(actual)
+	assert.EqualError(err, `Field 'email' does not match pattern ^[^@\s]+@[^@\s]+$`)
+
+	actual, err = applyInboundRules(Payload{"code": 1234.0}, rules, "1")
+	assert.Nil(actual)
+	assert.EqualError(err, `Field 'code' does not match pattern ^\d{3}$`)
+}
+
+// Ensures that inbound rules which specify Min and Max accept int8 values in range.
+func TestApplyInboundRulesRangeInt8(t *testing.T) {
+	assert := assert.New(t)
+	min := -10.0
+	max := 10.0
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "foo", FieldAlias: "foo", Type: Int8, Min: &min, Max: &max},
+	)
+
+	actual, err := applyInboundRules(Payload{"foo": float64(-10)}, rules, "1")
+	assert.Equal(Payload{"foo": int8(-10)}, actual, "Incorrect return value")
+	assert.Nil(err, "Error should be nil")
+
+	actual, err = applyInboundRules(Payload{"foo": "10"}, rules, "1")
+	assert.Equal(Payload{"foo": int8(10)}, actual, "Incorrect return value")
+	assert.Nil(err, "Error should be nil")
+}
+
+// Ensures that inbound rules which specify Min reject int8 values below it.
+func TestApplyInboundRulesRangeInt8BelowMin(t *testing.T) {
+	assert := assert.New(t)
+	min := -10.0
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "foo", FieldAlias: "foo", Type: Int8, Min: &min},
+	)
+
+	actual, err := applyInboundRules(Payload{"foo": float64(-11)}, rules, "1")
+
+	assert.Nil(actual, "Return value should be nil")
+	assert.EqualError(err, "Field 'foo' is -11, less than the minimum of -10")
+}
+
+// Ensures that inbound rules which specify Max reject uint values above it.
+func TestApplyInboundRulesRangeUintAboveMax(t *testing.T) {
+	assert := assert.New(t)
+	max := 100.0
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "foo", FieldAlias: "foo", Type: Uint, Max: &max},
+	)
+
+	actual, err := applyInboundRules(Payload{"foo": "101"}, rules, "1")
+
+	assert.Nil(actual, "Return value should be nil")
+	assert.EqualError(err, "Field 'foo' is 101, more than the maximum of 100")
+}
+
+// Ensures that the range is checked against the coerced value, so a float truncated
+// to an int is compared as an int.
+func TestApplyInboundRulesRangeCoercedInt(t *testing.T) {
+	assert := assert.New(t)
+	max := 5.0
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "foo", FieldAlias: "foo", Type: Int, Max: &max},
+	)
+
+	actual, err := applyInboundRules(Payload{"foo": float64(5.5)}, rules, "1")
+
+	assert.Equal(Payload{"foo": int(5)}, actual, "Incorrect return value")
+	assert.Nil(err, "Error should be nil")
+}
+
+// Ensures that inbound rules which specify Min and Max check float64 values.
+func TestApplyInboundRulesRangeFloat64(t *testing.T) {
+	assert := assert.New(t)
+	min := 0.5
+	max := 1.5
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "foo", FieldAlias: "foo", Type: Float64, Min: &min, Max: &max},
+	)
+
+	actual, err := applyInboundRules(Payload{"foo": float64(1.5)}, rules, "1")
+	assert.Equal(Payload{"foo": float64(1.5)}, actual, "Incorrect return value")
+	assert.Nil(err, "Error should be nil")
+
+	actual, err = applyInboundRules(Payload{"foo": float64(0.25)}, rules, "1")
+	assert.Nil(actual, "Return value should be nil")
+	assert.EqualError(err, "Field 'foo' is 0.25, less than the minimum of 0.5")
+}
+
+// Ensures that inbound rules which specify AllowedValues accept only those strings.
+func TestApplyInboundRulesAllowedValuesString(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{
+			Field:         "foo",
+			FieldAlias:    "foo",
+			Type:          String,
+			AllowedValues: []interface{}{"active", "archived"},
+		},
+	)
+
+	actual, err := applyInboundRules(Payload{"foo": "archived"}, rules, "1")
+	assert.Equal(Payload{"foo": "archived"}, actual, "Incorrect return value")
+	assert.Nil(err, "Error should be nil")
+
+	actual, err = applyInboundRules(Payload{"foo": "deleted"}, rules, "1")
+	assert.Nil(actual, "Return value should be nil")
+	assert.EqualError(err, "Field 'foo' must be one of: active, archived")
+}
+
+// Ensures that inbound rules which specify AllowedValues compare coerced ints by
+// value rather than Go type.
+func TestApplyInboundRulesAllowedValuesCoercedInt(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{
+			Field:         "foo",
+			FieldAlias:    "foo",
+			Type:          Int64,
+			AllowedValues: []interface{}{1, 2, 3},
+		},
+	)
+
+	actual, err := applyInboundRules(Payload{"foo": float64(2)}, rules, "1")
+	assert.Equal(Payload{"foo": int64(2)}, actual, "Incorrect return value")
+	assert.Nil(err, "Error should be nil")
+
+	actual, err = applyInboundRules(Payload{"foo": "3"}, rules, "1")
+	assert.Equal(Payload{"foo": int64(3)}, actual, "Incorrect return value")
+	assert.Nil(err, "Error should be nil")
+
+	actual, err = applyInboundRules(Payload{"foo": float64(4)}, rules, "1")
+	assert.Nil(actual, "Return value should be nil")
+	assert.EqualError(err, "Field 'foo' must be one of: 1, 2, 3")
+}
+
+// Ensures that inbound rules apply their Default, coerced to their Type, to fields
+// missing from the payload.
+func TestApplyInboundRulesDefault(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "limit", Type: Int64, Default: 10},
+		&Rule{FieldAlias: "status", Type: String, Default: "active"},
+		&Rule{FieldAlias: "timeout", Type: Duration, Default: "5s"},
+		&Rule{FieldAlias: "name", Type: String},
+	)
+
+	actual, err := applyInboundRules(Payload{"status": "archived"}, rules, "1")
+
+	assert.Nil(err, "Error should be nil")
+	assert.Equal(Payload{
+		"limit":   int64(10),
+		"status":  "archived",
+		"timeout": 5 * time.Second,
+	}, actual, "Incorrect return value")
+}
+
+// Ensures that Map and Slice Defaults are copied into each payload, so modifying
+// one payload doesn't affect the Default or other payloads.
+func TestApplyInboundRulesDefaultCopied(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "tags", Type: Slice, Default: []interface{}{"a"}},
+		&Rule{FieldAlias: "meta", Type: Map,
+			Default: map[string]interface{}{"nested": map[string]interface{}{"a": 1}}},
+	)
+
+	first, err := applyInboundRules(Payload{}, rules, "1")
+	assert.Nil(err, "Error should be nil")
+	first["tags"].([]interface{})[0] = "b"
+	first["meta"].(map[string]interface{})["nested"].(map[string]interface{})["a"] = 2
+
+	second, err := applyInboundRules(Payload{}, rules, "1")
+	assert.Nil(err, "Error should be nil")
+	assert.Equal(Payload{
+		"tags": []interface{}{"a"},
+		"meta": map[string]interface{}{"nested": map[string]interface{}{"a": 1}},
+	}, second, "Incorrect return value")
+}
+
+// Ensures that a Default doesn't replace a value explicitly sent as null.
+func TestApplyInboundRulesDefaultNull(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "status", Type: String, Default: "active"},
+	)
+
+	actual, err := applyInboundRules(Payload{"status": nil}, rules, "1")
+
+	assert.Nil(err, "Error should be nil")
+	assert.Equal(Payload{"status": nil}, actual, "Incorrect return value")
+}
+
+// Ensures that only the Defaults of Rules for the requested version are applied.
+func TestApplyInboundRulesDefaultVersions(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "status", Type: String, Default: "active", Versions: []string{"2"}},
+	)
+
+	actual, err := applyInboundRules(Payload{}, rules, "1")
+	assert.Nil(err, "Error should be nil")
+	assert.Equal(Payload{}, actual, "Incorrect return value")
+
+	actual, err = applyInboundRules(Payload{}, rules, "2")
+	assert.Nil(err, "Error should be nil")
+	assert.Equal(Payload{"status": "active"}, actual, "Incorrect return value")
+}
+
+// Ensures that Defaults satisfy required fields and aren't applied to partial
+// updates.
+func TestApplyInboundRulesDefaultRequired(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "status", Type: String, Required: true, Default: "active"},
+	)
+
+	actual, err := applyInboundRules(Payload{}, rules, "1")
+	assert.Nil(err, "Error should be nil")
+	assert.Equal(Payload{"status": "active"}, actual, "Incorrect return value")
+
+	actual, err = applyFilteredInboundRules(Payload{}, rules, "1", false, true, requestLogger{})
+	assert.Nil(err, "Error should be nil")
+	assert.Equal(Payload{}, actual, "Incorrect return value")
+}
+
+// Ensures that string values are decoded as JSON before nested inbound Rules are
+// applied when ParseJSONString is set.
+func TestApplyInboundRulesParseJSONString(t *testing.T) {
+	assert := assert.New(t)
+	payload := Payload{"foo": `{"bar": 1}`}
+	rules := NewRules((*TestResource)(nil),
+		&Rule{
+			Field:           "Foo",
+			FieldAlias:      "foo",
+			ParseJSONString: true,
+			Rules: NewRules((*TestResource)(nil),
+				&Rule{Field: "Bar", FieldAlias: "bar", Type: String}),
+		},
+	)
+
+	actual, err := applyInboundRules(payload, rules, "1")
+
+	assert.Nil(err, "Error should be nil")
+	assert.Equal(Payload{"foo": map[string]interface{}{"bar": "1"}}, actual,
+		"Incorrect return value")
+
+	// Without ParseJSONString, the string is left as is.
+	rules.Contents()[0].ParseJSONString = false
+	actual, err = applyInboundRules(payload, rules, "1")
+
+	assert.Nil(err, "Error should be nil")
+	assert.Equal(payload, actual, "Incorrect return value")
+}
+
+// Ensures that an error is returned when a ParseJSONString field isn't valid JSON.
+func TestApplyInboundRulesParseJSONStringInvalid(t *testing.T) {
+	assert := assert.New(t)
+	payload := Payload{"foo": `{"bar": `}
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", Type: Map, ParseJSONString: true},
+	)
+
+	actual, err := applyInboundRules(payload, rules, "1")
+
+	assert.Nil(actual, "Payload should be nil")
+	assert.EqualError(err, "Field 'foo' is not valid JSON: unexpected end of JSON input")
+}
+
 // Ensures that nested inbound Rules are correctly applied to slices.
 func TestApplyInboundRulesNestedRulesSlice(t *testing.T) {
 	assert := assert.New(t)
@@ -1114,6 +1617,39 @@ func TestApplyInboundRulesNonResourceRule(t *testing.T) {
 	assert.Nil(err, "Error should be nil")
 }
 
+// Ensures that inbound values provided under a secondary alias are keyed by the
+// primary alias of the field.
+func TestApplyInboundRulesSecondaryAlias(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", Required: true},
+		&Rule{Field: "Foo", FieldAlias: "old_foo", Required: true},
+	)
+
+	actual, err := applyInboundRules(Payload{"old_foo": "bar"}, rules, "1")
+	assert.Nil(err, "Error should be nil")
+	assert.Equal(Payload{"foo": "bar"}, actual, "Incorrect return value")
+
+	actual, err = applyInboundRules(Payload{"foo": "baz"}, rules, "1")
+	assert.Nil(err, "Error should be nil")
+	assert.Equal(Payload{"foo": "baz"}, actual, "Incorrect return value")
+}
+
+// Ensures that the primary alias takes precedence when a field is provided under
+// multiple aliases.
+func TestApplyInboundRulesPrimaryAliasPrecedence(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo"},
+		&Rule{Field: "Foo", FieldAlias: "old_foo"},
+	)
+
+	actual, err := applyInboundRules(Payload{"foo": "new", "old_foo": "old"}, rules, "1")
+
+	assert.Nil(err, "Error should be nil")
+	assert.Equal(Payload{"foo": "new"}, actual, "Incorrect return value")
+}
+
 // Ensures that nil is returned by applyOutboundRules if nil is passed in.
 func TestApplyOutboundRulesNilResource(t *testing.T) {
 	assert := assert.New(t)
@@ -1423,11 +1959,242 @@ func TestApplyOutboundRulesNonResourceRule(t *testing.T) {
 	)
 }
 
-// Ensures that Applies returns true if no versions are specified on the Rule.
-func TestAppliesNoVersions(t *testing.T) {
+// Ensures that non-resource Rules with an OutputHandlerCtx emit a virtual field
+// computed from the whole struct resource.
+func TestApplyOutboundRulesVirtualField(t *testing.T) {
 	assert := assert.New(t)
-	rule := &Rule{}
-
+	resource := &TestResource{Foo: "hello"}
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo"},
+		&Rule{
+			FieldAlias: "foo_length",
+			Type:       Int,
+			OutputHandlerCtx: func(ctx RequestContext, val interface{}) interface{} {
+				return len(val.(*TestResource).Foo)
+			},
+		},
+	)
+
+	assert.Equal(
+		Payload{"foo": "hello", "foo_length": 5},
+		applyOutboundRules(resource, rules, "1"),
+		"Incorrect return value",
+	)
+}
+
+// Ensures that virtual fields are output only, so values received for them are
+// discarded and they aren't documented as input.
+func TestApplyInboundRulesVirtualField(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo"},
+		&Rule{
+			FieldAlias: "foo_length",
+			Type:       Int,
+			OutputHandlerCtx: func(ctx RequestContext, val interface{}) interface{} {
+				return len(val.(*TestResource).Foo)
+			},
+		},
+	)
+
+	actual, err := applyInboundRules(Payload{"foo": "hello", "foo_length": 99}, rules, "1")
+
+	assert.Nil(err)
+	assert.Equal(Payload{"foo": "hello"}, actual)
+	assert.Equal(1, rules.Filter(Inbound).Size())
+	assert.Equal(2, rules.Filter(Outbound).Size())
+}
+
+// Ensures that non-resource Rules with an OutputHandlerCtx emit a virtual field
+// computed from the whole map resource.
+func TestApplyOutboundRulesMapVirtualField(t *testing.T) {
+	assert := assert.New(t)
+	resource := map[string]interface{}{"Foo": "hello"}
+	rules := NewRules((*TestResource)(nil),
+		&Rule{
+			FieldAlias: "exclaimed",
+			OutputHandlerCtx: func(ctx RequestContext, val interface{}) interface{} {
+				return val.(map[string]interface{})["Foo"].(string) + "!"
+			},
+		},
+	)
+
+	assert.Equal(
+		Payload{"exclaimed": "hello!"},
+		applyOutboundRules(resource, rules, "1"),
+		"Incorrect return value",
+	)
+}
+
+// Ensures that OutputHandlerCtx is passed the RequestContext and takes precedence
+// over OutputHandler.
+func TestApplyOutboundRulesOutputHandlerCtx(t *testing.T) {
+	assert := assert.New(t)
+	req, _ := http.NewRequest("GET", "http://example.com/foo?greeting=hi", nil)
+	ctx := NewContext(req, httptest.NewRecorder())
+	resource := &TestResource{Foo: "world"}
+	rules := NewRules((*TestResource)(nil),
+		&Rule{
+			Field:      "Foo",
+			FieldAlias: "foo",
+			OutputHandler: func(val interface{}) interface{} {
+				return "unused"
+			},
+			OutputHandlerCtx: func(ctx RequestContext, val interface{}) interface{} {
+				return ctx.ValueWithDefault("greeting", "").(string) + " " + val.(string)
+			},
+		},
+	)
+
+	assert.Equal(
+		Payload{"foo": "hi world"},
+		applyOutboundRulesDepth(ctx, resource, rules, "1", 0, 0, requestLogger{}),
+		"Incorrect return value",
+	)
+}
+
+// Ensures that two outbound Rules targeting the same struct field emit the value
+// under both aliases.
+func TestApplyOutboundRulesMultipleAliases(t *testing.T) {
+	assert := assert.New(t)
+	resource := &TestResource{Foo: "hello"}
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo"},
+		&Rule{Field: "Foo", FieldAlias: "old_foo", OutputOnly: true},
+	)
+
+	assert.Equal(
+		Payload{"foo": "hello", "old_foo": "hello"},
+		applyOutboundRules(resource, rules, "1"),
+		"Incorrect return value",
+	)
+}
+
+// Ensures that two outbound Rules targeting the same map field emit the value
+// under both aliases.
+func TestApplyOutboundRulesMapMultipleAliases(t *testing.T) {
+	assert := assert.New(t)
+	resource := map[string]interface{}{"Foo": "hello"}
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", FieldAlias: "foo", OutputOnly: true},
+		&Rule{Field: "Foo", FieldAlias: "old_foo", OutputOnly: true},
+	)
+
+	assert.Equal(
+		Payload{"foo": "hello", "old_foo": "hello"},
+		applyOutboundRules(resource, rules, "1"),
+		"Incorrect return value",
+	)
+}
+
+type OmitEmptyResource struct {
+	Name       string
+	MiddleName *string
+	Tags       []string
+	Attributes map[string]string
+}
+
+// Ensures that outbound Rules with OmitEmpty omit empty struct fields.
+func TestApplyOutboundRulesOmitEmpty(t *testing.T) {
+	assert := assert.New(t)
+	resource := &OmitEmptyResource{Name: "Bob", Tags: []string{}}
+	rules := NewRules((*OmitEmptyResource)(nil),
+		&Rule{Field: "Name", FieldAlias: "name", OmitEmpty: true},
+		&Rule{Field: "MiddleName", FieldAlias: "middle_name", OmitEmpty: true},
+		&Rule{Field: "Tags", FieldAlias: "tags", OmitEmpty: true},
+		&Rule{Field: "Attributes", FieldAlias: "attributes", OmitEmpty: true},
+	)
+
+	assert.Equal(
+		Payload{"name": "Bob"},
+		applyOutboundRules(resource, rules, "1"),
+		"Incorrect return value",
+	)
+}
+
+// Ensures that outbound Rules without OmitEmpty emit empty struct fields.
+func TestApplyOutboundRulesNoOmitEmpty(t *testing.T) {
+	assert := assert.New(t)
+	resource := &OmitEmptyResource{Tags: []string{}}
+	rules := NewRules((*OmitEmptyResource)(nil),
+		&Rule{Field: "Name", FieldAlias: "name"},
+		&Rule{Field: "MiddleName", FieldAlias: "middle_name"},
+		&Rule{Field: "Tags", FieldAlias: "tags"},
+		&Rule{Field: "Attributes", FieldAlias: "attributes"},
+	)
+
+	assert.Equal(
+		Payload{
+			"name":        "",
+			"middle_name": (*string)(nil),
+			"tags":        []string{},
+			"attributes":  map[string]string(nil),
+		},
+		applyOutboundRules(resource, rules, "1"),
+		"Incorrect return value",
+	)
+}
+
+// Ensures that outbound Rules with OmitEmpty omit empty map fields.
+func TestApplyOutboundRulesMapOmitEmpty(t *testing.T) {
+	assert := assert.New(t)
+	resource := map[string]interface{}{
+		"Name":       "",
+		"MiddleName": nil,
+		"Tags":       []interface{}{},
+		"Attributes": map[string]interface{}{"a": "b"},
+	}
+	rules := NewRules((*OmitEmptyResource)(nil),
+		&Rule{Field: "Name", FieldAlias: "name", OmitEmpty: true},
+		&Rule{Field: "MiddleName", FieldAlias: "middle_name", OmitEmpty: true},
+		&Rule{Field: "Tags", FieldAlias: "tags", OmitEmpty: true},
+		&Rule{Field: "Attributes", FieldAlias: "attributes", OmitEmpty: true},
+	)
+
+	assert.Equal(
+		Payload{"attributes": map[string]interface{}{"a": "b"}},
+		applyOutboundRules(resource, rules, "1"),
+		"Incorrect return value",
+	)
+}
+
+// Ensures that outbound Rules without OmitEmpty emit empty map fields.
+func TestApplyOutboundRulesMapNoOmitEmpty(t *testing.T) {
+	assert := assert.New(t)
+	resource := map[string]interface{}{"Name": "", "MiddleName": nil}
+	rules := NewRules((*OmitEmptyResource)(nil),
+		&Rule{Field: "Name", FieldAlias: "name"},
+		&Rule{Field: "MiddleName", FieldAlias: "middle_name"},
+	)
+
+	assert.Equal(
+		Payload{"name": "", "middle_name": nil},
+		applyOutboundRules(resource, rules, "1"),
+		"Incorrect return value",
+	)
+}
+
+// Ensures that isEmptyValue matches the encoding/json omitempty semantics.
+func TestIsEmptyValue(t *testing.T) {
+	assert := assert.New(t)
+	var nilPtr *string
+	s := "a"
+
+	for _, v := range []interface{}{nil, "", false, 0, int64(0), uint8(0), 0.0,
+		nilPtr, []int{}, [0]int{}, map[string]int{}} {
+		assert.True(isEmptyValue(v), "%#v should be empty", v)
+	}
+	for _, v := range []interface{}{"a", true, 1, 1.5, &s, []int{1},
+		map[string]int{"a": 1}, struct{}{}} {
+		assert.False(isEmptyValue(v), "%#v should not be empty", v)
+	}
+}
+
+// Ensures that Applies returns true if no versions are specified on the Rule.
+func TestAppliesNoVersions(t *testing.T) {
+	assert := assert.New(t)
+	rule := &Rule{}
+
 	assert.True(rule.Applies("v1"), "Incorrect return value")
 }
 
@@ -1528,6 +2295,91 @@ func TestRulesValidateBadField(t *testing.T) {
 	assert.NotNil(rules.Validate())
 }
 
+// Ensures that Validate returns an error if a Rule's Pattern doesn't compile.
+func TestRulesValidateBadPattern(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil), &Rule{Field: "Foo", Pattern: "[a-z"})
+
+	assert.EqualError(rules.Validate(), "Invalid Rule for rest.TestResource: field 'Foo' "+
+		"has invalid pattern: error parsing regexp: missing closing ]: `[a-z`")
+}
+
+// Ensures that Validate returns an error if a Rule's Min is greater than its Max.
+func TestRulesValidateMinGreaterThanMax(t *testing.T) {
+	assert := assert.New(t)
+	min := 10.0
+	max := 1.0
+	rules := NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "foo", Type: Int, Min: &min, Max: &max})
+
+	assert.EqualError(rules.Validate(), "Invalid Rule for rest.TestResource: field 'foo' "+
+		"has Min 10 greater than Max 1")
+}
+
+// Ensures that Validate returns an error if a Rule with Min or Max isn't numeric.
+func TestRulesValidateRangeNotNumeric(t *testing.T) {
+	assert := assert.New(t)
+	min := 1.0
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", Type: String, Min: &min})
+
+	assert.EqualError(rules.Validate(), "Invalid Rule for rest.TestResource: field 'Foo' "+
+		"has Min or Max but is type string, not a numeric type")
+}
+
+// Ensures that Validate returns an error if a Rule's Default can't be coerced to its
+// Type.
+func TestRulesValidateBadDefault(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil), &Rule{FieldAlias: "foo", Type: Bool, Default: 1})
+
+	assert.EqualError(rules.Validate(), "Invalid Rule for rest.TestResource: field 'foo' "+
+		"has invalid default: Unable to coerce float to bool")
+}
+
+// Ensures that Validate returns an error if a Rule's Default doesn't satisfy its
+// Pattern, Min or Max, or AllowedValues.
+func TestRulesValidateDefaultConstraints(t *testing.T) {
+	assert := assert.New(t)
+	max := 5.0
+	rules := NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "foo", Type: String, Pattern: "^[a-z]+$", Default: "ABC"})
+	assert.EqualError(rules.Validate(), "Invalid Rule for rest.TestResource: field 'foo' "+
+		"has invalid default: Field 'foo' does not match pattern ^[a-z]+$")
+
+	rules = NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "foo", Type: Int, Max: &max, Default: 10})
+	assert.EqualError(rules.Validate(), "Invalid Rule for rest.TestResource: field 'foo' "+
+		"has invalid default: Field 'foo' is 10, more than the maximum of 5")
+
+	rules = NewRules((*TestResource)(nil), &Rule{FieldAlias: "foo", Type: String,
+		AllowedValues: []interface{}{"a", "b"}, Default: "c"})
+	assert.EqualError(rules.Validate(), "Invalid Rule for rest.TestResource: field 'foo' "+
+		"has invalid default: Field 'foo' must be one of: a, b")
+
+	rules = NewRules((*TestResource)(nil), &Rule{FieldAlias: "foo", Type: String,
+		Pattern: "^[a-z]+$", AllowedValues: []interface{}{"a", "b"}, Default: "a"})
+	assert.Nil(rules.Validate())
+}
+
+// Ensures that withoutUnchanged drops the write-once fields whose values, coerced to
+// their Type, equal the stored resource's and keeps the changed ones.
+func TestWithoutUnchanged(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*point)(nil),
+		&Rule{Field: "X", FieldAlias: "x", Type: Int, WriteOnce: true},
+		&Rule{Field: "Y", FieldAlias: "y", Type: Int, WriteOnce: true},
+		&Rule{FieldAlias: "name"},
+	)
+	payload := Payload{"x": float64(1), "y": float64(2), "name": "origin"}
+
+	assert.Equal(Payload{"y": float64(2), "name": "origin"},
+		withoutUnchanged(payload, rules, &point{X: 1, Y: 3}))
+	assert.Equal(Payload{"x": float64(1), "name": "origin"},
+		withoutUnchanged(payload, rules, map[string]interface{}{"X": 2, "Y": 2}))
+	assert.Equal(Payload{"x": float64(1), "y": float64(2), "name": "origin"}, payload)
+}
+
 // Ensures that Validate does not return an error for non-resource Rules.
 func TestRulesValidateNonResourceRule(t *testing.T) {
 	assert := assert.New(t)
@@ -1535,3 +2387,431 @@ func TestRulesValidateNonResourceRule(t *testing.T) {
 
 	assert.Nil(rules.Validate())
 }
+
+type treeResource struct {
+	Name     string
+	Parent   *treeResource
+	Children []*treeResource
+}
+
+// selfReferentialRules returns Rules for treeResource whose nested Rules refer back to
+// themselves.
+func selfReferentialRules() Rules {
+	parent := &Rule{Field: "Parent", FieldAlias: "parent"}
+	children := &Rule{Field: "Children", FieldAlias: "children"}
+	rules := NewRules((*treeResource)(nil),
+		&Rule{Field: "Name", FieldAlias: "name"},
+		parent,
+		children,
+	)
+	parent.Rules = rules
+	children.Rules = rules
+	return rules
+}
+
+// Ensures that nested Rules are applied up to the maximum depth on cyclic data and
+// fields nested deeper are omitted.
+func TestApplyOutboundRulesMaxDepth(t *testing.T) {
+	assert := assert.New(t)
+	root := &treeResource{Name: "root"}
+	child := &treeResource{Name: "child", Parent: root}
+	root.Children = []*treeResource{child}
+
+	assert.Equal(
+		Payload{
+			"name":   "root",
+			"parent": (*treeResource)(nil),
+			"children": []interface{}{
+				Payload{
+					"name":     "child",
+					"parent":   Payload{"name": "root"},
+					"children": []interface{}{},
+				},
+			},
+		},
+		applyOutboundRulesDepth(nil, root, selfReferentialRules(), "1", 0, 2, requestLogger{}),
+	)
+
+	assert.Equal(
+		Payload{
+			"name":     "root",
+			"parent":   (*treeResource)(nil),
+			"children": []interface{}{Payload{"name": "child"}},
+		},
+		applyOutboundRulesDepth(nil, root, selfReferentialRules(), "1", 0, 1, requestLogger{}),
+	)
+}
+
+// Ensures that numeric fields are serialized as strings when SerializeAsString is set
+// and as numbers otherwise.
+func TestApplyOutboundRulesSerializeAsString(t *testing.T) {
+	assert := assert.New(t)
+	type idResource struct {
+		ID       int64
+		ParentID *uint64
+		Score    float64
+		Name     string
+	}
+	parentID := uint64(9007199254740993)
+	resource := &idResource{ID: 9007199254740993, ParentID: &parentID, Score: 1.5, Name: "foo"}
+	rules := NewRules((*idResource)(nil),
+		&Rule{Field: "ID", FieldAlias: "id", SerializeAsString: true},
+		&Rule{Field: "ID", FieldAlias: "raw_id"},
+		&Rule{Field: "ParentID", FieldAlias: "parent_id", SerializeAsString: true},
+		&Rule{Field: "Score", FieldAlias: "score", SerializeAsString: true},
+		&Rule{Field: "Name", FieldAlias: "name", SerializeAsString: true},
+	)
+
+	actual := applyOutboundRules(resource, rules, "1")
+
+	assert.Equal(Payload{
+		"id":        "9007199254740993",
+		"raw_id":    int64(9007199254740993),
+		"parent_id": "9007199254740993",
+		"score":     "1.5",
+		"name":      "foo",
+	}, actual)
+
+	serialized, err := json.Marshal(actual)
+	assert.Nil(err)
+	assert.Equal(
+		`{"id":"9007199254740993","name":"foo","parent_id":"9007199254740993","raw_id":9007199254740993,"score":"1.5"}`,
+		string(serialized))
+}
+
+// Ensures that slices with more elements than MaxItems are rejected before their
+// elements are processed.
+func TestApplyInboundRulesMaxItems(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResourceSlice)(nil),
+		&Rule{
+			Field:      "Foo",
+			FieldAlias: "items",
+			Type:       Slice,
+			MaxItems:   2,
+			Rules:      NewRules((*TestResource)(nil), &Rule{Field: "Foo", Type: Int}),
+		},
+	)
+
+	// The elements aren't processed, so the invalid one isn't reported.
+	actual, err := applyInboundRules(
+		Payload{"items": []interface{}{"1", "2", "not an int"}}, rules, "1")
+
+	assert.Nil(actual)
+	assert.EqualError(err, "Field 'items' has 3 items, more than the maximum of 2")
+
+	actual, err = applyInboundRules(Payload{"items": []interface{}{"1", "2"}}, rules, "1")
+
+	assert.Nil(err)
+	assert.Equal(Payload{"items": []interface{}{1, 2}}, actual)
+}
+
+// Ensures that slices with fewer elements than MinItems are rejected.
+func TestApplyInboundRulesMinItems(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResourceSlice)(nil),
+		&Rule{Field: "Foo", FieldAlias: "items", MinItems: 1},
+	)
+
+	actual, err := applyInboundRules(Payload{"items": []interface{}{}}, rules, "1")
+
+	assert.Nil(actual)
+	assert.EqualError(err, "Field 'items' has 0 items, fewer than the minimum of 1")
+
+	actual, err = applyInboundRules(Payload{"items": []interface{}{"a"}}, rules, "1")
+
+	assert.Nil(err)
+	assert.Equal(Payload{"items": []interface{}{"a"}}, actual)
+}
+
+type sensitiveProfile struct {
+	Email string
+	Token string
+}
+
+type sensitiveAccount struct {
+	Name     string
+	Password string
+	Profiles []sensitiveProfile
+}
+
+// Ensures that maskSensitive masks the values of Sensitive fields, including nested
+// ones, without modifying the original Payload.
+func TestMaskSensitive(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*sensitiveAccount)(nil),
+		&Rule{Field: "Name", FieldAlias: "name"},
+		&Rule{Field: "Password", FieldAlias: "password", Sensitive: true},
+		&Rule{
+			Field:      "Profiles",
+			FieldAlias: "profiles",
+			Rules: NewRules((*sensitiveProfile)(nil),
+				&Rule{Field: "Email", FieldAlias: "email"},
+				&Rule{Field: "Token", FieldAlias: "token", Sensitive: true},
+			),
+		},
+	)
+
+	payload, err := applyInboundRules(Payload{
+		"name":     "foo",
+		"password": "hunter2",
+		"profiles": []interface{}{map[string]interface{}{"email": "a@b.c", "token": "t"}},
+	}, rules, "1")
+	assert.Nil(err)
+
+	assert.Equal(Payload{
+		"name":     "foo",
+		"password": sensitiveMask,
+		"profiles": []interface{}{map[string]interface{}{"email": "a@b.c", "token": sensitiveMask}},
+	}, maskSensitive(payload, rules, "1"))
+	assert.Equal("hunter2", payload["password"])
+	assert.Equal("t", payload["profiles"].([]interface{})[0].(map[string]interface{})["token"])
+}
+
+type mapResource struct {
+	Items  map[string]TestResource
+	Groups map[string][]TestResource
+	Counts map[string]int
+}
+
+// Ensures that Validate validates the nested Rules of Map fields, which describe each
+// value.
+func TestRulesValidateNestedMapRules(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*mapResource)(nil),
+		&Rule{Field: "Items", Type: Map, Rules: NewRules((*TestResource)(nil),
+			&Rule{Field: "Foo", Type: String})},
+		&Rule{Field: "Counts", Type: Map, Rules: NewRules((*int)(nil), &Rule{Type: Int})},
+	)
+	assert.Nil(rules.Validate())
+
+	rules = NewRules((*mapResource)(nil),
+		&Rule{Field: "Items", Type: Map, Rules: NewRules((*TestResource)(nil),
+			&Rule{Field: "Bar", Type: String})},
+	)
+	assert.EqualError(rules.Validate(),
+		"Invalid Rule for rest.TestResource: field 'Bar' does not exist")
+}
+
+// Ensures that nested inbound Rules of Map fields are applied to each value, including
+// each item of slice values, and primitive values are coerced.
+func TestApplyInboundRulesNestedMapRules(t *testing.T) {
+	assert := assert.New(t)
+	payload := Payload{
+		"items": map[string]interface{}{
+			"a": map[string]interface{}{"foo": "x", "bar": true},
+		},
+		"groups": map[string]interface{}{
+			"a": []interface{}{map[string]interface{}{"foo": "y", "bar": true}},
+		},
+		"counts": map[string]interface{}{"a": "3"},
+	}
+	nested := NewRules((*TestResource)(nil), &Rule{Field: "Foo", FieldAlias: "foo", Type: String})
+	rules := NewRules((*mapResource)(nil),
+		&Rule{Field: "Items", FieldAlias: "items", Type: Map, Rules: nested},
+		&Rule{Field: "Groups", FieldAlias: "groups", Type: Map, Rules: nested},
+		&Rule{Field: "Counts", FieldAlias: "counts", Type: Map,
+			Rules: NewRules((*int)(nil), &Rule{Type: Int})},
+	)
+
+	actual, err := applyInboundRules(payload, rules, "1")
+
+	assert.Nil(err)
+	assert.Equal(Payload{
+		"items":  map[string]interface{}{"a": map[string]interface{}{"foo": "x"}},
+		"groups": map[string]interface{}{"a": []interface{}{map[string]interface{}{"foo": "y"}}},
+		"counts": map[string]interface{}{"a": 3},
+	}, actual)
+
+	payload = Payload{"counts": map[string]interface{}{"a": "three"}}
+	_, err = applyInboundRules(payload, rules, "1")
+	assert.NotNil(err)
+	assert.Regexp("^a: ", err.Error())
+
+	payload = Payload{"items": map[string]interface{}{"a": nil}}
+	_, err = applyInboundRules(payload, rules, "1")
+	assert.EqualError(err, "a: nested value is nil")
+
+	payload = Payload{"counts": map[string]interface{}{"b": "three"}}
+	_, err = applyInboundRulesCollect(payload, rules, "1", true, requestLogger{})
+	assert.Equal("counts.b", err.(ValidationErrors)[0].Field)
+	assert.Regexp("^b: ", err.(ValidationErrors)[0].Message)
+}
+
+// Ensures that primitive Map values are coerced to the type of the first nested Rule
+// which applies to the version as input.
+func TestApplyInboundRulesNestedMapRulesFiltered(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*mapResource)(nil),
+		&Rule{Field: "Counts", FieldAlias: "counts", Type: Map,
+			Rules: NewRules((*int)(nil),
+				&Rule{Type: String, OutputOnly: true},
+				&Rule{Type: Bool, Versions: []string{"2"}},
+				&Rule{Type: Int})},
+	)
+
+	actual, err := applyInboundRules(Payload{"counts": map[string]interface{}{"a": "3"}}, rules, "1")
+
+	assert.Nil(err)
+	assert.Equal(Payload{"counts": map[string]interface{}{"a": 3}}, actual)
+}
+
+// Ensures that nested outbound Rules of Map fields are applied to each value,
+// including each item of slice values.
+func TestApplyOutboundRulesNestedMapRules(t *testing.T) {
+	assert := assert.New(t)
+	resource := mapResource{
+		Items:  map[string]TestResource{"a": {Foo: "hello"}},
+		Groups: map[string][]TestResource{"a": {{Foo: "hi"}, {Foo: "hey"}}},
+	}
+	nested := NewRules((*TestResource)(nil), &Rule{Field: "Foo", FieldAlias: "f"})
+	rules := NewRules((*mapResource)(nil),
+		&Rule{Field: "Items", FieldAlias: "items", Type: Map, Rules: nested},
+		&Rule{Field: "Groups", FieldAlias: "groups", Type: Map, Rules: nested},
+		&Rule{Field: "Counts", FieldAlias: "counts", Type: Map, Rules: nested},
+	)
+
+	assert.Equal(Payload{
+		"items":  map[string]interface{}{"a": Payload{"f": "hello"}},
+		"groups": map[string]interface{}{"a": []interface{}{Payload{"f": "hi"}, Payload{"f": "hey"}}},
+		"counts": map[string]int(nil),
+	}, applyOutboundRules(resource, rules, "1"))
+}
+
+type point struct {
+	X, Y int
+}
+
+// coercePoint converts strings like "1,2" to a point.
+func coercePoint(value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("Unable to coerce %s to point", reflect.TypeOf(value))
+	}
+	var p point
+	if _, err := fmt.Sscanf(s, "%d,%d", &p.X, &p.Y); err != nil {
+		return nil, fmt.Errorf("Invalid point '%s'", s)
+	}
+	return p, nil
+}
+
+// Ensures that a Rule's Coerce function is used in place of the Type's coercion and
+// runs before its InputHandler.
+func TestApplyInboundRulesCoerce(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "origin", Type: String, Coerce: coercePoint},
+		&Rule{FieldAlias: "target", Coerce: coercePoint, InputHandler: func(value interface{}) interface{} {
+			p := value.(point)
+			return point{p.X * 2, p.Y * 2}
+		}},
+	)
+
+	actual, err := applyInboundRules(Payload{"origin": "1,2", "target": "3,4"}, rules, "1")
+
+	assert.Nil(err)
+	assert.Equal(Payload{"origin": point{1, 2}, "target": point{6, 8}}, actual)
+
+	_, err = applyInboundRules(Payload{"origin": "nowhere"}, rules, "1")
+	assert.EqualError(err, "Field 'origin' is invalid: Invalid point 'nowhere'")
+
+	// Null values aren't coerced.
+	actual, err = applyInboundRules(Payload{"origin": nil}, rules, "1")
+	assert.Nil(err)
+	assert.Equal(Payload{"origin": nil}, actual)
+}
+
+// Ensures that Coerce errors are reported as type mismatches when collecting all
+// validation errors.
+func TestApplyInboundRulesCoerceCollect(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "origin", Coerce: coercePoint},
+	)
+
+	_, err := applyInboundRulesCollect(Payload{"origin": 1.0}, rules, "1", true, requestLogger{})
+
+	assert.Equal(ValidationErrors{{
+		Field:   "origin",
+		Code:    CodeTypeMismatch,
+		Message: "Unable to coerce float64 to point",
+	}}, err)
+}
+
+// Ensures that Validate returns an error for a Rule with both Coerce and nested Rules.
+func TestValidateCoerceWithNestedRules(t *testing.T) {
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "Foo", Type: String, Coerce: coercePoint,
+			Rules: NewRules((*TestResource)(nil), &Rule{Field: "Foo", Type: String})},
+	)
+
+	assert.EqualError(t, rules.Validate(),
+		"Invalid Rule for rest.TestResource: field 'Foo' has both Coerce and nested Rules")
+}
+
+type keyedResource struct {
+	Items []TestResource
+}
+
+// Ensures that the items of Slice fields are sent as an array by default and keyed by
+// the KeyBy field if it's set.
+func TestApplyOutboundRulesKeyBy(t *testing.T) {
+	assert := assert.New(t)
+	resource := keyedResource{Items: []TestResource{{Foo: "a"}, {Foo: "b"}, {Foo: ""}}}
+	rule := &Rule{Field: "Items", FieldAlias: "items", Type: Slice,
+		Rules: NewRules((*TestResource)(nil), &Rule{Field: "Foo", FieldAlias: "id", OmitEmpty: true})}
+	rules := NewRules((*keyedResource)(nil), rule)
+
+	assert.Equal(Payload{"items": []interface{}{
+		Payload{"id": "a"}, Payload{"id": "b"}, Payload{},
+	}}, applyOutboundRules(resource, rules, "1"))
+
+	// Items without the field are omitted.
+	rule.KeyBy = "id"
+	assert.Equal(Payload{"items": map[string]interface{}{
+		"a": Payload{"id": "a"},
+		"b": Payload{"id": "b"},
+	}}, applyOutboundRules(resource, rules, "1"))
+}
+
+// Ensures that keyBy keeps the first item for each key and logs omitted items once.
+func TestKeyByOmittedItems(t *testing.T) {
+	assert := assert.New(t)
+	var logged bytes.Buffer
+	logger := requestLogger{logger: log.New(&logged, "", 0)}
+	items := []interface{}{
+		Payload{"id": "a", "n": 1},
+		Payload{"id": "a", "n": 2},
+		Payload{"n": 3},
+		Payload{"id": "b", "n": 4},
+		Payload{"n": 5},
+		Payload{"id": "b", "n": 6},
+	}
+
+	assert.Equal(map[string]interface{}{
+		"a": Payload{"id": "a", "n": 1},
+		"b": Payload{"id": "b", "n": 4},
+	}, keyBy(items, "id", logger))
+	assert.Equal("Omitting 2 items without field 'id'\n"+
+		"Omitting items with duplicate 'id': a, b\n", logged.String())
+}
+
+// Ensures that Validate rejects KeyBy on fields which aren't Slices with nested Rules
+// or which name a field the nested Rules don't output.
+func TestRulesValidateKeyBy(t *testing.T) {
+	assert := assert.New(t)
+	nested := NewRules((*TestResource)(nil), &Rule{Field: "Foo", FieldAlias: "id"})
+
+	rules := NewRules((*keyedResource)(nil),
+		&Rule{Field: "Items", Type: Slice, KeyBy: "id", Rules: nested})
+	assert.Nil(rules.Validate())
+
+	rules = NewRules((*keyedResource)(nil), &Rule{Field: "Items", Type: Slice, KeyBy: "id"})
+	assert.EqualError(rules.Validate(), "Invalid Rule for rest.keyedResource: field "+
+		"'Items' has KeyBy but is not a Slice with nested Rules")
+
+	rules = NewRules((*keyedResource)(nil),
+		&Rule{Field: "Items", Type: Slice, KeyBy: "name", Rules: nested})
+	assert.EqualError(rules.Validate(), "Invalid Rule for rest.keyedResource: field "+
+		"'Items' is keyed by 'name', which is not a nested output field")
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2014 - 2015 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v1"
+)
+
+// schemaTypeAliases maps friendly schema type names to Types in addition to the
+// names in typeToName.
+var schemaTypeAliases = map[string]Type{
+	"":         Unspecified,
+	"byte":     Byte,
+	"slice":    Slice,
+	"map":      Map,
+	"duration": Duration,
+	"time":     Time,
+}
+
+// schema is the document format parsed by RulesFromSchema.
+type schema struct {
+	Fields []schemaField `yaml:"fields"`
+}
+
+// schemaField describes a single Rule in a schema document.
+type schemaField struct {
+	Field             string   `yaml:"field"`
+	Alias             string   `yaml:"alias"`
+	Type              string   `yaml:"type"`
+	Required          bool     `yaml:"required"`
+	Versions          []string `yaml:"versions"`
+	InputOnly         bool     `yaml:"input_only"`
+	OutputOnly        bool     `yaml:"output_only"`
+	OmitEmpty         bool     `yaml:"omit_empty"`
+	SerializeAsString bool     `yaml:"serialize_as_string"`
+	Pattern           string   `yaml:"pattern"`
+	Min               *float64 `yaml:"min"`
+	Max               *float64 `yaml:"max"`
+	MinItems          int      `yaml:"min_items"`
+	MaxItems          int      `yaml:"max_items"`
+	Description       string   `yaml:"description"`
+}
+
+// RulesFromSchema parses a JSON or YAML schema document into Rules bound to the
+// resource type of the provided pointer, which follows the same conventions as
+// NewRules. The document contains a list of fields, for example:
+//
+//     fields:
+//       - field: Name
+//         alias: name
+//         type: string
+//         required: true
+//         versions: ["1", "2"]
+//
+// Types use the names shown in the generated documentation (e.g. int64, float64,
+// time.Time) or one of byte, slice, map, duration, or time. The resulting Rules are
+// validated against the resource type and an error is returned if they are invalid.
+func RulesFromSchema(reader io.Reader, ptr interface{}) (Rules, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var s schema
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("Invalid schema: %s", err)
+	}
+
+	contents := make([]*Rule, 0, len(s.Fields))
+	for _, f := range s.Fields {
+		ruleType, err := schemaType(f.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		contents = append(contents, &Rule{
+			Field:             f.Field,
+			FieldAlias:        f.Alias,
+			Type:              ruleType,
+			Required:          f.Required,
+			Versions:          f.Versions,
+			InputOnly:         f.InputOnly,
+			OutputOnly:        f.OutputOnly,
+			OmitEmpty:         f.OmitEmpty,
+			SerializeAsString: f.SerializeAsString,
+			Pattern:           f.Pattern,
+			Min:               f.Min,
+			Max:               f.Max,
+			MinItems:          f.MinItems,
+			MaxItems:          f.MaxItems,
+			DocString:         f.Description,
+		})
+	}
+
+	rules := NewRules(ptr, contents...)
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// schemaType returns the Type with the given schema type name. If there is no such
+// Type, an error is returned.
+func schemaType(name string) (Type, error) {
+	if t, ok := schemaTypeAliases[name]; ok {
+		return t, nil
+	}
+
+	for t, typeName := range typeToName {
+		if typeName == name {
+			return t, nil
+		}
+	}
+
+	return Unspecified, fmt.Errorf("Invalid schema: unknown type '%s'", name)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2014 - 2015 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type SchemaResource struct {
+	ID      int64
+	Name    string
+	Created time.Time
+}
+
+// Ensures that RulesFromSchema parses a YAML schema into Rules.
+func TestRulesFromSchemaYAML(t *testing.T) {
+	assert := assert.New(t)
+	schema := `
+fields:
+  - field: ID
+    alias: id
+    type: int64
+    output_only: true
+    serialize_as_string: true
+  - field: Name
+    alias: name
+    type: string
+    required: true
+    versions: ["1", "2"]
+    description: The name
+  - field: Created
+    type: time
+    omit_empty: true
+`
+
+	rules, err := RulesFromSchema(strings.NewReader(schema), (*SchemaResource)(nil))
+
+	assert.Nil(err)
+	assert.Equal(reflect.TypeOf(SchemaResource{}), rules.ResourceType())
+	assert.Equal([]*Rule{
+		{Field: "ID", FieldAlias: "id", Type: Int64, OutputOnly: true, SerializeAsString: true},
+		{Field: "Name", FieldAlias: "name", Type: String, Required: true,
+			Versions: []string{"1", "2"}, DocString: "The name"},
+		{Field: "Created", Type: Time, OmitEmpty: true},
+	}, rules.Contents())
+}
+
+// Ensures that RulesFromSchema parses a JSON schema into Rules.
+func TestRulesFromSchemaJSON(t *testing.T) {
+	assert := assert.New(t)
+	schema := `{"fields": [{"field": "Name", "alias": "name", "type": "string", "required": true}]}`
+
+	rules, err := RulesFromSchema(strings.NewReader(schema), (*SchemaResource)(nil))
+
+	assert.Nil(err)
+	assert.Equal([]*Rule{
+		{Field: "Name", FieldAlias: "name", Type: String, Required: true},
+	}, rules.Contents())
+}
+
+// Ensures that RulesFromSchema returns an error for an unknown type.
+func TestRulesFromSchemaUnknownType(t *testing.T) {
+	assert := assert.New(t)
+	schema := `{"fields": [{"field": "Name", "type": "str"}]}`
+
+	rules, err := RulesFromSchema(strings.NewReader(schema), (*SchemaResource)(nil))
+
+	assert.Nil(rules)
+	assert.EqualError(err, "Invalid schema: unknown type 'str'")
+}
+
+// Ensures that RulesFromSchema returns an error when the Rules don't match the
+// resource.
+func TestRulesFromSchemaInvalidRules(t *testing.T) {
+	assert := assert.New(t)
+	schema := `{"fields": [{"field": "Name", "type": "int"}]}`
+
+	rules, err := RulesFromSchema(strings.NewReader(schema), (*SchemaResource)(nil))
+
+	assert.Nil(rules)
+	assert.EqualError(err, "Invalid Rule for rest.SchemaResource: field 'Name' is type string, not int")
+}
+
+// Ensures that RulesFromSchema returns an error for a malformed document.
+func TestRulesFromSchemaMalformed(t *testing.T) {
+	_, err := RulesFromSchema(strings.NewReader(`{"fields": [`), (*SchemaResource)(nil))
+	assert.Error(t, err)
+}
+
+// Ensures that RulesFromSchema parses field constraints.
+func TestRulesFromSchemaConstraints(t *testing.T) {
+	assert := assert.New(t)
+	schema := `{"fields": [
+		{"field": "Name", "type": "string", "pattern": "^[a-z]+$"},
+		{"field": "ID", "type": "int64", "min": 1, "max": 100}
+	]}`
+
+	rules, err := RulesFromSchema(strings.NewReader(schema), (*SchemaResource)(nil))
+
+	assert.Nil(err)
+	min, max := 1.0, 100.0
+	assert.Equal([]*Rule{
+		{Field: "Name", Type: String, Pattern: "^[a-z]+$"},
+		{Field: "ID", Type: Int64, Min: &min, Max: &max},
+	}, rules.Contents())
+}
@@ -17,20 +17,47 @@ limitations under the License.
 package rest
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
 )
 
 const (
-	status   = "status"
-	reason   = "reason"
-	messages = "messages"
-	result   = "result"
-	results  = "results"
-	next     = "next"
+	status    = "status"
+	reason    = "reason"
+	messages  = "messages"
+	errorList = "errors"
+	result    = "result"
+	results   = "results"
+	next      = "next"
+	total     = "total"
+	meta      = "meta"
+	stack     = "stack"
+
+	durationMs  = "duration_ms"
+	versionList = "versions"
 )
 
+// reservedEnvelopeKeys are the keys of success response envelopes which can't be
+// used to hold results.
+var reservedEnvelopeKeys = []string{status, reason, messages, next, total, meta}
+
+// envelopeKeys are the keys under which response envelopes hold a single result
+// and a list of results.
+type envelopeKeys struct {
+	result  string
+	results string
+}
+
 // response is a data structure holding the serializable response body for a request and
 // HTTP status code. It should be created using NewResponse.
 type response struct {
@@ -49,6 +76,19 @@ type ResponseSerializer interface {
 	ContentType() string
 }
 
+// StreamingResponseSerializer is a ResponseSerializer which writes responses
+// incrementally rather than buffering them, e.g. one list item at a time. If
+// serialization fails or panics after part of the response has been written, the
+// connection is aborted so clients don't mistake the truncated response for a
+// complete one.
+type StreamingResponseSerializer interface {
+	ResponseSerializer
+
+	// SerializeTo marshals a response payload, writing it to the io.Writer as it's
+	// produced.
+	SerializeTo(io.Writer, Payload) error
+}
+
 // jsonSerializer is an implementation of ResponseSerializer which serializes responses
 // as JSON.
 type jsonSerializer struct{}
@@ -63,6 +103,215 @@ func (j jsonSerializer) ContentType() string {
 	return "application/json"
 }
 
+// ndjsonSerializer is an implementation of ResponseSerializer which serializes list
+// responses as newline-delimited JSON, writing each resource on its own line followed
+// by a line holding the rest of the envelope, such as "next" and "messages". Responses
+// which don't contain a list of resources, such as errors, are written as a single line.
+type ndjsonSerializer struct{}
+
+// NewNDJSONSerializer returns a ResponseSerializer which serializes list responses as
+// newline-delimited JSON with the application/x-ndjson MIME type. Register it with
+// RegisterResponseSerializer, e.g. under the "ndjson" format, to enable it.
+func NewNDJSONSerializer() ResponseSerializer {
+	return ndjsonSerializer{}
+}
+
+// Serialize marshals a response payload into a newline-delimited JSON byte slice to be
+// sent over the wire.
+func (n ndjsonSerializer) Serialize(p Payload) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := n.SerializeTo(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SerializeTo marshals a response payload as newline-delimited JSON, writing each
+// line to the io.Writer as it's produced.
+func (n ndjsonSerializer) SerializeTo(w io.Writer, p Payload) error {
+	encoder := json.NewEncoder(w)
+
+	// Keys are checked in sorted order so the output doesn't depend on map iteration.
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		resources, ok := p[key].([]Resource)
+		if !ok {
+			continue
+		}
+		for _, resource := range resources {
+			if err := encoder.Encode(resource); err != nil {
+				return err
+			}
+		}
+
+		// The trailing line holds the rest of the envelope, e.g. "next" and "messages".
+		metadata := make(Payload, len(p)-1)
+		for k, value := range p {
+			if k != key {
+				metadata[k] = value
+			}
+		}
+		return encoder.Encode(metadata)
+	}
+
+	return encoder.Encode(p)
+}
+
+// ContentType returns the NDJSON MIME type of the response.
+func (n ndjsonSerializer) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// XML element names used for the response root, slice elements, and map entries
+// whose keys aren't valid element names.
+const (
+	xmlRoot  = "response"
+	xmlItem  = "item"
+	xmlEntry = "entry"
+)
+
+// xmlSerializer is an implementation of ResponseSerializer which serializes responses
+// as XML.
+type xmlSerializer struct{}
+
+// NewXMLSerializer returns a ResponseSerializer which serializes responses as XML with
+// the application/xml MIME type. The envelope is written as a <response> element with
+// a child element for each field, e.g. <status> and <result>. Map entries are written
+// in key order, slice elements as <item> elements, and map keys which aren't valid
+// element names as <entry key="..."> elements. Register it with
+// RegisterResponseSerializer, e.g. under the "xml" format, or set
+// Configuration.XMLResponses to enable it.
+func NewXMLSerializer() ResponseSerializer {
+	return xmlSerializer{}
+}
+
+// Serialize marshals a response payload into an XML byte slice to be sent over the
+// wire.
+func (x xmlSerializer) Serialize(p Payload) ([]byte, error) {
+	// Round trip through JSON so resources are represented the same way they are in
+	// JSON responses, e.g. respecting struct tags and MarshalJSON implementations.
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	writeXMLElement(&buf, xmlRoot, value)
+	return buf.Bytes(), nil
+}
+
+// ContentType returns the XML MIME type of the response.
+func (x xmlSerializer) ContentType() string {
+	return "application/xml"
+}
+
+// writeXMLElement writes the decoded JSON value to the buffer as an XML element with
+// the given name.
+func writeXMLElement(buf *bytes.Buffer, name string, value interface{}) {
+	buf.WriteByte('<')
+	if validXMLName(name) {
+		buf.WriteString(name)
+	} else {
+		buf.WriteString(xmlEntry + ` key="`)
+		xml.EscapeText(buf, []byte(name))
+		buf.WriteByte('"')
+		name = xmlEntry
+	}
+
+	if value == nil {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteByte('>')
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writeXMLElement(buf, key, v[key])
+		}
+	case []interface{}:
+		for _, item := range v {
+			writeXMLElement(buf, xmlItem, item)
+		}
+	case string:
+		xml.EscapeText(buf, []byte(v))
+	default:
+		fmt.Fprint(buf, v)
+	}
+
+	buf.WriteString("</" + name + ">")
+}
+
+// validXMLName returns whether the string can be used as an XML element name. Names
+// starting with "xml" are reserved.
+func validXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+
+	for i, r := range name {
+		if unicode.IsLetter(r) || r == '_' {
+			continue
+		}
+		if i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// TypeSerializer produces the serialized representation of a resource field value,
+// e.g. a string for a Money struct.
+type TypeSerializer func(interface{}) interface{}
+
+// typeSerializerLookup returns the TypeSerializer registered for a type, if any.
+type typeSerializerLookup func(reflect.Type) (TypeSerializer, bool)
+
+// serializeType applies the TypeSerializer registered with the request's API for the
+// value's type, or the type it points to, returning the value as-is if there isn't one.
+// The RequestContext may be nil, in which case no TypeSerializers apply.
+func serializeType(ctx RequestContext, value interface{}) interface{} {
+	if value == nil || ctx == nil {
+		return value
+	}
+
+	lookup, ok := ctx.Value(typeSerializersKey).(typeSerializerLookup)
+	if !ok {
+		return value
+	}
+
+	if serializer, ok := lookup(reflect.TypeOf(value)); ok {
+		return serializer(value)
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		if serializer, ok := lookup(v.Elem().Type()); ok {
+			return serializer(v.Elem().Interface())
+		}
+	}
+
+	return value
+}
+
 // NewResponse constructs a new response struct containing the payload to send back.
 // It will either be a success or error response depending on the RequestContext.
 func NewResponse(ctx RequestContext) response {
@@ -77,19 +326,23 @@ func NewResponse(ctx RequestContext) response {
 // newSuccessResponse constructs a new response struct containing a resource response.
 func newSuccessResponse(ctx RequestContext) response {
 	r := ctx.Result()
-	resultKey := result
+	keys := envelopeKeys{result, results}
+	if named, ok := ctx.Value(envelopeKeysKey).(envelopeKeys); ok {
+		keys = named
+	}
+	resultKey := keys.result
 	if r != nil && reflect.TypeOf(r).Kind() == reflect.Slice {
-		resultKey = results
+		resultKey = keys.results
 	}
 
 	s := ctx.Status()
 	response := response{Status: s}
 
-	if s != http.StatusNoContent {
+	if s != http.StatusNoContent && ctx.Value(omitBodyKey) == nil {
 		payload := Payload{
 			status:    s,
-			reason:    http.StatusText(s),
-			messages:  ctx.Messages(),
+			reason:    translateText(ctx, http.StatusText(s)),
+			messages:  translateMessages(ctx, ctx.StructuredMessages()),
 			resultKey: r,
 		}
 
@@ -97,6 +350,12 @@ func newSuccessResponse(ctx RequestContext) response {
 			payload[next] = nextURL
 		}
 
+		if count, ok := ctx.Value(totalKey).(int); ok {
+			payload[total] = count
+		}
+
+		addMeta(ctx, payload)
+
 		response.Payload = payload
 	}
 
@@ -107,16 +366,44 @@ func newSuccessResponse(ctx RequestContext) response {
 func newErrorResponse(ctx RequestContext) response {
 	err := ctx.Error()
 	s := http.StatusInternalServerError
-	if restError, ok := err.(Error); ok {
+	var restError Error
+	var validationErrs ValidationErrors
+	isValidationErr := errors.As(err, &validationErrs)
+	if errors.As(err, &restError) {
 		s = restError.Status()
+	} else if isValidationErr {
+		s = statusUnprocessableEntity
 	}
 
 	payload := Payload{
-		status:   s,
-		reason:   http.StatusText(s),
-		messages: ctx.Messages(),
+		status: s,
+		reason: translateText(ctx, http.StatusText(s)),
 	}
 
+	// Translate the error's message where it's built, so validation messages are
+	// translated by their code.
+	msgs := translateMessages(ctx, addedMessages(ctx))
+	var fieldErr *FieldError
+	if isValidationErr {
+		translated := make(ValidationErrors, len(validationErrs))
+		for i, fieldErr := range validationErrs {
+			translated[i] = &FieldError{
+				Field:   fieldErr.Field,
+				Code:    fieldErr.Code,
+				Message: translateFieldError(ctx, fieldErr),
+			}
+		}
+		payload[errorList] = translated
+		msgs = append(msgs, translated.Error())
+	} else if errors.As(err, &fieldErr) {
+		msgs = append(msgs, translateFieldError(ctx, fieldErr))
+	} else if err != nil {
+		msgs = append(msgs, translateText(ctx, err.Error()))
+	}
+	payload[messages] = msgs
+
+	addMeta(ctx, payload)
+
 	response := response{
 		Payload: payload,
 		Status:  s,
@@ -124,3 +411,34 @@ func newErrorResponse(ctx RequestContext) response {
 
 	return response
 }
+
+// translateMessages returns the response messages with those which are strings
+// translated into the request's locale.
+func translateMessages(ctx RequestContext, msgs []interface{}) []interface{} {
+	for i, message := range msgs {
+		if str, ok := message.(string); ok {
+			msgs[i] = translateText(ctx, str)
+		}
+	}
+	return msgs
+}
+
+// addMeta adds the request metadata held by the RequestContext, such as the
+// processing duration, to the response payload under the "meta" key.
+func addMeta(ctx RequestContext, payload Payload) {
+	metadata := Payload{}
+	if duration, ok := ctx.Value(durationKey).(time.Duration); ok {
+		metadata[durationMs] = durationMillis(duration)
+	}
+	if supported, ok := ctx.Value(versionsKey).([]string); ok {
+		metadata[versionList] = supported
+	}
+	if len(metadata) > 0 {
+		payload[meta] = metadata
+	}
+}
+
+// durationMillis returns the duration in fractional milliseconds.
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -47,6 +48,7 @@ const (
 	Map
 	Duration
 	Time
+	UUID
 	Byte        = Uint8
 	Unspecified = Interface
 )
@@ -72,6 +74,7 @@ var typeToName = map[Type]string{
 	Map:       "map[string]interface{}",
 	Duration:  "time.Duration",
 	Time:      "time.Time",
+	UUID:      "uuid",
 }
 
 // typeToKind maps Types to their reflect Kind.
@@ -95,11 +98,20 @@ var typeToKind = map[Type]reflect.Kind{
 	Map:       reflect.Map,
 	Duration:  reflect.Int64,
 	Time:      reflect.Struct,
+	UUID:      reflect.String,
+}
+
+// isNumeric indicates if the Type is one of the integer or floating-point Types.
+func isNumeric(t Type) bool {
+	return t >= Int && t <= Float64
 }
 
 // timeLayout is the format in which strings are parsed as time.Time (ISO 8601).
 const timeLayout = "2006-01-02T15:04:05Z"
 
+// uuidHyphens are the indexes of the hyphens in a canonical UUID string.
+var uuidHyphens = []int{8, 13, 18, 23}
+
 // coerceType attempts to convert the given value to the specified Type. If it cannot
 // be coerced, nil will be returned along with an error.
 func coerceType(value interface{}, coerceTo Type) (interface{}, error) {
@@ -301,11 +313,41 @@ func coerceFromString(value string, coerceTo Type) (interface{}, error) {
 		}
 		return val, nil
 
+	// To UUID.
+	case UUID:
+		return parseUUID(value)
+
 	default:
 		return nil, fmt.Errorf("Unable to coerce string to %s", typeToName[coerceTo])
 	}
 }
 
+// parseUUID validates that the given string is a UUID in canonical form, i.e.
+// xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx, and returns it normalized to lowercase. If
+// it is not a valid UUID, an empty string will be returned along with an error.
+func parseUUID(value string) (string, error) {
+	if len(value) != 36 {
+		return "", fmt.Errorf("Invalid UUID '%s'", value)
+	}
+
+	uuid := []byte(strings.ToLower(value))
+	hyphen := 0
+	for i, c := range uuid {
+		if hyphen < len(uuidHyphens) && i == uuidHyphens[hyphen] {
+			if c != '-' {
+				return "", fmt.Errorf("Invalid UUID '%s'", value)
+			}
+			hyphen++
+			continue
+		}
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return "", fmt.Errorf("Invalid UUID '%s'", value)
+		}
+	}
+
+	return string(uuid), nil
+}
+
 // coerceFromSlice attempts to convert the given slice to the specified Type. Currently,
 // slices can only be coerced to slices (identity). If it cannot be coerced, nil will be
 // returned along with an error.